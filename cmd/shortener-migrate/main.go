@@ -0,0 +1,140 @@
+// Offline migration of links between storages. Links are streamed from source
+// to target with their shorts, owners, correlation ids and deleted flags.
+//
+// Storages are set by DSN:
+// - bolt://path and sqlite://path for embedded storages
+// - postgres://... for PostgreSQL
+// - file://path or just path for file storage
+//
+// How to run:
+//
+//	shortener-migrate -from storage.db -to postgres://localhost/shortener -dry-run
+//	shortener-migrate -from storage.db -to postgres://localhost/shortener -checkpoint migrate.json
+//	shortener-migrate -from storage.db -to postgres://localhost/shortener -checkpoint migrate.json -resume
+//	shortener-migrate -from storage.db -to postgres://localhost/shortener -verify
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	_ "github.com/lib/pq"
+	"go.uber.org/zap"
+
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/helpers/db"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/helpers/migrator"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/logger"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/bolt"
+	dbh "github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/db"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/file"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/repository"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/sqlite"
+)
+
+// fileScheme optional scheme of file storage DSN
+const fileScheme = "file://"
+
+func main() {
+	from := flag.String("from", "", "DSN of source storage")
+	to := flag.String("to", "", "DSN of target storage")
+	batch := flag.Int("batch", migrator.DefaultBatch, "links in one import batch")
+	checkpoint := flag.String("checkpoint", "", "file of last migrated position for resume")
+	resume := flag.Bool("resume", false, "continue after position from checkpoint")
+	dryRun := flag.Bool("dry-run", false, "walk source and check target without writes")
+	verify := flag.Bool("verify", false, "compare all links of source and target")
+	flag.Parse()
+
+	if *from == "" || *to == "" {
+		log.Fatal("source and target DSN are required")
+	}
+	if *resume && *checkpoint == "" {
+		log.Fatal("resume require checkpoint file")
+	}
+
+	l, err := logger.New()
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	opts := []migrator.Option{
+		migrator.WithBatch(*batch),
+		migrator.WithCheckpoint(*checkpoint),
+		migrator.WithDryRun(*dryRun),
+	}
+	if err = run(ctx, l, *from, *to, *verify, *resume, opts...); err != nil {
+		if errors.Is(err, context.Canceled) && *checkpoint != "" {
+			l.Info("Migration interrupted, run with -resume to continue")
+		}
+		log.Fatal(err)
+	}
+}
+
+// run migration or verification and log report
+func run(ctx context.Context, l *zap.Logger, from, to string, verify, resume bool, opts ...migrator.Option) error {
+	src, err := open(l, from)
+	if err != nil {
+		return fmt.Errorf("source storage: %w", err)
+	}
+	defer closeStorage(l, src)
+	dst, err := open(l, to)
+	if err != nil {
+		return fmt.Errorf("target storage: %w", err)
+	}
+	defer closeStorage(l, dst)
+
+	m := migrator.New(l, src, dst, opts...)
+	var report migrator.Report
+	if verify {
+		report, err = m.Verify(ctx)
+	} else {
+		report, err = m.Migrate(ctx, resume)
+	}
+	l.Info("Migration report",
+		zap.Bool("verify", verify),
+		zap.Int("users", report.Users),
+		zap.Int("links", report.Links),
+		zap.Int("deleted", report.Deleted),
+		zap.Int("imported", report.Imported),
+		zap.Int("existing", report.Existing),
+		zap.Int("missing", report.Missing),
+		zap.Int("mismatched", report.Mismatched),
+		zap.Int("extra", report.Extra),
+	)
+	return err
+}
+
+// open storage by DSN
+func open(l *zap.Logger, dsn string) (repository.Repository, error) {
+	switch {
+	case strings.HasPrefix(dsn, bolt.Scheme):
+		return bolt.New(strings.TrimPrefix(dsn, bolt.Scheme))
+	case strings.HasPrefix(dsn, sqlite.Scheme):
+		return sqlite.New(strings.TrimPrefix(dsn, sqlite.Scheme), l)
+	case strings.HasPrefix(dsn, "postgres://"), strings.HasPrefix(dsn, "postgresql://"):
+		c, err := db.New(l, dsn)
+		if err != nil {
+			return nil, err
+		}
+		return dbh.New(c, l)
+	default:
+		return file.New(strings.TrimPrefix(dsn, fileScheme))
+	}
+}
+
+// closeStorage flush and close storage if it can be closed
+func closeStorage(l *zap.Logger, r repository.Repository) {
+	if closer, ok := r.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			l.Info("Storage don't close", zap.Error(err))
+		}
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/configs"
 	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/handlers"
 	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/handlers/middlewares"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/helpers/importer"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/helpers/recorder"
 	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/helpers/worker"
 	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/routes"
 	proto "github.com/triumphpc/go-musthave-shortener-tpl/pkg/api"
@@ -14,6 +16,7 @@ import (
 	"go.uber.org/zap"
 	"golang.org/x/crypto/acme/autocert"
 	"google.golang.org/grpc"
+	"io"
 	"net"
 	"net/http"
 	_ "net/http/pprof"
@@ -34,8 +37,14 @@ func main() {
 	printBuildInfo()
 	// Init project config
 	c := configs.Instance()
+	// Recorder of redirect clicks
+	rec := recorder.New(
+		c.Logger, c.Clicks,
+		recorder.WithBuffer(c.ClicksBuffer),
+		recorder.WithFlushInterval(c.ClicksFlushInterval),
+	)
 	// Allocation handler and storage
-	h := handlers.New(c.Logger, c.Storage)
+	h := handlers.New(c.Logger, c.Storage, handlers.WithRecorder(rec))
 
 	// Init context
 	ctx, stop := signal.NotifyContext(
@@ -46,10 +55,29 @@ func main() {
 	)
 	defer stop()
 
+	// Import jobs of links from csv
+	imp := importer.New(c.Logger, c.Storage, importer.WithChunk(c.ImportChunk), importer.WithRetention(c.ImportRetention))
 	// Pool workers
-	p, poolClose := worker.New(ctx, c.Logger, c.Storage)
+	deletes := worker.Delete(c.Storage, c.DeleteBatchWindow, c.DeleteBatchSize)
+	deletes.Concurrency, deletes.MaxDepth = c.DeleteWorkers, c.DeleteQueueDepth
+	// Workers don't stop with signal, queue is drained on shutdown
+	p, _ := worker.New(
+		context.Background(), c.Logger,
+		worker.WithType(worker.TypeDelete, deletes),
+		// Reaper of expired links
+		worker.WithType(worker.TypeReap, worker.NewReaper(c.Logger, c.Storage, c.ReapInterval).Type()),
+		// Purger of links deleted longer than retention
+		worker.WithType(worker.TypePurge, worker.NewPurger(c.Logger, c.Storage, c.Clicks, c.PurgeInterval, c.PurgeRetention).Type()),
+		worker.WithType(importer.TypeImport, imp.Type()),
+		worker.WithType(recorder.TypeFlush, rec.Type()),
+		worker.WithQueue(c.Tasks),
+		worker.WithLease(c.DeleteLease),
+		worker.WithRetry(c.DeleteMaxAttempts, c.DeleteBackoffMin, c.DeleteBackoffMax),
+	)
+	imp.Attach(p)
+	rec.Attach(p)
 	// Init routes
-	rtr := routes.Router(h, c, p)
+	rtr := routes.Router(h, c, p, imp)
 	http.Handle("/", rtr)
 	// Init handle
 	mux := middlewares.Conveyor(
@@ -67,7 +95,7 @@ func main() {
 		if c.EnableGRPC == "true" {
 			rungRPC(c, p, s, stop)
 		}
-		releaseResources(ctx, c, srv, poolClose, s)
+		releaseResources(ctx, c, srv, p, rec, imp, s)
 	} else {
 		// HTTPS server
 		srv := startHTTPSServer(c, mux, stop)
@@ -75,7 +103,7 @@ func main() {
 			// gRPC service
 			rungRPC(c, p, s, stop)
 		}
-		releaseResources(ctx, c, srv, poolClose, s)
+		releaseResources(ctx, c, srv, p, rec, imp, s)
 	}
 
 }
@@ -88,7 +116,7 @@ func rungRPC(c *configs.Config, p *worker.Pool, s *grpc.Server, stop context.Can
 		c.Logger.Fatal(err.Error())
 	}
 	// service register
-	proto.RegisterShortenerServer(s, grpcshortener.New(c.Logger, c.Storage, c.Database, p))
+	proto.RegisterShortenerServer(s, grpcshortener.New(c.Logger, c.Storage, c.Database, p, c.Clicks, grpcshortener.WithRestoreGrace(c.RestoreGrace)))
 
 	c.Logger.Info("gRPC server started on :3200")
 
@@ -178,14 +206,55 @@ func printBuildInfo() {
 	fmt.Printf("Build commit: %s\n", buildCommit)
 }
 
-// releaseResources free resources
-func releaseResources(ctx context.Context, c *configs.Config, srv *http.Server, poolClose func(), s *grpc.Server) {
+// releaseResources free resources in order: new background work is rejected and queue is drained,
+// then HTTP server, gRPC server, storages and database are closed
+func releaseResources(ctx context.Context, c *configs.Config, srv *http.Server, p *worker.Pool, rec *recorder.Recorder, imp *importer.Importer, s *grpc.Server) {
 	<-ctx.Done()
 	if ctx.Err() != nil {
 		fmt.Printf("Error:%v\n", ctx.Err())
 	}
 
 	c.Logger.Info("The service is shutting down...")
+	// Drain queue while servers answer on job statuses and storage is available
+	drainCtx, drainCancel := context.WithTimeout(context.Background(), c.ShutdownDrainTimeout)
+	if left := p.Drain(drainCtx); left > 0 {
+		c.Logger.Info("Queue isn't drained, tasks stay in queue", zap.Int("tasks", left))
+	}
+	drainCancel()
+
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), c.ShutdownTimeout)
+	defer shutdownCancel()
+	// Server shutdown
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		c.Logger.Info("app error exit", zap.Error(err))
+	}
+	c.Logger.Info("HTTP server stopped")
+	// Close gRPC server
+	stopGRPC(shutdownCtx, s)
+	c.Logger.Info("gRPC server stopped")
+
+	// Import jobs are stopped with pool
+	imp.Close()
+	// Save buffered clicks while storage is available
+	if err := rec.Close(); err != nil {
+		c.Logger.Info("Recorder don't close", zap.Error(err))
+	}
+	if closer, ok := c.Clicks.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			c.Logger.Info("Clicks storage don't close", zap.Error(err))
+		}
+	}
+	if closer, ok := c.Tasks.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			c.Logger.Info("Tasks queue don't close", zap.Error(err))
+		}
+	}
+	// Flush file storage
+	if closer, ok := c.Storage.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			c.Logger.Info("Storage don't close", zap.Error(err))
+		}
+	}
 	// database close
 	if c.Database != nil {
 		c.Logger.Info("Closing connect to db")
@@ -194,15 +263,19 @@ func releaseResources(ctx context.Context, c *configs.Config, srv *http.Server,
 			c.Logger.Info("Closing don't close")
 		}
 	}
-	// Close gRPC server
-	s.Stop()
-	c.Logger.Info("gRPC server stopped")
+	c.Logger.Info("Done")
+}
 
-	// Close pool worker
-	poolClose()
-	// Server shutdown
-	if err := srv.Shutdown(ctx); err != nil {
-		c.Logger.Info("app error exit", zap.Error(err))
+// stopGRPC wait requests of gRPC server until context is done, then stop it
+func stopGRPC(ctx context.Context, s *grpc.Server) {
+	stopped := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		s.Stop()
 	}
-	c.Logger.Info("Done")
 }
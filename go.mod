@@ -13,6 +13,7 @@ require (
 	github.com/lib/pq v1.10.3
 	github.com/pressly/goose/v3 v3.1.0
 	github.com/stretchr/testify v1.7.0
+	go.etcd.io/bbolt v1.3.6
 	go.uber.org/zap v1.19.1
 	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
 	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c
@@ -21,4 +22,5 @@ require (
 	google.golang.org/grpc v1.45.0
 	google.golang.org/protobuf v1.26.0
 	honnef.co/go/tools v0.2.2
+	modernc.org/sqlite v1.17.3
 )
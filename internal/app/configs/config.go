@@ -14,12 +14,21 @@ import (
 	"log"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/helpers/db"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/helpers/shortcode"
 	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/logger"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/bolt"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/cache"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/clicks"
 	dbh "github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/db"
 	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/file"
+	fw "github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/filewrapper"
 	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/repository"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/sqlite"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/tasks"
 )
 
 // ErrUnknownParam error for unknown param
@@ -34,9 +43,66 @@ type Config struct {
 	EnableHTTPS     string `env:"ENABLE_HTTPS" envDefault:""`
 	TrustedSubnet   string `env:"TRUSTED_SUBNET" envDefault:""`
 	EnableGRPC      string `env:"ENABLE_GRPC" envDefault:""`
-	Storage         repository.Repository
-	Logger          *zap.Logger
-	Database        *sql.DB
+	// Journal settings of file storages of links and clicks
+	FileStorageSync         string        `env:"FILE_STORAGE_SYNC" envDefault:"interval"`
+	FileStorageSyncInterval time.Duration `env:"FILE_STORAGE_SYNC_INTERVAL" envDefault:"1s"`
+	FileStorageCompactEvery int           `env:"FILE_STORAGE_COMPACT_EVERY" envDefault:"10000"`
+	// Query timeouts of database storage
+	DatabaseReadTimeout  time.Duration `env:"DATABASE_READ_TIMEOUT" envDefault:"3s"`
+	DatabaseWriteTimeout time.Duration `env:"DATABASE_WRITE_TIMEOUT" envDefault:"5s"`
+	DatabaseBatchTimeout time.Duration `env:"DATABASE_BATCH_TIMEOUT" envDefault:"30s"`
+	// Generation of short codes
+	ShortCodeStrategy string `env:"SHORT_CODE_STRATEGY" envDefault:"random"`
+	ShortCodeLength   int    `env:"SHORT_CODE_LENGTH" envDefault:"10"`
+	ShortCodeAlphabet string `env:"SHORT_CODE_ALPHABET" envDefault:"ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789abcdefghijklmnopqrstuvwxyz"`
+	// Period of expired links deletion
+	ReapInterval time.Duration `env:"REAP_INTERVAL" envDefault:"1m"`
+	// Clicks analytics. File storage of clicks is used without database, by default near links file
+	ClicksStoragePath   string        `env:"CLICKS_STORAGE_PATH" envDefault:""`
+	ClicksBuffer        int           `env:"CLICKS_BUFFER" envDefault:"1024"`
+	ClicksFlushInterval time.Duration `env:"CLICKS_FLUSH_INTERVAL" envDefault:"1s"`
+	// Cache of links before storage. Zero size disable cache
+	CacheSize        int           `env:"CACHE_SIZE" envDefault:"10000"`
+	CacheTTL         time.Duration `env:"CACHE_TTL" envDefault:"1m"`
+	CacheNegativeTTL time.Duration `env:"CACHE_NEGATIVE_TTL" envDefault:"10s"`
+	// Import of links from csv. Rows are saved by chunks, reports of finished jobs are kept for retention
+	ImportChunk     int           `env:"IMPORT_CHUNK" envDefault:"500"`
+	ImportMaxSize   int64         `env:"IMPORT_MAX_SIZE" envDefault:"268435456"`
+	ImportRetention time.Duration `env:"IMPORT_RETENTION" envDefault:"1h"`
+	// Mass save. Batches from threshold size are loaded to database by COPY in chunks,
+	// batches over max size are rejected. Zero threshold disable COPY
+	BatchCopyThreshold int `env:"BATCH_COPY_THRESHOLD" envDefault:"1000"`
+	BatchCopyChunk     int `env:"BATCH_COPY_CHUNK" envDefault:"10000"`
+	BatchMaxSize       int `env:"BATCH_MAX_SIZE" envDefault:"100000"`
+	// Deleted links can be restored during grace period and are purged by interval after retention
+	RestoreGrace   time.Duration `env:"RESTORE_GRACE" envDefault:"24h"`
+	PurgeInterval  time.Duration `env:"PURGE_INTERVAL" envDefault:"1h"`
+	PurgeRetention time.Duration `env:"PURGE_RETENTION" envDefault:"720h"`
+	// Durable queue of deletion tasks. File queue is used without database, by default near links file.
+	// Claimed task is delivered again after lease
+	DeleteQueuePath string        `env:"DELETE_QUEUE_PATH" envDefault:""`
+	DeleteLease     time.Duration `env:"DELETE_LEASE" envDefault:"1m"`
+	// Tasks claimed during window are merged by users in one update up to batch size of ids
+	DeleteBatchWindow time.Duration `env:"DELETE_BATCH_WINDOW" envDefault:"50ms"`
+	DeleteBatchSize   int           `env:"DELETE_BATCH_SIZE" envDefault:"1000"`
+	// Failed tasks are retried with exponential backoff and moved to dead letters after max attempts
+	DeleteMaxAttempts int           `env:"DELETE_MAX_ATTEMPTS" envDefault:"5"`
+	DeleteBackoffMin  time.Duration `env:"DELETE_BACKOFF_MIN" envDefault:"1s"`
+	DeleteBackoffMax  time.Duration `env:"DELETE_BACKOFF_MAX" envDefault:"1m"`
+	// Dead letters over max count or older than retention are dropped, oldest first
+	DeadLettersMax       int           `env:"DEAD_LETTERS_MAX" envDefault:"1000"`
+	DeadLettersRetention time.Duration `env:"DEAD_LETTERS_RETENTION" envDefault:"168h"`
+	// Workers of deletion tasks, zero for count of CPU. Deletes over max depth of claimed and ready tasks are rejected
+	DeleteWorkers    int `env:"DELETE_WORKERS" envDefault:"0"`
+	DeleteQueueDepth int `env:"DELETE_QUEUE_DEPTH" envDefault:"10000"`
+	// Shutdown drains queue of background tasks up to drain timeout, then servers are stopped up to shutdown timeout
+	ShutdownDrainTimeout time.Duration `env:"SHUTDOWN_DRAIN_TIMEOUT" envDefault:"30s"`
+	ShutdownTimeout      time.Duration `env:"SHUTDOWN_TIMEOUT" envDefault:"10s"`
+	Storage              repository.Repository
+	Clicks               clicks.Store
+	Tasks                tasks.Queue
+	Logger               *zap.Logger
+	Database             *sql.DB
 }
 
 const (
@@ -85,12 +151,15 @@ func Instance() *Config {
 			log.Fatal(err)
 		}
 		instance.Logger = l
+		// Short codes generator
+		alloc, err := instance.allocator()
+		if err != nil {
+			log.Fatal(err)
+		}
 		// Database
 		dsn, _ := instance.Param(DatabaseDsn)
-		var dbc *sql.DB
-		err = nil
-		if dsn != "" {
-			dbc, err = db.New(l, dsn)
+		if dsn != "" && !strings.HasPrefix(dsn, bolt.Scheme) && !strings.HasPrefix(dsn, sqlite.Scheme) {
+			dbc, err := db.New(l, dsn)
 			if err != nil {
 				l.Info("Db error", zap.Error(err))
 			} else {
@@ -99,23 +168,83 @@ func Instance() *Config {
 		}
 
 		// Main handler
-		if dsn != "" && err == nil {
+		switch {
+		case strings.HasPrefix(dsn, bolt.Scheme):
+			// Embedded storage is selected by DSN scheme
+			l.Info("Set bolt handler")
+			path := strings.TrimPrefix(dsn, bolt.Scheme)
+			instance.Storage, err = bolt.New(path, bolt.WithAllocator(alloc))
+			if err != nil {
+				log.Fatal(err)
+			}
+			instance.Clicks, err = instance.fileClicks(path)
+			if err != nil {
+				log.Fatal(err)
+			}
+			instance.Tasks, err = instance.fileTasks(path)
+			if err != nil {
+				log.Fatal(err)
+			}
+		case strings.HasPrefix(dsn, sqlite.Scheme):
+			l.Info("Set sqlite handler")
+			sqs, err := sqlite.New(strings.TrimPrefix(dsn, sqlite.Scheme), l, sqlite.WithAllocator(alloc), sqlite.WithTimeouts(dbh.Timeouts{
+				Read:  instance.DatabaseReadTimeout,
+				Write: instance.DatabaseWriteTimeout,
+				Batch: instance.DatabaseBatchTimeout,
+			}))
+			if err != nil {
+				log.Fatal(err)
+			}
+			instance.Storage = sqs
+			// Connection is shared for ping and clicks
+			instance.Database = sqs.DB()
+			instance.Clicks = clicks.NewSQLiteStore(instance.Database, instance.DatabaseBatchTimeout)
+			instance.Tasks = tasks.NewSQLiteQueue(instance.Database, instance.DatabaseWriteTimeout, tasks.WithDeadLetterLimits(instance.DeadLettersMax, instance.DeadLettersRetention))
+		case instance.Database != nil:
 			l.Info("Set db handler")
-			instance.Storage, err = dbh.New(dbc, l)
+			instance.Storage, err = dbh.New(instance.Database, l, dbh.WithAllocator(alloc), dbh.WithTimeouts(dbh.Timeouts{
+				Read:  instance.DatabaseReadTimeout,
+				Write: instance.DatabaseWriteTimeout,
+				Batch: instance.DatabaseBatchTimeout,
+			}), dbh.WithBulk(instance.BatchCopyThreshold, instance.BatchCopyChunk))
 			if err != nil {
 				log.Fatal(err)
 			}
-		} else {
+			instance.Clicks = clicks.NewPostgreSQLStore(instance.Database, instance.DatabaseBatchTimeout)
+			instance.Tasks = tasks.NewPostgreSQLQueue(instance.Database, instance.DatabaseWriteTimeout, tasks.WithDeadLetterLimits(instance.DeadLettersMax, instance.DeadLettersRetention))
+		default:
 			l.Info("Set file handler")
 			// File and memory storage
 			fs, err := instance.Param(FileStoragePath)
 			if err != nil || fs == FileStoragePathDefault {
 				fs = ""
 			}
-			instance.Storage, err = file.New(fs)
+			instance.Storage, err = file.New(
+				fs,
+				file.WithSync(fw.SyncPolicy(instance.FileStorageSync), instance.FileStorageSyncInterval),
+				file.WithCompaction(instance.FileStorageCompactEvery),
+				file.WithAllocator(alloc),
+			)
+			if err != nil {
+				log.Fatal(err)
+			}
+			instance.Clicks, err = instance.fileClicks(fs)
 			if err != nil {
 				log.Fatal(err)
 			}
+			instance.Tasks, err = instance.fileTasks(fs)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		// Cache wrap any storage
+		if instance.CacheSize > 0 {
+			instance.Storage = cache.New(
+				instance.Storage,
+				cache.WithSize(instance.CacheSize),
+				cache.WithTTL(instance.CacheTTL),
+				cache.WithNegativeTTL(instance.CacheNegativeTTL),
+			)
 		}
 	}
 	return instance
@@ -142,6 +271,45 @@ func (c *Config) Param(p string) (string, error) {
 	return "", ErrUnknownParam
 }
 
+// allocator make short codes allocator by config
+func (c *Config) allocator() (*shortcode.Allocator, error) {
+	alphabet := c.ShortCodeAlphabet
+	if alphabet == "" {
+		alphabet = shortcode.Base62
+	}
+	length := c.ShortCodeLength
+	if length <= 0 {
+		length = shortcode.DefaultLength
+	}
+	gen, err := shortcode.NewStrategy(c.ShortCodeStrategy, alphabet)
+	if err != nil {
+		return nil, err
+	}
+	return shortcode.New(gen, shortcode.WithLength(length)), nil
+}
+
+// fileClicks make file storage of clicks near links file. Memory storage for empty path
+func (c *Config) fileClicks(linksPath string) (*clicks.FileStore, error) {
+	path := c.ClicksStoragePath
+	if path == "" && linksPath != "" {
+		path = linksPath + ".clicks"
+	}
+	return clicks.NewFileStore(path, fw.SyncPolicy(c.FileStorageSync), c.FileStorageSyncInterval,
+		clicks.WithCompaction(c.FileStorageCompactEvery))
+}
+
+// fileTasks make file queue of deletion tasks near links file. Memory queue for empty path
+func (c *Config) fileTasks(linksPath string) (*tasks.FileQueue, error) {
+	path := c.DeleteQueuePath
+	if path == "" && linksPath != "" {
+		path = linksPath + ".tasks"
+	}
+	return tasks.NewFileQueue(
+		path, fw.SyncPolicy(c.FileStorageSync), c.FileStorageSyncInterval,
+		tasks.WithDeadLetters(c.DeadLettersMax, c.DeadLettersRetention),
+	)
+}
+
 // initInv check from inv
 func (c *Config) initInv() {
 	// Get from inv
@@ -23,3 +23,36 @@ var ErrAlreadyHasShort = errors.New("already has short")
 
 // ErrURLIsGone in storage
 var ErrURLIsGone = errors.New("url is gone")
+
+// ErrStorageTimeout if storage don't answer in time
+var ErrStorageTimeout = errors.New("storage timeout")
+
+// ErrStorageUnavailable if storage can't process request
+var ErrStorageUnavailable = errors.New("storage unavailable")
+
+// ErrInvalidAlias if custom short code has bad format or reserved
+var ErrInvalidAlias = errors.New("invalid alias")
+
+// ErrAliasTaken if custom short code already used
+var ErrAliasTaken = errors.New("alias already taken")
+
+// ErrURLExpired if link lifetime is over
+var ErrURLExpired = errors.New("url is expired")
+
+// ErrInvalidExpiry if expiration time is in past or ttl has bad format
+var ErrInvalidExpiry = errors.New("invalid expiry")
+
+// ErrInvalidQuery if params of links list are wrong
+var ErrInvalidQuery = errors.New("invalid query")
+
+// ErrImportConflict if imported link has short of other link or user already has its origin
+var ErrImportConflict = errors.New("import conflict")
+
+// ErrBatchAborted if atomic mass save is rolled back because of invalid items
+var ErrBatchAborted = errors.New("batch aborted")
+
+// ErrBatchTooLarge if mass save has more urls than allowed
+var ErrBatchTooLarge = errors.New("batch is too large")
+
+// ErrQueueFull if queue of background tasks has max count of tasks
+var ErrQueueFull = errors.New("queue is full")
@@ -1,10 +1,15 @@
 // Package delete implement handler for delete links for route /api/user/urls
+// and status of deletion jobs for route /api/user/urls/jobs/{id}
 package delete
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"strconv"
+	"time"
 
+	"github.com/gorilla/mux"
 	"go.uber.org/zap"
 
 	er "github.com/triumphpc/go-musthave-shortener-tpl/internal/app/errors"
@@ -12,13 +17,19 @@ import (
 	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/helpers/worker"
 )
 
+// JobsRoute of deletion jobs, job status is on JobsRoute/{id}
+const JobsRoute = "/api/user/urls/jobs"
+
+// RetryAfter delay of deletion repeat if queue is full
+const RetryAfter = 5 * time.Second
+
 type Handler struct {
 	l *zap.Logger
-	p *worker.Pool
+	p worker.IPool
 }
 
 // New instance of deleted handler
-func New(l *zap.Logger, p *worker.Pool) *Handler {
+func New(l *zap.Logger, p worker.IPool) *Handler {
 	return &Handler{l, p}
 }
 
@@ -42,9 +53,59 @@ func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 	// Add to pool ids on delete
 	userID := helpers.GetContextUserID(r)
-	if !h.p.Push(linkIDs, string(userID)) {
-		http.Error(w, er.ErrBadResponse.Error(), http.StatusBadRequest)
-	} else {
-		w.WriteHeader(http.StatusAccepted)
+	id, err := h.p.Enqueue(worker.TypeDelete, string(userID), linkIDs)
+	if errors.Is(err, worker.ErrClosed) {
+		http.Error(w, er.ErrStorageUnavailable.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	if errors.Is(err, er.ErrQueueFull) {
+		w.Header().Set("Retry-After", strconv.Itoa(int(RetryAfter.Seconds())))
+		http.Error(w, er.ErrQueueFull.Error(), http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		if !helpers.StorageError(w, err) {
+			http.Error(w, er.ErrInternalError.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	body, err = json.Marshal(struct {
+		ID     string `json:"id"`
+		Status string `json:"status"`
+	}{id, worker.JobQueued})
+	if err != nil {
+		http.Error(w, er.ErrInternalError.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Location", JobsRoute+"/"+id)
+	w.Header().Add("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusAccepted)
+	_, _ = w.Write(body)
+}
+
+// Status of deletion job
+func (h Handler) Status(w http.ResponseWriter, r *http.Request) {
+	job, err := h.p.Job(string(helpers.GetContextUserID(r)), mux.Vars(r)["id"])
+	// Jobs of other types are not visible as deletions
+	if err == nil && job.Type != worker.TypeDelete {
+		err = worker.ErrJobNotFound
+	}
+	if errors.Is(err, worker.ErrJobNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, er.ErrInternalError.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	body, err := json.Marshal(job)
+	if err != nil {
+		http.Error(w, er.ErrInternalError.Error(), http.StatusInternalServerError)
+		return
 	}
+	w.Header().Add("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
 }
@@ -2,15 +2,23 @@ package delete
 
 import (
 	"context"
+	"encoding/json"
+	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/consts"
 	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/helpers/worker"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/helpers/worker/workertest"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/shortlink"
 	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/file"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/tasks"
 	"go.uber.org/zap"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 )
 
 func ExampleHandler_ServeHTTP() {
@@ -21,7 +29,7 @@ func ExampleHandler_ServeHTTP() {
 	}
 
 	// Pool workers
-	p, _ := worker.New(context.Background(), l, rep)
+	p, _ := worker.New(context.Background(), l, worker.WithType(worker.TypeDelete, worker.Delete(rep, 0, 0)))
 
 	h := New(l, p)
 	w := httptest.NewRecorder()
@@ -43,7 +51,7 @@ func TestHandler_ServeHTTP(t *testing.T) {
 	}
 
 	// Pool workers
-	p, poolClose := worker.New(context.Background(), l, rep)
+	p, poolClose := worker.New(context.Background(), l, worker.WithType(worker.TypeDelete, worker.Delete(rep, 0, 0)))
 
 	h := New(l, p)
 	w := httptest.NewRecorder()
@@ -54,12 +62,84 @@ func TestHandler_ServeHTTP(t *testing.T) {
 	res := w.Result()
 
 	assert.Equal(t, http.StatusAccepted, res.StatusCode, "не верный код ответа")
+	assert.True(t, strings.HasPrefix(res.Header.Get("Location"), JobsRoute+"/"))
 
 	defer res.Body.Close()
 
 	poolClose()
 }
 
+func TestHandler_Status(t *testing.T) {
+	ctx := context.Background()
+	l := zap.NewNop()
+	rep, err := file.New("")
+	require.NoError(t, err)
+	short, err := rep.Save(ctx, "user", "http://deleted.ru", shortlink.Options{})
+	require.NoError(t, err)
+
+	p, poolClose := worker.New(ctx, l,
+		worker.WithType(worker.TypeDelete, worker.Delete(rep, 0, 0)),
+		worker.WithType("import", worker.Type{Handler: func(context.Context, string, []tasks.Task) (worker.Result, error) {
+			return worker.Result{}, nil
+		}}),
+	)
+	defer poolClose()
+	h := New(l, p)
+	rtr := mux.NewRouter()
+	rtr.Handle("/api/user/urls", h).Methods(http.MethodDelete)
+	rtr.HandleFunc(JobsRoute+"/{id}", h.Status).Methods(http.MethodGet)
+
+	// serve request of user
+	serve := func(method, target, body, userID string) *http.Response {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		rtr.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), consts.UserIDCtxName, userID)))
+		return w.Result()
+	}
+	res := serve(http.MethodDelete, "/api/user/urls", `["`+string(short)+`"]`, "user")
+	defer res.Body.Close()
+	require.Equal(t, http.StatusAccepted, res.StatusCode)
+	location := res.Header.Get("Location")
+
+	var job worker.Job
+	require.Eventually(t, func() bool {
+		res := serve(http.MethodGet, location, "", "user")
+		defer res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&job))
+		return job.Status == worker.JobDone
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 1, job.Affected)
+	assert.Equal(t, location, JobsRoute+"/"+job.ID)
+
+	res = serve(http.MethodGet, location, "", "other")
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	// jobs of other types are not deletions
+	id, err := p.Enqueue("import", "user", []string{"http://imported.ru"})
+	require.NoError(t, err)
+	res = serve(http.MethodGet, JobsRoute+"/"+id, "", "user")
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+}
+
+func TestHandler_QueueFull(t *testing.T) {
+	l := zap.NewNop()
+	rep, err := file.New("")
+	require.NoError(t, err)
+	p := workertest.FullPool(t, rep)
+
+	w := httptest.NewRecorder()
+	h := New(l, p)
+	h.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/api/user/urls", strings.NewReader(`["2"]`)))
+	res := w.Result()
+	defer res.Body.Close()
+
+	assert.Equal(t, http.StatusTooManyRequests, res.StatusCode)
+	assert.Equal(t, "5", res.Header.Get("Retry-After"))
+}
+
 func ExampleNew() {
 	l := zap.NewNop()
 	_ = New(l, nil)
@@ -0,0 +1,220 @@
+// Package export implement streaming download of user links for route /api/user/urls/export
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+
+	er "github.com/triumphpc/go-musthave-shortener-tpl/internal/app/errors"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/helpers"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/shortlink"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/repository"
+)
+
+// Formats of export
+const (
+	FormatCSV    = "csv"
+	FormatJSON   = "json"
+	FormatNDJSON = "ndjson"
+)
+
+// flushEvery count of rows after which response is flushed to client
+const flushEvery = 100
+
+// csvHeader columns of csv export
+var csvHeader = []string{"short_url", "original_url", "correlation_id", "is_deleted", "created_at"}
+
+// Row of export
+type Row struct {
+	Short         string     `json:"short_url"`
+	Origin        string     `json:"original_url"`
+	CorrelationID string     `json:"correlation_id,omitempty"`
+	Deleted       bool       `json:"is_deleted"`
+	CreatedAt     *time.Time `json:"created_at,omitempty"`
+}
+
+// encoder write rows in format
+type encoder interface {
+	begin() error
+	row(r Row) error
+	end() error
+}
+
+// Handler struct
+type Handler struct {
+	l       *zap.Logger
+	s       repository.Repository
+	baseURL string
+}
+
+// New instance of export handler. Short URLs are built from base URL
+func New(l *zap.Logger, s repository.Repository, baseURL string) *Handler {
+	return &Handler{l, s, baseURL}
+}
+
+// ServeHTTP stream all user links in format from query
+func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = FormatCSV
+	}
+	var enc encoder
+	var contentType string
+	switch format {
+	case FormatCSV:
+		enc, contentType = &csvEncoder{w: csv.NewWriter(w)}, "text/csv; charset=utf-8"
+	case FormatJSON:
+		enc, contentType = &jsonEncoder{w: w}, "application/json; charset=utf-8"
+	case FormatNDJSON:
+		enc, contentType = &ndjsonEncoder{enc: json.NewEncoder(w)}, "application/x-ndjson"
+	default:
+		http.Error(w, er.ErrBadResponse.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Headers are sent with first row, so storage error before it still get status
+	started := false
+	start := func() error {
+		started = true
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="links.%s"`, format))
+		w.WriteHeader(http.StatusOK)
+		return enc.begin()
+	}
+	flusher, _ := w.(http.Flusher)
+	rows := 0
+
+	err := h.s.WalkUser(r.Context(), helpers.GetContextUserID(r), func(l shortlink.Link) error {
+		if !started {
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		if err := enc.row(h.row(l)); err != nil {
+			return err
+		}
+		rows++
+		if flusher != nil && rows%flushEvery == 0 {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err == nil && !started {
+		err = start()
+	}
+	if err == nil {
+		err = enc.end()
+	}
+	if err == nil {
+		h.l.Info("Links exported", zap.String("format", format), zap.Int("rows", rows))
+		return
+	}
+
+	h.l.Info("Export error", zap.Error(err), zap.Int("rows", rows))
+	if started {
+		// Status is already sent, client get broken document
+		return
+	}
+	if !helpers.StorageError(w, err) {
+		http.Error(w, er.ErrInternalError.Error(), http.StatusInternalServerError)
+	}
+}
+
+// row of export for link
+func (h Handler) row(l shortlink.Link) Row {
+	row := Row{
+		Short:         fmt.Sprintf("%s/%s", h.baseURL, string(l.Short)),
+		Origin:        l.Origin,
+		CorrelationID: l.CorrelationID,
+		Deleted:       l.Deleted,
+	}
+	// Links from old storages have no creation time
+	if !l.CreatedAt.IsZero() {
+		createdAt := l.CreatedAt
+		row.CreatedAt = &createdAt
+	}
+	return row
+}
+
+// csvEncoder write rows as csv with header
+type csvEncoder struct {
+	w *csv.Writer
+}
+
+func (e *csvEncoder) begin() error {
+	return e.w.Write(csvHeader)
+}
+
+func (e *csvEncoder) row(r Row) error {
+	var createdAt string
+	if r.CreatedAt != nil {
+		createdAt = r.CreatedAt.Format(time.RFC3339)
+	}
+	err := e.w.Write([]string{r.Short, r.Origin, r.CorrelationID, strconv.FormatBool(r.Deleted), createdAt})
+	if err != nil {
+		return err
+	}
+	// Send row to response writer, it is flushed by handler
+	e.w.Flush()
+	return e.w.Error()
+}
+
+func (e *csvEncoder) end() error {
+	e.w.Flush()
+	return e.w.Error()
+}
+
+// jsonEncoder write rows as one json array
+type jsonEncoder struct {
+	w    io.Writer
+	next bool
+}
+
+func (e *jsonEncoder) begin() error {
+	_, err := io.WriteString(e.w, "[")
+	return err
+}
+
+func (e *jsonEncoder) row(r Row) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if e.next {
+		if _, err = io.WriteString(e.w, ","); err != nil {
+			return err
+		}
+	}
+	e.next = true
+	_, err = e.w.Write(data)
+	return err
+}
+
+func (e *jsonEncoder) end() error {
+	_, err := io.WriteString(e.w, "]")
+	return err
+}
+
+// ndjsonEncoder write every row as json on own line
+type ndjsonEncoder struct {
+	enc *json.Encoder
+}
+
+func (e *ndjsonEncoder) begin() error {
+	return nil
+}
+
+func (e *ndjsonEncoder) row(r Row) error {
+	return e.enc.Encode(r)
+}
+
+func (e *ndjsonEncoder) end() error {
+	return nil
+}
@@ -0,0 +1,114 @@
+package export
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/consts"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/handlers/middlewares"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/shortlink"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/file"
+)
+
+func newHandler(t *testing.T) http.Handler {
+	ctx := context.Background()
+	rep, err := file.New("")
+	require.NoError(t, err)
+	_, err = rep.BunchSave(ctx, "owner", []shortlink.URLs{
+		{ID: "1", Origin: "http://one.ru"},
+		{ID: "2", Origin: "http://two.ru", Alias: "two"},
+	}, shortlink.BatchOptions{})
+	require.NoError(t, err)
+	_, err = rep.BunchUpdateAsDeleted(ctx, []string{"2"}, "owner")
+	require.NoError(t, err)
+	_, err = rep.Save(ctx, "other", "http://other.ru", shortlink.Options{})
+	require.NoError(t, err)
+	return New(zap.NewNop(), rep, "http://localhost:8080")
+}
+
+func request(format string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/api/user/urls/export?format="+format, nil)
+	return r.WithContext(context.WithValue(r.Context(), consts.UserIDCtxName, "owner"))
+}
+
+func TestHandler_ServeHTTP(t *testing.T) {
+	h := newHandler(t)
+
+	t.Run("csv", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, request(FormatCSV))
+		res := w.Result()
+		defer res.Body.Close()
+
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, "text/csv; charset=utf-8", res.Header.Get("Content-Type"))
+		records, err := csv.NewReader(res.Body).ReadAll()
+		require.NoError(t, err)
+		require.Len(t, records, 3)
+		assert.Equal(t, csvHeader, records[0])
+		for _, record := range records[1:] {
+			if record[0] == "http://localhost:8080/two" {
+				assert.Equal(t, []string{"http://two.ru", "2", "true"}, record[1:4])
+			}
+			assert.NotEmpty(t, record[4])
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, request(FormatJSON))
+		res := w.Result()
+		defer res.Body.Close()
+
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		var rows []Row
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&rows))
+		require.Len(t, rows, 2)
+		for _, row := range rows {
+			assert.NotNil(t, row.CreatedAt)
+			assert.Equal(t, row.CorrelationID == "2", row.Deleted)
+		}
+	})
+
+	t.Run("ndjson through gzip", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		r := request(FormatNDJSON)
+		r.Header.Set("Accept-Encoding", "gzip")
+		middlewares.NewCompressor(zap.NewNop()).GzipMiddleware(h).ServeHTTP(w, r)
+		res := w.Result()
+		defer res.Body.Close()
+
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, "gzip", res.Header.Get("Content-Encoding"))
+		gz, err := gzip.NewReader(res.Body)
+		require.NoError(t, err)
+		lines := 0
+		scanner := bufio.NewScanner(gz)
+		for scanner.Scan() {
+			var row Row
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &row))
+			lines++
+		}
+		require.NoError(t, scanner.Err())
+		assert.Equal(t, 2, lines)
+	})
+
+	t.Run("unknown format", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, request("xml"))
+		res := w.Result()
+		defer res.Body.Close()
+
+		assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+	})
+}
@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/gorilla/mux"
 	"go.uber.org/zap"
@@ -14,6 +16,8 @@ import (
 	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/configs"
 	er "github.com/triumphpc/go-musthave-shortener-tpl/internal/app/errors"
 	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/helpers"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/helpers/recorder"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/helpers/shortcode"
 	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/shortlink"
 	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/repository"
 )
@@ -22,11 +26,27 @@ import (
 type Handler struct {
 	s repository.Repository
 	l *zap.Logger
+	// rec clicks of redirects, optional
+	rec *recorder.Recorder
+}
+
+// Option configure Handler
+type Option func(h *Handler)
+
+// WithRecorder set recorder of redirect clicks
+func WithRecorder(rec *recorder.Recorder) Option {
+	return func(h *Handler) {
+		h.rec = rec
+	}
 }
 
 // New Allocation new handler
-func New(l *zap.Logger, s repository.Repository) *Handler {
-	return &Handler{s, l}
+func New(l *zap.Logger, s repository.Repository, opts ...Option) *Handler {
+	h := &Handler{s: s, l: l}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
 }
 
 // Save convert link to shorting and store in database
@@ -45,13 +65,26 @@ func (h *Handler) Save(w http.ResponseWriter, r *http.Request) {
 	}
 	origin := string(body)
 
+	// Expiration is optional in query params
+	expiresAt, err := expiryFromQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	h.l.Info("Save origin", zap.String("origin", origin))
 
-	short, err := h.s.Save(helpers.GetContextUserID(r), origin)
+	short, err := h.s.Save(r.Context(), helpers.GetContextUserID(r), origin, shortlink.Options{ExpiresAt: expiresAt})
 
 	status := http.StatusCreated
 	if errors.Is(err, er.ErrAlreadyHasShort) {
 		status = http.StatusConflict
+	} else if err != nil {
+		h.l.Info("Save error", zap.Error(err))
+		if !helpers.StorageError(w, err) {
+			http.Error(w, er.ErrInternalError.Error(), http.StatusInternalServerError)
+		}
+		return
 	}
 
 	// Prepare response
@@ -91,12 +124,35 @@ func (h *Handler) SaveJSON(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if url.Alias != "" {
+		if err = shortcode.ValidateAlias(url.Alias); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	expiresAt, err := helpers.Expiry(url.ExpiresAt, url.TTL, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	h.l.Info("save origin", zap.String("URL", url.URL))
 
-	short, err := h.s.Save(helpers.GetContextUserID(r), url.URL)
+	opts := shortlink.Options{Alias: url.Alias, ExpiresAt: expiresAt}
+	short, err := h.s.Save(r.Context(), helpers.GetContextUserID(r), url.URL, opts)
 	status := http.StatusCreated
 	if errors.Is(err, er.ErrAlreadyHasShort) {
 		status = http.StatusConflict
+	} else if errors.Is(err, er.ErrAliasTaken) {
+		http.Error(w, er.ErrAliasTaken.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		h.l.Info("SaveJSON error", zap.Error(err))
+		if !helpers.StorageError(w, err) {
+			http.Error(w, er.ErrInternalError.Error(), http.StatusInternalServerError)
+		}
+		return
 	}
 	baseURL, err := configs.Instance().Param(configs.BaseURL)
 	if err != nil {
@@ -126,9 +182,19 @@ func (h *Handler) SaveJSON(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// BunchSaveJSON save data and return from mass
+// BunchSaveJSON save data and return from mass. Response has status of every item,
+// with atomic=true query param nothing is saved if any item is invalid
 func (h *Handler) BunchSaveJSON(w http.ResponseWriter, r *http.Request) {
 	h.l.Info("BunchSaveJSON run")
+	var opts shortlink.BatchOptions
+	if v := r.URL.Query().Get("atomic"); v != "" {
+		atomic, err := strconv.ParseBool(v)
+		if err != nil {
+			http.Error(w, er.ErrBadResponse.Error(), http.StatusBadRequest)
+			return
+		}
+		opts.Atomic = atomic
+	}
 	body, err := helpers.BodyFromJSON(&w, r)
 	if err != nil {
 		http.Error(w, er.ErrInternalError.Error(), http.StatusBadRequest)
@@ -141,12 +207,56 @@ func (h *Handler) BunchSaveJSON(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, er.ErrUnknownURL.Error(), http.StatusBadRequest)
 		return
 	}
-
-	shorts, err := h.s.BunchSave(helpers.GetContextUserID(r), urls)
-	if err != nil {
-		http.Error(w, er.ErrInternalError.Error(), http.StatusBadRequest)
+	if limit := configs.Instance().BatchMaxSize; limit > 0 && len(urls) > limit {
+		http.Error(w, fmt.Sprintf("%s: max %d urls", er.ErrBatchTooLarge, limit), http.StatusRequestEntityTooLarge)
 		return
 	}
+	// Invalid items don't go to storage, positions keep order of request
+	results := make([]shortlink.ShortURLs, len(urls))
+	valid := make([]shortlink.URLs, 0, len(urls))
+	positions := make([]int, 0, len(urls))
+	now := time.Now()
+	for k, v := range urls {
+		results[k].ID = v.ID
+		if v.Alias != "" {
+			if err = shortcode.ValidateAlias(v.Alias); err != nil {
+				results[k] = shortlink.Invalid(v.ID, err)
+				continue
+			}
+		}
+		// Storage get only absolute expiration time
+		expiresAt, err := helpers.Expiry(v.ExpiresAt, v.TTL, now)
+		if err != nil {
+			results[k] = shortlink.Invalid(v.ID, err)
+			continue
+		}
+		v.ExpiresAt, v.TTL = nil, ""
+		if !expiresAt.IsZero() {
+			v.ExpiresAt = &expiresAt
+		}
+		valid = append(valid, v)
+		positions = append(positions, k)
+	}
+
+	code := http.StatusCreated
+	if opts.Atomic && shortlink.HasInvalid(results) {
+		shortlink.Abort(results)
+		code = http.StatusUnprocessableEntity
+	} else if len(valid) > 0 {
+		shorts, err := h.s.BunchSave(r.Context(), helpers.GetContextUserID(r), valid, opts)
+		if errors.Is(err, er.ErrBatchAborted) {
+			code = http.StatusUnprocessableEntity
+		} else if err != nil {
+			h.l.Info("BunchSaveJSON error", zap.Error(err))
+			if !helpers.StorageError(w, err) {
+				http.Error(w, er.ErrInternalError.Error(), http.StatusBadRequest)
+			}
+			return
+		}
+		for k, v := range shorts {
+			results[positions[k]] = v
+		}
+	}
 	// Determine base url
 	baseURL, err := configs.Instance().Param(configs.BaseURL)
 	if err != nil {
@@ -154,11 +264,13 @@ func (h *Handler) BunchSaveJSON(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	// Prepare results
-	for k := range shorts {
-		shorts[k].Short = fmt.Sprintf("%s/%s", baseURL, shorts[k].Short)
+	for k := range results {
+		if results[k].Short != "" {
+			results[k].Short = fmt.Sprintf("%s/%s", baseURL, results[k].Short)
+		}
 	}
 
-	body, err = json.Marshal(shorts)
+	body, err = json.Marshal(results)
 	if err != nil {
 		http.Error(w, er.ErrInternalError.Error(), http.StatusBadRequest)
 		return
@@ -166,7 +278,7 @@ func (h *Handler) BunchSaveJSON(w http.ResponseWriter, r *http.Request) {
 	}
 	// Prepare response
 	w.Header().Add("Content-Type", "application/json; charset=utf-8")
-	w.WriteHeader(http.StatusCreated)
+	w.WriteHeader(code)
 	_, err = w.Write(body)
 	if err != nil {
 		http.Error(w, er.ErrInternalError.Error(), http.StatusBadRequest)
@@ -185,7 +297,7 @@ func (h *Handler) Get(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	h.l.Info("Get id:", zap.String("id", id))
-	url, err := h.s.LinkByShort(shortlink.Short(id))
+	url, err := h.s.LinkByShort(r.Context(), shortlink.Short(id))
 
 	h.l.Info("Result err", zap.Error(err))
 	h.l.Info("Result url", zap.String("url", url))
@@ -196,40 +308,82 @@ func (h *Handler) Get(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, er.ErrURLIsGone.Error(), http.StatusGone)
 			return
 		}
+		if errors.Is(err, er.ErrURLExpired) {
+			h.l.Info("Get error is expired", zap.Error(err))
+			http.Error(w, er.ErrURLExpired.Error(), http.StatusGone)
+			return
+		}
+		if helpers.StorageError(w, err) {
+			return
+		}
 
 		h.l.Info("Get error", zap.Error(err))
 		http.Error(w, er.ErrBadResponse.Error(), http.StatusBadRequest)
 		return
 	}
 	h.l.Info("redirect")
+	// Click is saved in background, so redirect isn't slowed down
+	if h.rec != nil {
+		h.rec.Record(recorder.NewClick(r, shortlink.Short(id)))
+	}
 	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
 }
 
-// GetUrls all urls from user
+// GetUrls page of user urls. Query params: limit, cursor, status (active, deleted, all),
+// domain and order (asc, desc) by creation time. Total count and cursor of next page are in headers
 func (h *Handler) GetUrls(w http.ResponseWriter, r *http.Request) {
 	h.l.Info("GetUrls run")
-	links, err := h.s.LinksByUser(helpers.GetContextUserID(r))
-
-	if err != nil || len(links) == 0 {
+	q, err := queryFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	page, err := h.s.LinksPage(r.Context(), helpers.GetContextUserID(r), q)
+	if err != nil {
+		if helpers.StorageError(w, err) {
+			return
+		}
+		if errors.Is(err, er.ErrInvalidQuery) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		h.l.Info("GetUrls error", zap.Error(err))
+		http.Error(w, er.ErrInternalError.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("X-Total-Count", strconv.Itoa(page.Total))
+	if page.NextCursor != "" {
+		w.Header().Set("X-Next-Cursor", page.NextCursor)
+	}
+	if len(page.Links) == 0 {
 		http.Error(w, er.ErrNoContent.Error(), http.StatusNoContent)
 		return
 	}
 
 	type coupleLinks struct {
-		Short  string `json:"short_url"`
-		Origin string `json:"original_url"`
+		Short     string     `json:"short_url"`
+		Origin    string     `json:"original_url"`
+		CreatedAt time.Time  `json:"created_at"`
+		ExpiresAt *time.Time `json:"expires_at,omitempty"`
+		Deleted   bool       `json:"is_deleted,omitempty"`
 	}
-	var lks []coupleLinks
+	lks := make([]coupleLinks, 0, len(page.Links))
 	baseURL, _ := configs.Instance().Param(configs.BaseURL)
 
-	// Get all links
-	for k, v := range links {
-		lks = append(lks, coupleLinks{
-			Short:  fmt.Sprintf("%s/%s", baseURL, string(k)),
-			Origin: v,
-		})
+	for _, l := range page.Links {
+		link := coupleLinks{
+			Short:     fmt.Sprintf("%s/%s", baseURL, string(l.Short)),
+			Origin:    l.Origin,
+			CreatedAt: l.CreatedAt,
+			Deleted:   l.Deleted,
+		}
+		if !l.ExpiresAt.IsZero() {
+			expiresAt := l.ExpiresAt
+			link.ExpiresAt = &expiresAt
+		}
+		lks = append(lks, link)
 	}
-	h.l.Info("User links", zap.Reflect("links", lks))
+	h.l.Info("User links", zap.Int("count", len(lks)))
 
 	body, err := json.Marshal(lks)
 	if err != nil {
@@ -244,3 +398,35 @@ func (h *Handler) GetUrls(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, er.ErrBadResponse.Error(), http.StatusBadRequest)
 	}
 }
+
+// queryFromRequest get query of links page from query params
+func queryFromRequest(r *http.Request) (shortlink.Query, error) {
+	params := r.URL.Query()
+	q := shortlink.Query{
+		Status: params.Get("status"),
+		Domain: params.Get("domain"),
+		Order:  params.Get("order"),
+		Cursor: params.Get("cursor"),
+	}
+	if limit := params.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil {
+			return q, fmt.Errorf("%w: bad limit %s", er.ErrInvalidQuery, limit)
+		}
+		q.Limit = n
+	}
+	return q.Normalize()
+}
+
+// expiryFromQuery get expiration time from expires_at in RFC 3339 or ttl query params
+func expiryFromQuery(r *http.Request) (time.Time, error) {
+	var expiresAt *time.Time
+	if v := r.URL.Query().Get("expires_at"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%w: bad expires_at %s", er.ErrInvalidExpiry, v)
+		}
+		expiresAt = &t
+	}
+	return helpers.Expiry(expiresAt, r.URL.Query().Get("ttl"), time.Now())
+}
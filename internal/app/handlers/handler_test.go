@@ -1,6 +1,8 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"io"
@@ -10,10 +12,13 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gorilla/mux"
 	"go.uber.org/zap"
 
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/configs"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/shortlink"
 	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/file"
 )
 
@@ -234,3 +239,204 @@ func TestNew(t *testing.T) {
 
 	assert.IsType(t, &Handler{}, h)
 }
+
+func TestHandler_SaveTimeout(t *testing.T) {
+	w := httptest.NewRecorder()
+	rep, err := file.New("")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	h := New(zap.NewNop(), rep)
+
+	// Deadline already exceeded
+	ctx, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+	request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("http://newlink.ru")).WithContext(ctx)
+
+	h.Save(w, request)
+	res := w.Result()
+	defer res.Body.Close()
+
+	assert.Equal(t, http.StatusGatewayTimeout, res.StatusCode, "не верный код ответа")
+}
+
+func TestHandler_SaveJSONAlias(t *testing.T) {
+	rep, err := file.New("")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	h := New(zap.NewNop(), rep)
+
+	tests := []struct {
+		name string
+		body string
+		code int
+	}{
+		{"custom alias", `{"url":"http://alias.ru","alias":"my-alias"}`, http.StatusCreated},
+		{"alias taken", `{"url":"http://other.ru","alias":"my-alias"}`, http.StatusConflict},
+		{"reserved alias", `{"url":"http://other.ru","alias":"api"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(tt.body))
+
+			h.SaveJSON(w, request)
+			res := w.Result()
+			defer res.Body.Close()
+
+			assert.Equal(t, tt.code, res.StatusCode, "не верный код ответа")
+		})
+	}
+}
+
+func TestHandler_BunchSaveJSON(t *testing.T) {
+	rep, err := file.New("")
+	if err != nil {
+		log.Fatal(err)
+	}
+	exists, err := rep.Save(context.Background(), "all", "http://exists.ru", shortlink.Options{})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	h := New(zap.NewNop(), rep)
+	body := `[
+		{"correlation_id":"1","original_url":"http://batch.ru"},
+		{"correlation_id":"2","original_url":"http://exists.ru"},
+		{"correlation_id":"3","original_url":"http://alias.ru","alias":"api"}
+	]`
+
+	tests := []struct {
+		name     string
+		target   string
+		code     int
+		statuses []string
+	}{
+		{"atomic", "/api/shorten/batch?atomic=true", http.StatusUnprocessableEntity,
+			[]string{shortlink.ItemAborted, shortlink.ItemAborted, shortlink.ItemInvalid}},
+		{"per item", "/api/shorten/batch", http.StatusCreated,
+			[]string{shortlink.ItemCreated, shortlink.ItemExisting, shortlink.ItemInvalid}},
+		{"bad atomic", "/api/shorten/batch?atomic=yes", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodPost, tt.target, strings.NewReader(body))
+
+			h.BunchSaveJSON(w, request)
+			res := w.Result()
+			defer res.Body.Close()
+
+			assert.Equal(t, tt.code, res.StatusCode, "не верный код ответа")
+			if tt.statuses == nil {
+				return
+			}
+			var results []shortlink.ShortURLs
+			if err := json.NewDecoder(res.Body).Decode(&results); err != nil {
+				t.Fatal(err)
+			}
+			statuses := make([]string, 0, len(results))
+			for _, v := range results {
+				statuses = append(statuses, v.Status)
+			}
+			assert.Equal(t, tt.statuses, statuses)
+			if tt.code == http.StatusCreated {
+				assert.True(t, strings.HasSuffix(results[1].Short, "/"+string(exists)))
+				assert.NotEmpty(t, results[2].Reason)
+			}
+		})
+	}
+
+	// Batch over max size is rejected
+	c := configs.Instance()
+	defer func(limit int) {
+		c.BatchMaxSize = limit
+	}(c.BatchMaxSize)
+	c.BatchMaxSize = 2
+	w := httptest.NewRecorder()
+	h.BunchSaveJSON(w, httptest.NewRequest(http.MethodPost, "/api/shorten/batch", strings.NewReader(body)))
+	res := w.Result()
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusRequestEntityTooLarge, res.StatusCode, "не верный код ответа")
+}
+
+func TestHandler_GetExpired(t *testing.T) {
+	rtr := mux.NewRouter()
+	rep, err := file.New("")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	h := New(zap.NewNop(), rep)
+	rtr.HandleFunc("/{id:.+}", h.Get)
+
+	opts := shortlink.Options{ExpiresAt: time.Now().Add(-time.Minute)}
+	short, err := rep.Save(context.Background(), "all", "http://expired.ru", opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "/"+string(short), nil)
+	rtr.ServeHTTP(w, request)
+	res := w.Result()
+	defer res.Body.Close()
+
+	assert.Equal(t, http.StatusGone, res.StatusCode, "не верный код ответа")
+
+	// Bad ttl
+	w = httptest.NewRecorder()
+	request = httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"http://ttl.ru","ttl":"-1h"}`))
+	h.SaveJSON(w, request)
+	res = w.Result()
+	defer res.Body.Close()
+
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode, "не верный код ответа")
+}
+
+func TestHandler_GetUrlsPage(t *testing.T) {
+	rep, err := file.New("")
+	if err != nil {
+		log.Fatal(err)
+	}
+	h := New(zap.NewNop(), rep)
+	for i := 0; i < 3; i++ {
+		_, err = rep.Save(context.Background(), "all", fmt.Sprintf("http://page.ru/%d", i), shortlink.Options{})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	w := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "/api/user/urls?limit=2", nil)
+	h.GetUrls(w, request)
+	res := w.Result()
+	defer res.Body.Close()
+
+	assert.Equal(t, http.StatusOK, res.StatusCode, "не верный код ответа")
+	assert.Equal(t, "3", res.Header.Get("X-Total-Count"))
+	cursor := res.Header.Get("X-Next-Cursor")
+	assert.NotEmpty(t, cursor)
+
+	// Last page
+	w = httptest.NewRecorder()
+	request = httptest.NewRequest(http.MethodGet, "/api/user/urls?limit=2&cursor="+cursor, nil)
+	h.GetUrls(w, request)
+	res = w.Result()
+	defer res.Body.Close()
+
+	assert.Equal(t, http.StatusOK, res.StatusCode, "не верный код ответа")
+	assert.Empty(t, res.Header.Get("X-Next-Cursor"))
+
+	// Bad params
+	w = httptest.NewRecorder()
+	request = httptest.NewRequest(http.MethodGet, "/api/user/urls?status=unknown", nil)
+	h.GetUrls(w, request)
+	res = w.Result()
+	defer res.Body.Close()
+
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode, "не верный код ответа")
+}
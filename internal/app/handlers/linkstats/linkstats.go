@@ -0,0 +1,78 @@
+// Package linkstats implement handler of clicks statistic for route /api/user/urls/{short}/stats
+package linkstats
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+
+	er "github.com/triumphpc/go-musthave-shortener-tpl/internal/app/errors"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/helpers"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/click"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/shortlink"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/clicks"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/repository"
+)
+
+// Handler struct
+type Handler struct {
+	l *zap.Logger
+	s repository.Repository
+	c clicks.Store
+}
+
+// New instance of link stats handler
+func New(l *zap.Logger, s repository.Repository, c clicks.Store) *Handler {
+	return &Handler{l, s, c}
+}
+
+// ServeHTTP get daily clicks of user link
+func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	short := shortlink.Short(mux.Vars(r)["short"])
+	if short == "" {
+		http.Error(w, er.ErrBadResponse.Error(), http.StatusBadRequest)
+		return
+	}
+	// Statistic is available only for owner of link
+	rec, err := h.s.RecordByShort(r.Context(), short)
+	if err != nil && !errors.Is(err, er.ErrURLNotFound) {
+		h.l.Info("Link owner error", zap.Error(err))
+		if !helpers.StorageError(w, err) {
+			http.Error(w, er.ErrInternalError.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	if err != nil || rec.UserID != helpers.GetContextUserID(r) {
+		http.Error(w, er.ErrURLNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	days, err := h.c.Daily(r.Context(), short)
+	if err != nil {
+		h.l.Info("Daily clicks error", zap.Error(err))
+		if !helpers.StorageError(w, err) {
+			http.Error(w, er.ErrInternalError.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+	result := click.Stats{Short: string(short), Days: days}
+	for _, d := range days {
+		result.Total += d.Count
+	}
+
+	body, err := json.Marshal(result)
+	if err != nil {
+		http.Error(w, er.ErrInternalError.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Prepare response
+	w.Header().Add("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, err = w.Write(body)
+	if err != nil {
+		http.Error(w, er.ErrInternalError.Error(), http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,98 @@
+package linkstats
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/consts"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/click"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/shortlink"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/clicks"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/file"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/repository"
+)
+
+func TestHandler_ServeHTTP(t *testing.T) {
+	ctx := context.Background()
+	rep, err := file.New("")
+	require.NoError(t, err)
+	store, err := clicks.NewFileStore("", "", 0)
+	require.NoError(t, err)
+
+	short, err := rep.Save(ctx, "owner", "http://stats.ru", shortlink.Options{})
+	require.NoError(t, err)
+	day := time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, store.Save(ctx, []click.Click{{At: day, Short: short}, {At: day, Short: short}}))
+
+	rtr := mux.NewRouter()
+	rtr.Handle("/api/user/urls/{short}/stats", New(zap.NewNop(), rep, store))
+
+	tests := []struct {
+		name   string
+		userID string
+		code   int
+	}{
+		{"owner", "owner", http.StatusOK},
+		{"other user", "other", http.StatusNotFound},
+		{"unknown short", "owner", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := "/api/user/urls/" + string(short) + "/stats"
+			if tt.name == "unknown short" {
+				path = "/api/user/urls/unknown/stats"
+			}
+			w := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodGet, path, nil)
+			request = request.WithContext(context.WithValue(request.Context(), consts.UserIDCtxName, tt.userID))
+			rtr.ServeHTTP(w, request)
+			res := w.Result()
+			defer res.Body.Close()
+
+			assert.Equal(t, tt.code, res.StatusCode)
+			if tt.code != http.StatusOK {
+				return
+			}
+			var stats click.Stats
+			require.NoError(t, json.NewDecoder(res.Body).Decode(&stats))
+			assert.Equal(t, 2, stats.Total)
+			assert.Equal(t, []click.DailyCount{{Day: "2026-10-18", Count: 2}}, stats.Days)
+		})
+	}
+}
+
+// failingRepository fail lookup of link owner
+type failingRepository struct {
+	repository.Repository
+}
+
+func (failingRepository) RecordByShort(context.Context, shortlink.Short) (shortlink.Record, error) {
+	return shortlink.Record{}, errors.New("storage is broken")
+}
+
+func TestHandler_StorageError(t *testing.T) {
+	store, err := clicks.NewFileStore("", "", 0)
+	require.NoError(t, err)
+	rtr := mux.NewRouter()
+	rtr.Handle("/api/user/urls/{short}/stats", New(zap.NewNop(), failingRepository{}, store))
+
+	w := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "/api/user/urls/short/stats", nil)
+	request = request.WithContext(context.WithValue(request.Context(), consts.UserIDCtxName, "owner"))
+	rtr.ServeHTTP(w, request)
+	res := w.Result()
+	defer res.Body.Close()
+
+	// Unexpected error isn't hidden as unknown link
+	assert.Equal(t, http.StatusInternalServerError, res.StatusCode)
+}
@@ -70,3 +70,13 @@ func (w gzipWriter) Write(b []byte) (int, error) {
 	// Writer response by gzip
 	return w.Writer.Write(b)
 }
+
+// Flush compressed data to client, so streamed responses are not held in gzip buffer
+func (w gzipWriter) Flush() {
+	if f, ok := w.Writer.(interface{ Flush() error }); ok {
+		_ = f.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
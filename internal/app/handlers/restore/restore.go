@@ -0,0 +1,71 @@
+// Package restore implement handler of deleted links restore for route /api/user/urls/restore
+package restore
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+
+	er "github.com/triumphpc/go-musthave-shortener-tpl/internal/app/errors"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/helpers"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/repository"
+)
+
+// Route of restore
+const Route = "/api/user/urls/restore"
+
+// DefaultGrace period of deleted links restore
+const DefaultGrace = 24 * time.Hour
+
+// Handler struct
+type Handler struct {
+	l     *zap.Logger
+	s     repository.Repository
+	grace time.Duration
+}
+
+// New instance of restore handler. Links deleted within grace period can be restored,
+// not positive grace is replaced by default
+func New(l *zap.Logger, s repository.Repository, grace time.Duration) *Handler {
+	if grace <= 0 {
+		grace = DefaultGrace
+	}
+	return &Handler{l, s, grace}
+}
+
+// ServeHTTP restore user links by ids from JSON array
+func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := helpers.BodyFromJSON(&w, r)
+	if err != nil {
+		http.Error(w, er.ErrInternalError.Error(), http.StatusBadRequest)
+		return
+	}
+	var linkIDs []string
+	if err = json.Unmarshal(body, &linkIDs); err != nil || len(linkIDs) == 0 {
+		http.Error(w, er.ErrBadResponse.Error(), http.StatusBadRequest)
+		return
+	}
+
+	userID := helpers.GetContextUserID(r)
+	restored, err := h.s.Restore(r.Context(), linkIDs, string(userID), time.Now().Add(-h.grace))
+	if err != nil {
+		h.l.Info("Restore error", zap.Error(err))
+		if !helpers.StorageError(w, err) {
+			http.Error(w, er.ErrInternalError.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	body, err = json.Marshal(struct {
+		Restored int `json:"restored"`
+	}{len(restored)})
+	if err != nil {
+		http.Error(w, er.ErrInternalError.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
+}
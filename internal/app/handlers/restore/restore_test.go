@@ -0,0 +1,60 @@
+package restore
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/consts"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/shortlink"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/file"
+)
+
+func TestHandler_ServeHTTP(t *testing.T) {
+	ctx := context.Background()
+	s, err := file.New("")
+	require.NoError(t, err)
+	short, err := s.Save(ctx, "user", "http://deleted.ru", shortlink.Options{})
+	require.NoError(t, err)
+	_, err = s.BunchUpdateAsDeleted(ctx, []string{string(short)}, "user")
+	require.NoError(t, err)
+
+	h := New(zap.NewNop(), s, time.Hour)
+	tests := []struct {
+		name   string
+		userID string
+		body   string
+		code   int
+		want   string
+	}{
+		{"bad body", "user", "{", http.StatusBadRequest, ""},
+		{"empty ids", "user", "[]", http.StatusBadRequest, ""},
+		{"other user", "other", `["` + string(short) + `"]`, http.StatusOK, `{"restored":0}`},
+		{"restore", "user", `["` + string(short) + `"]`, http.StatusOK, `{"restored":1}`},
+		{"already restored", "user", `["` + string(short) + `"]`, http.StatusOK, `{"restored":0}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, Route, strings.NewReader(tt.body))
+			h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), consts.UserIDCtxName, tt.userID)))
+			res := w.Result()
+			defer res.Body.Close()
+			assert.Equal(t, tt.code, res.StatusCode)
+			if tt.want != "" {
+				assert.JSONEq(t, tt.want, w.Body.String())
+			}
+		})
+	}
+
+	origin, err := s.LinkByShort(ctx, short)
+	require.NoError(t, err)
+	assert.Equal(t, "http://deleted.ru", origin)
+}
@@ -0,0 +1,64 @@
+package stats
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"go.uber.org/zap"
+
+	er "github.com/triumphpc/go-musthave-shortener-tpl/internal/app/errors"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/helpers"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/helpers/worker"
+)
+
+// Limits of dead letters in response
+const (
+	DefaultDeadLettersLimit = 100
+	MaxDeadLettersLimit     = 1000
+)
+
+// DeadLetters handler of deletion tasks failed after all attempts
+type DeadLetters struct {
+	p *worker.Pool
+	l *zap.Logger
+}
+
+// NewDeadLetters implement dead letters handler
+func NewDeadLetters(p *worker.Pool, l *zap.Logger) *DeadLetters {
+	return &DeadLetters{p, l}
+}
+
+// ServeHTTP get last dead letters for trusted subnet
+func (h DeadLetters) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !trusted(h.l, w, r) {
+		return
+	}
+	limit := DefaultDeadLettersLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		var err error
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > MaxDeadLettersLimit {
+			http.Error(w, er.ErrBadResponse.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	letters, err := h.p.DeadLetters(r.Context(), limit)
+	if err != nil {
+		h.l.Info("Dead letters error", zap.Error(err))
+		if !helpers.StorageError(w, err) {
+			http.Error(w, er.ErrInternalError.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	body, err := json.Marshal(letters)
+	if err != nil {
+		http.Error(w, er.ErrInternalError.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/configs"
 	er "github.com/triumphpc/go-musthave-shortener-tpl/internal/app/errors"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/helpers"
 	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/repository"
 	"go.uber.org/zap"
 	"net"
@@ -26,35 +27,28 @@ func NewStats(s repository.Repository, l *zap.Logger) *Handler {
 
 // ServeHTTP implement logic for ping handler
 func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	ts, _ := configs.Instance().Param(configs.TrustedSubnet)
-
-	if ts != "" {
-		h.l.Info("TS: " + ts)
-		_, ipv4Net, err := net.ParseCIDR(ts)
-		if err != nil {
-			h.l.Info("Can't parse CIDR")
-			http.Error(w, er.ErrBadResponse.Error(), http.StatusForbidden)
-			return
-		}
+	if !trusted(h.l, w, r) {
+		return
+	}
 
-		ip, err := getIP(r)
-		if err != nil {
-			h.l.Info("Can't parse IP")
-			http.Error(w, er.ErrBadResponse.Error(), http.StatusForbidden)
-			return
+	// Main logic
+	urlCount, err := h.s.URLCount(r.Context())
+	if err != nil {
+		h.l.Info("URL count error", zap.Error(err))
+		if !helpers.StorageError(w, err) {
+			http.Error(w, er.ErrInternalError.Error(), http.StatusInternalServerError)
 		}
-
-		if !ipv4Net.Contains(ip) {
-			h.l.Info("Can't contain IP" + ip.String())
-			http.Error(w, er.ErrBadResponse.Error(), http.StatusForbidden)
-			return
+		return
+	}
+	userCount, err := h.s.UserCount(r.Context())
+	if err != nil {
+		h.l.Info("User count error", zap.Error(err))
+		if !helpers.StorageError(w, err) {
+			http.Error(w, er.ErrInternalError.Error(), http.StatusInternalServerError)
 		}
+		return
 	}
 
-	// Main logic
-	urlCount := h.s.URLCount()
-	userCount := h.s.UserCount()
-
 	result := struct {
 		Urls  int `json:"urls"`
 		Users int `json:"users"`
@@ -74,6 +68,35 @@ func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// trusted check request is from trusted subnet, if it set. Response is written for not trusted request
+func trusted(l *zap.Logger, w http.ResponseWriter, r *http.Request) bool {
+	ts, _ := configs.Instance().Param(configs.TrustedSubnet)
+
+	if ts != "" {
+		l.Info("TS: " + ts)
+		_, ipv4Net, err := net.ParseCIDR(ts)
+		if err != nil {
+			l.Info("Can't parse CIDR")
+			http.Error(w, er.ErrBadResponse.Error(), http.StatusForbidden)
+			return false
+		}
+
+		ip, err := getIP(r)
+		if err != nil {
+			l.Info("Can't parse IP")
+			http.Error(w, er.ErrBadResponse.Error(), http.StatusForbidden)
+			return false
+		}
+
+		if !ipv4Net.Contains(ip) {
+			l.Info("Can't contain IP" + ip.String())
+			http.Error(w, er.ErrBadResponse.Error(), http.StatusForbidden)
+			return false
+		}
+	}
+	return true
+}
+
 // getIP get current ip address for user
 func getIP(r *http.Request) (net.IP, error) {
 	// use request method
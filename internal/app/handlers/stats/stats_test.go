@@ -1,8 +1,10 @@
 package stats
 
 import (
+	"context"
 	"github.com/stretchr/testify/assert"
 	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/configs"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/helpers/worker"
 	"go.uber.org/zap"
 	"net/http"
 	"net/http/httptest"
@@ -34,3 +36,34 @@ func TestNewStats(t *testing.T) {
 
 	assert.IsType(t, &Handler{c.Storage, nil}, h)
 }
+
+func TestDeadLetters_ServeHTTP(t *testing.T) {
+	l := zap.NewNop()
+	c := configs.Instance()
+	p, poolClose := worker.New(context.Background(), l, worker.WithType(worker.TypeDelete, worker.Delete(c.Storage, 0, 0)))
+	defer poolClose()
+	h := NewDeadLetters(p, l)
+
+	tests := []struct {
+		name  string
+		query string
+		code  int
+	}{
+		{"default limit", "", http.StatusOK},
+		{"limit", "?limit=10", http.StatusOK},
+		{"bad limit", "?limit=a", http.StatusBadRequest},
+		{"over max limit", "?limit=1001", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			request := httptest.NewRequest(http.MethodGet, "/api/internal/dead-letters"+tt.query, nil)
+
+			h.ServeHTTP(w, request)
+			res := w.Result()
+			defer res.Body.Close()
+
+			assert.Equal(t, tt.code, res.StatusCode)
+		})
+	}
+}
@@ -0,0 +1,174 @@
+// Package upload implement handlers of csv links import for route /api/user/urls/import
+package upload
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"go.uber.org/zap"
+
+	er "github.com/triumphpc/go-musthave-shortener-tpl/internal/app/errors"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/helpers"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/helpers/importer"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/shortlink"
+)
+
+// FormFile name of multipart field with csv file
+const FormFile = "file"
+
+// Route of import, job status is on Route/{id}
+const Route = "/api/user/urls/import"
+
+// Limits of report rows in status response
+const (
+	DefaultRowsLimit = 1000
+	MaxRowsLimit     = 10000
+)
+
+// DefaultMaxSize of uploaded file in bytes
+const DefaultMaxSize = 256 << 20
+
+// errTooLarge if upload is bigger than max size
+var errTooLarge = errors.New("upload is too large")
+
+// Handler struct
+type Handler struct {
+	l       *zap.Logger
+	i       *importer.Importer
+	baseURL string
+	maxSize int64
+}
+
+// New instance of import handlers. Short URLs of report are built from base URL
+func New(l *zap.Logger, i *importer.Importer, baseURL string, maxSize int64) *Handler {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+	return &Handler{l, i, baseURL, maxSize}
+}
+
+// Start read csv from multipart form and run import job
+func (h *Handler) Start(w http.ResponseWriter, r *http.Request) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, er.ErrBadResponse.Error(), http.StatusBadRequest)
+		return
+	}
+	var id string
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			http.Error(w, fmt.Sprintf("%s: no %s field", er.ErrBadResponse, FormFile), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, er.ErrBadResponse.Error(), http.StatusBadRequest)
+			return
+		}
+		if part.FormName() != FormFile {
+			continue
+		}
+		id, err = h.i.Start(helpers.GetContextUserID(r), &limitedReader{r: part, left: h.maxSize})
+		if errors.Is(err, errTooLarge) {
+			http.Error(w, errTooLarge.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if errors.Is(err, importer.ErrClosed) {
+			http.Error(w, er.ErrStorageUnavailable.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		if err != nil {
+			h.l.Info("Import start error", zap.Error(err))
+			http.Error(w, er.ErrInternalError.Error(), http.StatusInternalServerError)
+			return
+		}
+		break
+	}
+
+	body, err := json.Marshal(struct {
+		ID     string `json:"id"`
+		Status string `json:"status"`
+	}{id, importer.StatusPending})
+	if err != nil {
+		http.Error(w, er.ErrInternalError.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Location", Route+"/"+id)
+	w.Header().Add("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusAccepted)
+	_, _ = w.Write(body)
+}
+
+// Status of import job with page of rows report
+func (h *Handler) Status(w http.ResponseWriter, r *http.Request) {
+	offset, err := intParam(r, "offset", 0)
+	if err != nil || offset < 0 {
+		http.Error(w, er.ErrBadResponse.Error(), http.StatusBadRequest)
+		return
+	}
+	limit, err := intParam(r, "limit", DefaultRowsLimit)
+	if err != nil || limit <= 0 || limit > MaxRowsLimit {
+		http.Error(w, er.ErrBadResponse.Error(), http.StatusBadRequest)
+		return
+	}
+
+	job, err := h.i.Job(helpers.GetContextUserID(r), mux.Vars(r)["id"], offset, limit)
+	if errors.Is(err, importer.ErrJobNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, er.ErrInternalError.Error(), http.StatusInternalServerError)
+		return
+	}
+	for k, row := range job.Rows {
+		if row.Short != "" {
+			job.Rows[k].Short = shortlink.Short(fmt.Sprintf("%s/%s", h.baseURL, string(row.Short)))
+		}
+	}
+
+	body, err := json.Marshal(job)
+	if err != nil {
+		http.Error(w, er.ErrInternalError.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
+}
+
+// intParam from query or default value
+func intParam(r *http.Request, name string, def int) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+	return strconv.Atoi(v)
+}
+
+// limitedReader fail with errTooLarge after limit of bytes
+type limitedReader struct {
+	r    io.Reader
+	left int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.left < 0 {
+		return 0, errTooLarge
+	}
+	// Read one byte over limit to know if upload is bigger
+	if int64(len(p)) > l.left+1 {
+		p = p[:l.left+1]
+	}
+	n, err := l.r.Read(p)
+	l.left -= int64(n)
+	if l.left < 0 {
+		return n, errTooLarge
+	}
+	return n, err
+}
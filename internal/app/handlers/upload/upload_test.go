@@ -0,0 +1,92 @@
+package upload
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/consts"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/helpers/importer"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/helpers/worker"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/file"
+)
+
+// multipartRequest with csv file for user
+func multipartRequest(t *testing.T, userID, data string) *http.Request {
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	fw, err := mw.CreateFormFile(FormFile, "links.csv")
+	require.NoError(t, err)
+	_, err = fw.Write([]byte(data))
+	require.NoError(t, err)
+	require.NoError(t, mw.Close())
+
+	r := httptest.NewRequest(http.MethodPost, Route, body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	return r.WithContext(context.WithValue(r.Context(), consts.UserIDCtxName, userID))
+}
+
+func TestHandler(t *testing.T) {
+	s, err := file.New("")
+	require.NoError(t, err)
+	imp := importer.New(zap.NewNop(), s)
+	defer imp.Close()
+	p, poolClose := worker.New(context.Background(), zap.NewNop(), worker.WithType(importer.TypeImport, imp.Type()))
+	defer poolClose()
+	imp.Attach(p)
+
+	h := New(zap.NewNop(), imp, "http://localhost:8080", 64)
+	rtr := mux.NewRouter()
+	rtr.HandleFunc(Route, h.Start).Methods(http.MethodPost)
+	rtr.HandleFunc(Route+"/{id}", h.Status).Methods(http.MethodGet)
+
+	w := httptest.NewRecorder()
+	rtr.ServeHTTP(w, multipartRequest(t, "user", "http://one.ru,1\nhttp://two.ru,2\n"))
+	res := w.Result()
+	defer res.Body.Close()
+	require.Equal(t, http.StatusAccepted, res.StatusCode)
+	location := res.Header.Get("Location")
+	require.NotEmpty(t, location)
+
+	status := func(userID string) (*http.Response, importer.Job) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, location, nil)
+		rtr.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), consts.UserIDCtxName, userID)))
+		res := w.Result()
+		defer res.Body.Close()
+		var job importer.Job
+		if res.StatusCode == http.StatusOK {
+			require.NoError(t, json.NewDecoder(res.Body).Decode(&job))
+		}
+		return res, job
+	}
+	require.Eventually(t, func() bool {
+		_, job := status("user")
+		return job.Status == importer.StatusDone
+	}, 5*time.Second, 10*time.Millisecond)
+
+	_, job := status("user")
+	assert.Equal(t, 2, job.Created)
+	require.Len(t, job.Rows, 2)
+	assert.Contains(t, string(job.Rows[0].Short), "http://localhost:8080/")
+
+	res, _ = status("other")
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+
+	// Upload over max size
+	w = httptest.NewRecorder()
+	rtr.ServeHTTP(w, multipartRequest(t, "user", string(bytes.Repeat([]byte("http://big.ru\n"), 10))))
+	res = w.Result()
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusRequestEntityTooLarge, res.StatusCode)
+}
@@ -2,9 +2,14 @@
 package helpers
 
 import (
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
+	"database/sql"
+	"database/sql/driver"
 	"encoding/hex"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"math/rand"
 	"net/http"
@@ -24,25 +29,6 @@ type encData struct {
 // encInstance save encrypt data
 var encInstance *encData
 
-const charset = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789abcdefghijklmnopqrstuvwxyz"
-
-var seededRand *rand.Rand = rand.New(
-	rand.NewSource(time.Now().UnixNano()))
-
-// stringWithCharset generate rand string from charset
-func stringWithCharset(length int, charset string) string {
-	b := make([]byte, length)
-	for i := range b {
-		b[i] = charset[seededRand.Intn(len(charset))]
-	}
-	return string(b)
-}
-
-// RandomString Get rand string
-func RandomString(length int) string {
-	return stringWithCharset(length, charset)
-}
-
 // Decode userId  from encrypted cookie
 func Decode(shaUserID string, userID *string) error {
 	// Init encrypt data
@@ -143,3 +129,38 @@ func GetContextUserID(r *http.Request) user.UniqUser {
 	}
 	return user.UniqUser(userID)
 }
+
+// StorageError write response for errors of storage timeout or unavailability.
+// Returns false if error has another reason
+func StorageError(w http.ResponseWriter, err error) bool {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		http.Error(w, er.ErrStorageTimeout.Error(), http.StatusGatewayTimeout)
+	case errors.Is(err, context.Canceled), errors.Is(err, driver.ErrBadConn), errors.Is(err, sql.ErrConnDone):
+		http.Error(w, er.ErrStorageUnavailable.Error(), http.StatusServiceUnavailable)
+	default:
+		return false
+	}
+	return true
+}
+
+// Expiry resolve expiration time of link from absolute time or ttl.
+// Returns zero time if both are empty
+func Expiry(expiresAt *time.Time, ttl string, now time.Time) (time.Time, error) {
+	switch {
+	case expiresAt != nil && ttl != "":
+		return time.Time{}, fmt.Errorf("%w: set expires_at or ttl, not both", er.ErrInvalidExpiry)
+	case expiresAt != nil:
+		if !expiresAt.After(now) {
+			return time.Time{}, fmt.Errorf("%w: expires_at is in past", er.ErrInvalidExpiry)
+		}
+		return expiresAt.UTC(), nil
+	case ttl != "":
+		d, err := time.ParseDuration(ttl)
+		if err != nil || d <= 0 {
+			return time.Time{}, fmt.Errorf("%w: bad ttl %s", er.ErrInvalidExpiry, ttl)
+		}
+		return now.Add(d).UTC(), nil
+	}
+	return time.Time{}, nil
+}
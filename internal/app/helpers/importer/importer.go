@@ -0,0 +1,483 @@
+// Package importer implement async jobs of links import from csv files
+package importer
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	er "github.com/triumphpc/go-musthave-shortener-tpl/internal/app/errors"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/helpers/shortcode"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/helpers/worker"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/shortlink"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/user"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/repository"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/tasks"
+)
+
+// TypeImport of local pool jobs importing csv from temp file
+const TypeImport = "import"
+
+// Defaults of importer
+const (
+	DefaultChunk     = 500
+	DefaultRetention = time.Hour
+)
+
+// MaxOriginLength limit of origin like in database
+const MaxOriginLength = 255
+
+// Statuses of job
+const (
+	StatusPending = "pending"
+	StatusRunning = "running"
+	StatusDone    = "done"
+	StatusFailed  = "failed"
+)
+
+// Statuses of row
+const (
+	RowCreated   = "created"
+	RowDuplicate = "duplicate"
+	RowRejected  = "rejected"
+)
+
+// ErrJobNotFound if job is unknown or belongs to other user
+var ErrJobNotFound = errors.New("import job not found")
+
+// ErrClosed if importer doesn't accept jobs after close or without pool
+var ErrClosed = errors.New("importer is closed")
+
+// headerOrigin first column of optional header row
+const headerOrigin = "original_url"
+
+// Row report of one csv row
+type Row struct {
+	// Line number of csv record in file, header included
+	Line          int    `json:"line"`
+	Origin        string `json:"original_url"`
+	CorrelationID string `json:"correlation_id,omitempty"`
+	Alias         string `json:"alias,omitempty"`
+	Status        string `json:"status"`
+	// Short of created link or existing link for duplicate
+	Short  shortlink.Short `json:"short_url,omitempty"`
+	Reason string          `json:"reason,omitempty"`
+}
+
+// Job state of import
+type Job struct {
+	ID         string     `json:"id"`
+	Status     string     `json:"status"`
+	Total      int        `json:"total"`
+	Created    int        `json:"created"`
+	Duplicates int        `json:"duplicates"`
+	Rejected   int        `json:"rejected"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Rows       []Row      `json:"rows"`
+
+	userID user.UniqUser
+}
+
+// task payload of import job in pool
+type task struct {
+	ID   string `json:"id"`
+	File string `json:"file"`
+}
+
+// Importer run import jobs by workers of pool and keep their reports
+type Importer struct {
+	l         *zap.Logger
+	s         repository.Repository
+	chunk     int
+	retention time.Duration
+
+	mu     sync.RWMutex
+	jobs   map[string]*Job
+	closed bool
+	pool   worker.IPool
+}
+
+// Option configure Importer
+type Option func(i *Importer)
+
+// WithChunk set count of rows saved by one BunchSave
+func WithChunk(n int) Option {
+	return func(i *Importer) {
+		if n > 0 {
+			i.chunk = n
+		}
+	}
+}
+
+// WithRetention set time of finished job report keeping
+func WithRetention(d time.Duration) Option {
+	return func(i *Importer) {
+		if d > 0 {
+			i.retention = d
+		}
+	}
+}
+
+// New Importer of links to storage. Its type is registered in pool, then pool is attached
+func New(l *zap.Logger, s repository.Repository, opts ...Option) *Importer {
+	i := &Importer{
+		l:         l,
+		s:         s,
+		chunk:     DefaultChunk,
+		retention: DefaultRetention,
+		jobs:      make(map[string]*Job),
+	}
+	for _, opt := range opts {
+		opt(i)
+	}
+	return i
+}
+
+// Type of local pool jobs, temp file of job is available only for this instance
+func (i *Importer) Type() worker.Type {
+	return worker.Type{
+		Handler: func(ctx context.Context, _ string, batch []tasks.Task) (worker.Result, error) {
+			created := 0
+			for _, t := range batch {
+				n, err := i.handle(ctx, t)
+				if err != nil {
+					return worker.Result{Affected: created}, worker.Permanent(err)
+				}
+				created += n
+			}
+			return worker.Result{Affected: created}, nil
+		},
+		Local: true,
+	}
+}
+
+// Attach pool with registered type of importer, jobs are pushed to it
+func (i *Importer) Attach(p worker.IPool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.pool = p
+}
+
+// Start copy csv to temp file and push import job of user to pool. Returns id of job
+func (i *Importer) Start(userID user.UniqUser, r io.Reader) (string, error) {
+	f, err := os.CreateTemp("", "shortener-import-*.csv")
+	if err != nil {
+		return "", err
+	}
+	if _, err = io.Copy(f, r); err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		_ = f.Close()
+		_ = os.Remove(f.Name())
+		return "", err
+	}
+
+	// Worker opens file by name
+	if err = f.Close(); err != nil {
+		_ = os.Remove(f.Name())
+		return "", err
+	}
+
+	job := &Job{ID: uuid.NewString(), Status: StatusPending, StartedAt: time.Now(), userID: userID}
+	i.mu.Lock()
+	pool := i.pool
+	if i.closed || pool == nil {
+		i.mu.Unlock()
+		_ = os.Remove(f.Name())
+		return "", ErrClosed
+	}
+	i.cleanup(job.StartedAt)
+	i.jobs[job.ID] = job
+	i.mu.Unlock()
+
+	if _, err = pool.Enqueue(TypeImport, string(userID), task{ID: job.ID, File: f.Name()}); err != nil {
+		i.mu.Lock()
+		delete(i.jobs, job.ID)
+		i.mu.Unlock()
+		_ = os.Remove(f.Name())
+		if errors.Is(err, worker.ErrClosed) {
+			return "", ErrClosed
+		}
+		return "", err
+	}
+	return job.ID, nil
+}
+
+// Job copy of job state with rows of report from offset up to limit.
+// Job is visible only for its user
+func (i *Importer) Job(userID user.UniqUser, id string, offset, limit int) (Job, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	job, ok := i.jobs[id]
+	if !ok || job.userID != userID {
+		return Job{}, ErrJobNotFound
+	}
+	snapshot := *job
+	snapshot.Rows = []Row{}
+	if offset < len(job.Rows) {
+		end := len(job.Rows)
+		if limit > 0 && offset+limit < end {
+			end = offset + limit
+		}
+		snapshot.Rows = append(snapshot.Rows, job.Rows[offset:end]...)
+	}
+	return snapshot, nil
+}
+
+// Close stop accepting of jobs. Running jobs are stopped by pool
+func (i *Importer) Close() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.closed = true
+}
+
+// handle task of pool, temp file is removed after job. Returns count of created links
+func (i *Importer) handle(ctx context.Context, t tasks.Task) (int, error) {
+	var v task
+	if err := json.Unmarshal(t.Payload, &v); err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = os.Remove(v.File)
+	}()
+
+	i.mu.RLock()
+	job, ok := i.jobs[v.ID]
+	i.mu.RUnlock()
+	if !ok {
+		return 0, ErrJobNotFound
+	}
+	f, err := os.Open(v.File)
+	if err != nil {
+		i.finish(job, err)
+		return 0, err
+	}
+	defer f.Close()
+
+	if err = i.run(ctx, job, f); err != nil {
+		return 0, err
+	}
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return job.Created, nil
+}
+
+// run job from csv reader
+func (i *Importer) run(ctx context.Context, job *Job, r io.Reader) error {
+	i.update(job, func() {
+		job.Status = StatusRunning
+	})
+	err := i.process(ctx, job, r)
+	i.finish(job, err)
+	return err
+}
+
+// finish job with error of import
+func (i *Importer) finish(job *Job, err error) {
+	i.update(job, func() {
+		now := time.Now()
+		job.FinishedAt = &now
+		job.Status = StatusDone
+		if err != nil {
+			job.Status = StatusFailed
+			job.Error = err.Error()
+		}
+	})
+	i.l.Info("Import finished", zap.String("job", job.ID), zap.String("status", job.Status), zap.Error(err))
+}
+
+// process read csv by chunks and save them
+func (i *Importer) process(ctx context.Context, job *Job, r io.Reader) error {
+	// Existing links of user for duplicates report
+	existing := make(map[string]shortlink.Short)
+	err := i.s.WalkUser(ctx, job.userID, func(l shortlink.Link) error {
+		existing[l.Origin] = l.Short
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+
+	rows := make([]Row, 0, i.chunk)
+	for line := 1; ; line++ {
+		record, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		var parseErr *csv.ParseError
+		switch {
+		case errors.As(err, &parseErr):
+			rows = append(rows, Row{Line: line, Status: RowRejected, Reason: parseErr.Err.Error()})
+		case err != nil:
+			return err
+		case line == 1 && strings.EqualFold(strings.TrimSpace(record[0]), headerOrigin):
+			continue
+		default:
+			rows = append(rows, parseRow(line, record))
+		}
+		if len(rows) < i.chunk {
+			continue
+		}
+		if err = i.save(ctx, job, rows, existing); err != nil {
+			return err
+		}
+		rows = rows[:0]
+	}
+	return i.save(ctx, job, rows, existing)
+}
+
+// save chunk of rows and add them to report
+func (i *Importer) save(ctx context.Context, job *Job, rows []Row, existing map[string]shortlink.Short) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	// Rows for save and duplicates of them in chunk
+	var pending []*Row
+	repeats := make(map[*Row]*Row)
+	byOrigin := make(map[string]*Row)
+	for k := range rows {
+		row := &rows[k]
+		if row.Status == RowRejected {
+			continue
+		}
+		if short, ok := existing[row.Origin]; ok {
+			row.Status, row.Short = RowDuplicate, short
+			continue
+		}
+		if first, ok := byOrigin[row.Origin]; ok {
+			repeats[row] = first
+			continue
+		}
+		byOrigin[row.Origin] = row
+		pending = append(pending, row)
+	}
+
+	if err := i.bunchSave(ctx, job.userID, pending); err != nil {
+		return err
+	}
+	for row, first := range repeats {
+		row.Status, row.Short = RowDuplicate, first.Short
+		if first.Status == RowRejected {
+			row.Status, row.Reason = RowRejected, first.Reason
+		}
+	}
+	for _, row := range pending {
+		if row.Short != "" {
+			existing[row.Origin] = row.Short
+		}
+	}
+
+	i.update(job, func() {
+		for _, row := range rows {
+			job.Total++
+			switch row.Status {
+			case RowCreated:
+				job.Created++
+			case RowDuplicate:
+				job.Duplicates++
+			default:
+				job.Rejected++
+			}
+			job.Rows = append(job.Rows, row)
+		}
+	})
+	return nil
+}
+
+// bunchSave rows in one batch and set their statuses from results of items
+func (i *Importer) bunchSave(ctx context.Context, userID user.UniqUser, rows []*Row) error {
+	if len(rows) == 0 {
+		return nil
+	}
+	urls := make([]shortlink.URLs, 0, len(rows))
+	for _, row := range rows {
+		urls = append(urls, shortlink.URLs{ID: row.CorrelationID, Origin: row.Origin, Alias: row.Alias})
+	}
+	results, err := i.s.BunchSave(ctx, userID, urls, shortlink.BatchOptions{})
+	if err != nil {
+		return err
+	}
+	for k, row := range rows {
+		switch v := results[k]; v.Status {
+		case shortlink.ItemCreated:
+			row.Status, row.Short = RowCreated, shortlink.Short(v.Short)
+		case shortlink.ItemExisting:
+			// User saved origin while import
+			row.Status, row.Short = RowDuplicate, shortlink.Short(v.Short)
+		default:
+			row.Status, row.Reason = RowRejected, v.Reason
+		}
+	}
+	return nil
+}
+
+// update job under lock
+func (i *Importer) update(job *Job, fn func()) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	fn()
+}
+
+// cleanup remove finished jobs after retention. Must be called under lock
+func (i *Importer) cleanup(now time.Time) {
+	for id, job := range i.jobs {
+		if job.FinishedAt != nil && now.Sub(*job.FinishedAt) > i.retention {
+			delete(i.jobs, id)
+		}
+	}
+}
+
+// parseRow validate csv record of origin, correlation id and alias
+func parseRow(line int, record []string) Row {
+	row := Row{Line: line}
+	fields := make([]string, 3)
+	for k := 0; k < len(record) && k < len(fields); k++ {
+		fields[k] = strings.TrimSpace(record[k])
+	}
+	row.Origin, row.CorrelationID, row.Alias = fields[0], fields[1], fields[2]
+
+	reason := ""
+	switch {
+	case len(record) > len(fields):
+		reason = fmt.Sprintf("expected at most %d columns", len(fields))
+	case row.Origin == "":
+		reason = er.ErrUnknownURL.Error()
+	case len(row.Origin) > MaxOriginLength:
+		reason = fmt.Sprintf("url is longer than %d", MaxOriginLength)
+	case !validOrigin(row.Origin):
+		reason = er.ErrUnknownURL.Error()
+	case row.Alias != "":
+		if err := shortcode.ValidateAlias(row.Alias); err != nil {
+			reason = err.Error()
+		}
+	}
+	if reason != "" {
+		row.Status, row.Reason = RowRejected, reason
+	}
+	return row
+}
+
+// validOrigin check origin is absolute http url
+func validOrigin(origin string) bool {
+	u, err := url.Parse(origin)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
@@ -0,0 +1,110 @@
+package importer
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/helpers/worker"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/shortlink"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/file"
+)
+
+const csvData = `original_url,correlation_id,alias
+http://one.ru,1
+http://exists.ru,2
+not url,3
+http://alias.ru,4,my-alias
+http://taken.ru,5,taken
+http://one.ru,6
+http://bad-alias.ru,7,a
+"http://broken.ru
+`
+
+func TestImporter_Start(t *testing.T) {
+	ctx := context.Background()
+	s, err := file.New("")
+	require.NoError(t, err)
+	existing, err := s.Save(ctx, "user", "http://exists.ru", shortlink.Options{})
+	require.NoError(t, err)
+	_, err = s.Save(ctx, "other", "http://other.ru", shortlink.Options{Alias: "taken"})
+	require.NoError(t, err)
+
+	i := newImporter(t, s, WithChunk(3))
+	id, err := i.Start("user", strings.NewReader(csvData))
+	require.NoError(t, err)
+
+	job := wait(t, i, id)
+	assert.Equal(t, StatusDone, job.Status)
+	assert.Equal(t, 8, job.Total)
+	assert.Equal(t, 2, job.Created)
+	assert.Equal(t, 2, job.Duplicates)
+	assert.Equal(t, 4, job.Rejected)
+
+	statuses := make(map[string]Row)
+	for _, row := range job.Rows {
+		statuses[row.CorrelationID] = row
+	}
+	assert.Equal(t, RowCreated, statuses["1"].Status)
+	assert.Equal(t, existing, statuses["2"].Short)
+	assert.Equal(t, RowDuplicate, statuses["2"].Status)
+	assert.Equal(t, RowRejected, statuses["3"].Status)
+	assert.Equal(t, shortlink.Short("my-alias"), statuses["4"].Short)
+	assert.Equal(t, RowRejected, statuses["5"].Status)
+	// Repeat of row from previous chunk
+	assert.Equal(t, RowDuplicate, statuses["6"].Status)
+	assert.Equal(t, statuses["1"].Short, statuses["6"].Short)
+	assert.Equal(t, RowRejected, statuses["7"].Status)
+	assert.Equal(t, 9, job.Rows[len(job.Rows)-1].Line)
+
+	// Report is paged and visible only for owner
+	page, err := i.Job("user", id, 7, 5)
+	require.NoError(t, err)
+	assert.Len(t, page.Rows, 1)
+	_, err = i.Job("other", id, 0, 0)
+	assert.ErrorIs(t, err, ErrJobNotFound)
+}
+
+func TestImporter_Close(t *testing.T) {
+	s, err := file.New("")
+	require.NoError(t, err)
+	// Importer without pool doesn't accept jobs
+	i := New(zap.NewNop(), s)
+	_, err = i.Start("user", strings.NewReader(csvData))
+	assert.ErrorIs(t, err, ErrClosed)
+
+	i = newImporter(t, s)
+	i.Close()
+
+	_, err = i.Start("user", strings.NewReader(csvData))
+	assert.ErrorIs(t, err, ErrClosed)
+}
+
+// newImporter with pool of its jobs, pool is closed on test cleanup
+func newImporter(t *testing.T, s *file.UserStorage, opts ...Option) *Importer {
+	i := New(zap.NewNop(), s, opts...)
+	p, poolClose := worker.New(context.Background(), zap.NewNop(), worker.WithType(TypeImport, i.Type()))
+	i.Attach(p)
+	t.Cleanup(func() {
+		i.Close()
+		poolClose()
+	})
+	return i
+}
+
+// wait job finish
+func wait(t *testing.T, i *Importer, id string) Job {
+	var job Job
+	require.Eventually(t, func() bool {
+		var err error
+		job, err = i.Job("user", id, 0, 0)
+		require.NoError(t, err)
+		return job.FinishedAt != nil
+	}, 5*time.Second, 10*time.Millisecond)
+	return job
+}
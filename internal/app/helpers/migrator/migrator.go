@@ -0,0 +1,291 @@
+// Package migrator move links with owners between storages
+package migrator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"time"
+
+	"go.uber.org/zap"
+
+	er "github.com/triumphpc/go-musthave-shortener-tpl/internal/app/errors"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/shortlink"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/user"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/repository"
+)
+
+// DefaultBatch count of links imported in one batch
+const DefaultBatch = 500
+
+// ErrVerifyFailed if target storage has not all links of source or they differ
+var ErrVerifyFailed = errors.New("verification failed")
+
+// Report of migration or verification
+type Report struct {
+	// Users and Links walked in source
+	Users int `json:"users"`
+	Links int `json:"links"`
+	// Deleted links walked in source
+	Deleted int `json:"deleted"`
+	// Imported links saved in target
+	Imported int `json:"imported"`
+	// Existing links which target already has
+	Existing int `json:"existing"`
+	// Missing links of source which target has not, only for verification
+	Missing int `json:"missing,omitempty"`
+	// Mismatched links which differ in source and target, only for verification
+	Mismatched int `json:"mismatched,omitempty"`
+	// Extra links of target which source has not, only for verification
+	Extra int `json:"extra,omitempty"`
+}
+
+// Checkpoint last migrated position with report, saved after every batch
+type Checkpoint struct {
+	UserID user.UniqUser   `json:"user_id"`
+	Short  shortlink.Short `json:"short"`
+	Report Report          `json:"report"`
+}
+
+// Migrator stream links from source to target storage
+type Migrator struct {
+	src        repository.Repository
+	dst        repository.Repository
+	l          *zap.Logger
+	batch      int
+	checkpoint string
+	dryRun     bool
+}
+
+// Option configure Migrator
+type Option func(m *Migrator)
+
+// WithBatch set count of links imported in one batch
+func WithBatch(n int) Option {
+	return func(m *Migrator) {
+		if n > 0 {
+			m.batch = n
+		}
+	}
+}
+
+// WithCheckpoint set path of checkpoint file for resume
+func WithCheckpoint(path string) Option {
+	return func(m *Migrator) {
+		m.checkpoint = path
+	}
+}
+
+// WithDryRun walk source and check target without writes
+func WithDryRun(dryRun bool) Option {
+	return func(m *Migrator) {
+		m.dryRun = dryRun
+	}
+}
+
+// New Migrator from source to target storage
+func New(l *zap.Logger, src, dst repository.Repository, opts ...Option) *Migrator {
+	m := &Migrator{src: src, dst: dst, l: l, batch: DefaultBatch}
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// Migrate walk all links of source and import them to target by batches.
+// If resume is set, walk continue after position of checkpoint
+func (m *Migrator) Migrate(ctx context.Context, resume bool) (Report, error) {
+	var cp Checkpoint
+	if resume {
+		var err error
+		if cp, err = m.readCheckpoint(); err != nil {
+			return cp.Report, err
+		}
+		m.l.Info("Resume migration", zap.String("user", string(cp.UserID)), zap.String("short", string(cp.Short)))
+	}
+	after := shortlink.Position{UserID: cp.UserID, Short: cp.Short}
+	report := cp.Report
+	lastUser := cp.UserID
+
+	batch := make([]shortlink.Record, 0, m.batch)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if m.dryRun {
+			existing, err := m.existing(ctx, batch)
+			if err != nil {
+				return err
+			}
+			report.Existing += existing
+		} else {
+			imported, err := m.dst.Import(ctx, batch)
+			if err != nil {
+				return err
+			}
+			report.Imported += imported
+			report.Existing += len(batch) - imported
+		}
+		last := batch[len(batch)-1]
+		batch = batch[:0]
+		if m.dryRun {
+			return nil
+		}
+		return m.writeCheckpoint(Checkpoint{UserID: last.UserID, Short: last.Short, Report: report})
+	}
+
+	err := m.src.Walk(ctx, after, func(rec shortlink.Record) error {
+		report.Links++
+		if rec.Deleted {
+			report.Deleted++
+		}
+		if rec.UserID != lastUser || report.Users == 0 {
+			report.Users++
+			lastUser = rec.UserID
+		}
+		batch = append(batch, rec)
+		if len(batch) < m.batch {
+			return nil
+		}
+		if err := flush(); err != nil {
+			return err
+		}
+		m.l.Info("Batch migrated", zap.Int("links", report.Links), zap.Int("imported", report.Imported))
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	return report, err
+}
+
+// Verify walk source and target together and compare every link.
+// Both storages walk links in same order, so links are compared without loading all of them
+func (m *Migrator) Verify(ctx context.Context) (Report, error) {
+	var report Report
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	targets := make(chan shortlink.Record, m.batch)
+	walkErr := make(chan error, 1)
+	go func() {
+		defer close(targets)
+		walkErr <- m.dst.Walk(ctx, shortlink.Position{}, func(rec shortlink.Record) error {
+			select {
+			case targets <- rec:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+
+	target, ok := <-targets
+	var lastUser user.UniqUser
+	err := m.src.Walk(ctx, shortlink.Position{}, func(rec shortlink.Record) error {
+		report.Links++
+		if rec.Deleted {
+			report.Deleted++
+		}
+		if rec.UserID != lastUser || report.Users == 0 {
+			report.Users++
+			lastUser = rec.UserID
+		}
+		for ok && target.Position().Less(rec.Position()) {
+			report.Extra++
+			target, ok = <-targets
+		}
+		if !ok || rec.Position().Less(target.Position()) {
+			report.Missing++
+			m.l.Info("Link is missing", zap.String("user", string(rec.UserID)), zap.String("short", string(rec.Short)))
+			return nil
+		}
+		if !sameLink(rec.Link, target.Link) {
+			report.Mismatched++
+			m.l.Info("Link is mismatched", zap.String("user", string(rec.UserID)), zap.String("short", string(rec.Short)))
+		} else {
+			report.Existing++
+		}
+		target, ok = <-targets
+		return nil
+	})
+	if err != nil {
+		// Stop target walk before return
+		cancel()
+		for range targets {
+		}
+		<-walkErr
+		return report, err
+	}
+	for ok {
+		report.Extra++
+		target, ok = <-targets
+	}
+	if err = <-walkErr; err != nil {
+		return report, err
+	}
+	if report.Missing > 0 || report.Mismatched > 0 {
+		return report, ErrVerifyFailed
+	}
+	return report, nil
+}
+
+// existing count links of batch which target already has
+func (m *Migrator) existing(ctx context.Context, recs []shortlink.Record) (int, error) {
+	counter := 0
+	for _, rec := range recs {
+		_, err := m.dst.LinkByShort(ctx, rec.Short)
+		switch {
+		case errors.Is(err, er.ErrURLNotFound):
+		case err == nil, errors.Is(err, er.ErrURLIsGone), errors.Is(err, er.ErrURLExpired):
+			counter++
+		default:
+			return counter, err
+		}
+	}
+	return counter, nil
+}
+
+// readCheckpoint read checkpoint file, empty checkpoint if file not exists
+func (m *Migrator) readCheckpoint() (Checkpoint, error) {
+	var cp Checkpoint
+	if m.checkpoint == "" {
+		return cp, nil
+	}
+	data, err := os.ReadFile(m.checkpoint)
+	if errors.Is(err, os.ErrNotExist) {
+		return cp, nil
+	}
+	if err != nil {
+		return cp, err
+	}
+	return cp, json.Unmarshal(data, &cp)
+}
+
+// writeCheckpoint replace checkpoint file atomically
+func (m *Migrator) writeCheckpoint(cp Checkpoint) error {
+	if m.checkpoint == "" {
+		return nil
+	}
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	tmp := m.checkpoint + ".tmp"
+	if err = os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.checkpoint)
+}
+
+// sameLink compare links of storages. Time is compared with microseconds,
+// because PostgreSQL keep time in microseconds
+func sameLink(a, b shortlink.Link) bool {
+	return a.Short == b.Short &&
+		a.Origin == b.Origin &&
+		a.CorrelationID == b.CorrelationID &&
+		a.Deleted == b.Deleted &&
+		a.CreatedAt.Truncate(time.Microsecond).Equal(b.CreatedAt.Truncate(time.Microsecond)) &&
+		a.ExpiresAt.Truncate(time.Microsecond).Equal(b.ExpiresAt.Truncate(time.Microsecond))
+}
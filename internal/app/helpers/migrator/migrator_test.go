@@ -0,0 +1,148 @@
+package migrator
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	er "github.com/triumphpc/go-musthave-shortener-tpl/internal/app/errors"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/shortlink"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/bolt"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/file"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/repository"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/repository/repositorytest"
+)
+
+var errImport = errors.New("import failed")
+
+// failingStorage fail import after count of batches
+type failingStorage struct {
+	repository.Repository
+	batches int
+}
+
+func (s *failingStorage) Import(ctx context.Context, recs []shortlink.Record) (int, error) {
+	if s.batches == 0 {
+		return 0, errImport
+	}
+	s.batches--
+	return s.Repository.Import(ctx, recs)
+}
+
+// source file storage with links of two users, one link is deleted
+func source(t *testing.T) *file.UserStorage {
+	ctx := context.Background()
+	src, err := file.New("")
+	require.NoError(t, err)
+	_, err = src.BunchSave(ctx, "first", []shortlink.URLs{
+		{ID: "1", Origin: "http://one.ru"},
+		{ID: "2", Origin: "http://two.ru"},
+		{ID: "3", Origin: "http://three.ru", Alias: "three"},
+	}, shortlink.BatchOptions{})
+	require.NoError(t, err)
+	_, err = src.Save(ctx, "second", "http://one.ru", shortlink.Options{ExpiresAt: time.Now().Add(time.Hour)})
+	require.NoError(t, err)
+	_, err = src.BunchUpdateAsDeleted(ctx, []string{"2"}, "first")
+	require.NoError(t, err)
+	return src
+}
+
+func TestMigrator_Migrate(t *testing.T) {
+	ctx := context.Background()
+	src := source(t)
+	dst, err := bolt.New(filepath.Join(t.TempDir(), "links.db"))
+	require.NoError(t, err)
+	defer dst.Close()
+
+	report, err := New(zap.NewNop(), src, dst, WithBatch(3)).Migrate(ctx, false)
+	require.NoError(t, err)
+	assert.Equal(t, Report{Users: 2, Links: 4, Deleted: 1, Imported: 4}, report)
+
+	// Shorts, owners and flags are kept
+	origin, err := dst.LinkByShort(ctx, "three")
+	require.NoError(t, err)
+	assert.Equal(t, "http://three.ru", origin)
+	page, err := dst.LinksPage(ctx, "first", shortlink.Query{Status: shortlink.StatusDeleted})
+	require.NoError(t, err)
+	require.Len(t, page.Links, 1)
+	assert.Equal(t, "2", page.Links[0].CorrelationID)
+
+	report, err = New(zap.NewNop(), src, dst).Verify(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 4, report.Existing)
+
+	// Second run skips existing links
+	report, err = New(zap.NewNop(), src, dst).Migrate(ctx, false)
+	require.NoError(t, err)
+	assert.Equal(t, 0, report.Imported)
+	assert.Equal(t, 4, report.Existing)
+}
+
+func TestMigrator_DryRun(t *testing.T) {
+	ctx := context.Background()
+	src := source(t)
+	dst, err := file.New("")
+	require.NoError(t, err)
+	_, err = dst.Import(ctx, []shortlink.Record{{UserID: "first", Link: shortlink.Link{Short: "three", Origin: "http://three.ru"}}})
+	require.NoError(t, err)
+
+	report, err := New(zap.NewNop(), src, dst, WithDryRun(true)).Migrate(ctx, false)
+	require.NoError(t, err)
+	assert.Equal(t, 4, report.Links)
+	assert.Equal(t, 1, report.Existing)
+	repositorytest.AssertCount(t, 1, dst.URLCount)
+}
+
+func TestMigrator_Resume(t *testing.T) {
+	ctx := context.Background()
+	src := source(t)
+	dst, err := file.New("")
+	require.NoError(t, err)
+	checkpoint := filepath.Join(t.TempDir(), "checkpoint.json")
+
+	// Fail after first batch
+	m := New(zap.NewNop(), src, &failingStorage{Repository: dst, batches: 1}, WithBatch(2), WithCheckpoint(checkpoint))
+	_, err = m.Migrate(ctx, false)
+	assert.ErrorIs(t, err, errImport)
+	repositorytest.AssertCount(t, 2, dst.URLCount)
+
+	report, err := New(zap.NewNop(), src, dst, WithBatch(2), WithCheckpoint(checkpoint)).Migrate(ctx, true)
+	require.NoError(t, err)
+	assert.Equal(t, Report{Users: 2, Links: 4, Deleted: 1, Imported: 4}, report)
+	repositorytest.AssertCount(t, 4, dst.URLCount)
+}
+
+func TestMigrator_Verify(t *testing.T) {
+	ctx := context.Background()
+	src := source(t)
+	dst, err := file.New("")
+	require.NoError(t, err)
+
+	var recs []shortlink.Record
+	require.NoError(t, src.Walk(ctx, shortlink.Position{}, func(rec shortlink.Record) error {
+		recs = append(recs, rec)
+		return nil
+	}))
+	// One link is lost, one is changed and one is unknown
+	recs[1].Deleted = !recs[1].Deleted
+	recs = append(recs[1:], shortlink.Record{UserID: "third", Link: shortlink.Link{Short: "extra", Origin: "http://extra.ru"}})
+	_, err = dst.Import(ctx, recs)
+	require.NoError(t, err)
+
+	report, err := New(zap.NewNop(), src, dst).Verify(ctx)
+	assert.ErrorIs(t, err, ErrVerifyFailed)
+	assert.Equal(t, 1, report.Missing)
+	assert.Equal(t, 1, report.Mismatched)
+	assert.Equal(t, 1, report.Extra)
+	assert.Equal(t, 2, report.Existing)
+
+	// Conflict with other link fail import
+	_, err = dst.Import(ctx, []shortlink.Record{{UserID: "other", Link: shortlink.Link{Short: "extra", Origin: "http://other.ru"}}})
+	assert.ErrorIs(t, err, er.ErrImportConflict)
+}
@@ -0,0 +1,239 @@
+// Package recorder implement asynchronous buffered writing of redirect clicks
+package recorder
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/helpers/worker"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/click"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/shortlink"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/clicks"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/tasks"
+)
+
+// TypeFlush of local pool jobs saving buffered clicks
+const TypeFlush = "flush-clicks"
+
+// Defaults of recorder
+const (
+	DefaultBuffer        = 1024
+	DefaultBatch         = 100
+	DefaultFlushInterval = time.Second
+	DefaultFlushTimeout  = 5 * time.Second
+)
+
+// Recorder collect clicks in buffer and save them to store by batches in jobs of pool.
+// Record never block, so clicks are dropped when buffer is full
+type Recorder struct {
+	l        *zap.Logger
+	store    clicks.Store
+	events   chan click.Click
+	batch    int
+	interval time.Duration
+	timeout  time.Duration
+
+	mu      sync.RWMutex
+	closed  bool
+	pool    worker.IPool
+	dropped uint64
+	// Flush job is pushed after full batch and not started yet
+	pushed int32
+}
+
+// Option configure Recorder
+type Option func(r *Recorder)
+
+// WithBuffer set size of events buffer
+func WithBuffer(size int) Option {
+	return func(r *Recorder) {
+		r.events = make(chan click.Click, size)
+	}
+}
+
+// WithBatch set max count of events in one save
+func WithBatch(size int) Option {
+	return func(r *Recorder) {
+		r.batch = size
+	}
+}
+
+// WithFlushInterval set max time of event in buffer before save
+func WithFlushInterval(interval time.Duration) Option {
+	return func(r *Recorder) {
+		r.interval = interval
+	}
+}
+
+// New recorder to store. Its type is registered in pool, then pool is attached
+func New(l *zap.Logger, store clicks.Store, opts ...Option) *Recorder {
+	r := &Recorder{
+		l:        l,
+		store:    store,
+		events:   make(chan click.Click, DefaultBuffer),
+		batch:    DefaultBatch,
+		interval: DefaultFlushInterval,
+		timeout:  DefaultFlushTimeout,
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	if r.batch <= 0 {
+		r.batch = DefaultBatch
+	}
+	if r.interval <= 0 {
+		r.interval = DefaultFlushInterval
+	}
+	return r
+}
+
+// Type of local pool jobs saving buffered clicks by flush interval, buffer is available only for this instance
+func (r *Recorder) Type() worker.Type {
+	return worker.Type{
+		Handler: func(ctx context.Context, _ string, _ []tasks.Task) (worker.Result, error) {
+			atomic.StoreInt32(&r.pushed, 0)
+			return worker.Result{Affected: r.drain(ctx, len(r.events))}, nil
+		},
+		Concurrency: 1,
+		Every:       r.interval,
+		Local:       true,
+	}
+}
+
+// Attach pool with registered type of recorder, flush jobs of full batches are pushed to it
+func (r *Recorder) Attach(p worker.IPool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.pool = p
+}
+
+// Record put click to buffer. Returns false if click is dropped
+func (r *Recorder) Record(c click.Click) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if r.closed {
+		atomic.AddUint64(&r.dropped, 1)
+		return false
+	}
+	select {
+	case r.events <- c:
+	default:
+		atomic.AddUint64(&r.dropped, 1)
+		return false
+	}
+	// Full batch is saved without wait of interval
+	if len(r.events) >= r.batch && r.pool != nil && atomic.CompareAndSwapInt32(&r.pushed, 0, 1) {
+		if _, err := r.pool.Enqueue(TypeFlush, worker.SystemUser, nil); err != nil {
+			atomic.StoreInt32(&r.pushed, 0)
+		}
+	}
+	return true
+}
+
+// Dropped count of clicks which were not buffered
+func (r *Recorder) Dropped() uint64 {
+	return atomic.LoadUint64(&r.dropped)
+}
+
+// Close stop recording and save buffered clicks
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.events)
+	}
+	r.mu.Unlock()
+
+	r.drain(context.Background(), len(r.events))
+	return nil
+}
+
+// drain save up to n buffered events by batches. Returns count of saved events
+func (r *Recorder) drain(ctx context.Context, n int) int {
+	saved := 0
+	buf := make([]click.Click, 0, r.batch)
+	for ; n > 0; n-- {
+		c, ok := r.next()
+		if !ok {
+			break
+		}
+		buf = append(buf, c)
+		if len(buf) >= r.batch {
+			saved += r.flush(ctx, buf)
+			buf = buf[:0]
+		}
+	}
+	return saved + r.flush(ctx, buf)
+}
+
+// next buffered event without wait
+func (r *Recorder) next() (click.Click, bool) {
+	select {
+	case c, ok := <-r.events:
+		return c, ok
+	default:
+		return click.Click{}, false
+	}
+}
+
+// flush save events. Failed events are dropped. Returns count of saved events
+func (r *Recorder) flush(ctx context.Context, buf []click.Click) int {
+	if len(buf) == 0 {
+		return 0
+	}
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	if err := r.store.Save(ctx, buf); err != nil {
+		r.l.Info("Clicks save error", zap.Error(err), zap.Int("count", len(buf)))
+		atomic.AddUint64(&r.dropped, uint64(len(buf)))
+		return 0
+	}
+	return len(buf)
+}
+
+// NewClick make click event of request to short
+func NewClick(r *http.Request, short shortlink.Short) click.Click {
+	return click.Click{
+		At:        time.Now().UTC(),
+		Short:     short,
+		Referrer:  r.Referer(),
+		UserAgent: r.UserAgent(),
+		IPHash:    HashIP(clientIP(r)),
+	}
+}
+
+// HashIP hash of client ip, so raw address is not stored
+func HashIP(ip string) string {
+	if ip == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(ip))
+	return hex.EncodeToString(sum[:])
+}
+
+// clientIP get ip from X-Real-IP, X-Forwarded-For or remote address
+func clientIP(r *http.Request) string {
+	if ip := net.ParseIP(r.Header.Get("X-Real-IP")); ip != nil {
+		return ip.String()
+	}
+	forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	if ip := net.ParseIP(strings.TrimSpace(forwarded[0])); ip != nil {
+		return ip.String()
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,70 @@
+package recorder
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/helpers/worker"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/click"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/clicks"
+)
+
+func TestRecorder_Record(t *testing.T) {
+	store, err := clicks.NewFileStore("", "", 0)
+	require.NoError(t, err)
+
+	r := New(zap.NewNop(), store, WithBatch(2), WithFlushInterval(time.Hour))
+	day := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 3; i++ {
+		assert.True(t, r.Record(click.Click{At: day, Short: "short"}))
+	}
+	assert.True(t, r.Record(click.Click{At: day.AddDate(0, 0, 1), Short: "short"}))
+	// Close flush rest of buffer
+	require.NoError(t, r.Close())
+	assert.False(t, r.Record(click.Click{At: day, Short: "short"}))
+	assert.Equal(t, uint64(1), r.Dropped())
+
+	days, err := store.Daily(context.Background(), "short")
+	require.NoError(t, err)
+	assert.Equal(t, []click.DailyCount{{Day: "2026-10-18", Count: 3}, {Day: "2026-10-19", Count: 1}}, days)
+}
+
+func TestRecorder_Type(t *testing.T) {
+	ctx := context.Background()
+	store, err := clicks.NewFileStore("", "", 0)
+	require.NoError(t, err)
+
+	r := New(zap.NewNop(), store, WithBatch(2), WithFlushInterval(time.Hour))
+	p, poolClose := worker.New(ctx, zap.NewNop(), worker.WithType(TypeFlush, r.Type()))
+	defer poolClose()
+	r.Attach(p)
+
+	// Full batch is saved by job of pool before flush interval
+	day := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 2; i++ {
+		assert.True(t, r.Record(click.Click{At: day, Short: "short"}))
+	}
+	require.Eventually(t, func() bool {
+		days, err := store.Daily(ctx, "short")
+		require.NoError(t, err)
+		return len(days) == 1 && days[0].Count == 2
+	}, 5*time.Second, 10*time.Millisecond)
+	require.NoError(t, r.Close())
+}
+
+func TestNewClick(t *testing.T) {
+	r := httptest.NewRequest("GET", "/short", nil)
+	r.Header.Set("Referer", "http://ref.ru")
+	r.Header.Set("X-Real-IP", "10.0.0.1")
+
+	c := NewClick(r, "short")
+	assert.Equal(t, "http://ref.ru", c.Referrer)
+	assert.Equal(t, HashIP("10.0.0.1"), c.IPHash)
+	assert.NotContains(t, c.IPHash, "10.0.0.1")
+}
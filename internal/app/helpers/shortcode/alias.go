@@ -0,0 +1,51 @@
+package shortcode
+
+import (
+	"fmt"
+	"strings"
+
+	er "github.com/triumphpc/go-musthave-shortener-tpl/internal/app/errors"
+)
+
+// Alias length limits
+const (
+	AliasMinLength = 3
+	AliasMaxLength = 50
+)
+
+// AliasAlphabet allowed symbols of custom short codes
+const AliasAlphabet = Base62 + "-_"
+
+// reservedAliases can't be used as custom short codes, because they are route prefixes
+var reservedAliases = map[string]struct{}{
+	"api":          {},
+	"ping":         {},
+	"user":         {},
+	"urls":         {},
+	"internal":     {},
+	"shorten":      {},
+	"batch":        {},
+	"stats":        {},
+	"debug":        {},
+	"jobs":         {},
+	"import":       {},
+	"export":       {},
+	"restore":      {},
+	"dead-letters": {},
+}
+
+// ValidateAlias check custom short code. Error wraps er.ErrInvalidAlias with reason
+func ValidateAlias(alias string) error {
+	if len(alias) < AliasMinLength || len(alias) > AliasMaxLength {
+		return fmt.Errorf("%w: length must be from %d to %d", er.ErrInvalidAlias, AliasMinLength, AliasMaxLength)
+	}
+	for _, r := range alias {
+		if !strings.ContainsRune(AliasAlphabet, r) {
+			return fmt.Errorf("%w: symbol %q is not allowed", er.ErrInvalidAlias, r)
+		}
+	}
+	if _, ok := reservedAliases[strings.ToLower(alias)]; ok {
+		return fmt.Errorf("%w: %s is reserved", er.ErrInvalidAlias, alias)
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package shortcode
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	er "github.com/triumphpc/go-musthave-shortener-tpl/internal/app/errors"
+)
+
+func TestValidateAlias(t *testing.T) {
+	tests := []struct {
+		alias string
+		valid bool
+	}{
+		{"my-link_1", true},
+		{"ab", false},
+		{strings.Repeat("a", AliasMaxLength+1), false},
+		{"bad/alias", false},
+		{"кириллица", false},
+		{"API", false},
+		{"stats", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.alias, func(t *testing.T) {
+			err := ValidateAlias(tt.alias)
+			if tt.valid {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, er.ErrInvalidAlias)
+		})
+	}
+}
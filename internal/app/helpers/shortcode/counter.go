@@ -0,0 +1,81 @@
+package shortcode
+
+import (
+	"context"
+	"sync"
+)
+
+// DefaultBlock count of counter values reserved in storage by one call
+const DefaultBlock = 1000
+
+// Reserve block of size counter values in storage and return first of them.
+// Blocks must never overlap, also after restart and for other instances
+type Reserve func(ctx context.Context, size uint64) (uint64, error)
+
+// Counter of sequence strategies. Without storage it counts from start in memory.
+// Storage make it durable: embedded storage seed it by max persisted value,
+// shared database give it blocks of values, so codes don't repeat after restart and across instances
+type Counter struct {
+	mu sync.Mutex
+	// next value to give
+	next uint64
+	// end of reserved block, values from next to end are free
+	end     uint64
+	block   uint64
+	reserve Reserve
+}
+
+// NewCounter local counter, first value is next after start
+func NewCounter(start uint64) *Counter {
+	return &Counter{next: start + 1}
+}
+
+// Next value of counter. Block is reserved in storage when current one is over
+func (c *Counter) Next() (uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.reserve != nil && c.next >= c.end {
+		first, err := c.reserve(context.Background(), c.block)
+		if err != nil {
+			return 0, err
+		}
+		c.next, c.end = first, first+c.block
+	}
+	n := c.next
+	c.next++
+	return n, nil
+}
+
+// Value last given value of counter, storage persist it as max used value
+func (c *Counter) Value() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.next == 0 {
+		return 0
+	}
+	return c.next - 1
+}
+
+// Seed counter by max persisted value, next values are after it
+func (c *Counter) Seed(value uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if value >= c.next {
+		c.next = value + 1
+	}
+}
+
+// Reserve values by blocks of size in storage. Current local values are dropped
+func (c *Counter) Reserve(reserve Reserve, block uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if block == 0 {
+		block = DefaultBlock
+	}
+	c.reserve, c.block = reserve, block
+	c.next, c.end = 0, 0
+}
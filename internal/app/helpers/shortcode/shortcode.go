@@ -0,0 +1,229 @@
+// Package shortcode implement strategies of short code generation and retry on collisions
+package shortcode
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/shortlink"
+)
+
+// Base62 default alphabet of short codes
+const Base62 = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789abcdefghijklmnopqrstuvwxyz"
+
+// Strategies names
+const (
+	StrategyRandom     = "random"
+	StrategySequence   = "sequence"
+	StrategyHash       = "hash"
+	StrategyObfuscated = "obfuscated"
+)
+
+// Defaults for allocator
+const (
+	DefaultLength    = 10
+	DefaultAttempts  = 10
+	DefaultWindow    = 1000
+	DefaultThreshold = 0.1
+	DefaultMaxLength = 32
+)
+
+// ErrCollision must be returned by save function if short already used
+var ErrCollision = errors.New("short code collision")
+
+// ErrAttemptsExhausted if all attempts had collisions
+var ErrAttemptsExhausted = errors.New("short code attempts exhausted")
+
+// ErrUnknownStrategy for unknown name of strategy
+var ErrUnknownStrategy = errors.New("unknown short code strategy")
+
+// ErrBadAlphabet if alphabet too short or has repeated symbols
+var ErrBadAlphabet = errors.New("bad short code alphabet")
+
+// ShortCodeGenerator generate short code for origin link
+type ShortCodeGenerator interface {
+	// Generate code of length for origin. Attempt is number of try after collisions, starting from zero
+	Generate(origin string, length int, attempt int) (string, error)
+}
+
+// NewStrategy make generator by strategy name
+func NewStrategy(name, alphabet string) (ShortCodeGenerator, error) {
+	if err := validateAlphabet(alphabet); err != nil {
+		return nil, err
+	}
+	switch name {
+	case StrategyRandom, "":
+		return NewRandom(alphabet), nil
+	case StrategySequence:
+		return NewSequence(alphabet, 0), nil
+	case StrategyHash:
+		return NewHash(alphabet), nil
+	case StrategyObfuscated:
+		return NewObfuscated(alphabet, 0), nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownStrategy, name)
+}
+
+// Allocator generate short codes by strategy and retry on collisions.
+// When share of collisions in window of tries is higher than threshold, length of codes grows
+type Allocator struct {
+	gen       ShortCodeGenerator
+	length    int64
+	attempts  int
+	window    int
+	threshold float64
+	maxLength int
+
+	mu         sync.Mutex
+	tries      int
+	collisions int
+}
+
+// Option configure Allocator
+type Option func(a *Allocator)
+
+// WithLength set initial length of codes
+func WithLength(length int) Option {
+	return func(a *Allocator) {
+		a.length = int64(length)
+	}
+}
+
+// WithAttempts set count of tries before ErrAttemptsExhausted
+func WithAttempts(attempts int) Option {
+	return func(a *Allocator) {
+		a.attempts = attempts
+	}
+}
+
+// WithGrowth set window of tries, collision rate threshold and max length for growth
+func WithGrowth(window int, threshold float64, maxLength int) Option {
+	return func(a *Allocator) {
+		a.window = window
+		a.threshold = threshold
+		a.maxLength = maxLength
+	}
+}
+
+// New Allocator for generator
+func New(gen ShortCodeGenerator, opts ...Option) *Allocator {
+	a := &Allocator{
+		gen:       gen,
+		length:    DefaultLength,
+		attempts:  DefaultAttempts,
+		window:    DefaultWindow,
+		threshold: DefaultThreshold,
+		maxLength: DefaultMaxLength,
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	return a
+}
+
+// Default Allocator with crypto random base62 codes
+func Default() *Allocator {
+	return New(NewRandom(Base62))
+}
+
+// Counter of generator, nil for strategies without counter
+func (a *Allocator) Counter() *Counter {
+	if g, ok := a.gen.(interface{ Counter() *Counter }); ok {
+		return g.Counter()
+	}
+	return nil
+}
+
+// Length current length of codes
+func (a *Allocator) Length() int {
+	return int(atomic.LoadInt64(&a.length))
+}
+
+// Allocate generate short for origin and pass it to save.
+// If save return ErrCollision, next code is generated
+func (a *Allocator) Allocate(origin string, save func(short shortlink.Short) error) (shortlink.Short, error) {
+	for attempt := 0; attempt < a.attempts; attempt++ {
+		code, err := a.gen.Generate(origin, a.Length(), attempt)
+		if err != nil {
+			return "", err
+		}
+		short := shortlink.Short(code)
+		err = save(short)
+		a.track(errors.Is(err, ErrCollision))
+		if errors.Is(err, ErrCollision) {
+			continue
+		}
+		return short, err
+	}
+	return "", ErrAttemptsExhausted
+}
+
+// AllocateBunch generate shorts for origins and pass them to save by one call per attempt.
+// Save get all shorts and indexes of them to try, it returns indexes which had collisions.
+// Collided origins get next codes, indexes of origins without free code after all attempts are returned as exhausted
+func (a *Allocator) AllocateBunch(origins []string, save func(shorts []shortlink.Short, pending []int) ([]int, error)) ([]shortlink.Short, []int, error) {
+	shorts := make([]shortlink.Short, len(origins))
+	pending := make([]int, len(origins))
+	for k := range pending {
+		pending[k] = k
+	}
+	for attempt := 0; attempt < a.attempts && len(pending) > 0; attempt++ {
+		for _, k := range pending {
+			code, err := a.gen.Generate(origins[k], a.Length(), attempt)
+			if err != nil {
+				return nil, nil, err
+			}
+			shorts[k] = shortlink.Short(code)
+		}
+		collisions, err := save(shorts, pending)
+		if err != nil {
+			return nil, nil, err
+		}
+		for i := 0; i < len(pending); i++ {
+			a.track(i < len(collisions))
+		}
+		pending = collisions
+	}
+	for _, k := range pending {
+		shorts[k] = ""
+	}
+	return shorts, pending, nil
+}
+
+// track collision statistic and grow length on high rate
+func (a *Allocator) track(collision bool) {
+	if a.window <= 0 {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.tries++
+	if collision {
+		a.collisions++
+	}
+	if a.tries < a.window {
+		return
+	}
+	if float64(a.collisions)/float64(a.tries) > a.threshold && a.Length() < a.maxLength {
+		atomic.AddInt64(&a.length, 1)
+	}
+	a.tries, a.collisions = 0, 0
+}
+
+// validateAlphabet check alphabet has at least two uniq symbols
+func validateAlphabet(alphabet string) error {
+	if len(alphabet) < 2 {
+		return ErrBadAlphabet
+	}
+	seen := make(map[byte]struct{}, len(alphabet))
+	for i := 0; i < len(alphabet); i++ {
+		if _, ok := seen[alphabet[i]]; ok {
+			return ErrBadAlphabet
+		}
+		seen[alphabet[i]] = struct{}{}
+	}
+	return nil
+}
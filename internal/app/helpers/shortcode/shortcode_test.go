@@ -0,0 +1,173 @@
+package shortcode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/shortlink"
+)
+
+func TestNewStrategy(t *testing.T) {
+	for _, name := range []string{StrategyRandom, StrategySequence, StrategyHash, StrategyObfuscated} {
+		t.Run(name, func(t *testing.T) {
+			gen, err := NewStrategy(name, "abc")
+			require.NoError(t, err)
+			if name == StrategySequence {
+				gen = NewSequence("abc", 1)
+			}
+
+			seen := map[string]struct{}{}
+			for i := 0; i < 20; i++ {
+				code, err := gen.Generate(fmt.Sprintf("http://%d.ru", i), 8, 0)
+				require.NoError(t, err)
+				assert.Len(t, code, 8)
+				assert.Empty(t, strings.Trim(code, "abc"), "symbols out of alphabet")
+				seen[code] = struct{}{}
+			}
+			assert.Greater(t, len(seen), 1)
+		})
+	}
+
+	_, err := NewStrategy("unknown", Base62)
+	assert.ErrorIs(t, err, ErrUnknownStrategy)
+	_, err = NewStrategy(StrategyRandom, "aa")
+	assert.ErrorIs(t, err, ErrBadAlphabet)
+}
+
+func TestHash_Generate(t *testing.T) {
+	gen := NewHash(Base62)
+
+	first, _ := gen.Generate("http://test.ru", 64, 0)
+	second, _ := gen.Generate("http://test.ru", 64, 0)
+	retry, _ := gen.Generate("http://test.ru", 64, 1)
+
+	assert.Len(t, first, 64)
+	assert.Equal(t, first, second)
+	assert.NotEqual(t, first, retry)
+}
+
+func TestObfuscated_Generate(t *testing.T) {
+	gen := NewObfuscated("01", 1)
+
+	// All codes in ring of length 4 are uniq
+	seen := map[string]struct{}{}
+	for i := 0; i < 16; i++ {
+		code, _ := gen.Generate("", 4, 0)
+		seen[code] = struct{}{}
+	}
+	assert.Len(t, seen, 16)
+}
+
+func TestCounter_Seed(t *testing.T) {
+	gen := NewSequence("01", 0)
+	gen.Counter().Seed(5)
+
+	code, err := gen.Generate("", 4, 0)
+	require.NoError(t, err)
+	assert.Equal(t, "0110", code)
+	assert.Equal(t, uint64(6), gen.Counter().Value())
+
+	// Lower persisted value don't move counter back
+	gen.Counter().Seed(2)
+	code, _ = gen.Generate("", 4, 0)
+	assert.Equal(t, "0111", code)
+}
+
+func TestCounter_Reserve(t *testing.T) {
+	gen := NewSequence("01", 0)
+	a := New(gen)
+	require.Same(t, gen.Counter(), a.Counter())
+	assert.Nil(t, Default().Counter())
+
+	var stored uint64 = 8
+	var calls int
+	a.Counter().Reserve(func(_ context.Context, size uint64) (uint64, error) {
+		calls++
+		first := stored
+		stored += size
+		return first, nil
+	}, 2)
+
+	var codes []string
+	for i := 0; i < 3; i++ {
+		code, err := gen.Generate("", 4, 0)
+		require.NoError(t, err)
+		codes = append(codes, code)
+	}
+	assert.Equal(t, []string{"1000", "1001", "1010"}, codes)
+	assert.Equal(t, 2, calls)
+
+	// Error of storage is returned by generator
+	boom := errors.New("boom")
+	gen.Counter().Reserve(func(context.Context, uint64) (uint64, error) {
+		return 0, boom
+	}, 2)
+	_, err := gen.Generate("", 4, 0)
+	assert.ErrorIs(t, err, boom)
+}
+
+func TestAllocator_Allocate(t *testing.T) {
+	a := New(NewSequence(Base62, 1), WithAttempts(3))
+
+	// Retry after collision
+	calls := 0
+	short, err := a.Allocate("http://test.ru", func(short shortlink.Short) error {
+		calls++
+		if calls == 1 {
+			return ErrCollision
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, calls)
+	assert.Len(t, string(short), DefaultLength)
+
+	// All attempts have collisions
+	_, err = a.Allocate("http://test.ru", func(short shortlink.Short) error {
+		return ErrCollision
+	})
+	assert.ErrorIs(t, err, ErrAttemptsExhausted)
+}
+
+func TestAllocator_AllocateBunch(t *testing.T) {
+	a := New(NewSequence(Base62, 1), WithAttempts(3))
+	origins := []string{"http://one.ru", "http://two.ru", "http://three.ru"}
+
+	// Second origin collides once, third collides on all attempts
+	calls := 0
+	shorts, exhausted, err := a.AllocateBunch(origins, func(shorts []shortlink.Short, pending []int) ([]int, error) {
+		calls++
+		var collisions []int
+		for _, k := range pending {
+			if k == 2 || (k == 1 && calls == 1) {
+				collisions = append(collisions, k)
+			}
+		}
+		return collisions, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, []int{2}, exhausted)
+	assert.Len(t, string(shorts[0]), DefaultLength)
+	assert.Len(t, string(shorts[1]), DefaultLength)
+	assert.NotEqual(t, shorts[0], shorts[1])
+	assert.Empty(t, shorts[2])
+}
+
+func TestAllocator_Growth(t *testing.T) {
+	a := New(NewRandom(Base62), WithLength(4), WithAttempts(2), WithGrowth(10, 0.3, 5))
+
+	for i := 0; i < 20; i++ {
+		_, _ = a.Allocate("http://test.ru", func(short shortlink.Short) error {
+			return ErrCollision
+		})
+	}
+	// Length grows only to max
+	assert.Equal(t, 5, a.Length())
+}
@@ -0,0 +1,149 @@
+package shortcode
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"math/big"
+	"strconv"
+)
+
+// Random crypto random codes
+type Random struct {
+	alphabet string
+}
+
+// NewRandom generator of random codes
+func NewRandom(alphabet string) *Random {
+	return &Random{alphabet}
+}
+
+// Generate random code. Bytes out of alphabet range are dropped for uniform distribution
+func (g *Random) Generate(_ string, length int, _ int) (string, error) {
+	size := len(g.alphabet)
+	limit := 256 - 256%size
+	code := make([]byte, 0, length)
+	buf := make([]byte, length)
+	for len(code) < length {
+		if _, err := rand.Read(buf); err != nil {
+			return "", err
+		}
+		for _, b := range buf {
+			if int(b) >= limit {
+				continue
+			}
+			code = append(code, g.alphabet[int(b)%size])
+			if len(code) == length {
+				break
+			}
+		}
+	}
+	return string(code), nil
+}
+
+// Sequence codes of counter in alphabet base. Length is minimal, codes get longer
+// when counter outgrows it. Storage make counter durable, so codes don't repeat after restart
+type Sequence struct {
+	alphabet string
+	counter  *Counter
+}
+
+// NewSequence generator of sequence codes, counter starts after start
+func NewSequence(alphabet string, start uint64) *Sequence {
+	return &Sequence{alphabet: alphabet, counter: NewCounter(start)}
+}
+
+// Counter of sequence
+func (g *Sequence) Counter() *Counter {
+	return g.counter
+}
+
+// Generate next code of sequence
+func (g *Sequence) Generate(_ string, length int, _ int) (string, error) {
+	n, err := g.counter.Next()
+	if err != nil {
+		return "", err
+	}
+	return encode(new(big.Int).SetUint64(n), g.alphabet, length), nil
+}
+
+// Hash codes from sha256 of origin. Same origin get same code on first attempt
+type Hash struct {
+	alphabet string
+}
+
+// NewHash generator of hash codes
+func NewHash(alphabet string) *Hash {
+	return &Hash{alphabet}
+}
+
+// Generate code from hash of origin and attempt
+func (g *Hash) Generate(origin string, length int, attempt int) (string, error) {
+	base := big.NewInt(int64(len(g.alphabet)))
+	code := make([]byte, 0, length)
+	// Extend digest by blocks if code is longer than one hash
+	for block := 0; len(code) < length; block++ {
+		sum := sha256.Sum256([]byte(origin + "#" + strconv.Itoa(attempt) + "#" + strconv.Itoa(block)))
+		n := new(big.Int).SetBytes(sum[:])
+		mod := new(big.Int)
+		// 256 bit hash give at least 40 symbols for alphabet up to 82 symbols
+		for i := 0; i < 40 && len(code) < length; i++ {
+			n.DivMod(n, base, mod)
+			code = append(code, g.alphabet[mod.Int64()])
+		}
+	}
+	return string(code), nil
+}
+
+// Obfuscated codes of counter which is mixed by multiplication in ring of all codes with length.
+// Codes are uniq for every counter value, but look random
+type Obfuscated struct {
+	alphabet string
+	counter  *Counter
+}
+
+// obfuscatePrime is prime, so it is coprime with any power of alphabet size
+// and multiplication by it is bijection in ring of codes
+var obfuscatePrime = big.NewInt(1580030173)
+
+// NewObfuscated generator of obfuscated sequence, counter starts after start
+func NewObfuscated(alphabet string, start uint64) *Obfuscated {
+	return &Obfuscated{alphabet: alphabet, counter: NewCounter(start)}
+}
+
+// Counter of obfuscated sequence
+func (g *Obfuscated) Counter() *Counter {
+	return g.counter
+}
+
+// Generate next obfuscated code
+func (g *Obfuscated) Generate(_ string, length int, _ int) (string, error) {
+	n, err := g.counter.Next()
+	if err != nil {
+		return "", err
+	}
+	space := new(big.Int).Exp(big.NewInt(int64(len(g.alphabet))), big.NewInt(int64(length)), nil)
+	x := new(big.Int).SetUint64(n)
+	x.Mul(x, obfuscatePrime)
+	x.Mod(x, space)
+	return encode(x, g.alphabet, length), nil
+}
+
+// encode number in alphabet base with left padding to length
+func encode(n *big.Int, alphabet string, length int) string {
+	base := big.NewInt(int64(len(alphabet)))
+	mod := new(big.Int)
+	n = new(big.Int).Set(n)
+	var code []byte
+	for n.Sign() > 0 {
+		n.DivMod(n, base, mod)
+		code = append(code, alphabet[mod.Int64()])
+	}
+	for len(code) < length {
+		code = append(code, alphabet[0])
+	}
+	// Reverse for most significant symbol first
+	for i, j := 0, len(code)-1; i < j; i, j = i+1, j-1 {
+		code[i], code[j] = code[j], code[i]
+	}
+	return string(code)
+}
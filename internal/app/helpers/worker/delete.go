@@ -0,0 +1,96 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/shortlink"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/repository"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/tasks"
+)
+
+// TypeDelete of jobs marking links as deleted, payload is array of correlation ids or shorts
+const TypeDelete = tasks.LegacyType
+
+// Defaults of deletion tasks merge. Tasks claimed during window are merged by users up to size of ids
+const (
+	DefaultBatchWindow = 50 * time.Millisecond
+	DefaultBatchSize   = 1000
+)
+
+// Delete type of jobs marking links of user as deleted in storage. Merged tasks of user
+// are done by one update, every task gets count of updated links by its ids. Zero window disable merge
+func Delete(s repository.Repository, window time.Duration, size int) Type {
+	if window < 0 {
+		window = DefaultBatchWindow
+	}
+	if size <= 0 {
+		size = DefaultBatchSize
+	}
+	return Type{
+		Handler: func(ctx context.Context, userID string, batch []tasks.Task) (Result, error) {
+			ids, err := deleteIDs(batch)
+			if err != nil {
+				return Result{}, Permanent(err)
+			}
+			updated, err := s.BunchUpdateAsDeleted(ctx, ids, userID)
+			if err != nil {
+				return Result{}, err
+			}
+			return deleteResult(batch, updated), nil
+		},
+		Window: window,
+		Size:   size,
+		Weight: func(t tasks.Task) int {
+			var ids []string
+			if err := json.Unmarshal(t.Payload, &ids); err != nil || len(ids) == 0 {
+				return 1
+			}
+			return len(ids)
+		},
+	}
+}
+
+// deleteIDs from payloads of tasks, repeated ids are merged
+func deleteIDs(batch []tasks.Task) ([]string, error) {
+	var ids []string
+	seen := make(map[string]struct{})
+	for _, t := range batch {
+		var v []string
+		if err := json.Unmarshal(t.Payload, &v); err != nil {
+			return nil, err
+		}
+		for _, id := range v {
+			if _, ok := seen[id]; !ok {
+				seen[id] = struct{}{}
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids, nil
+}
+
+// deleteResult of merged tasks. Updated link is counted for every task with its short or correlation id
+func deleteResult(batch []tasks.Task, updated []shortlink.Link) Result {
+	res := Result{Affected: len(updated), Tasks: make(map[string]Result, len(batch))}
+	for _, t := range batch {
+		var ids []string
+		// Payloads are already checked by deleteIDs
+		_ = json.Unmarshal(t.Payload, &ids)
+		lookup := make(map[string]struct{}, len(ids))
+		for _, id := range ids {
+			lookup[id] = struct{}{}
+		}
+		affected := 0
+		for _, l := range updated {
+			_, byShort := lookup[string(l.Short)]
+			_, byID := lookup[l.CorrelationID]
+			if byShort || (byID && l.CorrelationID != "") {
+				affected++
+			}
+		}
+		res.Tasks[t.ID] = Result{Affected: affected}
+	}
+	return res
+}
@@ -0,0 +1,115 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Statuses of job
+const (
+	JobQueued   = "queued"
+	JobRunning  = "running"
+	JobRetrying = "retrying"
+	JobDone     = "done"
+	JobFailed   = "failed"
+)
+
+// DefaultJobRetention time of finished job state keeping
+const DefaultJobRetention = time.Hour
+
+// SystemUser of tasks pushed by schedule or services. Jobs of system tasks aren't tracked
+const SystemUser = ""
+
+// ErrJobNotFound if job is unknown or belongs to other user
+var ErrJobNotFound = errors.New("job not found")
+
+// ErrUnknownType if pool has no handler of job type
+var ErrUnknownType = errors.New("unknown job type")
+
+// ErrClosed if pool doesn't accept tasks after close
+var ErrClosed = errors.New("worker pool is closed")
+
+// Job state of task. Failed attempt is retried after backoff,
+// job is failed after last attempt, and its task is kept in dead letters
+type Job struct {
+	ID     string `json:"id"`
+	Type   string `json:"type"`
+	Status string `json:"status"`
+	// Affected count of items changed by handler of job, for example links marked as deleted.
+	// Merged jobs of user share one call of handler, but handler may report result of every job
+	Affected int `json:"affected"`
+	// Result of handler
+	Result interface{} `json:"result,omitempty"`
+	// Merged count of jobs in call of handler
+	Merged     int        `json:"merged,omitempty"`
+	Error      string     `json:"error,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+
+	userID string
+	// Closed when job is finished
+	done chan struct{}
+}
+
+// Job copy of job state. Job is visible only for its user
+func (p *Pool) Job(userID, id string) (Job, error) {
+	p.jobsMu.RLock()
+	defer p.jobsMu.RUnlock()
+
+	job, ok := p.jobs[id]
+	if !ok || job.userID != userID {
+		return Job{}, ErrJobNotFound
+	}
+	return *job, nil
+}
+
+// Wait job finish and get its state. Job is visible only for its user
+func (p *Pool) Wait(ctx context.Context, userID, id string) (Job, error) {
+	p.jobsMu.RLock()
+	job, ok := p.jobs[id]
+	if !ok || job.userID != userID {
+		p.jobsMu.RUnlock()
+		return Job{}, ErrJobNotFound
+	}
+	done := job.done
+	p.jobsMu.RUnlock()
+
+	select {
+	case <-done:
+		return p.Job(userID, id)
+	case <-ctx.Done():
+		return Job{}, ctx.Err()
+	}
+}
+
+// track state of task. Job is added if it is unknown, for example after restart. System tasks are skipped
+func (p *Pool) track(id, typ, userID string, fn func(job *Job)) {
+	if userID == SystemUser {
+		return
+	}
+	p.jobsMu.Lock()
+	defer p.jobsMu.Unlock()
+
+	job, ok := p.jobs[id]
+	if !ok {
+		now := time.Now()
+		p.cleanup(now)
+		job = &Job{ID: id, Type: typ, Status: JobQueued, CreatedAt: now, userID: userID, done: make(chan struct{})}
+		p.jobs[id] = job
+	}
+	finished := job.FinishedAt != nil
+	fn(job)
+	if !finished && job.FinishedAt != nil {
+		close(job.done)
+	}
+}
+
+// cleanup remove finished jobs after retention. Must be called under lock
+func (p *Pool) cleanup(now time.Time) {
+	for id, job := range p.jobs {
+		if job.FinishedAt != nil && now.Sub(*job.FinishedAt) > p.retention {
+			delete(p.jobs, id)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/clicks"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/repository"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/tasks"
+)
+
+// Defaults of purger
+const (
+	DefaultPurgeInterval  = time.Hour
+	DefaultPurgeRetention = 30 * 24 * time.Hour
+)
+
+// TypePurge of scheduled jobs removing links deleted longer than retention
+const TypePurge = "purge"
+
+// Purger remove links deleted longer than retention and their clicks by scheduled jobs of pool
+type Purger struct {
+	// Logger
+	logger *zap.Logger
+	// Storage of users
+	storage repository.Repository
+	// Clicks of links
+	clicks clicks.Store
+	// Period of purges
+	interval time.Duration
+	// Time of deleted links keeping
+	retention time.Duration
+}
+
+// NewPurger instance of purger. Not positive interval and retention are replaced by defaults
+func NewPurger(l *zap.Logger, s repository.Repository, c clicks.Store, interval, retention time.Duration) *Purger {
+	if interval <= 0 {
+		interval = DefaultPurgeInterval
+	}
+	if retention <= 0 {
+		retention = DefaultPurgeRetention
+	}
+	return &Purger{logger: l, storage: s, clicks: c, interval: interval, retention: retention}
+}
+
+// Type of scheduled jobs purging deleted links by interval of purger
+func (p *Purger) Type() Type {
+	return Type{
+		Handler: func(ctx context.Context, _ string, _ []tasks.Task) (Result, error) {
+			purged, err := p.Purge(ctx)
+			return Result{Affected: purged}, err
+		},
+		Concurrency: 1,
+		Every:       p.interval,
+	}
+}
+
+// Purge remove links deleted before retention from current time and clicks of them
+func (p *Purger) Purge(ctx context.Context) (int, error) {
+	purged, err := p.storage.Purge(ctx, time.Now().Add(-p.retention))
+	if err == nil && len(purged) > 0 {
+		err = p.clicks.Delete(ctx, purged)
+	}
+	if err != nil {
+		return len(purged), err
+	}
+	p.logger.Info("Deleted links purged", zap.Int("count", len(purged)))
+	return len(purged), nil
+}
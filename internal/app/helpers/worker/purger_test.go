@@ -0,0 +1,57 @@
+package worker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	er "github.com/triumphpc/go-musthave-shortener-tpl/internal/app/errors"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/click"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/shortlink"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/clicks"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/file"
+)
+
+func TestPurger_Purge(t *testing.T) {
+	ctx := context.Background()
+	s, err := file.New("")
+	require.NoError(t, err)
+
+	deleted, err := s.Save(ctx, "user", "http://deleted.ru", shortlink.Options{})
+	require.NoError(t, err)
+	kept, err := s.Save(ctx, "user", "http://kept.ru", shortlink.Options{})
+	require.NoError(t, err)
+	_, err = s.BunchUpdateAsDeleted(ctx, []string{string(deleted)}, "user")
+	require.NoError(t, err)
+	store, err := clicks.NewFileStore("", "", 0)
+	require.NoError(t, err)
+	require.NoError(t, store.Save(ctx, []click.Click{{At: time.Now(), Short: deleted}, {At: time.Now(), Short: kept}}))
+
+	// Deletion is younger than retention
+	p := NewPurger(zap.NewNop(), s, store, 0, time.Hour)
+	purged, err := p.Purge(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, purged)
+	_, err = s.LinkByShort(ctx, deleted)
+	assert.ErrorIs(t, err, er.ErrURLIsGone)
+
+	time.Sleep(10 * time.Millisecond)
+	p = NewPurger(zap.NewNop(), s, store, 0, time.Millisecond)
+	purged, err = p.Purge(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, purged)
+	_, err = s.LinkByShort(ctx, deleted)
+	assert.ErrorIs(t, err, er.ErrURLNotFound)
+
+	// Clicks of purged link are removed in same job
+	days, err := store.Daily(ctx, deleted)
+	require.NoError(t, err)
+	assert.Empty(t, days)
+	days, err = store.Daily(ctx, kept)
+	require.NoError(t, err)
+	assert.Len(t, days, 1)
+}
@@ -0,0 +1,59 @@
+package worker
+
+import (
+	"context"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/repository"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/tasks"
+)
+
+// DefaultReapInterval period of expired links check
+const DefaultReapInterval = time.Minute
+
+// TypeReap of scheduled jobs marking expired links as deleted
+const TypeReap = "reap"
+
+// Reaper mark expired links as deleted by scheduled jobs of pool
+type Reaper struct {
+	// Logger
+	logger *zap.Logger
+	// Storage of users
+	storage repository.Repository
+	// Period of checks
+	interval time.Duration
+}
+
+// NewReaper instance of reaper. Not positive interval is replaced by default
+func NewReaper(l *zap.Logger, s repository.Repository, interval time.Duration) *Reaper {
+	if interval <= 0 {
+		interval = DefaultReapInterval
+	}
+	return &Reaper{logger: l, storage: s, interval: interval}
+}
+
+// Type of scheduled jobs reaping expired links by interval of reaper
+func (r *Reaper) Type() Type {
+	return Type{
+		Handler: func(ctx context.Context, _ string, _ []tasks.Task) (Result, error) {
+			updated, err := r.Reap(ctx)
+			return Result{Affected: updated}, err
+		},
+		Concurrency: 1,
+		Every:       r.interval,
+	}
+}
+
+// Reap mark links expired to current time as deleted
+func (r *Reaper) Reap(ctx context.Context) (int, error) {
+	updated, err := r.storage.UpdateExpiredAsDeleted(ctx, time.Now())
+	if err != nil {
+		return updated, err
+	}
+	if updated > 0 {
+		r.logger.Info("Expired links deleted", zap.Int("count", updated))
+	}
+	return updated, nil
+}
@@ -0,0 +1,71 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	er "github.com/triumphpc/go-musthave-shortener-tpl/internal/app/errors"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/shortlink"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/file"
+)
+
+func TestReaper_Reap(t *testing.T) {
+	ctx := context.Background()
+	s, err := file.New("")
+	require.NoError(t, err)
+
+	expired, err := s.Save(ctx, "user", "http://expired.ru", shortlink.Options{ExpiresAt: time.Now().Add(-time.Second)})
+	require.NoError(t, err)
+	alive, err := s.Save(ctx, "user", "http://alive.ru", shortlink.Options{ExpiresAt: time.Now().Add(time.Hour)})
+	require.NoError(t, err)
+
+	_, err = s.LinkByShort(ctx, expired)
+	assert.ErrorIs(t, err, er.ErrURLExpired)
+
+	r := NewReaper(zap.NewNop(), s, 0)
+	updated, err := r.Reap(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, updated)
+
+	// Reaped link is deleted, alive link is available
+	_, err = s.LinkByShort(ctx, expired)
+	assert.ErrorIs(t, err, er.ErrURLIsGone)
+	origin, err := s.LinkByShort(ctx, alive)
+	require.NoError(t, err)
+	assert.Equal(t, "http://alive.ru", origin)
+
+	// Nothing to reap again
+	updated, err = r.Reap(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, updated)
+}
+
+func TestReaper_Type(t *testing.T) {
+	ctx := context.Background()
+	s, err := file.New("")
+	require.NoError(t, err)
+
+	expired, err := s.Save(ctx, "user", "http://expired.ru", shortlink.Options{ExpiresAt: time.Now().Add(-time.Second)})
+	require.NoError(t, err)
+
+	// Scheduled job of pool reap link
+	p, closePool := New(ctx, zap.NewNop(), WithType(TypeReap, NewReaper(zap.NewNop(), s, 10*time.Millisecond).Type()))
+	defer closePool()
+	require.Eventually(t, func() bool {
+		_, err := s.LinkByShort(ctx, expired)
+		return errors.Is(err, er.ErrURLIsGone)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// System jobs aren't visible for users
+	_, err = p.Enqueue(TypeReap, SystemUser, nil)
+	require.NoError(t, err)
+	p.jobsMu.RLock()
+	assert.Empty(t, p.jobs)
+	p.jobsMu.RUnlock()
+}
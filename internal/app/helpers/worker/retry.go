@@ -0,0 +1,99 @@
+package worker
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Defaults of failed tasks retry
+const (
+	DefaultMaxAttempts = 5
+	DefaultBackoffMin  = time.Second
+	DefaultBackoffMax  = time.Minute
+)
+
+// Backoff exponential delays of retries with jitter
+type Backoff struct {
+	// Min delay of first retry
+	Min time.Duration
+	// Max delay of retry
+	Max time.Duration
+}
+
+// Delay before next attempt after failed attempt. Delay is doubled with every attempt up to max,
+// random half of it is jitter, so retries of many tasks aren't synchronized
+func (b Backoff) Delay(attempt int) time.Duration {
+	d := b.Min
+	for k := 1; k < attempt && d < b.Max; k++ {
+		d *= 2
+	}
+	if d > b.Max {
+		d = b.Max
+	}
+	if d <= 0 {
+		return 0
+	}
+	half := d / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+// permanentError error which retry can't fix
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent mark error as permanent, task with it isn't retried
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err}
+}
+
+// codeError error of SQLite driver with result code
+type codeError interface {
+	Code() int
+}
+
+// SQLite result codes of busy and locked database
+const (
+	sqliteBusy   = 5
+	sqliteLocked = 6
+)
+
+// Transient check if error can pass on retry. Errors of data and queries in database are permanent,
+// busy database, conflicts of transactions and other errors are transient
+func Transient(err error) bool {
+	var permanent permanentError
+	if errors.As(err, &permanent) {
+		return false
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code.Class() {
+		// Connection, transaction rollback, insufficient resources, operator intervention
+		case "08", "40", "53", "57":
+			return true
+		}
+		return false
+	}
+	var sqliteErr codeError
+	if errors.As(err, &sqliteErr) {
+		// Extended codes keep primary code in low byte
+		code := sqliteErr.Code() & 0xff
+		return code == sqliteBusy || code == sqliteLocked
+	}
+	// Timeouts, lost connections and network errors are here too
+	return true
+}
@@ -0,0 +1,69 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoff_Delay(t *testing.T) {
+	b := Backoff{Min: 100 * time.Millisecond, Max: time.Second}
+	tests := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{3, 400 * time.Millisecond},
+		{10, time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.attempt), func(t *testing.T) {
+			for k := 0; k < 100; k++ {
+				d := b.Delay(tt.attempt)
+				assert.GreaterOrEqual(t, d, tt.max/2)
+				assert.LessOrEqual(t, d, tt.max)
+			}
+		})
+	}
+}
+
+// sqliteError like error of SQLite driver
+type sqliteError int
+
+func (e sqliteError) Error() string {
+	return fmt.Sprintf("sqlite error %d", int(e))
+}
+
+func (e sqliteError) Code() int {
+	return int(e)
+}
+
+func TestTransient(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"timeout", fmt.Errorf("update: %w", context.DeadlineExceeded), true},
+		{"unknown", errors.New("unknown"), true},
+		{"permanent", Permanent(errors.New("broken")), false},
+		{"pq connection", &pq.Error{Code: "08006"}, true},
+		{"pq deadlock", &pq.Error{Code: "40P01"}, true},
+		{"pq data", &pq.Error{Code: "22001"}, false},
+		{"pq syntax", &pq.Error{Code: "42601"}, false},
+		{"sqlite busy", sqliteError(5), true},
+		{"sqlite locked extended", sqliteError(262), true},
+		{"sqlite constraint", sqliteError(19), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Transient(tt.err))
+		})
+	}
+}
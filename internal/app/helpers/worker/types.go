@@ -0,0 +1,96 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/tasks"
+)
+
+// Handler process tasks of one user and type. Several tasks are passed if type merges them
+// during batch window, result of handler or its result of task and error are reported to jobs of all tasks.
+// Error wrapped by Permanent isn't retried
+type Handler func(ctx context.Context, userID string, batch []tasks.Task) (Result, error)
+
+// Result of handler
+type Result struct {
+	// Affected count of items changed by tasks
+	Affected int
+	// Value of result in job state, it is encoded to json
+	Value interface{}
+	// Tasks results by task id. If it is set, job of merged task gets only result of its task
+	Tasks map[string]Result
+}
+
+// Type of jobs with handler and limits of its workers
+type Type struct {
+	Handler Handler
+	// Concurrency count of workers of type, by default it is count of pool workers
+	Concurrency int
+	// MaxDepth limit of type tasks claimed by pool and ready for claim, tasks over it are rejected with ErrQueueFull.
+	// Tasks leased by other instances and delayed retries aren't counted.
+	// Limit is strict for pushes of one pool and soft for pushes of several instances at once. Zero disable limit
+	MaxDepth int
+	// Window of tasks merge after first claimed task. Zero window disable merge
+	Window time.Duration
+	// Size limit of merged tasks by weights
+	Size int
+	// Weight of task in size of merge, by default every task weights one
+	Weight func(t tasks.Task) int
+	// Every period of system task push by pool, task isn't pushed while other task of type is in queue.
+	// Zero disable schedule
+	Every time.Duration
+	// Local keep tasks of type in memory queue of pool, they aren't delivered to other instances.
+	// It is for tasks of process state like buffers and temp files
+	Local bool
+}
+
+// jobType registered type of pool
+type jobType struct {
+	Type
+	name string
+	// Filter of claims
+	names []string
+	// Wake up of sleeping workers of type after push
+	notify chan struct{}
+	// Queue of tasks, durable queue of pool or its memory queue for local type
+	queue tasks.Queue
+	// Count of tasks claimed by workers of type and not finished yet
+	claimed int64
+	// Lock of depth check and push, so concurrent pushes of pool don't pass max depth together
+	depthMu sync.Mutex
+}
+
+// WithType register type of jobs by name. Tasks of unknown types stay in queue
+func WithType(name string, t Type) Option {
+	return func(p *Pool) {
+		if t.Handler != nil {
+			p.types[name] = &jobType{Type: t, name: name, names: []string{name}, notify: make(chan struct{}, 1)}
+		}
+	}
+}
+
+// weight of task in size of merge
+func (t *jobType) weight(task tasks.Task) int {
+	if t.Weight == nil {
+		return 1
+	}
+	return t.Weight(task)
+}
+
+// local count of tasks claimed by pool and tasks which pool can claim now.
+// Tasks leased by other instances and delayed retries aren't counted
+func (t *jobType) local(ctx context.Context) (int, error) {
+	n, err := t.queue.Ready(ctx, t.name)
+	return n + int(atomic.LoadInt64(&t.claimed)), err
+}
+
+// wake one sleeping worker of type
+func (t *jobType) wake() {
+	select {
+	case t.notify <- struct{}{}:
+	default:
+	}
+}
@@ -3,99 +3,210 @@ package worker
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"runtime"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"go.uber.org/zap"
-	"golang.org/x/sync/errgroup"
 
-	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/repository"
+	er "github.com/triumphpc/go-musthave-shortener-tpl/internal/app/errors"
+	fw "github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/filewrapper"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/tasks"
 )
 
+// DefaultPollInterval period of queue check for tasks of other instances and expired leases
+const DefaultPollInterval = time.Second
+
+// drainInterval period of queue check while drain
+const drainInterval = 50 * time.Millisecond
+
 type Pool struct {
 	// Workers pool
 	workerPool []*Worker
 	// Logger
 	logger *zap.Logger
-	// Queue for marks as deleted
-	queue *Queue
+	// Durable queue of tasks
+	queue tasks.Queue
+	// Memory queue of local types
+	local tasks.Queue
+	// Types of jobs by names
+	types map[string]*jobType
+	// Lease of claimed task
+	lease time.Duration
+	// Period of queue check without notifications
+	poll time.Duration
+	// Count of workers of type without concurrency limit
+	workers int
+	// Retry of failed tasks
+	maxAttempts int
+	backoff     Backoff
+	// Stop of workers
+	stop     chan struct{}
+	stopOnce sync.Once
+	// Stop of tasks accepting before drain
+	closing     chan struct{}
+	closingOnce sync.Once
+	// Cancel of tasks in progress
+	cancel context.CancelFunc
 	// Waiting group for worker goroutines
 	wg *sync.WaitGroup
 	// Total counter
 	total chan int
-	// Storage of users
-	storage repository.Repository
+	// States of jobs by task ids
+	jobs      map[string]*Job
+	jobsMu    sync.RWMutex
+	retention time.Duration
 }
 
 type IPool interface {
-	// Push ids for working
-	Push(ids []string, userID string)
-	// Run init pool of workers
-	Run(ctx context.Context)
+	// Enqueue task of type for user, payload is encoded to json. Returns id of job
+	Enqueue(typ, userID string, payload interface{}) (string, error)
+	// Job state of user
+	Job(userID, id string) (Job, error)
+	// Wait job finish and get its state
+	Wait(ctx context.Context, userID, id string) (Job, error)
+	// Drain claimed and ready tasks until context is done and close pool. Returns count of undrained tasks
+	Drain(ctx context.Context) int
+	// Close pool, not done tasks stay in queue
+	Close()
 }
 
-// Worker for process
+// Worker for process tasks of one type
 type Worker struct {
 	id   int
 	pool *Pool
+	typ  *jobType
 }
 
-// Queue for tasks in worker
-type Queue struct {
-	arr  []*Task
-	mu   sync.Mutex
-	cond *sync.Cond
-	stop bool
+// Option configure Pool
+type Option func(p *Pool)
+
+// WithQueue set durable queue of tasks. By default queue is kept in memory
+func WithQueue(q tasks.Queue) Option {
+	return func(p *Pool) {
+		if q != nil {
+			p.queue = q
+		}
+	}
 }
 
-// Task in queue updating link ids for user id
-type Task struct {
-	ids    []string
-	userID string
+// WithLease set time of task ownership by worker, task is delivered again after it
+func WithLease(d time.Duration) Option {
+	return func(p *Pool) {
+		if d > 0 {
+			p.lease = d
+		}
+	}
+}
+
+// WithPollInterval set period of queue check without notifications
+func WithPollInterval(d time.Duration) Option {
+	return func(p *Pool) {
+		if d > 0 {
+			p.poll = d
+		}
+	}
+}
+
+// WithWorkers set count of workers of types without concurrency limit, by default it is count of CPU
+func WithWorkers(n int) Option {
+	return func(p *Pool) {
+		if n > 0 {
+			p.workers = n
+		}
+	}
+}
+
+// WithRetry set max attempts of task and backoff delays between them
+func WithRetry(attempts int, min, max time.Duration) Option {
+	return func(p *Pool) {
+		if attempts > 0 {
+			p.maxAttempts = attempts
+		}
+		if min > 0 {
+			p.backoff.Min = min
+		}
+		if max > 0 {
+			p.backoff.Max = max
+		}
+	}
+}
+
+// WithJobRetention set time of finished job state keeping
+func WithJobRetention(d time.Duration) Option {
+	return func(p *Pool) {
+		if d > 0 {
+			p.retention = d
+		}
+	}
 }
 
-// New Instance new pool
-func New(ctx context.Context, l *zap.Logger, s repository.Repository) (*Pool, func()) {
-	p := &Pool{logger: l, storage: s}
+// New Instance new pool. Workers are started for every type of jobs
+func New(ctx context.Context, l *zap.Logger, opts ...Option) (*Pool, func()) {
+	p := &Pool{
+		logger:      l,
+		types:       make(map[string]*jobType),
+		lease:       tasks.DefaultLease,
+		poll:        DefaultPollInterval,
+		workers:     runtime.NumCPU(),
+		maxAttempts: DefaultMaxAttempts,
+		backoff:     Backoff{Min: DefaultBackoffMin, Max: DefaultBackoffMax},
+		stop:        make(chan struct{}),
+		closing:     make(chan struct{}),
+		wg:          &sync.WaitGroup{},
+		total:       make(chan int),
+		jobs:        make(map[string]*Job),
+		retention:   DefaultJobRetention,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	ctx, p.cancel = context.WithCancel(ctx)
+	// Memory queue without journal can't fail
+	p.local, _ = tasks.NewFileQueue("", fw.SyncNever, 0)
+	if p.queue == nil {
+		p.queue = p.local
+	}
+	for _, typ := range p.types {
+		typ.queue = p.queue
+		if typ.Local {
+			typ.queue = p.local
+		}
+	}
 
 	p.logger.Info("Init new worker pool")
-	// Init new worker pool
-	p.workerPool = make([]*Worker, 0, runtime.NumCPU())
-	// Init queue for tasks
-	p.queue = p.newQueue()
-	// Make workers
-	for i := 0; i < runtime.NumCPU(); i++ {
-		p.workerPool = append(p.workerPool, p.newWorker(i))
+	// Make workers up to concurrency of every type
+	for _, typ := range p.types {
+		n := typ.Concurrency
+		if n <= 0 {
+			n = p.workers
+		}
+		for i := 0; i < n; i++ {
+			p.workerPool = append(p.workerPool, p.newWorker(len(p.workerPool), typ))
+		}
 	}
 	// Run all workers in goroutines
-	ctx, cancel := context.WithCancel(ctx)
-	// Make error group for goroutines
-	g, _ := errgroup.WithContext(ctx)
-	p.wg = &sync.WaitGroup{}
-
 	for _, w := range p.workerPool {
 		p.wg.Add(1)
-		worker := w
-		f := func() error {
-			return worker.loop(ctx)
-		}
-		g.Go(f)
+		go w.loop(ctx)
 	}
-	// If we have some error in goroutine
-	go func() {
-		if err := g.Wait(); err != nil {
-			p.logger.Info("Pool error", zap.Error(err))
+	// Push system tasks of scheduled types
+	for _, typ := range p.types {
+		if typ.Every > 0 {
+			p.wg.Add(1)
+			go p.schedule(ctx, typ)
 		}
-	}()
+	}
 	// When all goroutines closed
 	go func() {
 		p.wg.Wait()
 		close(p.total)
-		// close context
-		cancel()
 	}()
 	// monitor for total updates
-	p.total = make(chan int)
 	go func() {
 		total := 0
 		for c := range p.total {
@@ -108,107 +219,350 @@ func New(ctx context.Context, l *zap.Logger, s repository.Repository) (*Pool, fu
 	return p, p.Close
 }
 
-// newQueue Init new queue for workers
-func (p *Pool) newQueue() *Queue {
-	q := Queue{}
-	// Condition for mutex use
-	q.cond = sync.NewCond(&q.mu)
-	q.stop = false
-	return &q
-}
-
 // newWorker constructor
-func (p *Pool) newWorker(id int) *Worker {
-	p.logger.Info("Init new worker", zap.Int("id", id))
-	return &Worker{id, p}
+func (p *Pool) newWorker(id int, typ *jobType) *Worker {
+	p.logger.Info("Init new worker", zap.Int("id", id), zap.String("type", typ.name))
+	return &Worker{id, p, typ}
 }
 
-// Close grace shutdown handler
+// Close grace shutdown handler. Workers finish current tasks, not done tasks stay in queue
 func (p *Pool) Close() {
-	p.queue.close()
+	p.stopOnce.Do(func() {
+		close(p.stop)
+	})
+	p.wg.Wait()
+	p.cancel()
+}
+
+// Drain stop accepting of tasks and wait until pool has no claimed tasks and queue has no ready tasks
+// of pool types or context is done, then close pool. Tasks leased by other instances and delayed retries
+// aren't waited, they are left to lease. Tasks in progress are cancelled after context,
+// they are delivered again after lease. Undrained tasks stay in queue, they are logged and their count is returned
+func (p *Pool) Drain(ctx context.Context) int {
+	p.closingOnce.Do(func() {
+		close(p.closing)
+	})
+	p.logger.Info("Drain worker pool")
+
+	ticker := time.NewTicker(drainInterval)
+	defer ticker.Stop()
+	for sum(p.pending(ctx)) > 0 {
+		select {
+		case <-ctx.Done():
+			// Context is done, queue is checked without it before cancel of claimed tasks
+			counts := p.pending(context.Background())
+			p.cancel()
+			p.Close()
+			return p.logUndrained(counts)
+		case <-ticker.C:
+		}
+	}
+	p.Close()
+	return 0
+}
+
+// pending count claimed and ready tasks of pool types. Type is counted as pending on queue error
+func (p *Pool) pending(ctx context.Context) map[string]int {
+	counts := make(map[string]int, len(p.types))
+	for name, typ := range p.types {
+		n, err := typ.local(ctx)
+		if err != nil {
+			p.logger.Info("Queue len error", zap.String("type", name), zap.Error(err))
+			n = 1
+		}
+		counts[name] = n
+	}
+	return counts
+}
+
+// logUndrained log counts of tasks by types and not finished jobs of pool. Returns count of tasks
+func (p *Pool) logUndrained(counts map[string]int) int {
+	for name, n := range counts {
+		if n > 0 {
+			p.logger.Info("Undrained tasks", zap.String("type", name), zap.Int("count", n))
+		}
+	}
+	p.jobsMu.RLock()
+	defer p.jobsMu.RUnlock()
+	for _, job := range p.jobs {
+		if job.FinishedAt == nil {
+			p.logger.Info("Undrained job", zap.String("job", job.ID), zap.String("type", job.Type), zap.String("status", job.Status))
+		}
+	}
+	return sum(counts)
 }
 
-// close pool for clients
-func (q *Queue) close() {
-	q.cond.L.Lock()
-	q.stop = true
-	// Broadcast that workers must close
-	q.cond.Broadcast()
-	q.cond.L.Unlock()
+// sum of counts
+func sum(counts map[string]int) int {
+	n := 0
+	for _, v := range counts {
+		n += v
+	}
+	return n
 }
 
-// loop listen for new tasks
-func (w *Worker) loop(ctx context.Context) error {
+// stopped check if pool is closed
+func (p *Pool) stopped() bool {
+	select {
+	case <-p.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// accepting check if pool accept tasks
+func (p *Pool) accepting() bool {
+	select {
+	case <-p.closing:
+		return false
+	default:
+		return !p.stopped()
+	}
+}
+
+// loop claim tasks of type from queue until pool is closed
+func (w *Worker) loop(ctx context.Context) {
 	defer func() {
 		w.pool.logger.Info("Close worker", zap.Int("worker id", w.id))
 		// Close counter
 		w.pool.wg.Done()
-		// Close queue
-		w.pool.queue.close()
-
-		<-ctx.Done()
-		w.pool.logger.Info("Aborting from ctx", zap.Int("worker id", w.id))
 	}()
 
-	for {
-		t, ok := w.pool.queue.PopWait()
-		// Check if pool available
+	ticker := time.NewTicker(w.pool.poll)
+	defer ticker.Stop()
+	for !w.pool.stopped() {
+		t, ok, err := w.typ.queue.Claim(ctx, w.pool.lease, w.typ.names)
+		if err != nil {
+			w.pool.logger.Info("Queue claim error", zap.Int("worker id", w.id), zap.Error(err))
+		}
 		if !ok {
-			return nil
+			select {
+			case <-ctx.Done():
+				return
+			case <-w.pool.stop:
+				return
+			case <-w.typ.notify:
+			case <-ticker.C:
+			}
+			continue
 		}
-		// bunch update
-		w.pool.logger.Info("Worker get new task", zap.Int("worker id", w.id))
-
-		if err := w.pool.storage.BunchUpdateAsDeleted(ctx, t.ids, t.userID); err != nil {
-			w.pool.logger.Info("Run to out from loop ")
-			return err
+		atomic.AddInt64(&w.typ.claimed, 1)
+		batch := w.collect(ctx, t)
+		for _, g := range merge(batch) {
+			w.process(ctx, g)
 		}
-		// Write len for counter
-		w.pool.total <- len(t.ids)
 	}
 }
 
-// PopWait Get task for update from fanOut
-func (q *Queue) PopWait() (*Task, bool) {
-	q.cond.L.Lock()
+// group merged tasks of user
+type group struct {
+	userID string
+	tasks  []tasks.Task
+}
 
-	for len(q.arr) == 0 && !q.stop {
-		// Goroutines to sleep
-		// After awake again take mutex
-		q.cond.Wait()
+// collect claim more tasks of type after first one during batch window until size of merge
+func (w *Worker) collect(ctx context.Context, first tasks.Task) []tasks.Task {
+	batch := []tasks.Task{first}
+	size := w.typ.weight(first)
+	if w.typ.Window > 0 {
+		timer := time.NewTimer(w.typ.Window)
+		defer timer.Stop()
+		for size < w.typ.Size {
+			t, ok, err := w.typ.queue.Claim(ctx, w.pool.lease, w.typ.names)
+			if err != nil {
+				w.pool.logger.Info("Queue claim error", zap.Int("worker id", w.id), zap.Error(err))
+			}
+			if ok {
+				atomic.AddInt64(&w.typ.claimed, 1)
+				batch = append(batch, t)
+				size += w.typ.weight(t)
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return batch
+			case <-w.pool.stop:
+				return batch
+			case <-timer.C:
+				return batch
+			case <-w.typ.notify:
+			}
+		}
 	}
+	// Other tasks may wait in queue
+	w.typ.wake()
+	return batch
+}
 
-	if q.stop {
-		q.cond.L.Unlock()
-		return nil, false
+// merge tasks by users in order of first tasks
+func merge(batch []tasks.Task) []*group {
+	var groups []*group
+	byUser := make(map[string]*group)
+	for _, t := range batch {
+		g, ok := byUser[t.UserID]
+		if !ok {
+			g = &group{userID: t.UserID}
+			byUser[t.UserID] = g
+			groups = append(groups, g)
+		}
+		g.tasks = append(g.tasks, t)
+	}
+	return groups
+}
+
+// process merged tasks of user by one call of handler and ack them. Failed tasks are retried
+func (w *Worker) process(ctx context.Context, g *group) {
+	w.pool.logger.Info("Worker get new tasks", zap.Int("worker id", w.id), zap.String("type", w.typ.name), zap.Int("tasks", len(g.tasks)))
+	for _, t := range g.tasks {
+		w.pool.track(t.ID, t.Type, t.UserID, func(job *Job) {
+			job.Status = JobRunning
+		})
 	}
 
-	// If pool is available
-	t := q.arr[0]
-	q.arr = q.arr[1:]
+	res, err := w.typ.Handler(ctx, g.userID, g.tasks)
+	if err != nil && !Transient(err) && len(g.tasks) > 1 {
+		// Find failed task by separate calls
+		for _, t := range g.tasks {
+			w.process(ctx, &group{userID: t.UserID, tasks: []tasks.Task{t}})
+		}
+		return
+	}
+	for _, t := range g.tasks {
+		taskErr := err
+		if taskErr == nil {
+			taskErr = w.typ.queue.Ack(ctx, t)
+		}
+		status := JobDone
+		if taskErr != nil {
+			w.pool.logger.Info("Task error", zap.String("task", t.ID), zap.String("type", t.Type), zap.Int("attempt", t.Attempts), zap.Error(taskErr))
+			status = w.retry(ctx, t, taskErr)
+		}
+		atomic.AddInt64(&w.typ.claimed, -1)
+		w.pool.track(t.ID, t.Type, t.UserID, func(job *Job) {
+			job.Merged = len(g.tasks)
+			job.Status, job.Error = status, ""
+			if taskErr != nil {
+				job.Error = taskErr.Error()
+			}
+			if status == JobRetrying {
+				return
+			}
+			now := time.Now()
+			job.FinishedAt = &now
+			if status == JobDone {
+				taskRes := res
+				if res.Tasks != nil {
+					taskRes = res.Tasks[t.ID]
+				}
+				job.Affected, job.Result = taskRes.Affected, taskRes.Value
+			}
+		})
+	}
+	if err != nil {
+		return
+	}
+	// Write len for counter
+	w.pool.total <- res.Affected
+}
 
-	q.cond.L.Unlock()
+// retry schedule failed task by backoff or move it to dead letters after last attempt or permanent error.
+// Returns status of job
+func (w *Worker) retry(ctx context.Context, t tasks.Task, err error) string {
+	if errors.Is(err, tasks.ErrLeaseLost) {
+		// Task is claimed again after expired lease, it belongs to other worker
+		return JobRetrying
+	}
+	if Transient(err) && t.Attempts < w.pool.maxAttempts {
+		delay := w.pool.backoff.Delay(t.Attempts)
+		if err := w.typ.queue.Retry(ctx, t, time.Now().Add(delay), err.Error()); err != nil {
+			// Task is delivered again after lease
+			w.pool.logger.Info("Queue retry error", zap.String("task", t.ID), zap.Error(err))
+		}
+		return JobRetrying
+	}
+	if err := w.typ.queue.Fail(ctx, t, err.Error()); err != nil {
+		w.pool.logger.Info("Queue fail error", zap.String("task", t.ID), zap.Error(err))
+		return JobRetrying
+	}
+	w.pool.logger.Info("Task moved to dead letters", zap.String("task", t.ID), zap.Int("attempts", t.Attempts))
+	return JobFailed
+}
 
-	return t, true
+// DeadLetters get last tasks failed after all attempts, newest first
+func (p *Pool) DeadLetters(ctx context.Context, limit int) ([]tasks.DeadLetter, error) {
+	return p.queue.DeadLetters(ctx, limit)
 }
 
-// Push lock all goroutines for push task in queue
-func (p *Pool) Push(ids []string, userID string) bool {
+// Enqueue save task of type in queue and wake worker of type. Returns id of job
+func (p *Pool) Enqueue(typ, userID string, payload interface{}) (string, error) {
 	// Check if workers has
-	if p.queue.stop {
-		return false
+	if !p.accepting() {
+		return "", ErrClosed
+	}
+	t, ok := p.types[typ]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownType, typ)
 	}
+	if t.MaxDepth > 0 {
+		t.depthMu.Lock()
+		defer t.depthMu.Unlock()
+		n, err := t.local(context.Background())
+		if err != nil {
+			p.logger.Info("Queue len error", zap.Error(err))
+			return "", err
+		}
+		if n >= t.MaxDepth {
+			return "", er.ErrQueueFull
+		}
+	}
+	return p.push(t, userID, payload)
+}
 
-	// Lock input queue
-	p.queue.cond.L.Lock()
-	defer p.queue.cond.L.Unlock()
+// push task of type in its queue and wake worker of type. Returns id of job
+func (p *Pool) push(t *jobType, userID string, payload interface{}) (string, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	p.logger.Info("Push new task in queue", zap.String("type", t.name))
+	id, err := t.queue.Push(context.Background(), t.name, userID, data)
+	if err != nil {
+		p.logger.Info("Queue push error", zap.Error(err))
+		return "", err
+	}
+	// Worker can take task before
+	p.track(id, t.name, userID, func(*Job) {})
+	// awake worker
+	t.wake()
+	return id, nil
+}
 
-	p.logger.Info("Push new task in queue")
-	// New Task for updating
-	t := Task{ids, userID}
-	// Add to queue new Task for update
-	p.queue.arr = append(p.queue.arr, &t)
-	// awake PopWait in worker
-	p.queue.cond.Signal()
-	return true
+// schedule push system task of type by its period until pool is closed.
+// Task isn't pushed while queue has task of type, for example from other instance
+func (p *Pool) schedule(ctx context.Context, t *jobType) {
+	defer p.wg.Done()
+	p.logger.Info("Run schedule", zap.String("type", t.name), zap.Duration("every", t.Every))
+
+	ticker := time.NewTicker(t.Every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.stop:
+			return
+		case <-p.closing:
+			return
+		case <-ticker.C:
+		}
+		n, err := t.queue.Len(ctx, t.name)
+		if err != nil {
+			p.logger.Info("Queue len error", zap.String("type", t.name), zap.Error(err))
+			continue
+		}
+		if n == 0 {
+			_, _ = p.push(t, SystemUser, nil)
+		}
+	}
 }
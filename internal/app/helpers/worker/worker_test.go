@@ -0,0 +1,558 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	er "github.com/triumphpc/go-musthave-shortener-tpl/internal/app/errors"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/shortlink"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/file"
+	fw "github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/filewrapper"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/repository"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/tasks"
+)
+
+func TestPool_Push(t *testing.T) {
+	ctx := context.Background()
+	s, err := file.New("")
+	require.NoError(t, err)
+	short, err := s.Save(ctx, "user", "http://deleted.ru", shortlink.Options{})
+	require.NoError(t, err)
+
+	p, poolClose := New(ctx, zap.NewNop(), WithType(TypeDelete, Delete(s, 0, 0)))
+	id, err := p.Enqueue(TypeDelete, "user", []string{string(short), "unknown"})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		job, err := p.Job("user", id)
+		require.NoError(t, err)
+		return job.Status == JobDone
+	}, 5*time.Second, 10*time.Millisecond)
+
+	job, err := p.Job("user", id)
+	require.NoError(t, err)
+	assert.Equal(t, 1, job.Affected)
+	assert.NotNil(t, job.FinishedAt)
+	_, err = s.LinkByShort(ctx, short)
+	assert.ErrorIs(t, err, er.ErrURLIsGone)
+	// Job is visible only for its user
+	_, err = p.Job("other", id)
+	assert.ErrorIs(t, err, ErrJobNotFound)
+
+	// Closed pool doesn't accept tasks
+	poolClose()
+	_, err = p.Enqueue(TypeDelete, "user", []string{string(short)})
+	assert.ErrorIs(t, err, ErrClosed)
+}
+
+func TestPool_Redelivery(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "links.tasks")
+	s, err := file.New("")
+	require.NoError(t, err)
+	accepted, err := s.Save(ctx, "user", "http://accepted.ru", shortlink.Options{})
+	require.NoError(t, err)
+	claimed, err := s.Save(ctx, "user", "http://claimed.ru", shortlink.Options{})
+	require.NoError(t, err)
+
+	// Tasks of crashed instance: accepted one and claimed one without ack
+	q, err := tasks.NewFileQueue(path, fw.SyncAlways, 0)
+	require.NoError(t, err)
+	_, err = q.Push(ctx, TypeDelete, "user", payload(t, []string{string(claimed)}))
+	require.NoError(t, err)
+	_, err = q.Push(ctx, TypeDelete, "user", payload(t, []string{string(accepted)}))
+	require.NoError(t, err)
+	_, _, err = q.Claim(ctx, 100*time.Millisecond, []string{TypeDelete})
+	require.NoError(t, err)
+	require.NoError(t, q.Close())
+
+	q, err = tasks.NewFileQueue(path, fw.SyncAlways, 0)
+	require.NoError(t, err)
+	defer q.Close()
+	_, poolClose := New(ctx, zap.NewNop(), WithType(TypeDelete, Delete(s, 0, 0)), WithQueue(q), WithPollInterval(10*time.Millisecond))
+	defer poolClose()
+
+	for _, short := range []shortlink.Short{accepted, claimed} {
+		require.Eventually(t, func() bool {
+			_, err := s.LinkByShort(ctx, short)
+			return errors.Is(err, er.ErrURLIsGone)
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+}
+
+func TestPool_LeaseLost(t *testing.T) {
+	ctx := context.Background()
+	q, err := tasks.NewFileQueue("", fw.SyncNever, 0)
+	require.NoError(t, err)
+	started, release := make(chan struct{}), make(chan struct{})
+	slow := Type{
+		Handler: func(ctx context.Context, userID string, batch []tasks.Task) (Result, error) {
+			close(started)
+			<-release
+			return Result{Affected: 1}, nil
+		},
+		Concurrency: 1,
+	}
+	p, poolClose := New(ctx, zap.NewNop(), WithType("slow", slow), WithQueue(q), WithLease(50*time.Millisecond))
+	defer poolClose()
+	id, err := p.Enqueue("slow", "user", 1)
+	require.NoError(t, err)
+
+	// Task is claimed by other worker after lease of slow handler
+	<-started
+	time.Sleep(100 * time.Millisecond)
+	other, ok, err := q.Claim(ctx, time.Hour, []string{"slow"})
+	require.NoError(t, err)
+	require.True(t, ok)
+	close(release)
+
+	// Late ack of pool doesn't remove task of other worker
+	require.Eventually(t, func() bool {
+		job, err := p.Job("user", id)
+		return err == nil && job.Status == JobRetrying
+	}, 5*time.Second, 10*time.Millisecond)
+	n, err := q.Len(ctx, "slow")
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	require.NoError(t, q.Ack(ctx, other))
+}
+
+// countingStorage count updates of links
+type countingStorage struct {
+	repository.Repository
+	updates int32
+}
+
+func (s *countingStorage) BunchUpdateAsDeleted(ctx context.Context, ids []string, userID string) ([]shortlink.Link, error) {
+	atomic.AddInt32(&s.updates, 1)
+	return s.Repository.BunchUpdateAsDeleted(ctx, ids, userID)
+}
+
+func TestPool_Merge(t *testing.T) {
+	ctx := context.Background()
+	fs, err := file.New("")
+	require.NoError(t, err)
+	s := &countingStorage{Repository: fs}
+	shorts, err := s.BunchSave(ctx, "user", []shortlink.URLs{
+		{ID: "1", Origin: "http://one.ru"},
+		{ID: "2", Origin: "http://two.ru"},
+		{ID: "3", Origin: "http://three.ru"},
+	}, shortlink.BatchOptions{})
+	require.NoError(t, err)
+	other, err := s.Save(ctx, "other", "http://other.ru", shortlink.Options{})
+	require.NoError(t, err)
+
+	// Burst of deletes before workers start
+	q, err := tasks.NewFileQueue("", fw.SyncNever, 0)
+	require.NoError(t, err)
+	var ids []string
+	for _, v := range [][]string{{"1"}, {"2", "1"}, {string(other)}, {shorts[2].Short}} {
+		userID := "user"
+		if v[0] == string(other) {
+			userID = "other"
+		}
+		id, err := q.Push(ctx, TypeDelete, userID, payload(t, v))
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+
+	p, poolClose := New(ctx, zap.NewNop(), WithType(TypeDelete, Delete(s, 200*time.Millisecond, 100)), WithQueue(q), WithWorkers(1))
+	defer poolClose()
+	// One update for every user, merged jobs count only links by their ids
+	userJobs := map[string]int{ids[0]: 1, ids[1]: 2, ids[3]: 1}
+	for id, affected := range userJobs {
+		require.Eventually(t, func() bool {
+			// Job is known after claim of task
+			job, err := p.Job("user", id)
+			return err == nil && job.Status == JobDone
+		}, 5*time.Second, 10*time.Millisecond)
+		job, err := p.Job("user", id)
+		require.NoError(t, err)
+		assert.Equal(t, affected, job.Affected)
+		assert.Equal(t, 3, job.Merged)
+	}
+	job, err := p.Job("other", ids[2])
+	require.NoError(t, err)
+	assert.Equal(t, JobDone, job.Status)
+	assert.Equal(t, 1, job.Affected)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&s.updates))
+}
+
+func TestMerge(t *testing.T) {
+	batch := []tasks.Task{
+		{ID: "x", UserID: "a", Payload: payload(t, []string{"1"})},
+		{ID: "y", UserID: "b", Payload: payload(t, []string{"1"})},
+		{ID: "z", UserID: "a", Payload: payload(t, []string{"1", "2"})},
+	}
+	groups := merge(batch)
+	require.Len(t, groups, 2)
+	assert.Equal(t, "a", groups[0].userID)
+	assert.Len(t, groups[0].tasks, 2)
+	assert.Equal(t, "b", groups[1].userID)
+
+	ids, err := deleteIDs(groups[0].tasks)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1", "2"}, ids)
+	assert.Equal(t, 2, Delete(nil, 0, 0).Weight(batch[2]))
+}
+
+// failingStorage fail updates of user with error while fails are left
+type failingStorage struct {
+	repository.Repository
+	userID string
+	err    error
+	fails  int32
+}
+
+func (s *failingStorage) BunchUpdateAsDeleted(ctx context.Context, ids []string, userID string) ([]shortlink.Link, error) {
+	if userID == s.userID && atomic.AddInt32(&s.fails, -1) >= 0 {
+		return nil, s.err
+	}
+	return s.Repository.BunchUpdateAsDeleted(ctx, ids, userID)
+}
+
+func TestPool_Retry(t *testing.T) {
+	ctx := context.Background()
+	fs, err := file.New("")
+	require.NoError(t, err)
+	short, err := fs.Save(ctx, "user", "http://retry.ru", shortlink.Options{})
+	require.NoError(t, err)
+
+	s := &failingStorage{Repository: fs, userID: "user", err: context.DeadlineExceeded, fails: 2}
+	p, poolClose := New(ctx, zap.NewNop(), WithType(TypeDelete, Delete(s, 0, 0)), WithRetry(3, 10*time.Millisecond, 20*time.Millisecond))
+	defer poolClose()
+
+	id, err := p.Enqueue(TypeDelete, "user", []string{string(short)})
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		job, err := p.Job("user", id)
+		require.NoError(t, err)
+		return job.Status == JobDone
+	}, 5*time.Second, 10*time.Millisecond)
+	job, err := p.Job("user", id)
+	require.NoError(t, err)
+	assert.Equal(t, 1, job.Affected)
+	assert.Empty(t, job.Error)
+}
+
+func TestPool_DeadLetters(t *testing.T) {
+	ctx := context.Background()
+	fs, err := file.New("")
+	require.NoError(t, err)
+	alive, err := fs.Save(ctx, "other", "http://alive.ru", shortlink.Options{})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		err      error
+		attempts int
+	}{
+		{"attempts exhausted", context.DeadlineExceeded, 2},
+		{"permanent error", Permanent(errors.New("broken")), 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &failingStorage{Repository: fs, userID: "user", err: tt.err, fails: 100}
+			p, poolClose := New(ctx, zap.NewNop(), WithType(TypeDelete, Delete(s, 0, 0)), WithRetry(2, time.Millisecond, time.Millisecond))
+			defer poolClose()
+
+			id, err := p.Enqueue(TypeDelete, "user", []string{"1"})
+			require.NoError(t, err)
+			require.Eventually(t, func() bool {
+				job, err := p.Job("user", id)
+				require.NoError(t, err)
+				return job.Status == JobFailed
+			}, 5*time.Second, 10*time.Millisecond)
+
+			letters, err := p.DeadLetters(ctx, 10)
+			require.NoError(t, err)
+			require.Len(t, letters, 1)
+			assert.Equal(t, id, letters[0].ID)
+			assert.Equal(t, tt.attempts, letters[0].Attempts)
+			assert.Equal(t, tt.err.Error(), letters[0].Error)
+
+			// Pool keeps running after failed task
+			id, err = p.Enqueue(TypeDelete, "other", []string{string(alive)})
+			require.NoError(t, err)
+			require.Eventually(t, func() bool {
+				job, err := p.Job("other", id)
+				require.NoError(t, err)
+				return job.Status == JobDone
+			}, 5*time.Second, 10*time.Millisecond)
+		})
+	}
+}
+
+// payload of task in json
+func payload(t *testing.T, v interface{}) json.RawMessage {
+	data, err := json.Marshal(v)
+	require.NoError(t, err)
+	return data
+}
+
+func TestPool_Types(t *testing.T) {
+	ctx := context.Background()
+	var running, peak int32
+	check := Type{
+		Handler: func(ctx context.Context, userID string, batch []tasks.Task) (Result, error) {
+			n := atomic.AddInt32(&running, 1)
+			defer atomic.AddInt32(&running, -1)
+			for {
+				v := atomic.LoadInt32(&peak)
+				if n <= v || atomic.CompareAndSwapInt32(&peak, v, n) {
+					break
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+
+			var url string
+			if err := json.Unmarshal(batch[0].Payload, &url); err != nil {
+				return Result{}, Permanent(err)
+			}
+			return Result{Affected: 1, Value: map[string]string{"url": url, "status": "alive"}}, nil
+		},
+		Concurrency: 2,
+	}
+	p, poolClose := New(ctx, zap.NewNop(), WithType("check", check), WithWorkers(8))
+	defer poolClose()
+
+	var ids []string
+	for k := 0; k < 6; k++ {
+		id, err := p.Enqueue("check", "user", "http://alive.ru")
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+	for _, id := range ids {
+		wctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		job, err := p.Wait(wctx, "user", id)
+		cancel()
+		require.NoError(t, err)
+		assert.Equal(t, "check", job.Type)
+		assert.Equal(t, JobDone, job.Status)
+		assert.Equal(t, map[string]string{"url": "http://alive.ru", "status": "alive"}, job.Result)
+	}
+	// Workers of type are limited by its concurrency
+	assert.LessOrEqual(t, atomic.LoadInt32(&peak), int32(2))
+
+	// Handler errors are reported to job
+	id, err := p.Enqueue("check", "user", 42)
+	require.NoError(t, err)
+	wctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	job, err := p.Wait(wctx, "user", id)
+	require.NoError(t, err)
+	assert.Equal(t, JobFailed, job.Status)
+	assert.NotEmpty(t, job.Error)
+
+	_, err = p.Enqueue("flush", "user", nil)
+	assert.ErrorIs(t, err, ErrUnknownType)
+	_, err = p.Wait(ctx, "other", id)
+	assert.ErrorIs(t, err, ErrJobNotFound)
+}
+
+func TestPool_Local(t *testing.T) {
+	ctx := context.Background()
+	q, err := tasks.NewFileQueue("", fw.SyncNever, 0)
+	require.NoError(t, err)
+	release := make(chan struct{})
+	local := Type{
+		Handler: func(ctx context.Context, userID string, batch []tasks.Task) (Result, error) {
+			<-release
+			return Result{Affected: len(batch)}, nil
+		},
+		Concurrency: 1,
+		Local:       true,
+	}
+	p, poolClose := New(ctx, zap.NewNop(), WithType("local", local), WithQueue(q))
+	defer poolClose()
+
+	id, err := p.Enqueue("local", "user", nil)
+	require.NoError(t, err)
+	// Task of local type isn't visible for other instances
+	n, err := q.Len(ctx, "local")
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	close(release)
+	job, err := p.Wait(ctx, "user", id)
+	require.NoError(t, err)
+	assert.Equal(t, JobDone, job.Status)
+}
+
+func TestPool_MaxDepth(t *testing.T) {
+	ctx := context.Background()
+	s, err := file.New("")
+	require.NoError(t, err)
+	q, err := tasks.NewFileQueue("", fw.SyncNever, 0)
+	require.NoError(t, err)
+	// Task leased by other instance isn't counted
+	_, err = q.Push(ctx, TypeDelete, "other", payload(t, []string{"1"}))
+	require.NoError(t, err)
+	_, _, err = q.Claim(ctx, time.Hour, []string{TypeDelete})
+	require.NoError(t, err)
+	// Ready task of other instance fills queue, workers don't run before context
+	ready, err := q.Push(ctx, TypeDelete, "other", payload(t, []string{"2"}))
+	require.NoError(t, err)
+
+	deletes := Delete(s, 0, 0)
+	deletes.Concurrency, deletes.MaxDepth = 1, 1
+	wctx, cancel := context.WithCancel(ctx)
+	cancel()
+	p, poolClose := New(wctx, zap.NewNop(), WithType(TypeDelete, deletes), WithQueue(q))
+	defer poolClose()
+
+	_, err = p.Enqueue(TypeDelete, "user", []string{"3"})
+	assert.ErrorIs(t, err, er.ErrQueueFull)
+
+	require.NoError(t, q.Ack(ctx, tasks.Task{ID: ready}))
+	_, err = p.Enqueue(TypeDelete, "user", []string{"3"})
+	assert.NoError(t, err)
+}
+
+// slowQueue push tasks with delay, so concurrent pushes overlap
+type slowQueue struct {
+	tasks.Queue
+}
+
+func (q slowQueue) Push(ctx context.Context, typ, userID string, payload json.RawMessage) (string, error) {
+	time.Sleep(10 * time.Millisecond)
+	return q.Queue.Push(ctx, typ, userID, payload)
+}
+
+func TestPool_MaxDepthConcurrent(t *testing.T) {
+	s, err := file.New("")
+	require.NoError(t, err)
+	q, err := tasks.NewFileQueue("", fw.SyncNever, 0)
+	require.NoError(t, err)
+	deletes := Delete(s, 0, 0)
+	deletes.MaxDepth = 3
+	// Workers don't run before context
+	wctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	p, poolClose := New(wctx, zap.NewNop(), WithType(TypeDelete, deletes), WithQueue(slowQueue{q}))
+	defer poolClose()
+
+	var wg sync.WaitGroup
+	var accepted, rejected int32
+	for k := 0; k < 20; k++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := p.Enqueue(TypeDelete, "user", []string{"1"})
+			if errors.Is(err, er.ErrQueueFull) {
+				atomic.AddInt32(&rejected, 1)
+				return
+			}
+			assert.NoError(t, err)
+			atomic.AddInt32(&accepted, 1)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(3), accepted)
+	assert.Equal(t, int32(17), rejected)
+}
+
+func TestPool_Drain(t *testing.T) {
+	ctx := context.Background()
+	slow := Type{
+		Handler: func(ctx context.Context, userID string, batch []tasks.Task) (Result, error) {
+			time.Sleep(20 * time.Millisecond)
+			return Result{Affected: 1}, nil
+		},
+		Concurrency: 1,
+	}
+	p, _ := New(ctx, zap.NewNop(), WithType("slow", slow))
+
+	var ids []string
+	for k := 0; k < 5; k++ {
+		id, err := p.Enqueue("slow", "user", k)
+		require.NoError(t, err)
+		ids = append(ids, id)
+	}
+	dctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	assert.Equal(t, 0, p.Drain(dctx))
+
+	// Accepted tasks are done before close
+	for _, id := range ids {
+		job, err := p.Job("user", id)
+		require.NoError(t, err)
+		assert.Equal(t, JobDone, job.Status)
+	}
+	_, err := p.Enqueue("slow", "user", 0)
+	assert.ErrorIs(t, err, ErrClosed)
+}
+
+func TestPool_DrainForeign(t *testing.T) {
+	ctx := context.Background()
+	q, err := tasks.NewFileQueue("", fw.SyncNever, 0)
+	require.NoError(t, err)
+	// Task leased by other instance and delayed retry
+	_, err = q.Push(ctx, "slow", "other", payload(t, 1))
+	require.NoError(t, err)
+	_, _, err = q.Claim(ctx, time.Hour, []string{"slow"})
+	require.NoError(t, err)
+	_, err = q.Push(ctx, "slow", "other", payload(t, 2))
+	require.NoError(t, err)
+	delayed, _, err := q.Claim(ctx, time.Hour, []string{"slow"})
+	require.NoError(t, err)
+	require.NoError(t, q.Retry(ctx, delayed, time.Now().Add(time.Hour), "busy"))
+
+	slow := Type{
+		Handler: func(ctx context.Context, userID string, batch []tasks.Task) (Result, error) {
+			return Result{Affected: 1}, nil
+		},
+		Concurrency: 1,
+	}
+	p, _ := New(ctx, zap.NewNop(), WithType("slow", slow), WithQueue(q))
+	id, err := p.Enqueue("slow", "user", 3)
+	require.NoError(t, err)
+
+	// Own task is done, tasks of lease aren't waited
+	dctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	assert.Equal(t, 0, p.Drain(dctx))
+	assert.Less(t, time.Since(start), time.Second)
+	job, err := p.Job("user", id)
+	require.NoError(t, err)
+	assert.Equal(t, JobDone, job.Status)
+	n, err := q.Len(ctx, "slow")
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+}
+
+func TestPool_DrainDeadline(t *testing.T) {
+	ctx := context.Background()
+	q, err := tasks.NewFileQueue("", fw.SyncNever, 0)
+	require.NoError(t, err)
+	stuck := Type{
+		Handler: func(ctx context.Context, userID string, batch []tasks.Task) (Result, error) {
+			<-ctx.Done()
+			return Result{}, ctx.Err()
+		},
+		Concurrency: 1,
+	}
+	p, _ := New(ctx, zap.NewNop(), WithType("stuck", stuck), WithQueue(q))
+	for k := 0; k < 2; k++ {
+		_, err = p.Enqueue("stuck", "user", k)
+		require.NoError(t, err)
+	}
+
+	// Task in progress is cancelled at deadline, tasks stay in queue
+	dctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, 2, p.Drain(dctx))
+	n, err := q.Len(ctx, "stuck")
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+}
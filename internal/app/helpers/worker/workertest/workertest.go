@@ -0,0 +1,37 @@
+// Package workertest helpers of worker pool for tests of handlers
+package workertest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/helpers/worker"
+	fw "github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/filewrapper"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/repository"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/tasks"
+)
+
+// FullPool pool which delete queue is full, so new delete tasks are rejected
+func FullPool(t *testing.T, s repository.Repository) worker.IPool {
+	t.Helper()
+	ctx := context.Background()
+
+	// Queue is filled by ready task of other instance
+	q, err := tasks.NewFileQueue("", fw.SyncNever, 0)
+	require.NoError(t, err)
+	_, err = q.Push(ctx, worker.TypeDelete, "other", []byte(`["1"]`))
+	require.NoError(t, err)
+
+	deletes := worker.Delete(s, 0, 0)
+	deletes.MaxDepth = 1
+	// Workers of cancelled pool don't claim task
+	wctx, cancel := context.WithCancel(ctx)
+	cancel()
+	p, poolClose := worker.New(wctx, zap.NewNop(), worker.WithType(worker.TypeDelete, deletes), worker.WithQueue(q))
+	t.Cleanup(poolClose)
+
+	return p
+}
@@ -0,0 +1,34 @@
+// Package click contain types for redirect analytics
+package click
+
+import (
+	"time"
+
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/shortlink"
+)
+
+// DayLayout format of day in daily statistic
+const DayLayout = "2006-01-02"
+
+// Click event of redirect by short link
+type Click struct {
+	At        time.Time       `json:"at"`
+	Short     shortlink.Short `json:"short"`
+	Referrer  string          `json:"referrer,omitempty"`
+	UserAgent string          `json:"user_agent,omitempty"`
+	// IPHash hash of client ip, raw address is not stored
+	IPHash string `json:"ip_hash,omitempty"`
+}
+
+// DailyCount clicks count in one day by UTC
+type DailyCount struct {
+	Day   string `json:"day"`
+	Count int    `json:"count"`
+}
+
+// Stats clicks statistic of short link
+type Stats struct {
+	Short string       `json:"short"`
+	Total int          `json:"total"`
+	Days  []DailyCount `json:"days"`
+}
@@ -0,0 +1,207 @@
+package shortlink
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	er "github.com/triumphpc/go-musthave-shortener-tpl/internal/app/errors"
+)
+
+// Statuses of links for filter
+const (
+	StatusActive  = "active"
+	StatusDeleted = "deleted"
+	StatusAll     = "all"
+)
+
+// Orders of links by creation time
+const (
+	OrderAsc  = "asc"
+	OrderDesc = "desc"
+)
+
+// Limits of page size
+const (
+	DefaultLimit = 100
+	MaxLimit     = 1000
+)
+
+// Link full record of user link
+type Link struct {
+	Short         Short
+	Origin        string
+	CorrelationID string
+	CreatedAt     time.Time
+	// ExpiresAt zero for link without expiration
+	ExpiresAt time.Time
+	Deleted   bool
+}
+
+// Expired check if link lifetime is over at now
+func (l Link) Expired(now time.Time) bool {
+	return !l.ExpiresAt.IsZero() && !now.Before(l.ExpiresAt)
+}
+
+// Query of user links page. Links are sorted by creation time and short
+type Query struct {
+	// Status active, deleted or all. Expired links are deleted, also before reaper marks them
+	Status string
+	// Domain of origin, subdomains are matched too
+	Domain string
+	// Order asc or desc
+	Order string
+	// Cursor from previous page, empty for first page
+	Cursor string
+	Limit  int
+	// Now time of expiration check, current time if it is empty
+	Now time.Time
+}
+
+// Page of user links
+type Page struct {
+	Links []Link
+	// Total count of links by filters
+	Total int
+	// NextCursor empty on last page
+	NextCursor string
+}
+
+// Cursor position of last link on page
+type Cursor struct {
+	CreatedAt time.Time
+	Short     Short
+}
+
+// cursorData encoded cursor
+type cursorData struct {
+	T int64  `json:"t"`
+	S string `json:"s"`
+}
+
+// Normalize check query and set defaults. Error wraps er.ErrInvalidQuery
+func (q Query) Normalize() (Query, error) {
+	switch q.Status {
+	case "":
+		q.Status = StatusActive
+	case StatusActive, StatusDeleted, StatusAll:
+	default:
+		return q, fmt.Errorf("%w: unknown status %s", er.ErrInvalidQuery, q.Status)
+	}
+	switch q.Order {
+	case "":
+		q.Order = OrderAsc
+	case OrderAsc, OrderDesc:
+	default:
+		return q, fmt.Errorf("%w: unknown order %s", er.ErrInvalidQuery, q.Order)
+	}
+	switch {
+	case q.Limit == 0:
+		q.Limit = DefaultLimit
+	case q.Limit < 0 || q.Limit > MaxLimit:
+		return q, fmt.Errorf("%w: limit must be from 1 to %d", er.ErrInvalidQuery, MaxLimit)
+	}
+	q.Domain = strings.ToLower(strings.TrimSpace(q.Domain))
+	if q.Now.IsZero() {
+		q.Now = time.Now()
+	}
+	if q.Cursor != "" {
+		if _, err := DecodeCursor(q.Cursor); err != nil {
+			return q, err
+		}
+	}
+	return q, nil
+}
+
+// Match check link by status and domain filters
+func (q Query) Match(l Link) bool {
+	gone := l.Deleted || l.Expired(q.Now)
+	switch q.Status {
+	case StatusActive:
+		if gone {
+			return false
+		}
+	case StatusDeleted:
+		if !gone {
+			return false
+		}
+	}
+	return q.Domain == "" || HostMatches(l.Origin, q.Domain)
+}
+
+// Less order of links by creation time and short
+func Less(a, b Link) bool {
+	if !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.Before(b.CreatedAt)
+	}
+	return a.Short < b.Short
+}
+
+// Paginate make page of links in memory by normalized query
+func Paginate(links []Link, q Query) Page {
+	var page Page
+	matched := make([]Link, 0, len(links))
+	for _, l := range links {
+		if q.Match(l) {
+			matched = append(matched, l)
+		}
+	}
+	page.Total = len(matched)
+
+	sort.Slice(matched, func(i, j int) bool {
+		if q.Order == OrderDesc {
+			return Less(matched[j], matched[i])
+		}
+		return Less(matched[i], matched[j])
+	})
+	// Skip links before cursor
+	if q.Cursor != "" {
+		c, _ := DecodeCursor(q.Cursor)
+		at := Link{CreatedAt: c.CreatedAt, Short: c.Short}
+		matched = matched[sort.Search(len(matched), func(i int) bool {
+			if q.Order == OrderDesc {
+				return Less(matched[i], at)
+			}
+			return Less(at, matched[i])
+		}):]
+	}
+	if len(matched) > q.Limit {
+		matched = matched[:q.Limit]
+		page.NextCursor = EncodeCursor(matched[len(matched)-1])
+	}
+	page.Links = matched
+	return page
+}
+
+// HostMatches check host of origin is domain or its subdomain
+func HostMatches(origin, domain string) bool {
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// EncodeCursor of link position
+func EncodeCursor(l Link) string {
+	data, _ := json.Marshal(cursorData{T: l.CreatedAt.UnixNano(), S: string(l.Short)})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeCursor from query. Error wraps er.ErrInvalidQuery
+func DecodeCursor(cursor string) (Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return Cursor{}, fmt.Errorf("%w: bad cursor", er.ErrInvalidQuery)
+	}
+	var c cursorData
+	if err = json.Unmarshal(data, &c); err != nil || c.S == "" {
+		return Cursor{}, fmt.Errorf("%w: bad cursor", er.ErrInvalidQuery)
+	}
+	return Cursor{CreatedAt: time.Unix(0, c.T).UTC(), Short: Short(c.S)}, nil
+}
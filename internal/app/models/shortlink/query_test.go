@@ -0,0 +1,76 @@
+package shortlink
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	er "github.com/triumphpc/go-musthave-shortener-tpl/internal/app/errors"
+)
+
+func TestPaginate(t *testing.T) {
+	at := time.Date(2026, 10, 18, 0, 0, 0, 0, time.UTC)
+	links := []Link{
+		{Short: "c", Origin: "http://a.example.com/1", CreatedAt: at.Add(time.Second)},
+		{Short: "a", Origin: "https://example.com/2", CreatedAt: at},
+		{Short: "b", Origin: "http://other.ru", CreatedAt: at},
+		{Short: "d", Origin: "http://example.com/3", CreatedAt: at.Add(2 * time.Second), Deleted: true},
+	}
+
+	// Walk all pages of active links
+	q, err := Query{Limit: 2}.Normalize()
+	require.NoError(t, err)
+	page := Paginate(links, q)
+	assert.Equal(t, 3, page.Total)
+	assert.Equal(t, []Short{"a", "b"}, shorts(page))
+	require.NotEmpty(t, page.NextCursor)
+
+	q.Cursor = page.NextCursor
+	page = Paginate(links, q)
+	assert.Equal(t, []Short{"c"}, shorts(page))
+	assert.Empty(t, page.NextCursor)
+
+	// Domain with subdomains in all statuses and desc order
+	q, err = Query{Domain: "Example.com", Status: StatusAll, Order: OrderDesc}.Normalize()
+	require.NoError(t, err)
+	page = Paginate(links, q)
+	assert.Equal(t, []Short{"d", "c", "a"}, shorts(page))
+
+	q, err = Query{Status: StatusDeleted}.Normalize()
+	require.NoError(t, err)
+	assert.Equal(t, []Short{"d"}, shorts(Paginate(links, q)))
+
+	// Expired link is deleted before reaper marks it
+	links = append(links, Link{Short: "e", Origin: "http://expired.ru", CreatedAt: at, ExpiresAt: at.Add(time.Minute)})
+	q, err = Query{Now: at.Add(time.Minute)}.Normalize()
+	require.NoError(t, err)
+	page = Paginate(links, q)
+	assert.Equal(t, 3, page.Total)
+	assert.Equal(t, []Short{"a", "b", "c"}, shorts(page))
+	q, err = Query{Status: StatusDeleted, Now: at.Add(time.Minute)}.Normalize()
+	require.NoError(t, err)
+	assert.Equal(t, []Short{"e", "d"}, shorts(Paginate(links, q)))
+}
+
+func TestQuery_Normalize(t *testing.T) {
+	for _, q := range []Query{
+		{Status: "unknown"},
+		{Order: "random"},
+		{Limit: MaxLimit + 1},
+		{Cursor: "bad cursor"},
+	} {
+		_, err := q.Normalize()
+		assert.ErrorIs(t, err, er.ErrInvalidQuery)
+	}
+}
+
+// shorts of page links
+func shorts(page Page) []Short {
+	var result []Short
+	for _, l := range page.Links {
+		result = append(result, l.Short)
+	}
+	return result
+}
@@ -0,0 +1,38 @@
+package shortlink
+
+import "github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/user"
+
+// Record link with owner for moving links between storages
+type Record struct {
+	UserID user.UniqUser
+	Link
+}
+
+// Position of record in storage walk. Records are ordered by user and short
+type Position struct {
+	UserID user.UniqUser
+	Short  Short
+}
+
+// Position of record
+func (r Record) Position() Position {
+	return Position{UserID: r.UserID, Short: r.Short}
+}
+
+// IsZero check if position is start of storage
+func (p Position) IsZero() bool {
+	return p.UserID == "" && p.Short == ""
+}
+
+// Less check if position is before other in walk order
+func (p Position) Less(other Position) bool {
+	if p.UserID != other.UserID {
+		return p.UserID < other.UserID
+	}
+	return p.Short < other.Short
+}
+
+// After check if position is after other in walk order
+func (p Position) After(other Position) bool {
+	return other.Less(p)
+}
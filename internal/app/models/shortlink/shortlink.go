@@ -1,25 +1,101 @@
 // Package shortlink contain types for short link
 package shortlink
 
+import "time"
+
 // ShortLinks maps original and shorts
 type ShortLinks map[Short]string
 
 // Short link
 type Short string
 
-// ShortURLs shorts from mass save
+// Statuses of items from mass save
+const (
+	// ItemCreated new link is saved
+	ItemCreated = "created"
+	// ItemExisting user already has link of origin, short is existing one
+	ItemExisting = "existing"
+	// ItemInvalid item is not saved, reason has details
+	ItemInvalid = "invalid"
+	// ItemAborted item is valid but atomic batch is rolled back
+	ItemAborted = "aborted"
+)
+
+// ShortURLs result of one item from mass save
 type ShortURLs struct {
-	Short string `json:"short_url"`
-	ID    string `json:"correlation_id"`
+	Short  string `json:"short_url,omitempty"`
+	ID     string `json:"correlation_id"`
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// BatchOptions of mass save
+type BatchOptions struct {
+	// Atomic save all items or nothing if any item is invalid
+	Atomic bool
+}
+
+// Created result of saved item
+func Created(id string, short Short) ShortURLs {
+	return ShortURLs{Short: string(short), ID: id, Status: ItemCreated}
+}
+
+// Existing result of item which origin user already has
+func Existing(id string, short Short) ShortURLs {
+	return ShortURLs{Short: string(short), ID: id, Status: ItemExisting}
+}
+
+// Invalid result of item with reason from error
+func Invalid(id string, err error) ShortURLs {
+	return ShortURLs{ID: id, Status: ItemInvalid, Reason: err.Error()}
+}
+
+// HasInvalid check if any result is invalid
+func HasInvalid(results []ShortURLs) bool {
+	for _, v := range results {
+		if v.Status == ItemInvalid {
+			return true
+		}
+	}
+	return false
+}
+
+// Abort mark results of rolled back atomic batch. Invalid items keep their reasons
+func Abort(results []ShortURLs) {
+	for k, v := range results {
+		if v.Status != ItemInvalid {
+			results[k] = ShortURLs{ID: v.ID, Status: ItemAborted}
+		}
+	}
 }
 
 // URL it's users full url
 type URL struct {
 	URL string `json:"url"`
+	// Alias custom short code, optional
+	Alias string `json:"alias,omitempty"`
+	// ExpiresAt time of link expiration, optional
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// TTL duration of link life like 168h, optional
+	TTL string `json:"ttl,omitempty"`
 }
 
 // URLs from mass save
 type URLs struct {
 	ID     string `json:"correlation_id"`
 	Origin string `json:"original_url"`
+	// Alias custom short code, optional
+	Alias string `json:"alias,omitempty"`
+	// ExpiresAt time of link expiration, optional
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// TTL duration of link life like 168h, optional
+	TTL string `json:"ttl,omitempty"`
+}
+
+// Options of new short link
+type Options struct {
+	// Alias custom short code instead of generated one
+	Alias string
+	// ExpiresAt time after which link is gone. Zero value for link without expiration
+	ExpiresAt time.Time
 }
@@ -10,12 +10,17 @@ import (
 	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/configs"
 	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/handlers"
 	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/handlers/delete"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/handlers/export"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/handlers/linkstats"
 	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/handlers/ping"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/handlers/restore"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/handlers/upload"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/helpers/importer"
 	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/helpers/worker"
 )
 
 // Router define routes priority
-func Router(h *handlers.Handler, c *configs.Config, p *worker.Pool) *mux.Router {
+func Router(h *handlers.Handler, c *configs.Config, p *worker.Pool, imp *importer.Importer) *mux.Router {
 	rtr := mux.NewRouter()
 	// Mass save short links
 	rtr.HandleFunc("/api/shorten/batch", h.BunchSaveJSON).Methods(http.MethodPost)
@@ -25,10 +30,26 @@ func Router(h *handlers.Handler, c *configs.Config, p *worker.Pool) *mux.Router
 	rtr.HandleFunc("/api/user/urls", h.GetUrls).Methods(http.MethodGet)
 	// Get user stat
 	rtr.Handle("/api/internal/stats", stats.NewStats(c.Storage, c.Logger)).Methods(http.MethodGet)
+	// Get deletion tasks failed after all attempts
+	rtr.Handle("/api/internal/dead-letters", stats.NewDeadLetters(p, c.Logger)).Methods(http.MethodGet)
 	// Ping db connection
 	rtr.Handle("/ping", ping.NewPing(c.Database, c.Logger)).Methods(http.MethodGet)
+	// Download all user links
+	baseURL, _ := c.Param(configs.BaseURL)
+	rtr.Handle("/api/user/urls/export", export.New(c.Logger, c.Storage, baseURL)).Methods(http.MethodGet)
+	// Import links from csv in background and get report
+	uh := upload.New(c.Logger, imp, baseURL, c.ImportMaxSize)
+	rtr.HandleFunc(upload.Route, uh.Start).Methods(http.MethodPost)
+	rtr.HandleFunc(upload.Route+"/{id}", uh.Status).Methods(http.MethodGet)
+	// Status of deletion job
+	dh := delete.New(c.Logger, p)
+	rtr.HandleFunc(delete.JobsRoute+"/{id}", dh.Status).Methods(http.MethodGet)
+	// Get clicks statistic of user link
+	rtr.Handle("/api/user/urls/{short}/stats", linkstats.New(c.Logger, c.Storage, c.Clicks)).Methods(http.MethodGet)
+	// Restore deleted links within grace period
+	rtr.Handle(restore.Route, restore.New(c.Logger, c.Storage, c.RestoreGrace)).Methods(http.MethodPost)
 	// Delete links session
-	rtr.Handle("/api/user/urls", delete.New(c.Logger, p)).Methods(http.MethodDelete)
+	rtr.Handle("/api/user/urls", dh).Methods(http.MethodDelete)
 	// Get origin by short link
 	rtr.HandleFunc("/{id:.+}", h.Get).Methods(http.MethodGet)
 	// Save origin to short
@@ -0,0 +1,46 @@
+package routes
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/configs"
+	er "github.com/triumphpc/go-musthave-shortener-tpl/internal/app/errors"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/handlers"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/helpers/importer"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/helpers/shortcode"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/helpers/worker"
+)
+
+func TestRouter_ReservedAliases(t *testing.T) {
+	c := configs.Instance()
+	p, poolClose := worker.New(context.Background(), c.Logger)
+	defer poolClose()
+	imp := importer.New(c.Logger, c.Storage)
+	defer imp.Close()
+	rtr := Router(handlers.New(c.Logger, c.Storage), c, p, imp)
+
+	// Every static segment of routes can't be custom short code
+	segments := 0
+	err := rtr.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		tpl, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		for _, segment := range strings.Split(tpl, "/") {
+			if segment == "" || strings.HasPrefix(segment, "{") {
+				continue
+			}
+			segments++
+			assert.ErrorIs(t, shortcode.ValidateAlias(segment), er.ErrInvalidAlias, "route %s", tpl)
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	assert.NotZero(t, segments)
+}
@@ -0,0 +1,831 @@
+// Package bolt contain methods for embedded bbolt key-value storage
+package bolt
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.etcd.io/bbolt"
+
+	er "github.com/triumphpc/go-musthave-shortener-tpl/internal/app/errors"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/helpers/shortcode"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/shortlink"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/user"
+)
+
+// Scheme of DSN for bolt storage, rest of DSN is path of database file
+const Scheme = "bolt://"
+
+// walkChunk count of links read in one transaction of walk
+const walkChunk = 1000
+
+// DefaultOpenTimeout wait of file lock if database is opened by other process
+const DefaultOpenTimeout = time.Second
+
+// Buckets of storage
+var (
+	// bucketShorts maps short and record of link
+	bucketShorts = []byte("shorts")
+	// bucketUsers contain nested bucket for every user, which maps origin and short
+	bucketUsers = []byte("users")
+	// bucketDeletions maps short and time of soft delete
+	bucketDeletions = []byte("deletions")
+	// bucketExpirations index of expiration time and short for reaper
+	bucketExpirations = []byte("expirations")
+	// bucketCounters maps name and max persisted value of counter
+	bucketCounters = []byte("counters")
+)
+
+// keyShortCodes counter of sequence short codes
+var keyShortCodes = []byte("short_codes")
+
+// record stored data of short link
+type record struct {
+	UserID        user.UniqUser `json:"user"`
+	Origin        string        `json:"origin"`
+	CorrelationID string        `json:"correlation_id,omitempty"`
+	// CreatedAt and ExpiresAt unix time in nanoseconds, zero ExpiresAt for link without expiration
+	CreatedAt int64 `json:"created_at"`
+	ExpiresAt int64 `json:"expires_at,omitempty"`
+}
+
+// Storage of links in bbolt database
+type Storage struct {
+	db    *bbolt.DB
+	alloc *shortcode.Allocator
+}
+
+// Option configure Storage
+type Option func(s *Storage)
+
+// WithAllocator set generator of short codes
+func WithAllocator(a *shortcode.Allocator) Option {
+	return func(s *Storage) {
+		s.alloc = a
+	}
+}
+
+// New open bolt database on path and create buckets
+func New(path string, opts ...Option) (*Storage, error) {
+	db, err := bbolt.Open(path, 0666, &bbolt.Options{Timeout: DefaultOpenTimeout})
+	if err != nil {
+		return nil, err
+	}
+	s := &Storage{db: db, alloc: shortcode.Default()}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if err = db.Update(createBuckets); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	// Continue codes counter after last persisted value
+	if c := s.alloc.Counter(); c != nil {
+		err = db.View(func(tx *bbolt.Tx) error {
+			if v := tx.Bucket(bucketCounters).Get(keyShortCodes); v != nil {
+				c.Seed(binary.BigEndian.Uint64(v))
+			}
+			return nil
+		})
+		if err != nil {
+			_ = db.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// LinkByShort get origin by short
+func (s *Storage) LinkByShort(ctx context.Context, short shortlink.Short) (origin string, err error) {
+	if err = ctx.Err(); err != nil {
+		return "", err
+	}
+	err = s.db.View(func(tx *bbolt.Tx) error {
+		r, ok, err := getRecord(tx, short)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return er.ErrURLNotFound
+		}
+		if tx.Bucket(bucketDeletions).Get([]byte(short)) != nil {
+			return er.ErrURLIsGone
+		}
+		if r.ExpiresAt != 0 && time.Now().UnixNano() >= r.ExpiresAt {
+			return er.ErrURLExpired
+		}
+		origin = r.Origin
+		return nil
+	})
+	return origin, err
+}
+
+// RecordByShort get link with owner by short
+func (s *Storage) RecordByShort(ctx context.Context, short shortlink.Short) (rec shortlink.Record, err error) {
+	if err = ctx.Err(); err != nil {
+		return rec, err
+	}
+	err = s.db.View(func(tx *bbolt.Tx) error {
+		r, ok, err := getRecord(tx, short)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return er.ErrURLNotFound
+		}
+		l, _, err := getLink(tx, short)
+		rec = shortlink.Record{UserID: r.UserID, Link: l}
+		return err
+	})
+	return rec, err
+}
+
+// LinksByUser return all user links
+func (s *Storage) LinksByUser(ctx context.Context, userID user.UniqUser) (shortlink.ShortLinks, error) {
+	links := shortlink.ShortLinks{}
+	if err := ctx.Err(); err != nil {
+		return links, err
+	}
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return forEachUserLink(tx, userID, func(l shortlink.Link) error {
+			links[l.Short] = l.Origin
+			return nil
+		})
+	})
+	return links, err
+}
+
+// LinksPage return page of user links by filters
+func (s *Storage) LinksPage(ctx context.Context, userID user.UniqUser, q shortlink.Query) (shortlink.Page, error) {
+	q, err := q.Normalize()
+	if err != nil {
+		return shortlink.Page{}, err
+	}
+	if err = ctx.Err(); err != nil {
+		return shortlink.Page{}, err
+	}
+	var links []shortlink.Link
+	err = s.db.View(func(tx *bbolt.Tx) error {
+		return forEachUserLink(tx, userID, func(l shortlink.Link) error {
+			links = append(links, l)
+			return nil
+		})
+	})
+	if err != nil {
+		return shortlink.Page{}, err
+	}
+	return shortlink.Paginate(links, q), nil
+}
+
+// Save url in storage of short links
+func (s *Storage) Save(ctx context.Context, userID user.UniqUser, url string, opts shortlink.Options) (short shortlink.Short, err error) {
+	if err = ctx.Err(); err != nil {
+		return "", err
+	}
+	r := record{UserID: userID, Origin: url, CreatedAt: time.Now().UnixNano(), ExpiresAt: unixNano(opts.ExpiresAt)}
+	err = s.db.Update(func(tx *bbolt.Tx) error {
+		// Check if user already has short for origin
+		if origins := userOrigins(tx, userID); origins != nil {
+			if current := origins.Get([]byte(url)); current != nil {
+				short = shortlink.Short(current)
+				return er.ErrAlreadyHasShort
+			}
+		}
+		if opts.Alias != "" {
+			taken, err := aliasTaken(tx, userID, opts.Alias, url)
+			if err != nil {
+				return err
+			}
+			if taken {
+				return er.ErrAliasTaken
+			}
+		}
+		short, err = s.save(tx, r, opts.Alias)
+		return err
+	})
+	if err != nil && !errors.Is(err, er.ErrAlreadyHasShort) {
+		return "", err
+	}
+	return short, err
+}
+
+// BunchSave save mass urls in one transaction
+func (s *Storage) BunchSave(ctx context.Context, userID user.UniqUser, urls []shortlink.URLs, opts shortlink.BatchOptions) ([]shortlink.ShortURLs, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	results := make([]shortlink.ShortURLs, len(urls))
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		// Check aliases before save anything
+		aliases := make(map[string]struct{})
+		for k, v := range urls {
+			results[k] = shortlink.ShortURLs{ID: v.ID}
+			if v.Alias == "" {
+				continue
+			}
+			taken, err := aliasTaken(tx, userID, v.Alias, v.Origin)
+			if err != nil {
+				return err
+			}
+			if _, seen := aliases[v.Alias]; seen || taken {
+				results[k] = shortlink.Invalid(v.ID, fmt.Errorf("%w: %s", er.ErrAliasTaken, v.Alias))
+				continue
+			}
+			aliases[v.Alias] = struct{}{}
+		}
+		if opts.Atomic && shortlink.HasInvalid(results) {
+			return er.ErrBatchAborted
+		}
+
+		now := time.Now().UnixNano()
+		origins := userOrigins(tx, userID)
+		for k, v := range urls {
+			if results[k].Status == shortlink.ItemInvalid {
+				continue
+			}
+			// Origins which user already has are returned with current short
+			if origins != nil {
+				if short := origins.Get([]byte(v.Origin)); short != nil {
+					results[k] = shortlink.Existing(v.ID, shortlink.Short(short))
+					continue
+				}
+			}
+			r := record{UserID: userID, Origin: v.Origin, CorrelationID: v.ID, CreatedAt: now}
+			if v.ExpiresAt != nil {
+				r.ExpiresAt = unixNano(*v.ExpiresAt)
+			}
+			short, err := s.save(tx, r, v.Alias)
+			if err != nil {
+				return err
+			}
+			origins = userOrigins(tx, userID)
+			results[k] = shortlink.Created(v.ID, short)
+		}
+		return nil
+	})
+	if errors.Is(err, er.ErrBatchAborted) {
+		shortlink.Abort(results)
+		return results, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// Clear all buckets
+func (s *Storage) Clear(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range [][]byte{bucketShorts, bucketUsers, bucketDeletions, bucketExpirations} {
+			if err := tx.DeleteBucket(name); err != nil {
+				return err
+			}
+		}
+		return createBuckets(tx)
+	})
+}
+
+// BunchUpdateAsDeleted mark user links as deleted by correlation ids or shorts
+func (s *Storage) BunchUpdateAsDeleted(ctx context.Context, ids []string, userID string) ([]shortlink.Link, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	lookup := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		lookup[id] = struct{}{}
+	}
+	var updated []shortlink.Link
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		updated = nil
+		var shorts []shortlink.Short
+		err := forEachUserLink(tx, user.UniqUser(userID), func(l shortlink.Link) error {
+			_, byShort := lookup[string(l.Short)]
+			_, byID := lookup[l.CorrelationID]
+			if !l.Deleted && (byShort || (byID && l.CorrelationID != "")) {
+				shorts = append(shorts, l.Short)
+				updated = append(updated, shortlink.Link{Short: l.Short, CorrelationID: l.CorrelationID})
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return markDeleted(tx, shorts, time.Now())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return updated, nil
+}
+
+// UpdateExpiredAsDeleted mark links expired before now as deleted
+func (s *Storage) UpdateExpiredAsDeleted(ctx context.Context, now time.Time) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	var shorts []shortlink.Short
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(bucketExpirations).Cursor()
+		limit := uint64(now.UnixNano())
+		var keys [][]byte
+		for k, _ := c.First(); k != nil && binary.BigEndian.Uint64(k[:8]) <= limit; k, _ = c.Next() {
+			keys = append(keys, append([]byte(nil), k...))
+			shorts = append(shorts, shortlink.Short(k[8:]))
+		}
+		// Index is not needed after delete
+		b := tx.Bucket(bucketExpirations)
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return markDeleted(tx, shorts, now)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(shorts), nil
+}
+
+// Restore remove user links by correlation ids or shorts from deletions, if they were deleted since time
+// and not expired
+func (s *Storage) Restore(ctx context.Context, ids []string, userID string, since time.Time) ([]shortlink.Short, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	lookup := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		lookup[id] = struct{}{}
+	}
+	now := time.Now()
+	var restored []shortlink.Short
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		restored = nil
+		var links []shortlink.Link
+		err := forEachUserLink(tx, user.UniqUser(userID), func(l shortlink.Link) error {
+			_, byShort := lookup[string(l.Short)]
+			_, byID := lookup[l.CorrelationID]
+			if l.Deleted && (byShort || (byID && l.CorrelationID != "")) && (l.ExpiresAt.IsZero() || now.Before(l.ExpiresAt)) {
+				links = append(links, l)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		deletions := tx.Bucket(bucketDeletions)
+		for _, l := range links {
+			at, err := deletedAt(deletions.Get([]byte(l.Short)))
+			if err != nil {
+				return err
+			}
+			if at.Before(since) {
+				continue
+			}
+			if err = deletions.Delete([]byte(l.Short)); err != nil {
+				return err
+			}
+			// Index can be dropped by import of deleted link
+			if !l.ExpiresAt.IsZero() {
+				if err = tx.Bucket(bucketExpirations).Put(expirationKey(l.ExpiresAt.UnixNano(), l.Short), nil); err != nil {
+					return err
+				}
+			}
+			restored = append(restored, l.Short)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return restored, nil
+}
+
+// Purge remove links which were deleted before time from all buckets
+func (s *Storage) Purge(ctx context.Context, before time.Time) ([]shortlink.Short, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var purged []shortlink.Short
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		var shorts []shortlink.Short
+		err := tx.Bucket(bucketDeletions).ForEach(func(k, v []byte) error {
+			at, err := deletedAt(v)
+			if err != nil {
+				return err
+			}
+			if at.Before(before) {
+				shorts = append(shorts, shortlink.Short(k))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, short := range shorts {
+			if err = purge(tx, short); err != nil {
+				return err
+			}
+		}
+		purged = shorts
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return purged, nil
+}
+
+// URLCount get count of links
+func (s *Storage) URLCount(ctx context.Context) (counter int, err error) {
+	if err = ctx.Err(); err != nil {
+		return 0, err
+	}
+	err = s.db.View(func(tx *bbolt.Tx) error {
+		counter = tx.Bucket(bucketShorts).Stats().KeyN
+		return nil
+	})
+	return counter, err
+}
+
+// UserCount get count of users with links
+func (s *Storage) UserCount(ctx context.Context) (counter int, err error) {
+	if err = ctx.Err(); err != nil {
+		return 0, err
+	}
+	err = s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucketUsers).ForEach(func(_, _ []byte) error {
+			counter++
+			return nil
+		})
+	})
+	return counter, err
+}
+
+// WalkUser call fn for every link of user in order of origins.
+// Links are read by chunks, so transaction is not held while fn works
+func (s *Storage) WalkUser(ctx context.Context, userID user.UniqUser, fn func(l shortlink.Link) error) error {
+	var after []byte
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var links []shortlink.Link
+		err := s.db.View(func(tx *bbolt.Tx) error {
+			origins := userOrigins(tx, userID)
+			if origins == nil {
+				return nil
+			}
+			c := origins.Cursor()
+			k, v := c.First()
+			if after != nil {
+				k, v = c.Seek(after)
+				if k != nil && bytes.Equal(k, after) {
+					k, v = c.Next()
+				}
+			}
+			for ; k != nil && len(links) < walkChunk; k, v = c.Next() {
+				l, ok, err := getLink(tx, shortlink.Short(v))
+				if err != nil {
+					return err
+				}
+				if ok {
+					links = append(links, l)
+				}
+				// Keys are valid only in transaction
+				after = append(after[:0], k...)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, l := range links {
+			if err = fn(l); err != nil {
+				return err
+			}
+		}
+		if len(links) < walkChunk {
+			return nil
+		}
+	}
+}
+
+// Walk call fn for every link in order of user and short after position.
+// Links are read by users, so transaction is not held while fn works
+func (s *Storage) Walk(ctx context.Context, after shortlink.Position, fn func(rec shortlink.Record) error) error {
+	// User of position is walked too, its links are filtered by position
+	userID, include := after.UserID, true
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var links []shortlink.Link
+		found := false
+		err := s.db.View(func(tx *bbolt.Tx) error {
+			c := tx.Bucket(bucketUsers).Cursor()
+			k, _ := c.Seek([]byte(userID))
+			if k != nil && !include && string(k) == string(userID) {
+				k, _ = c.Next()
+			}
+			if k == nil {
+				return nil
+			}
+			found = true
+			userID = user.UniqUser(k)
+			return forEachUserLink(tx, userID, func(l shortlink.Link) error {
+				links = append(links, l)
+				return nil
+			})
+		})
+		if err != nil {
+			return err
+		}
+		if !found {
+			return nil
+		}
+		include = false
+		sort.Slice(links, func(i, j int) bool {
+			return links[i].Short < links[j].Short
+		})
+		for _, l := range links {
+			rec := shortlink.Record{UserID: userID, Link: l}
+			if !rec.Position().After(after) {
+				continue
+			}
+			if err = fn(rec); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Import save records with their shorts in one transaction.
+// Existing records are skipped, conflicts rollback whole batch
+func (s *Storage) Import(ctx context.Context, recs []shortlink.Record) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	imported := 0
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		now := time.Now()
+		for _, rec := range recs {
+			current, ok, err := getRecord(tx, rec.Short)
+			if err != nil {
+				return err
+			}
+			if ok {
+				if current.UserID == rec.UserID && current.Origin == rec.Origin {
+					continue
+				}
+				return fmt.Errorf("%w: short %s", er.ErrImportConflict, rec.Short)
+			}
+			if origins := userOrigins(tx, rec.UserID); origins != nil && origins.Get([]byte(rec.Origin)) != nil {
+				return fmt.Errorf("%w: user %s already has %s", er.ErrImportConflict, rec.UserID, rec.Origin)
+			}
+			r := record{
+				UserID:        rec.UserID,
+				Origin:        rec.Origin,
+				CorrelationID: rec.CorrelationID,
+				CreatedAt:     unixNano(rec.CreatedAt),
+				ExpiresAt:     unixNano(rec.ExpiresAt),
+			}
+			if err = putRecord(tx, rec.Short, r); err != nil {
+				return err
+			}
+			if rec.Deleted {
+				// Deleted link is not needed in expirations index
+				if r.ExpiresAt != 0 {
+					if err = tx.Bucket(bucketExpirations).Delete(expirationKey(r.ExpiresAt, rec.Short)); err != nil {
+						return err
+					}
+				}
+				if err = markDeleted(tx, []shortlink.Short{rec.Short}, now); err != nil {
+					return err
+				}
+			}
+			imported++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return imported, nil
+}
+
+// Close database file
+func (s *Storage) Close() error {
+	return s.db.Close()
+}
+
+// save record with alias or generated short in transaction
+func (s *Storage) save(tx *bbolt.Tx, r record, alias string) (shortlink.Short, error) {
+	put := func(short shortlink.Short) error {
+		if tx.Bucket(bucketShorts).Get([]byte(short)) != nil {
+			return shortcode.ErrCollision
+		}
+		return putRecord(tx, short, r)
+	}
+	if alias != "" {
+		return shortlink.Short(alias), put(shortlink.Short(alias))
+	}
+	short, err := s.alloc.Allocate(r.Origin, put)
+	if err != nil {
+		return short, err
+	}
+	// Counter is kept in same transaction as link, so restart don't repeat persisted codes
+	if c := s.alloc.Counter(); c != nil {
+		return short, markCounter(tx, c.Value())
+	}
+	return short, nil
+}
+
+// markCounter persist value of codes counter if it is bigger than stored one
+func markCounter(tx *bbolt.Tx, value uint64) error {
+	b := tx.Bucket(bucketCounters)
+	if v := b.Get(keyShortCodes); v != nil && binary.BigEndian.Uint64(v) >= value {
+		return nil
+	}
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint64(v, value)
+	return b.Put(keyShortCodes, v)
+}
+
+// putRecord write record and indexes of new link
+func putRecord(tx *bbolt.Tx, short shortlink.Short, r record) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	if err = tx.Bucket(bucketShorts).Put([]byte(short), data); err != nil {
+		return err
+	}
+	origins, err := tx.Bucket(bucketUsers).CreateBucketIfNotExists([]byte(r.UserID))
+	if err != nil {
+		return err
+	}
+	if err = origins.Put([]byte(r.Origin), []byte(short)); err != nil {
+		return err
+	}
+	if r.ExpiresAt != 0 {
+		return tx.Bucket(bucketExpirations).Put(expirationKey(r.ExpiresAt, short), nil)
+	}
+	return nil
+}
+
+// getRecord read record of short
+func getRecord(tx *bbolt.Tx, short shortlink.Short) (record, bool, error) {
+	var r record
+	data := tx.Bucket(bucketShorts).Get([]byte(short))
+	if data == nil {
+		return r, false, nil
+	}
+	return r, true, json.Unmarshal(data, &r)
+}
+
+// forEachUserLink call fn for every link of user
+func forEachUserLink(tx *bbolt.Tx, userID user.UniqUser, fn func(l shortlink.Link) error) error {
+	origins := userOrigins(tx, userID)
+	if origins == nil {
+		return nil
+	}
+	return origins.ForEach(func(_, v []byte) error {
+		l, ok, err := getLink(tx, shortlink.Short(v))
+		if err != nil || !ok {
+			return err
+		}
+		return fn(l)
+	})
+}
+
+// getLink read record of short with deleted flag as model
+func getLink(tx *bbolt.Tx, short shortlink.Short) (shortlink.Link, bool, error) {
+	r, ok, err := getRecord(tx, short)
+	if err != nil || !ok {
+		return shortlink.Link{}, ok, err
+	}
+	l := shortlink.Link{
+		Short:         short,
+		Origin:        r.Origin,
+		CorrelationID: r.CorrelationID,
+		CreatedAt:     fromUnixNano(r.CreatedAt),
+		ExpiresAt:     fromUnixNano(r.ExpiresAt),
+		Deleted:       tx.Bucket(bucketDeletions).Get([]byte(short)) != nil,
+	}
+	return l, true, nil
+}
+
+// markDeleted put shorts to deletions bucket
+func markDeleted(tx *bbolt.Tx, shorts []shortlink.Short, at time.Time) error {
+	b := tx.Bucket(bucketDeletions)
+	value := []byte(at.UTC().Format(time.RFC3339Nano))
+	for _, short := range shorts {
+		if b.Get([]byte(short)) != nil {
+			continue
+		}
+		if err := b.Put([]byte(short), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deletedAt parse time of soft delete from deletions bucket
+func deletedAt(value []byte) (time.Time, error) {
+	return time.Parse(time.RFC3339Nano, string(value))
+}
+
+// purge remove link from all buckets. Bucket of user is removed with last link
+func purge(tx *bbolt.Tx, short shortlink.Short) error {
+	r, ok, err := getRecord(tx, short)
+	if err != nil {
+		return err
+	}
+	if err = tx.Bucket(bucketDeletions).Delete([]byte(short)); err != nil || !ok {
+		return err
+	}
+	if r.ExpiresAt != 0 {
+		if err = tx.Bucket(bucketExpirations).Delete(expirationKey(r.ExpiresAt, short)); err != nil {
+			return err
+		}
+	}
+	if err = tx.Bucket(bucketShorts).Delete([]byte(short)); err != nil {
+		return err
+	}
+	origins := userOrigins(tx, r.UserID)
+	if origins == nil {
+		return nil
+	}
+	if bytes.Equal(origins.Get([]byte(r.Origin)), []byte(short)) {
+		if err = origins.Delete([]byte(r.Origin)); err != nil {
+			return err
+		}
+	}
+	if k, _ := origins.Cursor().First(); k == nil {
+		return tx.Bucket(bucketUsers).DeleteBucket([]byte(r.UserID))
+	}
+	return nil
+}
+
+// userOrigins bucket of user, nil if user has no links
+func userOrigins(tx *bbolt.Tx, userID user.UniqUser) *bbolt.Bucket {
+	return tx.Bucket(bucketUsers).Bucket([]byte(userID))
+}
+
+// aliasTaken check if alias is used by another link
+func aliasTaken(tx *bbolt.Tx, userID user.UniqUser, alias, origin string) (bool, error) {
+	r, ok, err := getRecord(tx, shortlink.Short(alias))
+	if err != nil {
+		return false, err
+	}
+	return ok && (r.UserID != userID || r.Origin != origin), nil
+}
+
+// expirationKey sorted by time key of expirations index
+func expirationKey(expiresAt int64, short shortlink.Short) []byte {
+	key := make([]byte, 8, 8+len(short))
+	binary.BigEndian.PutUint64(key, uint64(expiresAt))
+	return append(key, short...)
+}
+
+// createBuckets of storage if not exist
+func createBuckets(tx *bbolt.Tx) error {
+	for _, name := range [][]byte{bucketShorts, bucketUsers, bucketDeletions, bucketExpirations, bucketCounters} {
+		if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// unixNano convert expiration time, zero time is kept as zero
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// fromUnixNano convert time from record, zero is kept as zero time
+func fromUnixNano(nsec int64) time.Time {
+	if nsec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nsec).UTC()
+}
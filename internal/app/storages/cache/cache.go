@@ -0,0 +1,181 @@
+// Package cache implement read-through LRU cache decorator for repository
+package cache
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	er "github.com/triumphpc/go-musthave-shortener-tpl/internal/app/errors"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/shortlink"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/user"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/repository"
+)
+
+// Defaults of cache
+const (
+	DefaultSize        = 10000
+	DefaultTTL         = time.Minute
+	DefaultNegativeTTL = 10 * time.Second
+)
+
+// Storage cache results of LinkByShort before wrapped repository.
+// Links are looked up by RecordByShort, so cached link ends at its expiration if it is before ttl.
+// Unknown, deleted and expired shorts are cached too with own ttl.
+// Other methods are passed to wrapped repository and invalidate changed shorts
+type Storage struct {
+	repository.Repository
+	items       *lru
+	ttl         time.Duration
+	negativeTTL time.Duration
+	now         func() time.Time
+}
+
+// Option configure cache
+type Option func(s *Storage)
+
+// WithSize set max count of cached shorts
+func WithSize(size int) Option {
+	return func(s *Storage) {
+		s.items = newLRU(size)
+	}
+}
+
+// WithTTL set lifetime of found links
+func WithTTL(ttl time.Duration) Option {
+	return func(s *Storage) {
+		s.ttl = ttl
+	}
+}
+
+// WithNegativeTTL set lifetime of unknown and deleted shorts
+func WithNegativeTTL(ttl time.Duration) Option {
+	return func(s *Storage) {
+		s.negativeTTL = ttl
+	}
+}
+
+// New cache for repository
+func New(r repository.Repository, opts ...Option) *Storage {
+	s := &Storage{
+		Repository:  r,
+		items:       newLRU(DefaultSize),
+		ttl:         DefaultTTL,
+		negativeTTL: DefaultNegativeTTL,
+		now:         time.Now,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// LinkByShort get origin from cache or from repository
+func (s *Storage) LinkByShort(ctx context.Context, short shortlink.Short) (string, error) {
+	now := s.now()
+	if it, ok := s.items.get(short, now); ok {
+		return it.origin, it.err
+	}
+	t := s.items.begin(short)
+	rec, err := s.Repository.RecordByShort(ctx, short)
+	var it *item
+	switch {
+	case errors.Is(err, er.ErrURLNotFound):
+		it = &item{short: short, err: err, expires: now.Add(s.negativeTTL)}
+	case err != nil:
+	case rec.Deleted:
+		err = er.ErrURLIsGone
+		it = &item{short: short, err: err, expires: now.Add(s.negativeTTL)}
+	case !rec.ExpiresAt.IsZero() && !now.Before(rec.ExpiresAt):
+		err = er.ErrURLExpired
+		it = &item{short: short, err: err, expires: now.Add(s.negativeTTL)}
+	default:
+		// Link is cached not longer than it lives
+		expires := now.Add(s.ttl)
+		if !rec.ExpiresAt.IsZero() && rec.ExpiresAt.Before(expires) {
+			expires = rec.ExpiresAt
+		}
+		it = &item{short: short, origin: rec.Origin, expires: expires}
+	}
+	s.items.end(t, it)
+	if err != nil {
+		return "", err
+	}
+	return rec.Origin, nil
+}
+
+// Save link and drop negative cache of new short
+func (s *Storage) Save(ctx context.Context, userID user.UniqUser, url string, opts shortlink.Options) (shortlink.Short, error) {
+	short, err := s.Repository.Save(ctx, userID, url, opts)
+	if short != "" {
+		s.items.evict(short)
+	}
+	return short, err
+}
+
+// BunchSave links and drop negative cache of new shorts
+func (s *Storage) BunchSave(ctx context.Context, userID user.UniqUser, urls []shortlink.URLs, opts shortlink.BatchOptions) ([]shortlink.ShortURLs, error) {
+	shorts, err := s.Repository.BunchSave(ctx, userID, urls, opts)
+	for _, v := range shorts {
+		if v.Status == shortlink.ItemCreated {
+			s.items.evict(shortlink.Short(v.Short))
+		}
+	}
+	return shorts, err
+}
+
+// Clear repository and cache
+func (s *Storage) Clear(ctx context.Context) error {
+	defer s.items.purge()
+	return s.Repository.Clear(ctx)
+}
+
+// BunchUpdateAsDeleted mark links deleted and drop updated shorts from cache
+func (s *Storage) BunchUpdateAsDeleted(ctx context.Context, ids []string, userID string) ([]shortlink.Link, error) {
+	updated, err := s.Repository.BunchUpdateAsDeleted(ctx, ids, userID)
+	for _, l := range updated {
+		s.items.evict(l.Short)
+	}
+	return updated, err
+}
+
+// Restore links and drop restored shorts from cache, where they are kept as gone
+func (s *Storage) Restore(ctx context.Context, ids []string, userID string, since time.Time) ([]shortlink.Short, error) {
+	restored, err := s.Repository.Restore(ctx, ids, userID, since)
+	s.items.evict(restored...)
+	return restored, err
+}
+
+// Purge removed links and drop purged shorts from cache
+func (s *Storage) Purge(ctx context.Context, before time.Time) ([]shortlink.Short, error) {
+	purged, err := s.Repository.Purge(ctx, before)
+	s.items.evict(purged...)
+	return purged, err
+}
+
+// UpdateExpiredAsDeleted mark expired links deleted and drop cache if something is changed
+func (s *Storage) UpdateExpiredAsDeleted(ctx context.Context, now time.Time) (int, error) {
+	updated, err := s.Repository.UpdateExpiredAsDeleted(ctx, now)
+	if updated > 0 {
+		s.items.purge()
+	}
+	return updated, err
+}
+
+// Import records and evict their shorts, which can be cached as not found
+func (s *Storage) Import(ctx context.Context, recs []shortlink.Record) (int, error) {
+	imported, err := s.Repository.Import(ctx, recs)
+	for _, rec := range recs {
+		s.items.evict(rec.Short)
+	}
+	return imported, err
+}
+
+// Close wrapped repository if it can be closed
+func (s *Storage) Close() error {
+	if closer, ok := s.Repository.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package cache
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	er "github.com/triumphpc/go-musthave-shortener-tpl/internal/app/errors"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/shortlink"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/file"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/repository"
+)
+
+// countingRepository count lookups in wrapped repository
+type countingRepository struct {
+	repository.Repository
+	lookups int
+	// started and hold lookup after repository result if they are set
+	started chan struct{}
+	hold    chan struct{}
+}
+
+func (r *countingRepository) RecordByShort(ctx context.Context, short shortlink.Short) (shortlink.Record, error) {
+	r.lookups++
+	rec, err := r.Repository.RecordByShort(ctx, short)
+	if r.hold != nil {
+		r.started <- struct{}{}
+		<-r.hold
+	}
+	return rec, err
+}
+
+func newCounting(t *testing.T) *countingRepository {
+	s, err := file.New("")
+	require.NoError(t, err)
+	return &countingRepository{Repository: s}
+}
+
+func TestStorage_LinkByShort(t *testing.T) {
+	ctx := context.Background()
+	r := newCounting(t)
+	s := New(r)
+
+	short, err := s.Save(ctx, "user", "http://hot.ru", shortlink.Options{})
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		origin, err := s.LinkByShort(ctx, short)
+		require.NoError(t, err)
+		assert.Equal(t, "http://hot.ru", origin)
+	}
+	assert.Equal(t, 1, r.lookups)
+
+	// Unknown short is cached until it is saved
+	for i := 0; i < 2; i++ {
+		_, err = s.LinkByShort(ctx, "my-alias")
+		assert.ErrorIs(t, err, er.ErrURLNotFound)
+	}
+	assert.Equal(t, 2, r.lookups)
+	_, err = s.Save(ctx, "user", "http://alias.ru", shortlink.Options{Alias: "my-alias"})
+	require.NoError(t, err)
+	origin, err := s.LinkByShort(ctx, "my-alias")
+	require.NoError(t, err)
+	assert.Equal(t, "http://alias.ru", origin)
+}
+
+func TestStorage_BunchUpdateAsDeleted(t *testing.T) {
+	ctx := context.Background()
+	s := New(newCounting(t))
+
+	shorts, err := s.BunchSave(ctx, "user", []shortlink.URLs{{ID: "1", Origin: "http://one.ru"}}, shortlink.BatchOptions{})
+	require.NoError(t, err)
+	short := shortlink.Short(shorts[0].Short)
+	_, err = s.LinkByShort(ctx, short)
+	require.NoError(t, err)
+
+	// Deleted by correlation id
+	_, err = s.BunchUpdateAsDeleted(ctx, []string{"1"}, "user")
+	require.NoError(t, err)
+	_, err = s.LinkByShort(ctx, short)
+	assert.ErrorIs(t, err, er.ErrURLIsGone)
+}
+
+func TestStorage_EvictAffected(t *testing.T) {
+	ctx := context.Background()
+	r := newCounting(t)
+	s := New(r)
+
+	shorts, err := s.BunchSave(ctx, "user", []shortlink.URLs{
+		{ID: "1", Origin: "http://one.ru"},
+		{ID: "2", Origin: "http://two.ru"},
+	}, shortlink.BatchOptions{})
+	require.NoError(t, err)
+	for _, v := range shorts {
+		_, err = s.LinkByShort(ctx, shortlink.Short(v.Short))
+		require.NoError(t, err)
+	}
+
+	// Only deleted and restored links are looked up again
+	deleted, err := s.BunchUpdateAsDeleted(ctx, []string{"1"}, "user")
+	require.NoError(t, err)
+	assert.Equal(t, []shortlink.Link{{Short: shortlink.Short(shorts[0].Short), CorrelationID: "1"}}, deleted)
+	_, err = s.LinkByShort(ctx, shortlink.Short(shorts[1].Short))
+	require.NoError(t, err)
+	assert.Equal(t, 2, r.lookups)
+	_, err = s.LinkByShort(ctx, shortlink.Short(shorts[0].Short))
+	assert.ErrorIs(t, err, er.ErrURLIsGone)
+	assert.Equal(t, 3, r.lookups)
+
+	restored, err := s.Restore(ctx, []string{"1"}, "user", time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Len(t, restored, 1)
+	_, err = s.LinkByShort(ctx, shortlink.Short(shorts[0].Short))
+	require.NoError(t, err)
+	assert.Equal(t, 4, r.lookups)
+}
+
+func TestStorage_LookupInFlight(t *testing.T) {
+	ctx := context.Background()
+	r := newCounting(t)
+	s := New(r)
+	short, err := s.Save(ctx, "user", "http://hot.ru", shortlink.Options{})
+	require.NoError(t, err)
+
+	// lookup short and change cache during lookup
+	lookup := func(change func()) {
+		r.started, r.hold = make(chan struct{}), make(chan struct{})
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			_, _ = s.LinkByShort(ctx, short)
+		}()
+		<-r.started
+		change()
+		close(r.hold)
+		<-done
+		r.started, r.hold = nil, nil
+	}
+
+	// Save of other short doesn't drop result of lookup
+	lookup(func() {
+		_, err := s.Save(ctx, "user", "http://other.ru", shortlink.Options{})
+		require.NoError(t, err)
+	})
+	_, err = s.LinkByShort(ctx, short)
+	require.NoError(t, err)
+	assert.Equal(t, 1, r.lookups)
+
+	// Result of lookup is stale after delete of short
+	s.items.evict(short)
+	lookup(func() {
+		_, err := s.BunchUpdateAsDeleted(ctx, []string{string(short)}, "user")
+		require.NoError(t, err)
+	})
+	_, err = s.LinkByShort(ctx, short)
+	assert.ErrorIs(t, err, er.ErrURLIsGone)
+	assert.Equal(t, 3, r.lookups)
+	assert.Empty(t, s.items.flights)
+}
+
+func TestStorage_Eviction(t *testing.T) {
+	ctx := context.Background()
+	r := newCounting(t)
+	s := New(r, WithSize(1), WithTTL(time.Minute))
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	first, err := s.Save(ctx, "user", "http://first.ru", shortlink.Options{})
+	require.NoError(t, err)
+	second, err := s.Save(ctx, "user", "http://second.ru", shortlink.Options{})
+	require.NoError(t, err)
+
+	_, _ = s.LinkByShort(ctx, first)
+	_, _ = s.LinkByShort(ctx, second)
+	// First is evicted by size
+	_, _ = s.LinkByShort(ctx, first)
+	assert.Equal(t, 3, r.lookups)
+	assert.Equal(t, 1, s.items.len())
+
+	// Expired by ttl
+	now = now.Add(time.Minute)
+	_, _ = s.LinkByShort(ctx, first)
+	assert.Equal(t, 4, r.lookups)
+}
+
+func TestStorage_ExpiresAt(t *testing.T) {
+	ctx := context.Background()
+	r := newCounting(t)
+	s := New(r, WithTTL(time.Hour))
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	short, err := s.Save(ctx, "user", "http://expiring.ru", shortlink.Options{ExpiresAt: now.Add(time.Minute)})
+	require.NoError(t, err)
+	origin, err := s.LinkByShort(ctx, short)
+	require.NoError(t, err)
+	assert.Equal(t, "http://expiring.ru", origin)
+
+	// Cached link ends at its expiration before ttl
+	now = now.Add(time.Minute)
+	_, err = s.LinkByShort(ctx, short)
+	assert.ErrorIs(t, err, er.ErrURLExpired)
+	assert.Equal(t, 2, r.lookups)
+}
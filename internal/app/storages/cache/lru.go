@@ -0,0 +1,158 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/shortlink"
+)
+
+// item cached result of lookup by short
+type item struct {
+	short   shortlink.Short
+	origin  string
+	err     error
+	expires time.Time
+}
+
+// flight lookups of one short in wrapped repository
+type flight struct {
+	// readers count of lookups in progress
+	readers int
+	// gen changed by every eviction of short
+	gen uint64
+}
+
+// ticket of lookup, its result is added if short and cache weren't invalidated after start of lookup
+type ticket struct {
+	short shortlink.Short
+	gen   uint64
+	purge uint64
+}
+
+// lru list of items with recently used in front and index by short
+type lru struct {
+	mu    sync.Mutex
+	size  int
+	ll    *list.List
+	items map[shortlink.Short]*list.Element
+	// Generations of shorts with lookups in progress
+	flights map[shortlink.Short]*flight
+	// purges changed by every purge of all items
+	purges uint64
+}
+
+// newLRU cache with max count of items
+func newLRU(size int) *lru {
+	return &lru{
+		size:    size,
+		ll:      list.New(),
+		items:   make(map[shortlink.Short]*list.Element),
+		flights: make(map[shortlink.Short]*flight),
+	}
+}
+
+// get not expired item and move it to front
+func (c *lru) get(short shortlink.Short, now time.Time) (item, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[short]
+	if !ok {
+		return item{}, false
+	}
+	it := el.Value.(*item)
+	if !now.Before(it.expires) {
+		c.remove(el)
+		return item{}, false
+	}
+	c.ll.MoveToFront(el)
+	return *it, true
+}
+
+// begin lookup of short in wrapped repository. Ticket must be passed to end
+func (c *lru) begin(short shortlink.Short) ticket {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	f, ok := c.flights[short]
+	if !ok {
+		f = &flight{}
+		c.flights[short] = f
+	}
+	f.readers++
+	return ticket{short: short, gen: f.gen, purge: c.purges}
+}
+
+// end lookup and add its item if it is not nil. Item is skipped if its short or all items
+// were invalidated after begin, because it can be stale
+func (c *lru) end(t ticket, it *item) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	f := c.flights[t.short]
+	if f.readers--; f.readers == 0 {
+		delete(c.flights, t.short)
+	}
+	if it == nil || f.gen != t.gen || c.purges != t.purge {
+		return
+	}
+	c.add(*it)
+}
+
+// add item to front and evict least recently used items over size. Must be called under lock
+func (c *lru) add(it item) {
+	if c.size <= 0 {
+		return
+	}
+	if el, ok := c.items[it.short]; ok {
+		el.Value = &it
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.items[it.short] = c.ll.PushFront(&it)
+	for c.ll.Len() > c.size {
+		c.remove(c.ll.Back())
+	}
+}
+
+// evict items by shorts
+func (c *lru) evict(shorts ...shortlink.Short) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, short := range shorts {
+		if el, ok := c.items[short]; ok {
+			c.remove(el)
+		}
+		// Lookups in progress can get state before eviction
+		if f, ok := c.flights[short]; ok {
+			f.gen++
+		}
+	}
+}
+
+// purge all items
+func (c *lru) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.purges++
+	c.ll.Init()
+	c.items = make(map[shortlink.Short]*list.Element)
+}
+
+// len count of items
+func (c *lru) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.ll.Len()
+}
+
+// remove element. Must be called under lock
+func (c *lru) remove(el *list.Element) {
+	c.ll.Remove(el)
+	delete(c.items, el.Value.(*item).short)
+}
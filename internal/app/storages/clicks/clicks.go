@@ -0,0 +1,19 @@
+// Package clicks contain storages of redirect click events
+package clicks
+
+import (
+	"context"
+
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/click"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/shortlink"
+)
+
+// Store persist click events and count them by days
+type Store interface {
+	// Save batch of click events
+	Save(ctx context.Context, clicks []click.Click) error
+	// Daily get clicks count of short by UTC days in ascending order
+	Daily(ctx context.Context, short shortlink.Short) ([]click.DailyCount, error)
+	// Delete all clicks of shorts, it is called for purged links
+	Delete(ctx context.Context, shorts []shortlink.Short) error
+}
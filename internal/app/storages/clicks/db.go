@@ -0,0 +1,119 @@
+package clicks
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/click"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/shortlink"
+)
+
+// sqlNewClick for new click event
+const sqlNewClick = `
+insert into storage.clicks (short, clicked_at, referrer, user_agent, ip_hash) 
+values ($1, $2, $3, $4, $5)
+`
+
+// sqlDailyClicks count clicks by UTC days
+const sqlDailyClicks = `
+select to_char(clicked_at at time zone 'UTC', 'YYYY-MM-DD') as day, count(*) 
+from storage.clicks 
+where short=$1 
+group by day 
+order by day
+`
+
+// sqlDeleteClicks remove clicks of shorts
+const sqlDeleteClicks = `delete from storage.clicks where short = any($1)`
+
+// PostgreSQLStore store of clicks in storage.clicks table.
+// Table is created by migrations of links storage
+type PostgreSQLStore struct {
+	db      *sql.DB
+	timeout time.Duration
+}
+
+// NewPostgreSQLStore store on connection with timeout of queries. Zero timeout disable limit
+func NewPostgreSQLStore(db *sql.DB, timeout time.Duration) *PostgreSQLStore {
+	return &PostgreSQLStore{db: db, timeout: timeout}
+}
+
+// Save batch of clicks in one transaction
+func (s *PostgreSQLStore) Save(ctx context.Context, clicks []click.Click) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	stmt, err := tx.PrepareContext(ctx, sqlNewClick)
+	if err != nil {
+		return err
+	}
+	defer func(stmt *sql.Stmt) {
+		_ = stmt.Close()
+	}(stmt)
+
+	for _, c := range clicks {
+		if _, err = stmt.ExecContext(ctx, string(c.Short), c.At, c.Referrer, c.UserAgent, c.IPHash); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Daily get clicks count of short by days
+func (s *PostgreSQLStore) Daily(ctx context.Context, short shortlink.Short) ([]click.DailyCount, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, sqlDailyClicks, string(short))
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	days := make([]click.DailyCount, 0)
+	for rows.Next() {
+		var d click.DailyCount
+		if err = rows.Scan(&d.Day, &d.Count); err != nil {
+			return nil, err
+		}
+		days = append(days, d)
+	}
+	return days, rows.Err()
+}
+
+// Delete clicks of shorts
+func (s *PostgreSQLStore) Delete(ctx context.Context, shorts []shortlink.Short) error {
+	if len(shorts) == 0 {
+		return nil
+	}
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	values := make([]string, len(shorts))
+	for k, short := range shorts {
+		values[k] = string(short)
+	}
+	_, err := s.db.ExecContext(ctx, sqlDeleteClicks, pq.Array(values))
+	return err
+}
+
+// withTimeout limit context by timeout if it set
+func (s *PostgreSQLStore) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, s.timeout)
+}
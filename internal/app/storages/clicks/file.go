@@ -0,0 +1,233 @@
+package clicks
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/click"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/shortlink"
+	fw "github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/filewrapper"
+)
+
+// DefaultCompactEvery count of appended events after which journal compacts
+const DefaultCompactEvery = 10000
+
+// FileStore keep daily counters in memory and events in append-only journal.
+// Compaction replace raw events of journal by daily counters
+type FileStore struct {
+	mu           sync.RWMutex
+	daily        map[shortlink.Short]map[string]int
+	journal      *fw.Journal
+	compactEvery int
+	// appended records since last compaction
+	appended int
+	// counters count of short and day pairs
+	counters int
+}
+
+// FileOption configure FileStore
+type FileOption func(s *FileStore)
+
+// WithCompaction set count of appended events after which journal compacts
+func WithCompaction(every int) FileOption {
+	return func(s *FileStore) {
+		s.compactEvery = every
+	}
+}
+
+// entry record of journal: raw click event, daily counter of compacted journal or deletion of shorts
+type entry struct {
+	click.Click
+	// Day of counter, empty for raw event
+	Day   string `json:"day,omitempty"`
+	Count int    `json:"count,omitempty"`
+	// Deleted shorts which clicks are removed
+	Deleted []shortlink.Short `json:"deleted,omitempty"`
+}
+
+// NewFileStore open journal of clicks on path and count events from it.
+// Empty path for memory only store
+func NewFileStore(path string, policy fw.SyncPolicy, interval time.Duration, opts ...FileOption) (*FileStore, error) {
+	s := &FileStore{daily: make(map[shortlink.Short]map[string]int), compactEvery: DefaultCompactEvery}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if path == "" {
+		return s, nil
+	}
+	j, err := fw.OpenJournal(path, policy, interval)
+	if err != nil {
+		return nil, err
+	}
+	s.journal = j
+
+	err = j.Replay(func(data []byte) error {
+		var e entry
+		if err := json.Unmarshal(data, &e); err != nil {
+			return err
+		}
+		switch {
+		case len(e.Deleted) > 0:
+			s.drop(e.Deleted)
+		case e.Day != "":
+			s.add(e.Short, e.Day, e.Count)
+		default:
+			s.count(e.Click)
+		}
+		s.appended++
+		return nil
+	})
+	if err != nil {
+		_ = j.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Save append clicks to journal and counters. Batch is counted only after all events are in journal,
+// so failed batch is not counted in memory
+func (s *FileStore) Save(ctx context.Context, clicks []click.Click) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.journal != nil {
+		for _, c := range clicks {
+			data, err := json.Marshal(c)
+			if err != nil {
+				return err
+			}
+			if err = s.journal.Append(data); err != nil {
+				return err
+			}
+			s.appended++
+		}
+	}
+	for _, c := range clicks {
+		s.count(c)
+	}
+	return s.compactIfNeeded()
+}
+
+// Daily get clicks count of short by days
+func (s *FileStore) Daily(ctx context.Context, short shortlink.Short) ([]click.DailyCount, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	days := make([]click.DailyCount, 0, len(s.daily[short]))
+	for day, count := range s.daily[short] {
+		days = append(days, click.DailyCount{Day: day, Count: count})
+	}
+	// Days in layout are sorted as strings
+	sort.Slice(days, func(i, j int) bool {
+		return days[i].Day < days[j].Day
+	})
+	return days, nil
+}
+
+// Delete counters of shorts, they are removed from journal by compaction
+func (s *FileStore) Delete(ctx context.Context, shorts []shortlink.Short) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(shorts) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.journal != nil {
+		data, err := json.Marshal(entry{Deleted: shorts})
+		if err != nil {
+			return err
+		}
+		if err = s.journal.Append(data); err != nil {
+			return err
+		}
+		s.appended++
+	}
+	s.drop(shorts)
+	return s.compactIfNeeded()
+}
+
+// Close flush and close journal
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.journal == nil {
+		return nil
+	}
+	return s.journal.Close()
+}
+
+// count click in daily counters. Must be called under write lock
+func (s *FileStore) count(c click.Click) {
+	s.add(c.Short, c.At.UTC().Format(click.DayLayout), 1)
+}
+
+// add clicks to daily counter of short. Must be called under write lock
+func (s *FileStore) add(short shortlink.Short, day string, n int) {
+	days, ok := s.daily[short]
+	if !ok {
+		days = make(map[string]int)
+		s.daily[short] = days
+	}
+	if _, ok = days[day]; !ok {
+		s.counters++
+	}
+	days[day] += n
+}
+
+// drop daily counters of shorts. Must be called under write lock
+func (s *FileStore) drop(shorts []shortlink.Short) {
+	for _, short := range shorts {
+		s.counters -= len(s.daily[short])
+		delete(s.daily, short)
+	}
+}
+
+// compactIfNeeded compact journal when it at least twice bigger than daily counters.
+// Must be called under write lock
+func (s *FileStore) compactIfNeeded() error {
+	if s.journal == nil || s.compactEvery <= 0 {
+		return nil
+	}
+	if s.appended < s.compactEvery || s.appended < 2*s.counters {
+		return nil
+	}
+	return s.compact()
+}
+
+// compact rewrite journal with daily counters instead of raw events. Must be called under write lock
+func (s *FileStore) compact() error {
+	written := 0
+	err := s.journal.Compact(func(emit func(data []byte) error) error {
+		for short, days := range s.daily {
+			for day, count := range days {
+				data, err := json.Marshal(entry{Click: click.Click{Short: short}, Day: day, Count: count})
+				if err != nil {
+					return err
+				}
+				if err = emit(data); err != nil {
+					return err
+				}
+				written++
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.appended = written
+	return nil
+}
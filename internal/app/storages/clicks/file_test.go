@@ -0,0 +1,115 @@
+package clicks
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/click"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/shortlink"
+	fw "github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/filewrapper"
+)
+
+func TestFileStore_Daily(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "clicks.db")
+	day := time.Date(2026, 10, 18, 23, 0, 0, 0, time.UTC)
+
+	s, err := NewFileStore(path, fw.SyncAlways, 0)
+	require.NoError(t, err)
+	require.NoError(t, s.Save(ctx, []click.Click{
+		{At: day.AddDate(0, 0, 1), Short: "short"},
+		{At: day, Short: "short"},
+		{At: day, Short: "other"},
+	}))
+	require.NoError(t, s.Close())
+
+	// Counters are restored from journal
+	s, err = NewFileStore(path, fw.SyncAlways, 0)
+	require.NoError(t, err)
+	defer s.Close()
+
+	days, err := s.Daily(ctx, "short")
+	require.NoError(t, err)
+	assert.Equal(t, []click.DailyCount{{Day: "2026-10-18", Count: 1}, {Day: "2026-10-19", Count: 1}}, days)
+
+	days, err = s.Daily(ctx, "unknown")
+	require.NoError(t, err)
+	assert.Empty(t, days)
+}
+
+func TestFileStore_Compaction(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "clicks.db")
+	day := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+	s, err := NewFileStore(path, fw.SyncAlways, 0, WithCompaction(2))
+	require.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, s.Save(ctx, []click.Click{
+			{At: day, Short: "short"},
+			{At: day.Add(time.Hour), Short: "short"},
+		}))
+	}
+	require.NoError(t, s.Close())
+
+	// Raw events are replaced by one counter of day
+	j, err := fw.OpenJournal(path, fw.SyncAlways, 0)
+	require.NoError(t, err)
+	records := 0
+	require.NoError(t, j.Replay(func([]byte) error {
+		records++
+		return nil
+	}))
+	require.NoError(t, j.Close())
+	assert.Equal(t, 1, records)
+
+	s, err = NewFileStore(path, fw.SyncAlways, 0)
+	require.NoError(t, err)
+	defer s.Close()
+	require.NoError(t, s.Save(ctx, []click.Click{{At: day, Short: "short"}}))
+	days, err := s.Daily(ctx, "short")
+	require.NoError(t, err)
+	assert.Equal(t, []click.DailyCount{{Day: "2026-10-18", Count: 7}}, days)
+}
+
+func TestFileStore_SaveFailed(t *testing.T) {
+	ctx := context.Background()
+	s, err := NewFileStore(filepath.Join(t.TempDir(), "clicks.db"), fw.SyncAlways, 0)
+	require.NoError(t, err)
+	require.NoError(t, s.journal.Close())
+
+	// Batch which is not in journal is not counted
+	err = s.Save(ctx, []click.Click{{At: time.Now(), Short: "short"}, {At: time.Now(), Short: "short"}})
+	assert.ErrorIs(t, err, fw.ErrJournalClosed)
+	days, err := s.Daily(ctx, "short")
+	require.NoError(t, err)
+	assert.Empty(t, days)
+}
+
+func TestFileStore_Delete(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "clicks.db")
+	day := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+	s, err := NewFileStore(path, fw.SyncAlways, 0)
+	require.NoError(t, err)
+	require.NoError(t, s.Save(ctx, []click.Click{{At: day, Short: "short"}, {At: day, Short: "other"}}))
+	require.NoError(t, s.Delete(ctx, []shortlink.Short{"short"}))
+	require.NoError(t, s.Close())
+
+	// Deletion is replayed from journal
+	s, err = NewFileStore(path, fw.SyncAlways, 0)
+	require.NoError(t, err)
+	defer s.Close()
+	days, err := s.Daily(ctx, "short")
+	require.NoError(t, err)
+	assert.Empty(t, days)
+	days, err = s.Daily(ctx, "other")
+	require.NoError(t, err)
+	assert.Equal(t, []click.DailyCount{{Day: "2026-10-18", Count: 1}}, days)
+}
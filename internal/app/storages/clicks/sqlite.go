@@ -0,0 +1,107 @@
+package clicks
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/click"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/shortlink"
+)
+
+// sqlSQLiteNewClick for new click event, time in unix nanoseconds
+const sqlSQLiteNewClick = `
+insert into clicks (short, clicked_at, referrer, user_agent, ip_hash) 
+values (?, ?, ?, ?, ?)
+`
+
+// sqlSQLiteDailyClicks count clicks by UTC days
+const sqlSQLiteDailyClicks = `
+select strftime('%Y-%m-%d', clicked_at / 1000000000, 'unixepoch') as day, count(*) 
+from clicks 
+where short=? 
+group by day 
+order by day
+`
+
+// sqlSQLiteDeleteClicks remove clicks of short
+const sqlSQLiteDeleteClicks = `delete from clicks where short=?`
+
+// SQLiteStore store of clicks in clicks table of SQLite links storage
+type SQLiteStore struct {
+	PostgreSQLStore
+}
+
+// NewSQLiteStore store on connection of SQLite links storage with timeout of queries
+func NewSQLiteStore(db *sql.DB, timeout time.Duration) *SQLiteStore {
+	return &SQLiteStore{PostgreSQLStore{db: db, timeout: timeout}}
+}
+
+// Save batch of clicks in one transaction
+func (s *SQLiteStore) Save(ctx context.Context, clicks []click.Click) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	for _, c := range clicks {
+		if _, err = tx.ExecContext(ctx, sqlSQLiteNewClick, string(c.Short), c.At.UnixNano(), c.Referrer, c.UserAgent, c.IPHash); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Delete clicks of shorts in one transaction
+func (s *SQLiteStore) Delete(ctx context.Context, shorts []shortlink.Short) error {
+	if len(shorts) == 0 {
+		return nil
+	}
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	for _, short := range shorts {
+		if _, err = tx.ExecContext(ctx, sqlSQLiteDeleteClicks, string(short)); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Daily get clicks count of short by days
+func (s *SQLiteStore) Daily(ctx context.Context, short shortlink.Short) ([]click.DailyCount, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, sqlSQLiteDailyClicks, string(short))
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	days := make([]click.DailyCount, 0)
+	for rows.Next() {
+		var d click.DailyCount
+		if err = rows.Scan(&d.Day, &d.Count); err != nil {
+			return nil, err
+		}
+		days = append(days, d)
+	}
+	return days, rows.Err()
+}
@@ -0,0 +1,237 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/lib/pq"
+
+	er "github.com/triumphpc/go-musthave-shortener-tpl/internal/app/errors"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/helpers/shortcode"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/shortlink"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/user"
+)
+
+// DefaultBulkChunk count of urls loaded by one COPY
+const DefaultBulkChunk = 10000
+
+// Max lengths of links table columns
+const (
+	maxOriginLength        = 255
+	maxCorrelationIDLength = 100
+)
+
+// errTooLong if value don't fit in column of links table
+var errTooLong = errors.New("value is too long")
+
+// batchTable temporary table for COPY of mass save
+const batchTable = "batch_links"
+
+// sqlCreateBatchTable create temporary table, it is dropped with end of transaction
+const sqlCreateBatchTable = `
+create temp table ` + batchTable + ` (
+	pos integer not null,
+	origin text not null,
+	short text not null,
+	correlation_id text,
+	expires_at timestamptz
+) on commit drop
+`
+
+// sqlTruncateBatchTable clear temporary table before next COPY
+const sqlTruncateBatchTable = `truncate ` + batchTable
+
+// sqlMergeBatch move links from temporary table in order of positions, first position of origin wins
+const sqlMergeBatch = `
+insert into storage.short_links (user_id, origin, short, correlation_id, expires_at)
+select $1::varchar, origin, short, correlation_id, expires_at from (
+	select distinct on (origin) pos, origin, short, correlation_id, expires_at
+	from ` + batchTable + `
+	order by origin, pos
+) b
+order by pos
+on conflict do nothing
+returning short, origin
+`
+
+// sqlSelectBatchCurrent user shorts of origins from temporary table
+const sqlSelectBatchCurrent = `
+select origin, short from storage.short_links
+where user_id=$1 and origin in (select origin from ` + batchTable + `)
+`
+
+// bulkSave save urls in transaction by COPY to temporary table and merge of it by chunks
+func (s *PostgreSQLStorage) bulkSave(ctx context.Context, tx *sql.Tx, userID user.UniqUser, urls []shortlink.URLs) ([]shortlink.ShortURLs, error) {
+	if _, err := tx.ExecContext(ctx, sqlCreateBatchTable); err != nil {
+		return nil, contextError(ctx, err)
+	}
+	results := make([]shortlink.ShortURLs, len(urls))
+	for start := 0; start < len(urls); start += s.bulkChunk {
+		end := start + s.bulkChunk
+		if end > len(urls) {
+			end = len(urls)
+		}
+		if err := s.bulkSaveChunk(ctx, tx, userID, urls[start:end], results[start:end]); err != nil {
+			return nil, contextError(ctx, err)
+		}
+	}
+	return results, nil
+}
+
+// bulkSaveChunk save chunk of urls. Urls with aliases are merged before urls with generated shorts,
+// which are merged again with next codes while they have collisions
+func (s *PostgreSQLStorage) bulkSaveChunk(ctx context.Context, tx *sql.Tx, userID user.UniqUser, urls []shortlink.URLs, results []shortlink.ShortURLs) error {
+	shorts := make([]shortlink.Short, len(urls))
+	var aliases, generated []int
+	var origins []string
+	for k, v := range urls {
+		if err := fits(v); err != nil {
+			results[k] = shortlink.Invalid(v.ID, err)
+			continue
+		}
+		if v.Alias != "" {
+			shorts[k] = shortlink.Short(v.Alias)
+			aliases = append(aliases, k)
+			continue
+		}
+		generated = append(generated, k)
+		origins = append(origins, v.Origin)
+	}
+
+	if len(aliases) > 0 {
+		collisions, err := s.merge(ctx, tx, userID, urls, shorts, aliases, results)
+		if err != nil {
+			return err
+		}
+		for _, k := range collisions {
+			results[k] = shortlink.Invalid(urls[k].ID, fmt.Errorf("%w: %s", er.ErrAliasTaken, urls[k].Alias))
+		}
+	}
+	if len(generated) == 0 {
+		return nil
+	}
+	// Allocator works with indexes of generated urls only
+	_, exhausted, err := s.alloc.AllocateBunch(origins, func(codes []shortlink.Short, pending []int) ([]int, error) {
+		idx := make([]int, len(pending))
+		for i, k := range pending {
+			idx[i] = generated[k]
+			shorts[generated[k]] = codes[k]
+		}
+		collisions, err := s.merge(ctx, tx, userID, urls, shorts, idx, results)
+		if err != nil {
+			return nil, err
+		}
+		// Back to indexes of allocator
+		lookup := make(map[int]int, len(pending))
+		for i, k := range pending {
+			lookup[idx[i]] = k
+		}
+		for i, k := range collisions {
+			collisions[i] = lookup[k]
+		}
+		return collisions, nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, k := range exhausted {
+		results[generated[k]] = shortlink.Invalid(urls[generated[k]].ID, shortcode.ErrAttemptsExhausted)
+	}
+	return nil
+}
+
+// merge urls of pending indexes with shorts by COPY to temporary table. Results of created and existing
+// links are set, indexes of urls with shorts used by other links are returned
+func (s *PostgreSQLStorage) merge(ctx context.Context, tx *sql.Tx, userID user.UniqUser, urls []shortlink.URLs, shorts []shortlink.Short, pending []int, results []shortlink.ShortURLs) ([]int, error) {
+	if _, err := tx.ExecContext(ctx, sqlTruncateBatchTable); err != nil {
+		return nil, err
+	}
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn(batchTable, "pos", "origin", "short", "correlation_id", "expires_at"))
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range pending {
+		v := urls[k]
+		var expiresAt interface{}
+		if v.ExpiresAt != nil {
+			expiresAt = *v.ExpiresAt
+		}
+		if _, err = stmt.ExecContext(ctx, k, v.Origin, string(shorts[k]), v.ID, expiresAt); err != nil {
+			_ = stmt.Close()
+			return nil, err
+		}
+	}
+	// Empty exec flush data of COPY
+	if _, err = stmt.ExecContext(ctx); err != nil {
+		_ = stmt.Close()
+		return nil, err
+	}
+	if err = stmt.Close(); err != nil {
+		return nil, err
+	}
+
+	inserted := make(map[shortlink.Short]string, len(pending))
+	err = queryPairs(ctx, tx, sqlMergeBatch, string(userID), func(short, origin string) {
+		inserted[shortlink.Short(short)] = origin
+	})
+	if err != nil {
+		return nil, err
+	}
+	current := make(map[string]shortlink.Short, len(pending))
+	err = queryPairs(ctx, tx, sqlSelectBatchCurrent, string(userID), func(origin, short string) {
+		current[origin] = shortlink.Short(short)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var collisions []int
+	for _, k := range pending {
+		v := urls[k]
+		if origin, ok := inserted[shorts[k]]; ok && origin == v.Origin {
+			// Repeats of origin in batch get existing status
+			delete(inserted, shorts[k])
+			results[k] = shortlink.Created(v.ID, shorts[k])
+			continue
+		}
+		if short, ok := current[v.Origin]; ok {
+			results[k] = shortlink.Existing(v.ID, short)
+			continue
+		}
+		collisions = append(collisions, k)
+	}
+	return collisions, nil
+}
+
+// queryPairs run query with user id and pass pairs of string columns to fn
+func queryPairs(ctx context.Context, tx *sql.Tx, query, userID string, fn func(a, b string)) error {
+	rows, err := tx.QueryContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+	for rows.Next() {
+		var a, b string
+		if err = rows.Scan(&a, &b); err != nil {
+			return err
+		}
+		fn(a, b)
+	}
+	return rows.Err()
+}
+
+// fits check url values by sizes of columns, so one url can't fail whole batch
+func fits(v shortlink.URLs) error {
+	if utf8.RuneCountInString(v.Origin) > maxOriginLength {
+		return fmt.Errorf("%w: origin is longer than %d", errTooLong, maxOriginLength)
+	}
+	if utf8.RuneCountInString(v.ID) > maxCorrelationIDLength {
+		return fmt.Errorf("%w: correlation id is longer than %d", errTooLong, maxCorrelationIDLength)
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	er "github.com/triumphpc/go-musthave-shortener-tpl/internal/app/errors"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/helpers/shortcode"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/shortlink"
+)
+
+// testDSN environment variable with PostgreSQL for integration tests, they are skipped without it
+const testDSN = "TEST_DATABASE_DSN"
+
+// newStorage on clean test database with mass save by COPY for every batch
+func newStorage(t *testing.T, opts ...Option) *PostgreSQLStorage {
+	dsn := os.Getenv(testDSN)
+	if dsn == "" {
+		t.Skipf("%s is not set", testDSN)
+	}
+	c, err := sql.Open("postgres", dsn)
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		_ = c.Close()
+	})
+	s, err := New(c, zap.NewNop(), append([]Option{WithBulk(1, DefaultBulkChunk)}, opts...)...)
+	require.NoError(t, err)
+	require.NoError(t, s.Clear(context.Background()))
+	t.Cleanup(func() {
+		_ = s.Clear(context.Background())
+	})
+	return s
+}
+
+// listGenerator give codes from list in order of calls
+type listGenerator struct {
+	mu    sync.Mutex
+	codes []string
+}
+
+func (g *listGenerator) Generate(string, int, int) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	code := g.codes[0]
+	g.codes = g.codes[1:]
+	return code, nil
+}
+
+func TestPostgreSQLStorage_BulkSaveDuplicates(t *testing.T) {
+	ctx := context.Background()
+	s := newStorage(t)
+
+	stored, err := s.Save(ctx, "user", "http://stored.ru", shortlink.Options{})
+	require.NoError(t, err)
+
+	results, err := s.BunchSave(ctx, "user", []shortlink.URLs{
+		{ID: "1", Origin: "http://one.ru"},
+		{ID: "2", Origin: "http://stored.ru"},
+		{ID: "3", Origin: "http://one.ru"},
+	}, shortlink.BatchOptions{})
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+
+	// First repeat of origin is created, next ones get its short
+	assert.Equal(t, shortlink.ItemCreated, results[0].Status)
+	assert.Equal(t, shortlink.Existing("3", shortlink.Short(results[0].Short)), results[2])
+	// Origin which user already has get stored short
+	assert.Equal(t, shortlink.Existing("2", stored), results[1])
+
+	count, err := s.URLCount(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+}
+
+func TestPostgreSQLStorage_BulkSaveCollisions(t *testing.T) {
+	ctx := context.Background()
+	gen := &listGenerator{codes: []string{"taken", "fresh"}}
+	s := newStorage(t, WithAllocator(shortcode.New(gen)))
+
+	_, err := s.Save(ctx, "other", "http://other.ru", shortlink.Options{Alias: "taken"})
+	require.NoError(t, err)
+	_, err = s.Save(ctx, "other", "http://alias.ru", shortlink.Options{Alias: "alias"})
+	require.NoError(t, err)
+
+	results, err := s.BunchSave(ctx, "user", []shortlink.URLs{
+		{ID: "1", Origin: "http://one.ru"},
+		{ID: "2", Origin: "http://two.ru", Alias: "alias"},
+		{ID: "3", Origin: "http://three.ru", Alias: "mine"},
+	}, shortlink.BatchOptions{})
+	require.NoError(t, err)
+
+	// Generated short of other user is replaced by next code
+	assert.Equal(t, shortlink.Created("1", "fresh"), results[0])
+	// Alias of other user is not available
+	assert.Equal(t, shortlink.ItemInvalid, results[1].Status)
+	assert.Contains(t, results[1].Reason, er.ErrAliasTaken.Error())
+	assert.Equal(t, shortlink.Created("3", "mine"), results[2])
+}
+
+func TestPostgreSQLStorage_BulkSaveChunks(t *testing.T) {
+	ctx := context.Background()
+	s := newStorage(t, WithBulk(1, 2))
+
+	urls := []shortlink.URLs{
+		{ID: "1", Origin: "http://one.ru"},
+		{ID: "2", Origin: "http://two.ru"},
+		{ID: "3", Origin: "http://three.ru"},
+		{ID: "4", Origin: "http://one.ru"},
+		{ID: "5", Origin: "http://five.ru"},
+	}
+	results, err := s.BunchSave(ctx, "user", urls, shortlink.BatchOptions{})
+	require.NoError(t, err)
+	require.Len(t, results, len(urls))
+
+	// Order of results is kept across chunks
+	for k, v := range results {
+		assert.Equal(t, urls[k].ID, v.ID)
+	}
+	// Repeat in next chunk get short of previous chunk
+	assert.Equal(t, shortlink.Existing("4", shortlink.Short(results[0].Short)), results[3])
+	links, err := s.LinksByUser(ctx, "user")
+	require.NoError(t, err)
+	assert.Len(t, links, 4)
+	for _, k := range []int{0, 1, 2, 4} {
+		assert.Equal(t, shortlink.ItemCreated, results[k].Status)
+		assert.Equal(t, urls[k].Origin, links[shortlink.Short(results[k].Short)])
+	}
+}
+
+func TestPostgreSQLStorage_BulkSaveAtomic(t *testing.T) {
+	ctx := context.Background()
+	s := newStorage(t, WithBulk(1, 1))
+
+	results, err := s.BunchSave(ctx, "user", []shortlink.URLs{
+		{ID: "1", Origin: "http://one.ru"},
+		{ID: "2", Origin: "http://" + strings.Repeat("a", maxOriginLength)},
+		{ID: "3", Origin: "http://three.ru"},
+	}, shortlink.BatchOptions{Atomic: true})
+	assert.ErrorIs(t, err, er.ErrBatchAborted)
+	require.Len(t, results, 3)
+	assert.Equal(t, shortlink.ItemAborted, results[0].Status)
+	assert.Equal(t, shortlink.ItemInvalid, results[1].Status)
+	assert.Equal(t, shortlink.ItemAborted, results[2].Status)
+
+	// Links of previous chunks are rolled back
+	count, err := s.URLCount(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+}
@@ -4,13 +4,17 @@ package db
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
 	"github.com/jackc/pgerrcode"
 	"github.com/lib/pq"
 	"github.com/pressly/goose/v3"
 	"go.uber.org/zap"
 
 	er "github.com/triumphpc/go-musthave-shortener-tpl/internal/app/errors"
-	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/helpers"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/helpers/shortcode"
 	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/shortlink"
 	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/user"
 	"github.com/triumphpc/go-musthave-shortener-tpl/migrations"
@@ -18,14 +22,59 @@ import (
 
 // PostgreSQLStorage storage
 type PostgreSQLStorage struct {
-	db *sql.DB
-	l  *zap.Logger
+	db       *sql.DB
+	l        *zap.Logger
+	timeouts Timeouts
+	alloc    *shortcode.Allocator
+	// Mass save by COPY for batches from bulkThreshold urls, zero disable it
+	bulkThreshold int
+	bulkChunk     int
+}
+
+// Timeouts limit duration of queries by kind of operation. Zero value disable limit
+type Timeouts struct {
+	// Read for select one link or user links and counters
+	Read time.Duration
+	// Write for save one link
+	Write time.Duration
+	// Batch for mass save and mass delete
+	Batch time.Duration
+}
+
+// Option configure PostgreSQLStorage
+type Option func(s *PostgreSQLStorage)
+
+// WithAllocator set generator of short codes
+func WithAllocator(a *shortcode.Allocator) Option {
+	return func(s *PostgreSQLStorage) {
+		s.alloc = a
+	}
 }
 
+// WithTimeouts set query timeouts
+func WithTimeouts(t Timeouts) Option {
+	return func(s *PostgreSQLStorage) {
+		s.timeouts = t
+	}
+}
+
+// WithBulk set size of batch from which urls are loaded by COPY and count of urls in one COPY
+func WithBulk(threshold, chunk int) Option {
+	return func(s *PostgreSQLStorage) {
+		s.bulkThreshold = threshold
+		s.bulkChunk = chunk
+	}
+}
+
+// Unique indexes of links table
+const (
+	constraintShort = "short_links_short_uindex"
+)
+
 // sqlNewRecord for new record in db
 const sqlNewRecord = `
-insert into storage.short_links (id, user_id, origin, short) 
-values (default, $1, $2, $3)
+insert into storage.short_links (id, user_id, origin, short, expires_at) 
+values (default, $1, $2, $3, $4)
 `
 
 // sqlDeleteRecords sql for clear records
@@ -36,15 +85,22 @@ const sqlGetCurrentRecord = "select short from storage.short_links where user_id
 
 // sqlBunchNewRecord for new record in db
 const sqlBunchNewRecord = `
-insert into storage.short_links (id, user_id, origin, short, correlation_id) 
-values (default, $1, $2, $3, $4)
-on conflict (user_id, origin)
-do nothing;
+insert into storage.short_links (id, user_id, origin, short, correlation_id, expires_at) 
+values (default, $1, $2, $3, $4, $5)
+on conflict do nothing
+returning short;
 `
 
 // sqlSelectFromOrigin select origin
 const sqlSelectOrigin = `
-select origin, is_deleted from storage.short_links where short=$1
+select origin, is_deleted, expires_at from storage.short_links where short=$1
+`
+
+// sqlSelectRecord select link with owner by short
+const sqlSelectRecord = `
+select coalesce(user_id, ''), origin, coalesce(correlation_id, ''), created_at, expires_at, coalesce(is_deleted, false) 
+from storage.short_links 
+where short=$1
 `
 
 // SqlSelectOriginAndShort select origin and short
@@ -52,118 +108,361 @@ const sqlSelectOriginAndShort = `
 select origin, short from storage.short_links where user_id=$1
 `
 
+// sqlOriginHost host of origin in lower case
+const sqlOriginHost = `lower(substring(origin from '^[a-zA-Z][a-zA-Z0-9+.-]*://(?:[^/?#@]*@)?([^/?#:]+)'))`
+
+// sqlLinksFilter filter user links by status and domain of origin. Expired links are deleted
+const sqlLinksFilter = `
+where user_id=$1 
+and ($2 = 'all' or (coalesce(is_deleted, false) or coalesce(expires_at <= now(), false)) = ($2 = 'deleted')) 
+and ($3 = '' or ` + sqlOriginHost + ` = $3 or ` + sqlOriginHost + ` like '%.' || $3)
+`
+
+// sqlLinksCount count of user links by filter
+const sqlLinksCount = `select count(*) from storage.short_links ` + sqlLinksFilter
+
+// sqlLinksSelect user links by filter
+const sqlLinksSelect = `
+select short, origin, coalesce(correlation_id, ''), created_at, expires_at, coalesce(is_deleted, false) 
+from storage.short_links ` + sqlLinksFilter
+
+// sqlLinksPageAsc page of user links after cursor in ascending order
+const sqlLinksPageAsc = sqlLinksSelect + `
+and ($4::timestamptz is null or (created_at, short) > ($4, $5)) 
+order by created_at, short 
+limit $6
+`
+
+// sqlLinksPageDesc page of user links after cursor in descending order
+const sqlLinksPageDesc = sqlLinksSelect + `
+and ($4::timestamptz is null or (created_at, short) < ($4, $5)) 
+order by created_at desc, short desc 
+limit $6
+`
+
 // sqlUpdate for set delete flag
 const sqlUpdate = `
 	UPDATE storage.short_links 
-	SET is_deleted=true 
+	SET is_deleted=true, deleted_at=now() 
+	WHERE user_id=$1 
+	AND coalesce(is_deleted, false)=false 
+	AND (correlation_id = ANY($2) OR short=ANY($3)) 
+	RETURNING short, coalesce(correlation_id, '')
+`
+
+// sqlUpdateExpired for set delete flag on expired links
+const sqlUpdateExpired = `
+	UPDATE storage.short_links 
+	SET is_deleted=true, deleted_at=$1 
+	WHERE expires_at <= $1 
+	AND is_deleted=false
+`
+
+// sqlRestore remove delete flag from links deleted since time
+const sqlRestore = `
+	UPDATE storage.short_links 
+	SET is_deleted=false, deleted_at=null 
 	WHERE user_id=$1 
-	AND (correlation_id = ANY($2) OR short=ANY($3))
+	AND is_deleted=true 
+	AND deleted_at >= $4 
+	AND (expires_at is null or expires_at > now()) 
+	AND (correlation_id = ANY($2) OR short=ANY($3)) 
+	RETURNING short
+`
+
+// sqlPurge remove links deleted before time
+const sqlPurge = `
+	DELETE FROM storage.short_links 
+	WHERE is_deleted=true 
+	AND deleted_at < $1
+	RETURNING short
+`
+
+// sqlWalk chunk of all links after position. Collation C give byte order like other storages
+const sqlWalk = `
+select coalesce(user_id, ''), short, origin, coalesce(correlation_id, ''), created_at, expires_at, coalesce(is_deleted, false) 
+from storage.short_links 
+where (coalesce(user_id, '') collate "C", short collate "C") > ($1, $2) 
+order by coalesce(user_id, '') collate "C", short collate "C" 
+limit $3
+`
+
+// sqlImportRecord for imported link with all fields
+const sqlImportRecord = `
+insert into storage.short_links (id, user_id, origin, short, correlation_id, is_deleted, created_at, expires_at, deleted_at) 
+values (default, $1, $2, $3, nullif($4, ''), $5, $6, $7, case when $5 then now() end)
+on conflict do nothing
+returning short
+`
+
+// sqlSelectOwner owner and origin of short
+const sqlSelectOwner = `
+select coalesce(user_id, ''), origin from storage.short_links where short=$1
+`
+
+// walkChunk count of links selected by one query of walk
+const walkChunk = 1000
+
+// sqlReserveCounter move counter by size and return start of reserved block
+const sqlReserveCounter = `
+insert into storage.counters (name, value) values ($1, $2)
+on conflict (name) do update set value = storage.counters.value + excluded.value
+returning value - $2
 `
 
+// counterShortCodes name of sequence short codes counter
+const counterShortCodes = "short_codes"
+
+// sqlURLCount count of links
+const sqlURLCount = `SELECT count(*) FROM storage.short_links`
+
+// sqlUserCount count of users
+const sqlUserCount = `SELECT count(DISTINCT user_id) FROM storage.short_links`
+
 // New New new Storage with not null fields
-func New(c *sql.DB, l *zap.Logger) (*PostgreSQLStorage, error) {
+func New(c *sql.DB, l *zap.Logger, opts ...Option) (*PostgreSQLStorage, error) {
 	// Check if scheme exist
 	goose.SetBaseFS(migrations.EmbedMigrations)
+	// Dialect is global and can be changed by SQLite storage
+	if err := goose.SetDialect("postgres"); err != nil {
+		panic(err)
+	}
 	if err := goose.Up(c, "."); err != nil {
 		panic(err)
 	}
 
-	return &PostgreSQLStorage{c, l}, nil
+	s := &PostgreSQLStorage{db: c, l: l, alloc: shortcode.Default(), bulkChunk: DefaultBulkChunk}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.bulkChunk <= 0 {
+		s.bulkChunk = DefaultBulkChunk
+	}
+	// Instances take blocks of codes counter from database
+	if c := s.alloc.Counter(); c != nil {
+		c.Reserve(s.reserveCodes, shortcode.DefaultBlock)
+	}
+	return s, nil
+}
+
+// reserveCodes take block of codes counter. It is not a part of save transaction,
+// so block is not given again after rollback
+func (s *PostgreSQLStorage) reserveCodes(ctx context.Context, size uint64) (uint64, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Write)
+	defer cancel()
+
+	var first int64
+	if err := s.db.QueryRowContext(ctx, sqlReserveCounter, counterShortCodes, int64(size)).Scan(&first); err != nil {
+		return 0, contextError(ctx, err)
+	}
+	return uint64(first), nil
 }
 
 // LinkByShort implement interface for get data from storage by userId and shortLink
-func (s *PostgreSQLStorage) LinkByShort(short shortlink.Short) (string, error) {
+func (s *PostgreSQLStorage) LinkByShort(ctx context.Context, short shortlink.Short) (string, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Read)
+	defer cancel()
+
 	var origin string
 	var gone bool
+	var expiresAt sql.NullTime
 
-	err := s.db.QueryRowContext(context.Background(), sqlSelectOrigin, string(short)).Scan(&origin, &gone)
-
-	if err != nil {
+	err := s.db.QueryRowContext(ctx, sqlSelectOrigin, string(short)).Scan(&origin, &gone, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
 		return "", er.ErrURLNotFound
 	}
+	if err != nil {
+		return "", contextError(ctx, err)
+	}
 
 	if gone {
 		return "", er.ErrURLIsGone
 	}
+	if expiresAt.Valid && !time.Now().Before(expiresAt.Time) {
+		return "", er.ErrURLExpired
+	}
 
 	return origin, nil
 }
 
-// LinksByUser return all user links
-func (s *PostgreSQLStorage) LinksByUser(userID user.UniqUser) (shortlink.ShortLinks, error) {
-	origins := shortlink.ShortLinks{}
-	rows, err := s.db.QueryContext(context.Background(), sqlSelectOriginAndShort, userID)
+// RecordByShort get link with owner by short
+func (s *PostgreSQLStorage) RecordByShort(ctx context.Context, short shortlink.Short) (shortlink.Record, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Read)
+	defer cancel()
+
+	rec := shortlink.Record{Link: shortlink.Link{Short: short}}
+	var expiresAt sql.NullTime
+
+	err := s.db.QueryRowContext(ctx, sqlSelectRecord, string(short)).
+		Scan(&rec.UserID, &rec.Origin, &rec.CorrelationID, &rec.CreatedAt, &expiresAt, &rec.Deleted)
+	if errors.Is(err, sql.ErrNoRows) {
+		return shortlink.Record{}, er.ErrURLNotFound
+	}
 	if err != nil {
-		return origins, err
+		return shortlink.Record{}, contextError(ctx, err)
 	}
+	rec.ExpiresAt = expiresAt.Time
+	return rec, nil
+}
+
+// LinksByUser return all user links
+func (s *PostgreSQLStorage) LinksByUser(ctx context.Context, userID user.UniqUser) (shortlink.ShortLinks, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Read)
+	defer cancel()
 
-	err = rows.Err()
+	origins := shortlink.ShortLinks{}
+	rows, err := s.db.QueryContext(ctx, sqlSelectOriginAndShort, userID)
 	if err != nil {
-		return origins, err
+		return origins, contextError(ctx, err)
 	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
 
 	for rows.Next() {
 		var origin string
 		var short string
 		err = rows.Scan(&origin, &short)
 		if err != nil {
-			return origins, err
+			return origins, contextError(ctx, err)
 		}
 		origins[shortlink.Short(short)] = origin
 	}
+	if err = rows.Err(); err != nil {
+		return origins, contextError(ctx, err)
+	}
 	return origins, nil
 }
 
-// Save url in storage of short links
-func (s *PostgreSQLStorage) Save(userID user.UniqUser, origin string) (shortlink.Short, error) {
-	short := shortlink.Short(helpers.RandomString(10))
-	// Save to database
-	if _, err := s.db.ExecContext(context.Background(), sqlNewRecord, userID, origin, short); err != nil {
-		if err, ok := err.(*pq.Error); ok {
-			if err.Code == pgerrcode.UniqueViolation {
-				// take current link
-				var short string
-				_ = s.db.QueryRowContext(context.Background(), sqlGetCurrentRecord, string(userID), origin).Scan(&short)
-				return shortlink.Short(short), er.ErrAlreadyHasShort
-			}
+// LinksPage return page of user links by filters
+func (s *PostgreSQLStorage) LinksPage(ctx context.Context, userID user.UniqUser, q shortlink.Query) (shortlink.Page, error) {
+	var page shortlink.Page
+	q, err := q.Normalize()
+	if err != nil {
+		return page, err
+	}
+	ctx, cancel := withTimeout(ctx, s.timeouts.Read)
+	defer cancel()
+
+	err = s.db.QueryRowContext(ctx, sqlLinksCount, string(userID), q.Status, q.Domain).Scan(&page.Total)
+	if err != nil {
+		return page, contextError(ctx, err)
+	}
+
+	var after sql.NullTime
+	var afterShort string
+	if q.Cursor != "" {
+		c, _ := shortlink.DecodeCursor(q.Cursor)
+		after = sql.NullTime{Time: c.CreatedAt, Valid: true}
+		afterShort = string(c.Short)
+	}
+	query := sqlLinksPageAsc
+	if q.Order == shortlink.OrderDesc {
+		query = sqlLinksPageDesc
+	}
+	// One more link to know if next page exists
+	rows, err := s.db.QueryContext(ctx, query, string(userID), q.Status, q.Domain, after, afterShort, q.Limit+1)
+	if err != nil {
+		return page, contextError(ctx, err)
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	for rows.Next() {
+		var l shortlink.Link
+		var expiresAt sql.NullTime
+		if err = rows.Scan(&l.Short, &l.Origin, &l.CorrelationID, &l.CreatedAt, &expiresAt, &l.Deleted); err != nil {
+			return page, contextError(ctx, err)
 		}
-		return short, err
+		l.ExpiresAt = expiresAt.Time
+		page.Links = append(page.Links, l)
 	}
-	return short, nil
+	if err = rows.Err(); err != nil {
+		return page, contextError(ctx, err)
+	}
+	if len(page.Links) > q.Limit {
+		page.Links = page.Links[:q.Limit]
+		page.NextCursor = shortlink.EncodeCursor(page.Links[q.Limit-1])
+	}
+	return page, nil
 }
 
-// BunchSave save mass urls
-func (s *PostgreSQLStorage) BunchSave(userID user.UniqUser, urls []shortlink.URLs) ([]shortlink.ShortURLs, error) {
-	// Generate shorts
-	type temp struct {
-		ID,
-		Origin,
-		Short string
+// Save url in storage of short links
+func (s *PostgreSQLStorage) Save(ctx context.Context, userID user.UniqUser, origin string, opts shortlink.Options) (shortlink.Short, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Write)
+	defer cancel()
+
+	insert := func(short shortlink.Short) error {
+		_, err := s.db.ExecContext(ctx, sqlNewRecord, userID, origin, short, nullTime(opts.ExpiresAt))
+		return conflictError(err)
 	}
-	var buffer []temp
-	for _, v := range urls {
-		var t = temp{
-			ID:     v.ID,
-			Origin: v.Origin,
-			Short:  helpers.RandomString(10),
+	// Save to database
+	var short shortlink.Short
+	var err error
+	if opts.Alias != "" {
+		short = shortlink.Short(opts.Alias)
+		if err = insert(short); errors.Is(err, shortcode.ErrCollision) {
+			return "", er.ErrAliasTaken
 		}
-		buffer = append(buffer, t)
+	} else {
+		short, err = s.alloc.Allocate(origin, insert)
+	}
+	if errors.Is(err, er.ErrAlreadyHasShort) {
+		// take current link
+		var current string
+		_ = s.db.QueryRowContext(ctx, sqlGetCurrentRecord, string(userID), origin).Scan(&current)
+		return shortlink.Short(current), er.ErrAlreadyHasShort
+	}
+	if err != nil {
+		return short, contextError(ctx, err)
 	}
-	var shorts []shortlink.ShortURLs
+	return short, nil
+}
+
+// BunchSave save mass urls in one transaction. Big batches are loaded by COPY
+func (s *PostgreSQLStorage) BunchSave(ctx context.Context, userID user.UniqUser, urls []shortlink.URLs, opts shortlink.BatchOptions) ([]shortlink.ShortURLs, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Batch)
+	defer cancel()
 
 	// Start transaction
-	tx, err := s.db.Begin()
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		return shorts, err
+		return nil, contextError(ctx, err)
 	}
 	// Rollback handler
 	defer func(tx *sql.Tx) {
 		_ = tx.Rollback()
 	}(tx)
+
+	var results []shortlink.ShortURLs
+	if s.bulkThreshold > 0 && len(urls) >= s.bulkThreshold {
+		results, err = s.bulkSave(ctx, tx, userID, urls)
+	} else {
+		results, err = s.insertBunch(ctx, tx, userID, urls)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if opts.Atomic && shortlink.HasInvalid(results) {
+		shortlink.Abort(results)
+		return results, er.ErrBatchAborted
+	}
+	// Save changes
+	err = tx.Commit()
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+
+	return results, nil
+}
+
+// insertBunch save urls in transaction by one insert per url
+func (s *PostgreSQLStorage) insertBunch(ctx context.Context, tx *sql.Tx, userID user.UniqUser, urls []shortlink.URLs) ([]shortlink.ShortURLs, error) {
 	// Prepare statement
-	stmt, err := tx.PrepareContext(context.Background(), sqlBunchNewRecord)
+	stmt, err := tx.PrepareContext(ctx, sqlBunchNewRecord)
 	if err != nil {
-		return shorts, err
+		return nil, contextError(ctx, err)
 	}
 	// Close statement
 	defer func(stmt *sql.Stmt) {
@@ -172,52 +471,364 @@ func (s *PostgreSQLStorage) BunchSave(userID user.UniqUser, urls []shortlink.URL
 			s.l.Info("Close statement error", zap.Error(err))
 		}
 	}(stmt)
-	for _, v := range buffer {
-		// Add record to transaction
-		if _, err = stmt.ExecContext(context.Background(), userID, v.Origin, v.Short, v.ID); err == nil {
-			shorts = append(shorts, shortlink.ShortURLs{
-				Short: v.Short,
-				ID:    v.ID,
-			})
+	results := make([]shortlink.ShortURLs, len(urls))
+	for k, v := range urls {
+		if err = fits(v); err != nil {
+			results[k] = shortlink.Invalid(v.ID, err)
+			continue
+		}
+		var expiresAt sql.NullTime
+		if v.ExpiresAt != nil {
+			expiresAt = nullTime(*v.ExpiresAt)
+		}
+		// Add record to transaction. Conflicts don't abort transaction because of on conflict do nothing
+		var current string
+		insert := func(short shortlink.Short) error {
+			var saved string
+			err := stmt.QueryRowContext(ctx, userID, v.Origin, short, v.ID, expiresAt).Scan(&saved)
+			if !errors.Is(err, sql.ErrNoRows) {
+				return err
+			}
+			// Nothing inserted: user already has origin or short is used
+			err = tx.QueryRowContext(ctx, sqlGetCurrentRecord, string(userID), v.Origin).Scan(&current)
+			if errors.Is(err, sql.ErrNoRows) {
+				return shortcode.ErrCollision
+			}
+			if err != nil {
+				return err
+			}
+			return er.ErrAlreadyHasShort
+		}
+		var short shortlink.Short
+		if v.Alias != "" {
+			short = shortlink.Short(v.Alias)
+			if err = insert(short); errors.Is(err, shortcode.ErrCollision) {
+				err = fmt.Errorf("%w: %s", er.ErrAliasTaken, v.Alias)
+			}
 		} else {
+			short, err = s.alloc.Allocate(v.Origin, insert)
+		}
+		switch {
+		case err == nil:
+			results[k] = shortlink.Created(v.ID, short)
+		case errors.Is(err, er.ErrAlreadyHasShort):
+			results[k] = shortlink.Existing(v.ID, shortlink.Short(current))
+		case errors.Is(err, er.ErrAliasTaken), errors.Is(err, shortcode.ErrAttemptsExhausted):
+			results[k] = shortlink.Invalid(v.ID, err)
+		default:
+			// Transaction can't continue after database error
 			s.l.Info("Save bunch error", zap.Error(err))
+			return nil, contextError(ctx, err)
 		}
 	}
-	// Save changes
-	err = tx.Commit()
+	return results, nil
+}
+
+// Clear truncate links table
+func (s *PostgreSQLStorage) Clear(ctx context.Context) error {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Batch)
+	defer cancel()
+
+	if _, err := s.db.ExecContext(ctx, sqlDeleteRecords); err != nil {
+		return contextError(ctx, err)
+	}
+	return nil
+}
+
+// BunchUpdateAsDeleted  update as deleted
+func (s *PostgreSQLStorage) BunchUpdateAsDeleted(ctx context.Context, ids []string, userID string) ([]shortlink.Link, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	ctx, cancel := withTimeout(ctx, s.timeouts.Batch)
+	defer cancel()
+
+	idsArr := pq.Array(ids)
+	rows, err := s.db.QueryContext(ctx, sqlUpdate, userID, idsArr, idsArr)
 	if err != nil {
-		return nil, err
+		return nil, contextError(ctx, err)
 	}
+	defer rows.Close()
 
-	return shorts, nil
+	var links []shortlink.Link
+	for rows.Next() {
+		var l shortlink.Link
+		if err = rows.Scan(&l.Short, &l.CorrelationID); err != nil {
+			return nil, err
+		}
+		links = append(links, l)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return links, nil
 }
 
-func (s *PostgreSQLStorage) Clear() error {
-	if _, err := s.db.ExecContext(context.Background(), sqlDeleteRecords); err != nil {
-		return err
+// UpdateExpiredAsDeleted set delete flag for links expired before now
+func (s *PostgreSQLStorage) UpdateExpiredAsDeleted(ctx context.Context, now time.Time) (int, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Batch)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, sqlUpdateExpired, now)
+	if err != nil {
+		return 0, contextError(ctx, err)
 	}
-	return nil
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(updated), nil
 }
 
-// BunchUpdateAsDeleted  update as deleted
-func (s *PostgreSQLStorage) BunchUpdateAsDeleted(ctx context.Context, ids []string, userID string) error {
+// Restore remove delete flag from user links by correlation ids or shorts, which were deleted since time
+func (s *PostgreSQLStorage) Restore(ctx context.Context, ids []string, userID string, since time.Time) ([]shortlink.Short, error) {
 	if len(ids) == 0 {
-		return nil
+		return nil, nil
 	}
+	ctx, cancel := withTimeout(ctx, s.timeouts.Batch)
+	defer cancel()
+
 	idsArr := pq.Array(ids)
-	_, err := s.db.ExecContext(ctx, sqlUpdate, userID, idsArr, idsArr)
+	return s.queryShorts(ctx, sqlRestore, userID, idsArr, idsArr, since)
+}
 
-	return err
+// queryShorts run query returning shorts of changed links
+func (s *PostgreSQLStorage) queryShorts(ctx context.Context, query string, args ...interface{}) ([]shortlink.Short, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	defer rows.Close()
+
+	var shorts []shortlink.Short
+	for rows.Next() {
+		var short shortlink.Short
+		if err = rows.Scan(&short); err != nil {
+			return nil, err
+		}
+		shorts = append(shorts, short)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return shorts, nil
+}
+
+// Purge remove links deleted before time
+func (s *PostgreSQLStorage) Purge(ctx context.Context, before time.Time) ([]shortlink.Short, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Batch)
+	defer cancel()
+
+	return s.queryShorts(ctx, sqlPurge, before)
 }
 
 // URLCount get saved url in storage
-func (s *PostgreSQLStorage) URLCount() (counter int) {
-	s.db.QueryRow("SELECT count(*) FROM storage.short_links").Scan(&counter)
-	return
+func (s *PostgreSQLStorage) URLCount(ctx context.Context) (counter int, err error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Read)
+	defer cancel()
+
+	if err = s.db.QueryRowContext(ctx, sqlURLCount).Scan(&counter); err != nil {
+		return 0, contextError(ctx, err)
+	}
+	return counter, nil
 }
 
 // UserCount get uniq url count in storage
-func (s *PostgreSQLStorage) UserCount() (counter int) {
-	s.db.QueryRow("SELECT count(DISTINCT user_id) FROM storage.short_links").Scan(&counter)
-	return
+func (s *PostgreSQLStorage) UserCount(ctx context.Context) (counter int, err error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Read)
+	defer cancel()
+
+	if err = s.db.QueryRowContext(ctx, sqlUserCount).Scan(&counter); err != nil {
+		return 0, contextError(ctx, err)
+	}
+	return counter, nil
+}
+
+// WalkUser call fn for every link of user in order of creation.
+// Links are selected by chunks with keyset of page query
+func (s *PostgreSQLStorage) WalkUser(ctx context.Context, userID user.UniqUser, fn func(l shortlink.Link) error) error {
+	var after sql.NullTime
+	var afterShort string
+	for {
+		links, err := s.userChunk(ctx, userID, after, afterShort)
+		if err != nil {
+			return err
+		}
+		for _, l := range links {
+			if err = fn(l); err != nil {
+				return err
+			}
+		}
+		if len(links) < walkChunk {
+			return nil
+		}
+		last := links[len(links)-1]
+		after, afterShort = sql.NullTime{Time: last.CreatedAt, Valid: true}, string(last.Short)
+	}
+}
+
+// userChunk select chunk of user links after creation time and short
+func (s *PostgreSQLStorage) userChunk(ctx context.Context, userID user.UniqUser, after sql.NullTime, afterShort string) ([]shortlink.Link, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Batch)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, sqlLinksPageAsc, string(userID), shortlink.StatusAll, "", after, afterShort, walkChunk)
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	links := make([]shortlink.Link, 0, walkChunk)
+	for rows.Next() {
+		var l shortlink.Link
+		var expiresAt sql.NullTime
+		if err = rows.Scan(&l.Short, &l.Origin, &l.CorrelationID, &l.CreatedAt, &expiresAt, &l.Deleted); err != nil {
+			return nil, contextError(ctx, err)
+		}
+		l.ExpiresAt = expiresAt.Time
+		links = append(links, l)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return links, nil
+}
+
+// Walk call fn for every link in order of user and short after position.
+// Links are selected by chunks, so connection is not held while fn works
+func (s *PostgreSQLStorage) Walk(ctx context.Context, after shortlink.Position, fn func(rec shortlink.Record) error) error {
+	for {
+		recs, err := s.walkChunk(ctx, after)
+		if err != nil {
+			return err
+		}
+		for _, rec := range recs {
+			if err = fn(rec); err != nil {
+				return err
+			}
+		}
+		if len(recs) < walkChunk {
+			return nil
+		}
+		after = recs[len(recs)-1].Position()
+	}
+}
+
+// walkChunk select chunk of links after position
+func (s *PostgreSQLStorage) walkChunk(ctx context.Context, after shortlink.Position) ([]shortlink.Record, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Batch)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, sqlWalk, string(after.UserID), string(after.Short), walkChunk)
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	recs := make([]shortlink.Record, 0, walkChunk)
+	for rows.Next() {
+		var rec shortlink.Record
+		var expiresAt sql.NullTime
+		err = rows.Scan(&rec.UserID, &rec.Short, &rec.Origin, &rec.CorrelationID, &rec.CreatedAt, &expiresAt, &rec.Deleted)
+		if err != nil {
+			return nil, contextError(ctx, err)
+		}
+		rec.ExpiresAt = expiresAt.Time
+		recs = append(recs, rec)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return recs, nil
+}
+
+// Import save records with their shorts in one transaction.
+// Existing records are skipped, conflicts rollback whole batch
+func (s *PostgreSQLStorage) Import(ctx context.Context, recs []shortlink.Record) (int, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Batch)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, contextError(ctx, err)
+	}
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	stmt, err := tx.PrepareContext(ctx, sqlImportRecord)
+	if err != nil {
+		return 0, contextError(ctx, err)
+	}
+	defer func(stmt *sql.Stmt) {
+		_ = stmt.Close()
+	}(stmt)
+
+	imported := 0
+	for _, rec := range recs {
+		var saved string
+		err = stmt.QueryRowContext(ctx, string(rec.UserID), rec.Origin, string(rec.Short), rec.CorrelationID,
+			rec.Deleted, rec.CreatedAt, nullTime(rec.ExpiresAt)).Scan(&saved)
+		if err == nil {
+			imported++
+			continue
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return 0, contextError(ctx, err)
+		}
+		// Nothing inserted: same link is already imported or conflict
+		var owner, origin string
+		err = tx.QueryRowContext(ctx, sqlSelectOwner, string(rec.Short)).Scan(&owner, &origin)
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, fmt.Errorf("%w: user %s already has %s", er.ErrImportConflict, rec.UserID, rec.Origin)
+		}
+		if err != nil {
+			return 0, contextError(ctx, err)
+		}
+		if owner != string(rec.UserID) || origin != rec.Origin {
+			return 0, fmt.Errorf("%w: short %s", er.ErrImportConflict, rec.Short)
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, contextError(ctx, err)
+	}
+	return imported, nil
+}
+
+// withTimeout limit context by timeout if it set
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// contextError return context error if query was interrupted by deadline or cancel,
+// because driver return own error text for cancelled query
+func contextError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+// nullTime convert expiration time for query, zero time is null
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+// conflictError convert unique violations to collision of short or existing origin of user
+func conflictError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == pgerrcode.UniqueViolation {
+		if pqErr.Constraint == constraintShort {
+			return shortcode.ErrCollision
+		}
+		return er.ErrAlreadyHasShort
+	}
+	return err
 }
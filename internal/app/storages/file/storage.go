@@ -3,113 +3,909 @@ package file
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
 
-	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/helpers"
+	er "github.com/triumphpc/go-musthave-shortener-tpl/internal/app/errors"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/helpers/shortcode"
 	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/shortlink"
 	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/user"
 	fw "github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/filewrapper"
 )
 
-// ErrURLNotFound error by package level
-var ErrURLNotFound = errors.New("url not found")
+// ErrURLNotFound error by package level, same as for all storages
+var ErrURLNotFound = er.ErrURLNotFound
+
+// Default journal settings
+const (
+	DefaultSyncInterval = time.Second
+	DefaultCompactEvery = 10000
+)
+
+// record stored data of short link
+type record struct {
+	UserID        user.UniqUser
+	Origin        string
+	CorrelationID string
+	Deleted       bool
+	// ExpiresAt zero for link without expiration
+	ExpiresAt time.Time
+	CreatedAt time.Time
+	// DeletedAt time of soft deletion, zero for active link
+	DeletedAt time.Time
+}
+
+// toLink convert record to model
+func (r *record) toLink(short shortlink.Short) shortlink.Link {
+	return shortlink.Link{
+		Short:         short,
+		Origin:        r.Origin,
+		CorrelationID: r.CorrelationID,
+		CreatedAt:     r.CreatedAt,
+		ExpiresAt:     r.ExpiresAt,
+		Deleted:       r.Deleted,
+	}
+}
+
+// expired check if link lifetime is over at now
+func (r *record) expired(now time.Time) bool {
+	return !r.ExpiresAt.IsZero() && !now.Before(r.ExpiresAt)
+}
+
+// userLinks maps short and record for user
+type userLinks map[shortlink.Short]*record
 
 // UserStorage for file storage
 type UserStorage struct {
-	data            map[user.UniqUser]shortlink.ShortLinks
+	mu              sync.RWMutex
+	data            map[user.UniqUser]userLinks
+	shorts          map[shortlink.Short]*record
+	origins         map[user.UniqUser]map[string]shortlink.Short
 	fileStoragePath string
+	journal         *fw.Journal
+	syncPolicy      fw.SyncPolicy
+	syncInterval    time.Duration
+	compactEvery    int
+	alloc           *shortcode.Allocator
+	// counter max value of codes counter in journal
+	counter uint64
+	// appended records since last compaction
+	appended int
+}
+
+// Option configure file storage
+type Option func(s *UserStorage)
+
+// WithSync set fsync policy of journal
+func WithSync(policy fw.SyncPolicy, interval time.Duration) Option {
+	return func(s *UserStorage) {
+		s.syncPolicy = policy
+		s.syncInterval = interval
+	}
+}
+
+// WithAllocator set generator of short codes
+func WithAllocator(a *shortcode.Allocator) Option {
+	return func(s *UserStorage) {
+		s.alloc = a
+	}
+}
+
+// WithCompaction set count of appended records after which journal compacts
+func WithCompaction(every int) Option {
+	return func(s *UserStorage) {
+		s.compactEvery = every
+	}
+}
+
+// Journal operations
+const (
+	opSave    = "save"
+	opDelete  = "delete"
+	opRestore = "restore"
+	opPurge   = "purge"
+	// opCounter keep max value of codes counter in compacted journal
+	opCounter = "counter"
+	// opBatch entries of batch written as one record, so batch is applied on replay whole or not at all
+	opBatch = "batch"
+)
+
+// entry record in journal
+type entry struct {
+	Op            string            `json:"op"`
+	UserID        user.UniqUser     `json:"user"`
+	Short         shortlink.Short   `json:"short,omitempty"`
+	Shorts        []shortlink.Short `json:"shorts,omitempty"`
+	Origin        string            `json:"origin,omitempty"`
+	CorrelationID string            `json:"correlation_id,omitempty"`
+	Deleted       bool              `json:"deleted,omitempty"`
+	// ExpiresAt unix time in nanoseconds
+	ExpiresAt int64 `json:"expires_at,omitempty"`
+	// CreatedAt unix time in nanoseconds, zero for links from old journal
+	CreatedAt int64 `json:"created_at,omitempty"`
+	// DeletedAt unix time in nanoseconds of deletion, zero for deletions from old journal
+	DeletedAt int64 `json:"deleted_at,omitempty"`
+	// Counter value of codes counter for generated short, zero for other strategies
+	Counter uint64 `json:"counter,omitempty"`
+	// Batch entries of opBatch
+	Batch []entry `json:"batch,omitempty"`
 }
 
 // New Instance new Storage with not null fields
-func New(fileStoragePath string) (*UserStorage, error) {
+func New(fileStoragePath string, opts ...Option) (*UserStorage, error) {
 	s := &UserStorage{
-		data:            make(map[user.UniqUser]shortlink.ShortLinks),
+		data:            make(map[user.UniqUser]userLinks),
+		shorts:          make(map[shortlink.Short]*record),
+		origins:         make(map[user.UniqUser]map[string]shortlink.Short),
 		fileStoragePath: fileStoragePath,
+		syncPolicy:      fw.SyncInterval,
+		syncInterval:    DefaultSyncInterval,
+		compactEvery:    DefaultCompactEvery,
+		alloc:           shortcode.Default(),
+	}
+	for _, opt := range opts {
+		opt(s)
 	}
 	// Load from file storage
 	if err := s.Load(); err != nil {
 		return s, err
 	}
+	// Continue codes counter after last persisted value
+	if c := s.alloc.Counter(); c != nil {
+		c.Seed(s.counter)
+	}
 	return s, nil
 }
 
 // LinkByShort implement interface for get data from storage by userId and shortLink
-func (s *UserStorage) LinkByShort(short shortlink.Short) (string, error) {
-	shorts, ok := s.data["all"]
+func (s *UserStorage) LinkByShort(ctx context.Context, short shortlink.Short) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	link, ok := s.shorts[short]
 	if !ok {
 		return "", ErrURLNotFound
 	}
-	url, ok := shorts[short]
+	if link.Deleted {
+		return "", er.ErrURLIsGone
+	}
+	if link.expired(time.Now()) {
+		return "", er.ErrURLExpired
+	}
+	return link.Origin, nil
+}
+
+// RecordByShort get link with owner by short
+func (s *UserStorage) RecordByShort(ctx context.Context, short shortlink.Short) (shortlink.Record, error) {
+	if err := ctx.Err(); err != nil {
+		return shortlink.Record{}, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	link, ok := s.shorts[short]
 	if !ok {
-		return "", ErrURLNotFound
+		return shortlink.Record{}, ErrURLNotFound
 	}
-	return url, nil
+	return shortlink.Record{UserID: link.UserID, Link: link.toLink(short)}, nil
 }
 
 // LinksByUser return all user links
-func (s *UserStorage) LinksByUser(userID user.UniqUser) (shortlink.ShortLinks, error) {
-	shorts, ok := s.data[userID]
+func (s *UserStorage) LinksByUser(ctx context.Context, userID user.UniqUser) (shortlink.ShortLinks, error) {
+	if err := ctx.Err(); err != nil {
+		return shortlink.ShortLinks{}, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	links, ok := s.data[userID]
 	if !ok {
-		return shorts, ErrURLNotFound
+		return shortlink.ShortLinks{}, ErrURLNotFound
+	}
+	shorts := make(shortlink.ShortLinks, len(links))
+	for short, link := range links {
+		shorts[short] = link.Origin
 	}
 	return shorts, nil
 }
 
+// LinksPage return page of user links by filters
+func (s *UserStorage) LinksPage(ctx context.Context, userID user.UniqUser, q shortlink.Query) (shortlink.Page, error) {
+	q, err := q.Normalize()
+	if err != nil {
+		return shortlink.Page{}, err
+	}
+	if err = ctx.Err(); err != nil {
+		return shortlink.Page{}, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	links := make([]shortlink.Link, 0, len(s.data[userID]))
+	for short, link := range s.data[userID] {
+		links = append(links, link.toLink(short))
+	}
+	return shortlink.Paginate(links, q), nil
+}
+
 // Save url in storage of short links
-func (s *UserStorage) Save(userID user.UniqUser, url string) (shortlink.Short, error) {
-	short := shortlink.Short(helpers.RandomString(10))
-	// Get current urls for user
-	currentUrls := shortlink.ShortLinks{}
-	if urls, ok := s.data[userID]; ok {
-		currentUrls = urls
-	}
-	currentUrls[short] = url
-	// Save in map storage or rewrite current
-	s.data[userID] = currentUrls
-	s.data["all"] = currentUrls
-	// Save to file storage
-	if s.fileStoragePath == "" {
-		return short, nil
+func (s *UserStorage) Save(ctx context.Context, userID user.UniqUser, url string, opts shortlink.Options) (shortlink.Short, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Check if user already has short for origin
+	if short, ok := s.origins[userID][url]; ok {
+		return short, er.ErrAlreadyHasShort
 	}
-	_ = fw.Write(s.fileStoragePath, s.data)
-	return short, nil
+	e := entry{Op: opSave, UserID: userID, Origin: url, ExpiresAt: unixNano(opts.ExpiresAt), CreatedAt: time.Now().UnixNano()}
+	short, err := s.save(e, opts.Alias)
+	if err != nil {
+		return "", err
+	}
+	return short, s.compactIfNeeded()
 }
 
-// Load all links to map
+// Load all links to map from journal
 func (s *UserStorage) Load() error {
 	// If file storage not exists
 	if s.fileStoragePath == "" {
 		return nil
 	}
-	if err := fw.Read(s.fileStoragePath, &s.data); err != nil {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, err := fw.OpenJournal(s.fileStoragePath, s.syncPolicy, s.syncInterval)
+	if errors.Is(err, fw.ErrLegacyFormat) {
+		return s.importLegacy()
+	}
+	if err != nil {
 		return err
 	}
-	return nil
+	s.journal = j
+
+	return j.Replay(func(data []byte) error {
+		var e entry
+		if err := json.Unmarshal(data, &e); err != nil {
+			return err
+		}
+		s.apply(e)
+		s.appended++
+		return nil
+	})
 }
 
 // BunchSave save mass urls
-func (s *UserStorage) BunchSave(userID user.UniqUser, urls []shortlink.URLs) ([]shortlink.ShortURLs, error) {
-	var shorts []shortlink.ShortURLs
-	return shorts, nil
+func (s *UserStorage) BunchSave(ctx context.Context, userID user.UniqUser, urls []shortlink.URLs, opts shortlink.BatchOptions) ([]shortlink.ShortURLs, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Check aliases before save anything
+	results := make([]shortlink.ShortURLs, len(urls))
+	aliases := make(map[string]struct{})
+	for k, v := range urls {
+		results[k].ID = v.ID
+		if v.Alias == "" {
+			continue
+		}
+		_, seen := aliases[v.Alias]
+		if seen || s.aliasTaken(userID, v.Alias, v.Origin) {
+			results[k] = shortlink.Invalid(v.ID, fmt.Errorf("%w: %s", er.ErrAliasTaken, v.Alias))
+			continue
+		}
+		aliases[v.Alias] = struct{}{}
+	}
+	if opts.Atomic && shortlink.HasInvalid(results) {
+		shortlink.Abort(results)
+		return results, er.ErrBatchAborted
+	}
+
+	// Allocate shorts of all items before write, nothing is saved if any of them fails
+	entries := make([]entry, 0, len(urls))
+	taken := make(map[shortlink.Short]struct{}, len(urls))
+	for alias := range aliases {
+		taken[shortlink.Short(alias)] = struct{}{}
+	}
+	origins := make(map[string]shortlink.Short, len(urls))
+	for k, v := range urls {
+		if results[k].Status == shortlink.ItemInvalid {
+			continue
+		}
+		// Origins which user already has are returned with current short
+		if short, ok := s.origins[userID][v.Origin]; ok {
+			results[k] = shortlink.Existing(v.ID, short)
+			continue
+		}
+		if short, ok := origins[v.Origin]; ok {
+			results[k] = shortlink.Existing(v.ID, short)
+			continue
+		}
+		e := entry{Op: opSave, UserID: userID, Origin: v.Origin, CorrelationID: v.ID, CreatedAt: time.Now().UnixNano()}
+		if v.ExpiresAt != nil {
+			e.ExpiresAt = unixNano(*v.ExpiresAt)
+		}
+		if err := s.allocate(&e, v.Alias, taken); err != nil {
+			return nil, err
+		}
+		taken[e.Short] = struct{}{}
+		origins[v.Origin] = e.Short
+		entries = append(entries, e)
+		results[k] = shortlink.Created(v.ID, e.Short)
+	}
+	// Items are written by one record and applied together
+	if err := s.writeBatch(entries); err != nil {
+		return nil, err
+	}
+
+	return results, s.compactIfNeeded()
 }
 
 // Clear database
-func (s *UserStorage) Clear() error {
+func (s *UserStorage) Clear(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.data = make(map[user.UniqUser]userLinks)
+	s.shorts = make(map[shortlink.Short]*record)
+	s.origins = make(map[user.UniqUser]map[string]shortlink.Short)
+
+	return s.compact()
+}
+
+// BunchUpdateAsDeleted set deleted flag for user links by correlation ids or shorts
+func (s *UserStorage) BunchUpdateAsDeleted(ctx context.Context, ids []string, userID string) ([]shortlink.Link, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	links, ok := s.data[user.UniqUser(userID)]
+	if !ok {
+		return nil, nil
+	}
+	lookup := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		lookup[id] = struct{}{}
+	}
+	e := entry{Op: opDelete, UserID: user.UniqUser(userID), DeletedAt: time.Now().UnixNano()}
+	var updated []shortlink.Link
+	for short, link := range links {
+		_, byShort := lookup[string(short)]
+		_, byID := lookup[link.CorrelationID]
+		if !link.Deleted && (byShort || (byID && link.CorrelationID != "")) {
+			e.Shorts = append(e.Shorts, short)
+			updated = append(updated, shortlink.Link{Short: short, CorrelationID: link.CorrelationID})
+		}
+	}
+	if len(e.Shorts) == 0 {
+		return nil, nil
+	}
+	if err := s.write(e); err != nil {
+		return nil, err
+	}
+	return updated, s.compactIfNeeded()
+}
+
+// UpdateExpiredAsDeleted set deleted flag for links expired before now
+func (s *UserStorage) UpdateExpiredAsDeleted(ctx context.Context, now time.Time) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	updated := 0
+	for userID, links := range s.data {
+		e := entry{Op: opDelete, UserID: userID, DeletedAt: now.UnixNano()}
+		for short, link := range links {
+			if !link.Deleted && link.expired(now) {
+				e.Shorts = append(e.Shorts, short)
+			}
+		}
+		if len(e.Shorts) == 0 {
+			continue
+		}
+		if err := s.write(e); err != nil {
+			return updated, err
+		}
+		updated += len(e.Shorts)
+	}
+	return updated, s.compactIfNeeded()
+}
+
+// Restore remove deleted flag from user links by correlation ids or shorts, which were deleted since time
+// and not expired
+func (s *UserStorage) Restore(ctx context.Context, ids []string, userID string, since time.Time) ([]shortlink.Short, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lookup := make(map[string]struct{}, len(ids))
+	for _, id := range ids {
+		lookup[id] = struct{}{}
+	}
+	now := time.Now()
+	e := entry{Op: opRestore, UserID: user.UniqUser(userID)}
+	for short, link := range s.data[user.UniqUser(userID)] {
+		_, byShort := lookup[string(short)]
+		_, byID := lookup[link.CorrelationID]
+		if !byShort && (!byID || link.CorrelationID == "") {
+			continue
+		}
+		if link.Deleted && !link.DeletedAt.Before(since) && !link.expired(now) {
+			e.Shorts = append(e.Shorts, short)
+		}
+	}
+	if len(e.Shorts) == 0 {
+		return nil, nil
+	}
+	if err := s.write(e); err != nil {
+		return nil, err
+	}
+	return e.Shorts, s.compactIfNeeded()
+}
+
+// Purge remove links which were deleted before time
+func (s *UserStorage) Purge(ctx context.Context, before time.Time) ([]shortlink.Short, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var purged []shortlink.Short
+	for userID, links := range s.data {
+		e := entry{Op: opPurge, UserID: userID}
+		for short, link := range links {
+			if link.Deleted && link.DeletedAt.Before(before) {
+				e.Shorts = append(e.Shorts, short)
+			}
+		}
+		if len(e.Shorts) == 0 {
+			continue
+		}
+		if err := s.write(e); err != nil {
+			return purged, err
+		}
+		purged = append(purged, e.Shorts...)
+	}
+	if len(purged) == 0 {
+		return nil, nil
+	}
+	// Journal is rewritten without purged links
+	return purged, s.compact()
+}
+
+// URLCount get saved url count in storage
+func (s *UserStorage) URLCount(ctx context.Context) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.shorts), nil
+}
+
+// UserCount get users count in storage
+func (s *UserStorage) UserCount(ctx context.Context) (counter int, err error) {
+	if err = ctx.Err(); err != nil {
+		return 0, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, links := range s.data {
+		if len(links) > 0 {
+			counter++
+		}
+	}
+	return counter, nil
+}
+
+// WalkUser call fn for every link of user in order of creation
+func (s *UserStorage) WalkUser(ctx context.Context, userID user.UniqUser, fn func(l shortlink.Link) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.RLock()
+	links := make([]shortlink.Link, 0, len(s.data[userID]))
+	for short, link := range s.data[userID] {
+		links = append(links, link.toLink(short))
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(links, func(i, j int) bool {
+		return shortlink.Less(links[i], links[j])
+	})
+	for _, l := range links {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(l); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-// BunchUpdateAsDeleted implementation
-func (s *UserStorage) BunchUpdateAsDeleted(ctx context.Context, ids []string, userID string) error {
+// Walk call fn for every link in order of user and short after position
+func (s *UserStorage) Walk(ctx context.Context, after shortlink.Position, fn func(rec shortlink.Record) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	// Snapshot of records, so fn can take time without lock
+	s.mu.RLock()
+	recs := make([]shortlink.Record, 0, len(s.shorts))
+	for short, link := range s.shorts {
+		rec := shortlink.Record{UserID: link.UserID, Link: link.toLink(short)}
+		if rec.Position().After(after) {
+			recs = append(recs, rec)
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(recs, func(i, j int) bool {
+		return recs[i].Position().Less(recs[j].Position())
+	})
+	for _, rec := range recs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-// URLCount implementation
-func (s *UserStorage) URLCount() (counter int) {
-	return 0
+// Import save records with their shorts. Existing records are skipped, conflicts fail whole batch
+func (s *UserStorage) Import(ctx context.Context, recs []shortlink.Record) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Check conflicts before save anything
+	var entries []entry
+	batch := make(map[shortlink.Short]struct{}, len(recs))
+	batchOrigins := make(map[[2]string]struct{}, len(recs))
+	for _, rec := range recs {
+		if link, ok := s.shorts[rec.Short]; ok {
+			if link.UserID == rec.UserID && link.Origin == rec.Origin {
+				continue
+			}
+			return 0, fmt.Errorf("%w: short %s", er.ErrImportConflict, rec.Short)
+		}
+		if _, ok := s.origins[rec.UserID][rec.Origin]; ok {
+			return 0, fmt.Errorf("%w: user %s already has %s", er.ErrImportConflict, rec.UserID, rec.Origin)
+		}
+		origin := [2]string{string(rec.UserID), rec.Origin}
+		if _, ok := batch[rec.Short]; ok {
+			return 0, fmt.Errorf("%w: short %s", er.ErrImportConflict, rec.Short)
+		}
+		if _, ok := batchOrigins[origin]; ok {
+			return 0, fmt.Errorf("%w: user %s already has %s", er.ErrImportConflict, rec.UserID, rec.Origin)
+		}
+		batch[rec.Short] = struct{}{}
+		batchOrigins[origin] = struct{}{}
+		entries = append(entries, entry{
+			Op:            opSave,
+			UserID:        rec.UserID,
+			Short:         rec.Short,
+			Origin:        rec.Origin,
+			CorrelationID: rec.CorrelationID,
+			Deleted:       rec.Deleted,
+			ExpiresAt:     unixNano(rec.ExpiresAt),
+			CreatedAt:     unixNano(rec.CreatedAt),
+		})
+	}
+	for i, e := range entries {
+		if err := s.write(e); err != nil {
+			return i, err
+		}
+	}
+	return len(entries), s.compactIfNeeded()
+}
+
+// Close flush and close journal
+func (s *UserStorage) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
+	if s.journal == nil {
+		return nil
+	}
+	return s.journal.Close()
 }
 
-// UserCount implementation
-func (s *UserStorage) UserCount() (counter int) {
-	return 0
+// write entry to journal and apply it to memory. Must be called under write lock
+func (s *UserStorage) write(e entry) error {
+	if s.journal != nil {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if err := s.journal.Append(data); err != nil {
+			return err
+		}
+		s.appended++
+	}
+	s.apply(e)
+	return nil
+}
+
+// writeBatch write entries to journal as one record and apply them to memory.
+// Must be called under write lock
+func (s *UserStorage) writeBatch(entries []entry) error {
+	switch len(entries) {
+	case 0:
+		return nil
+	case 1:
+		return s.write(entries[0])
+	}
+	return s.write(entry{Op: opBatch, Batch: entries})
+}
+
+// apply entry to memory. Must be called under write lock
+func (s *UserStorage) apply(e entry) {
+	if e.Counter > s.counter {
+		s.counter = e.Counter
+	}
+	switch e.Op {
+	case opBatch:
+		for _, b := range e.Batch {
+			s.apply(b)
+		}
+	case opSave:
+		// Get current urls for user
+		links, ok := s.data[e.UserID]
+		if !ok {
+			links = userLinks{}
+			s.data[e.UserID] = links
+		}
+		r := &record{
+			UserID:        e.UserID,
+			Origin:        e.Origin,
+			CorrelationID: e.CorrelationID,
+			Deleted:       e.Deleted,
+			CreatedAt:     fromUnixNano(e.CreatedAt),
+			ExpiresAt:     fromUnixNano(e.ExpiresAt),
+		}
+		if e.Deleted {
+			r.DeletedAt = deletedAt(e.DeletedAt)
+		}
+		// Keep user and global indexes together
+		links[e.Short] = r
+		s.shorts[e.Short] = r
+		s.index(e.UserID, e.Short, e.Origin)
+	case opDelete:
+		links := s.data[e.UserID]
+		for _, short := range e.Shorts {
+			if link, ok := links[short]; ok {
+				link.Deleted, link.DeletedAt = true, deletedAt(e.DeletedAt)
+			}
+		}
+	case opRestore:
+		links := s.data[e.UserID]
+		for _, short := range e.Shorts {
+			if link, ok := links[short]; ok {
+				link.Deleted, link.DeletedAt = false, time.Time{}
+			}
+		}
+	case opPurge:
+		links := s.data[e.UserID]
+		for _, short := range e.Shorts {
+			link, ok := links[short]
+			if !ok {
+				continue
+			}
+			delete(links, short)
+			delete(s.shorts, short)
+			if s.origins[e.UserID][link.Origin] == short {
+				delete(s.origins[e.UserID], link.Origin)
+			}
+		}
+		if len(links) == 0 {
+			delete(s.data, e.UserID)
+			delete(s.origins, e.UserID)
+		}
+	}
+}
+
+// aliasTaken check if alias is used by another link. Must be called under lock
+func (s *UserStorage) aliasTaken(userID user.UniqUser, alias, origin string) bool {
+	link, ok := s.shorts[shortlink.Short(alias)]
+	return ok && (link.UserID != userID || link.Origin != origin)
+}
+
+// index origin of user for conflicts check. Must be called under write lock
+func (s *UserStorage) index(userID user.UniqUser, short shortlink.Short, origin string) {
+	origins, ok := s.origins[userID]
+	if !ok {
+		origins = make(map[string]shortlink.Short)
+		s.origins[userID] = origins
+	}
+	origins[origin] = short
+}
+
+// save new link entry with alias or generated short which not used by any user.
+// Must be called under write lock
+func (s *UserStorage) save(e entry, alias string) (shortlink.Short, error) {
+	if err := s.allocate(&e, alias, nil); err != nil {
+		return "", err
+	}
+	return e.Short, s.write(e)
+}
+
+// allocate alias or generated short for new link entry. Generated short isn't used by any user
+// and isn't in taken shorts of batch. Must be called under write lock
+func (s *UserStorage) allocate(e *entry, alias string, taken map[shortlink.Short]struct{}) error {
+	if alias != "" {
+		if s.aliasTaken(e.UserID, alias, e.Origin) {
+			return er.ErrAliasTaken
+		}
+		e.Short = shortlink.Short(alias)
+		return nil
+	}
+	_, err := s.alloc.Allocate(e.Origin, func(short shortlink.Short) error {
+		if _, ok := s.shorts[short]; ok {
+			return shortcode.ErrCollision
+		}
+		if _, ok := taken[short]; ok {
+			return shortcode.ErrCollision
+		}
+		e.Short = short
+		if c := s.alloc.Counter(); c != nil {
+			e.Counter = c.Value()
+		}
+		return nil
+	})
+	return err
+}
+
+// compactIfNeeded compact journal when it at least twice bigger than live data,
+// so write cost don't depend on total count of links. Must be called under write lock
+func (s *UserStorage) compactIfNeeded() error {
+	if s.journal == nil || s.compactEvery <= 0 {
+		return nil
+	}
+	if s.appended < s.compactEvery || s.appended < 2*len(s.shorts) {
+		return nil
+	}
+	return s.compact()
+}
+
+// compact rewrite journal with current links. Must be called under write lock
+func (s *UserStorage) compact() error {
+	if s.journal == nil {
+		return nil
+	}
+	written := 0
+	err := s.journal.Compact(func(emit func(data []byte) error) error {
+		if s.counter > 0 {
+			data, err := json.Marshal(entry{Op: opCounter, Counter: s.counter})
+			if err != nil {
+				return err
+			}
+			if err = emit(data); err != nil {
+				return err
+			}
+			written++
+		}
+		for short, link := range s.shorts {
+			data, err := json.Marshal(entry{
+				Op:            opSave,
+				UserID:        link.UserID,
+				Short:         short,
+				Origin:        link.Origin,
+				CorrelationID: link.CorrelationID,
+				Deleted:       link.Deleted,
+				ExpiresAt:     unixNano(link.ExpiresAt),
+				CreatedAt:     unixNano(link.CreatedAt),
+				DeletedAt:     unixNano(link.DeletedAt),
+			})
+			if err != nil {
+				return err
+			}
+			if err := emit(data); err != nil {
+				return err
+			}
+			written++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.appended = written
+	return nil
+}
+
+// importLegacy move links from old gob snapshot to new journal.
+// Old file is kept with .legacy suffix. Must be called under write lock
+func (s *UserStorage) importLegacy() error {
+	legacy := make(map[user.UniqUser]shortlink.ShortLinks)
+	if err := fw.Read(s.fileStoragePath, &legacy); err != nil {
+		return err
+	}
+	if err := os.Rename(s.fileStoragePath, s.fileStoragePath+".legacy"); err != nil {
+		return err
+	}
+	j, err := fw.OpenJournal(s.fileStoragePath, s.syncPolicy, s.syncInterval)
+	if err != nil {
+		return err
+	}
+	s.journal = j
+	// Bucket "all" was alias for links of last saved user, but gRPC saved under this user id,
+	// so links of "all" which aren't in other buckets are own links of it
+	owned := make(map[shortlink.Short]bool)
+	for userID, links := range legacy {
+		if userID == "all" {
+			continue
+		}
+		for short, origin := range links {
+			owned[short] = true
+			s.apply(entry{Op: opSave, UserID: userID, Short: short, Origin: origin})
+		}
+	}
+	for short, origin := range legacy["all"] {
+		if !owned[short] {
+			s.apply(entry{Op: opSave, UserID: "all", Short: short, Origin: origin})
+		}
+	}
+	return s.compact()
+}
+
+// deletedAt time of deletion from journal. Deletions from old journal get current time,
+// so retention of them starts from load
+func deletedAt(nsec int64) time.Time {
+	if nsec == 0 {
+		return time.Now().UTC()
+	}
+	return time.Unix(0, nsec).UTC()
+}
+
+// unixNano convert expiration time for journal, zero time is kept as zero
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// fromUnixNano convert time from journal, zero is kept as zero time
+func fromUnixNano(nsec int64) time.Time {
+	if nsec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nsec).UTC()
 }
@@ -0,0 +1,389 @@
+package file
+
+import (
+	"context"
+	"encoding/gob"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	er "github.com/triumphpc/go-musthave-shortener-tpl/internal/app/errors"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/helpers/shortcode"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/shortlink"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/user"
+	fw "github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/filewrapper"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/repository/repositorytest"
+)
+
+func TestUserStorage_Save(t *testing.T) {
+	ctx := context.Background()
+	s, err := New("")
+	require.NoError(t, err)
+
+	short, err := s.Save(ctx, "user", "http://test.ru", shortlink.Options{})
+	require.NoError(t, err)
+
+	origin, err := s.LinkByShort(ctx, short)
+	require.NoError(t, err)
+	assert.Equal(t, "http://test.ru", origin)
+
+	// Same origin for same user
+	existing, err := s.Save(ctx, "user", "http://test.ru", shortlink.Options{})
+	assert.ErrorIs(t, err, er.ErrAlreadyHasShort)
+	assert.Equal(t, short, existing)
+}
+
+func TestUserStorage_SaveAlias(t *testing.T) {
+	ctx := context.Background()
+	s, err := New("")
+	require.NoError(t, err)
+
+	short, err := s.Save(ctx, "user", "http://test.ru", shortlink.Options{Alias: "my-link"})
+	require.NoError(t, err)
+	assert.Equal(t, shortlink.Short("my-link"), short)
+
+	// Alias of other link or user is taken
+	_, err = s.Save(ctx, "user", "http://other.ru", shortlink.Options{Alias: "my-link"})
+	assert.ErrorIs(t, err, er.ErrAliasTaken)
+	_, err = s.Save(ctx, "other", "http://test.ru", shortlink.Options{Alias: "my-link"})
+	assert.ErrorIs(t, err, er.ErrAliasTaken)
+
+	// Alias taken in atomic batch fails whole batch
+	shorts, err := s.BunchSave(ctx, "user", []shortlink.URLs{
+		{ID: "1", Origin: "http://one.ru", Alias: "one"},
+		{ID: "2", Origin: "http://two.ru", Alias: "one"},
+	}, shortlink.BatchOptions{Atomic: true})
+	assert.ErrorIs(t, err, er.ErrBatchAborted)
+	assert.Equal(t, shortlink.ItemAborted, shorts[0].Status)
+	assert.Equal(t, shortlink.ItemInvalid, shorts[1].Status)
+	repositorytest.AssertCount(t, 1, s.URLCount)
+
+	// Without atomic mode only repeated alias is invalid
+	shorts, err = s.BunchSave(ctx, "user", []shortlink.URLs{
+		{ID: "1", Origin: "http://one.ru", Alias: "one"},
+		{ID: "2", Origin: "http://two.ru", Alias: "one"},
+	}, shortlink.BatchOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, shortlink.Created("1", "one"), shorts[0])
+	assert.Equal(t, shortlink.ItemInvalid, shorts[1].Status)
+	repositorytest.AssertCount(t, 2, s.URLCount)
+}
+
+func TestUserStorage_BunchSave(t *testing.T) {
+	ctx := context.Background()
+	s, err := New("")
+	require.NoError(t, err)
+
+	exists, err := s.Save(ctx, "user", "http://exists.ru", shortlink.Options{})
+	require.NoError(t, err)
+
+	shorts, err := s.BunchSave(ctx, "user", []shortlink.URLs{
+		{ID: "1", Origin: "http://one.ru"},
+		{ID: "2", Origin: "http://exists.ru"},
+		{ID: "3", Origin: "http://three.ru"},
+		{ID: "4", Origin: "http://one.ru"},
+	}, shortlink.BatchOptions{})
+	require.NoError(t, err)
+	require.Len(t, shorts, 4)
+	assert.Equal(t, shortlink.ItemCreated, shorts[0].Status)
+	assert.Equal(t, shortlink.Existing("2", exists), shorts[1])
+	assert.Equal(t, shortlink.ItemCreated, shorts[2].Status)
+	// Repeat of origin in batch gets short of first item
+	assert.Equal(t, shortlink.Existing("4", shortlink.Short(shorts[0].Short)), shorts[3])
+
+	repositorytest.AssertCount(t, 3, s.URLCount)
+	repositorytest.AssertCount(t, 1, s.UserCount)
+}
+
+func TestUserStorage_BunchSaveJournal(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.db")
+	urls := []shortlink.URLs{
+		{ID: "1", Origin: "http://one.ru"},
+		{ID: "2", Origin: "http://two.ru", Alias: "two"},
+		{ID: "3", Origin: "http://three.ru"},
+	}
+
+	// Batch is replayed from one journal record
+	s, err := New(path, WithCompaction(0))
+	require.NoError(t, err)
+	_, err = s.BunchSave(ctx, "user", urls, shortlink.BatchOptions{})
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+	s, err = New(path, WithCompaction(0))
+	require.NoError(t, err)
+	repositorytest.AssertCount(t, 3, s.URLCount)
+
+	// Failed write of batch doesn't leave part of it
+	require.NoError(t, s.journal.Close())
+	results, err := s.BunchSave(ctx, "other", urls, shortlink.BatchOptions{})
+	assert.ErrorIs(t, err, fw.ErrJournalClosed)
+	assert.Nil(t, results)
+	repositorytest.AssertCount(t, 3, s.URLCount)
+	repositorytest.AssertCount(t, 1, s.UserCount)
+}
+
+func TestUserStorage_BunchUpdateAsDeleted(t *testing.T) {
+	ctx := context.Background()
+	s, err := New("")
+	require.NoError(t, err)
+
+	shorts, err := s.BunchSave(ctx, "user", []shortlink.URLs{
+		{ID: "1", Origin: "http://one.ru"},
+		{ID: "2", Origin: "http://two.ru"},
+	}, shortlink.BatchOptions{})
+	require.NoError(t, err)
+
+	updated, err := s.BunchUpdateAsDeleted(ctx, []string{"1", shorts[1].Short}, "user")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []shortlink.Link{
+		{Short: shortlink.Short(shorts[0].Short), CorrelationID: "1"},
+		{Short: shortlink.Short(shorts[1].Short), CorrelationID: "2"},
+	}, updated)
+	// Deleted links aren't counted again
+	updated, err = s.BunchUpdateAsDeleted(ctx, []string{"1"}, "user")
+	require.NoError(t, err)
+	assert.Empty(t, updated)
+
+	for _, v := range shorts {
+		_, err = s.LinkByShort(ctx, shortlink.Short(v.Short))
+		assert.ErrorIs(t, err, er.ErrURLIsGone)
+	}
+}
+
+func TestUserStorage_Concurrent(t *testing.T) {
+	ctx := context.Background()
+	s, err := New("")
+	require.NoError(t, err)
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			userID := user.UniqUser(fmt.Sprintf("user_%d", i))
+			for j := 0; j < 100; j++ {
+				short, err := s.Save(ctx, userID, fmt.Sprintf("http://%d.ru/%d", i, j), shortlink.Options{})
+				assert.NoError(t, err)
+				_, _ = s.LinkByShort(ctx, short)
+				_, _ = s.LinksByUser(ctx, userID)
+				_, _ = s.URLCount(ctx)
+			}
+			_, _ = s.BunchUpdateAsDeleted(ctx, []string{"x"}, string(userID))
+		}(i)
+	}
+	wg.Wait()
+
+	repositorytest.AssertCount(t, 1000, s.URLCount)
+	repositorytest.AssertCount(t, 10, s.UserCount)
+}
+
+func TestUserStorage_Journal(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.db")
+
+	s, err := New(path, WithSync(fw.SyncAlways, 0), WithCompaction(2))
+	require.NoError(t, err)
+	shorts, err := s.BunchSave(ctx, "user", []shortlink.URLs{
+		{ID: "1", Origin: "http://one.ru"},
+		{ID: "2", Origin: "http://two.ru"},
+		{ID: "3", Origin: "http://three.ru"},
+	}, shortlink.BatchOptions{})
+	require.NoError(t, err)
+	_, err = s.BunchUpdateAsDeleted(ctx, []string{"2"}, "user")
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+
+	// Replay journal on start
+	s, err = New(path)
+	require.NoError(t, err)
+	defer s.Close()
+
+	repositorytest.AssertCount(t, 3, s.URLCount)
+	origin, err := s.LinkByShort(ctx, shortlink.Short(shorts[0].Short))
+	require.NoError(t, err)
+	assert.Equal(t, "http://one.ru", origin)
+	_, err = s.LinkByShort(ctx, shortlink.Short(shorts[1].Short))
+	assert.ErrorIs(t, err, er.ErrURLIsGone)
+	_, err = s.Save(ctx, "user", "http://three.ru", shortlink.Options{})
+	assert.ErrorIs(t, err, er.ErrAlreadyHasShort)
+}
+
+func TestUserStorage_Counter(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.db")
+	open := func(opts ...Option) *UserStorage {
+		// One attempt, so repeated code is error
+		alloc := shortcode.New(shortcode.NewSequence(shortcode.Base62, 0), shortcode.WithAttempts(1))
+		s, err := New(path, append(opts, WithAllocator(alloc))...)
+		require.NoError(t, err)
+		return s
+	}
+
+	s := open()
+	first, err := s.Save(ctx, "user", "http://one.ru", shortlink.Options{})
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+
+	// Counter continues after restart and keeps value in compacted journal without first link
+	s = open(WithCompaction(1))
+	second, err := s.Save(ctx, "user", "http://two.ru", shortlink.Options{})
+	require.NoError(t, err)
+	_, err = s.BunchUpdateAsDeleted(ctx, []string{string(first)}, "user")
+	require.NoError(t, err)
+	purged, err := s.Purge(ctx, time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	require.Len(t, purged, 1)
+	require.NoError(t, s.Close())
+
+	s = open()
+	defer s.Close()
+	third, err := s.Save(ctx, "user", "http://three.ru", shortlink.Options{})
+	require.NoError(t, err)
+	assert.NotEqual(t, first, second)
+	assert.NotEqual(t, second, third)
+	assert.NotEqual(t, first, third)
+}
+
+func TestUserStorage_RestorePurge(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.db")
+	s, err := New(path, WithSync(fw.SyncAlways, 0))
+	require.NoError(t, err)
+	shorts, err := s.BunchSave(ctx, "user", []shortlink.URLs{
+		{ID: "1", Origin: "http://one.ru"},
+		{ID: "2", Origin: "http://two.ru"},
+	}, shortlink.BatchOptions{})
+	require.NoError(t, err)
+	_, err = s.BunchUpdateAsDeleted(ctx, []string{"1", "2"}, "user")
+	require.NoError(t, err)
+
+	now := time.Now()
+	// Links of other user and deleted before grace period are kept deleted
+	restored, err := s.Restore(ctx, []string{"1"}, "other", now.Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Empty(t, restored)
+	restored, err = s.Restore(ctx, []string{"1"}, "user", now.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Empty(t, restored)
+
+	restored, err = s.Restore(ctx, []string{"1"}, "user", now.Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Len(t, restored, 1)
+	origin, err := s.LinkByShort(ctx, shortlink.Short(shorts[0].Short))
+	require.NoError(t, err)
+	assert.Equal(t, "http://one.ru", origin)
+
+	// Only deletions before time are purged
+	purged, err := s.Purge(ctx, now.Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Empty(t, purged)
+	purged, err = s.Purge(ctx, time.Now().Add(time.Second))
+	require.NoError(t, err)
+	assert.Len(t, purged, 1)
+	_, err = s.LinkByShort(ctx, shortlink.Short(shorts[1].Short))
+	assert.ErrorIs(t, err, er.ErrURLNotFound)
+	repositorytest.AssertCount(t, 1, s.URLCount)
+
+	// Restore and purge are replayed from journal
+	require.NoError(t, s.Close())
+	s, err = New(path)
+	require.NoError(t, err)
+	defer s.Close()
+	repositorytest.AssertCount(t, 1, s.URLCount)
+	_, err = s.LinkByShort(ctx, shortlink.Short(shorts[0].Short))
+	assert.NoError(t, err)
+}
+
+func TestUserStorage_Legacy(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.db")
+
+	// Old snapshot format
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	legacy := map[user.UniqUser]shortlink.ShortLinks{
+		"user": {"short": "http://legacy.ru"},
+		"all":  {"short": "http://legacy.ru", "grpc": "http://grpc.ru"},
+	}
+	require.NoError(t, gob.NewEncoder(f).Encode(legacy))
+	require.NoError(t, f.Close())
+
+	s, err := New(path)
+	require.NoError(t, err)
+	defer s.Close()
+
+	origin, err := s.LinkByShort(ctx, "short")
+	require.NoError(t, err)
+	assert.Equal(t, "http://legacy.ru", origin)
+	// Link saved only to "all" bucket by gRPC is kept
+	links, err := s.LinksByUser(ctx, "all")
+	require.NoError(t, err)
+	assert.Equal(t, shortlink.ShortLinks{"grpc": "http://grpc.ru"}, links)
+	repositorytest.AssertCount(t, 2, s.URLCount)
+	assert.FileExists(t, path+".legacy")
+}
+
+func TestUserStorage_ZeroCreatedAt(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.db")
+	s, err := New(path)
+	require.NoError(t, err)
+	// Imported record without creation time
+	_, err = s.Import(ctx, []shortlink.Record{{UserID: "user", Link: shortlink.Link{Short: "short", Origin: "http://zero.ru"}}})
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+
+	// Zero time stays zero after replay, not 1970
+	s, err = New(path)
+	require.NoError(t, err)
+	defer s.Close()
+	var links []shortlink.Link
+	require.NoError(t, s.WalkUser(ctx, "user", func(l shortlink.Link) error {
+		links = append(links, l)
+		return nil
+	}))
+	require.Len(t, links, 1)
+	assert.True(t, links[0].CreatedAt.IsZero())
+	assert.True(t, links[0].ExpiresAt.IsZero())
+}
+
+func TestUserStorage_LinkByShort(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "storage.db")
+
+	s, err := New(path)
+	require.NoError(t, err)
+	first, err := s.Save(ctx, "first", "http://first.ru", shortlink.Options{})
+	require.NoError(t, err)
+	second, err := s.Save(ctx, "second", "http://second.ru", shortlink.Options{})
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+
+	s, err = New(path)
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Links of every user are resolved
+	origin, err := s.LinkByShort(ctx, first)
+	require.NoError(t, err)
+	assert.Equal(t, "http://first.ru", origin)
+	origin, err = s.LinkByShort(ctx, second)
+	require.NoError(t, err)
+	assert.Equal(t, "http://second.ru", origin)
+	repositorytest.AssertCount(t, 2, s.UserCount)
+
+	// Owner of link is found by short
+	rec, err := s.RecordByShort(ctx, second)
+	require.NoError(t, err)
+	assert.Equal(t, user.UniqUser("second"), rec.UserID)
+	assert.Equal(t, "http://second.ru", rec.Origin)
+	_, err = s.RecordByShort(ctx, "unknown")
+	assert.ErrorIs(t, err, er.ErrURLNotFound)
+}
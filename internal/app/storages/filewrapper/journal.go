@@ -0,0 +1,348 @@
+package filewrapper
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ErrLegacyFormat if file is not journal, but old gob snapshot
+var ErrLegacyFormat = errors.New("file storage has legacy format")
+
+// ErrJournalClosed if journal already closed
+var ErrJournalClosed = errors.New("journal is closed")
+
+// ErrFrameTooLarge if record is longer than max frame size
+var ErrFrameTooLarge = errors.New("journal record is too large")
+
+// ErrJournalBroken if failed append can't be cut from journal, appends are refused until reopen
+var ErrJournalBroken = errors.New("journal is broken")
+
+// journalMagic header of journal file
+var journalMagic = []byte("SHJ1\n")
+
+// frameHeaderSize length and checksum of record
+const frameHeaderSize = 8
+
+// MaxFrameSize limit of record length. Longer length in header of frame means broken frame
+const MaxFrameSize = 64 << 20
+
+// SyncPolicy define when journal flush appended records to disk
+type SyncPolicy string
+
+const (
+	// SyncAlways fsync after every append
+	SyncAlways SyncPolicy = "always"
+	// SyncInterval fsync in background by interval
+	SyncInterval SyncPolicy = "interval"
+	// SyncNever leave flush to operating system
+	SyncNever SyncPolicy = "never"
+)
+
+// Journal append-only log of records.
+// Every record framed by length and crc32 checksum, so torn tail after crash
+// is detected and cut on open
+type Journal struct {
+	mu     sync.Mutex
+	path   string
+	f      *os.File
+	w      *bufio.Writer
+	policy SyncPolicy
+	// offset end of last written record, failed append is cut back to it
+	offset int64
+	// broken error of failed append which wasn't cut
+	broken   error
+	dirty    bool
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+// OpenJournal open journal on path and create it if not exists.
+// Returns ErrLegacyFormat if path contain data in old snapshot format
+func OpenJournal(path string, policy SyncPolicy, interval time.Duration) (*Journal, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	if st.Size() == 0 {
+		if _, err := f.Write(journalMagic); err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+	} else {
+		header := make([]byte, len(journalMagic))
+		if _, err := io.ReadFull(f, header); err != nil || !bytes.Equal(header, journalMagic) {
+			_ = f.Close()
+			return nil, ErrLegacyFormat
+		}
+	}
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	j := &Journal{
+		path:   path,
+		f:      f,
+		w:      bufio.NewWriter(f),
+		policy: policy,
+		offset: offset,
+		done:   make(chan struct{}),
+	}
+	if policy == SyncInterval && interval > 0 {
+		go j.syncLoop(interval)
+	}
+	return j, nil
+}
+
+// Replay read all records from start of journal.
+// Broken tail of journal is truncated
+func (j *Journal) Replay(fn func(data []byte) error) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.f == nil {
+		return ErrJournalClosed
+	}
+	st, err := j.f.Stat()
+	if err != nil {
+		return err
+	}
+	if _, err := j.f.Seek(int64(len(journalMagic)), io.SeekStart); err != nil {
+		return err
+	}
+	r := bufio.NewReader(j.f)
+	offset := int64(len(journalMagic))
+	header := make([]byte, frameHeaderSize)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			break
+		}
+		size := binary.BigEndian.Uint32(header[:4])
+		sum := binary.BigEndian.Uint32(header[4:])
+		// Length of torn or corrupted header isn't allocated
+		if size > MaxFrameSize || int64(size) > st.Size()-offset-frameHeaderSize {
+			break
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(r, data); err != nil {
+			break
+		}
+		if crc32.ChecksumIEEE(data) != sum {
+			break
+		}
+		if err := fn(data); err != nil {
+			return err
+		}
+		offset += frameHeaderSize + int64(size)
+	}
+	// Cut torn tail
+	if err := j.f.Truncate(offset); err != nil {
+		return err
+	}
+	if _, err = j.f.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	j.offset = offset
+	return nil
+}
+
+// Append record to end of journal
+func (j *Journal) Append(data []byte) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.f == nil {
+		return ErrJournalClosed
+	}
+	if j.broken != nil {
+		return fmt.Errorf("%w: %v", ErrJournalBroken, j.broken)
+	}
+	if err := j.append(data); err != nil {
+		j.rollback(err)
+		return err
+	}
+	j.offset += frameHeaderSize + int64(len(data))
+	return nil
+}
+
+// append write record and flush it by policy. Must be called under lock
+func (j *Journal) append(data []byte) error {
+	if err := writeFrame(j.w, data); err != nil {
+		return err
+	}
+	if j.policy == SyncAlways {
+		return j.sync()
+	}
+	// Write to file for reading, fsync later by policy
+	j.dirty = true
+	return j.w.Flush()
+}
+
+// rollback drop buffer of failed append and cut its part written to file.
+// If it isn't possible, journal is broken. Must be called under lock
+func (j *Journal) rollback(cause error) {
+	j.w.Reset(j.f)
+	if err := j.f.Truncate(j.offset); err != nil {
+		j.broken = cause
+		return
+	}
+	if _, err := j.f.Seek(j.offset, io.SeekStart); err != nil {
+		j.broken = cause
+	}
+}
+
+// Compact rewrite journal with records of snapshot and replace it atomically
+func (j *Journal) Compact(snapshot func(emit func(data []byte) error) error) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.f == nil {
+		return ErrJournalClosed
+	}
+	tmpPath := j.path + ".compact"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	// Remove temp file on any error
+	ok := false
+	defer func() {
+		if !ok {
+			_ = tmp.Close()
+			_ = os.Remove(tmpPath)
+		}
+	}()
+
+	w := bufio.NewWriter(tmp)
+	if _, err := w.Write(journalMagic); err != nil {
+		return err
+	}
+	if err := snapshot(func(data []byte) error {
+		return writeFrame(w, data)
+	}); err != nil {
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	offset, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, j.path); err != nil {
+		return err
+	}
+	ok = true
+	syncDir(j.path)
+
+	// Switch to new file
+	_ = j.f.Close()
+	j.f = tmp
+	j.w = bufio.NewWriter(tmp)
+	j.offset = offset
+	j.broken = nil
+	j.dirty = false
+	return nil
+}
+
+// Sync flush journal to disk
+func (j *Journal) Sync() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.f == nil {
+		return ErrJournalClosed
+	}
+	return j.sync()
+}
+
+// Close stop background sync and close file
+func (j *Journal) Close() error {
+	j.stopOnce.Do(func() {
+		close(j.done)
+	})
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.f == nil {
+		return nil
+	}
+	err := j.sync()
+	if cErr := j.f.Close(); err == nil {
+		err = cErr
+	}
+	j.f = nil
+	return err
+}
+
+// sync flush buffer and fsync file. Must be called under lock
+func (j *Journal) sync() error {
+	if err := j.w.Flush(); err != nil {
+		return err
+	}
+	j.dirty = false
+	return j.f.Sync()
+}
+
+// syncLoop fsync journal by interval
+func (j *Journal) syncLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-j.done:
+			return
+		case <-ticker.C:
+			j.mu.Lock()
+			if j.f != nil && j.dirty {
+				_ = j.sync()
+			}
+			j.mu.Unlock()
+		}
+	}
+}
+
+// writeFrame write data with length and checksum
+func writeFrame(w io.Writer, data []byte) error {
+	if len(data) > MaxFrameSize {
+		return ErrFrameTooLarge
+	}
+	header := make([]byte, frameHeaderSize)
+	binary.BigEndian.PutUint32(header[:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(header[4:], crc32.ChecksumIEEE(data))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// syncDir fsync directory for persist rename
+func syncDir(path string) {
+	d, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return
+	}
+	_ = d.Sync()
+	_ = d.Close()
+}
@@ -0,0 +1,187 @@
+package filewrapper
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readAll replay journal records to slice
+func readAll(t *testing.T, j *Journal) []string {
+	var records []string
+	err := j.Replay(func(data []byte) error {
+		records = append(records, string(data))
+		return nil
+	})
+	require.NoError(t, err)
+	return records
+}
+
+func TestJournal_AppendReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.db")
+
+	j, err := OpenJournal(path, SyncAlways, 0)
+	require.NoError(t, err)
+	require.NoError(t, j.Append([]byte("first")))
+	require.NoError(t, j.Append([]byte("second")))
+	require.NoError(t, j.Close())
+
+	j, err = OpenJournal(path, SyncNever, 0)
+	require.NoError(t, err)
+	defer j.Close()
+
+	assert.Equal(t, []string{"first", "second"}, readAll(t, j))
+}
+
+func TestJournal_TornTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.db")
+
+	j, err := OpenJournal(path, SyncAlways, 0)
+	require.NoError(t, err)
+	require.NoError(t, j.Append([]byte("first")))
+	require.NoError(t, j.Close())
+
+	// Emulate crash in the middle of write
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0666)
+	require.NoError(t, err)
+	_, err = f.Write([]byte{0, 0, 0, 10, 1, 2})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	j, err = OpenJournal(path, SyncAlways, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"first"}, readAll(t, j))
+	// Append after cut tail
+	require.NoError(t, j.Append([]byte("second")))
+	require.NoError(t, j.Close())
+
+	j, err = OpenJournal(path, SyncAlways, 0)
+	require.NoError(t, err)
+	defer j.Close()
+	assert.Equal(t, []string{"first", "second"}, readAll(t, j))
+}
+
+func TestJournal_OversizeFrame(t *testing.T) {
+	tests := []struct {
+		name   string
+		header []byte
+	}{
+		{"over max frame size", []byte{0xff, 0xff, 0xff, 0xff, 1, 2, 3, 4}},
+		{"over file size", []byte{0, 0, 1, 0, 1, 2, 3, 4}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "journal.db")
+			j, err := OpenJournal(path, SyncAlways, 0)
+			require.NoError(t, err)
+			require.NoError(t, j.Append([]byte("first")))
+			require.NoError(t, j.Close())
+
+			// Corrupted header with huge length
+			f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0666)
+			require.NoError(t, err)
+			_, err = f.Write(append(tt.header, []byte("tail")...))
+			require.NoError(t, err)
+			require.NoError(t, f.Close())
+
+			j, err = OpenJournal(path, SyncAlways, 0)
+			require.NoError(t, err)
+			defer j.Close()
+			assert.Equal(t, []string{"first"}, readAll(t, j))
+			st, err := os.Stat(path)
+			require.NoError(t, err)
+			assert.Equal(t, int64(len(journalMagic)+frameHeaderSize+len("first")), st.Size())
+		})
+	}
+
+	j, err := OpenJournal(filepath.Join(t.TempDir(), "journal.db"), SyncNever, 0)
+	require.NoError(t, err)
+	defer j.Close()
+	assert.ErrorIs(t, j.Append(make([]byte, MaxFrameSize+1)), ErrFrameTooLarge)
+}
+
+// partialWriter write only first n bytes of data and fail
+type partialWriter struct {
+	w io.Writer
+	n int
+}
+
+func (p *partialWriter) Write(data []byte) (int, error) {
+	if len(data) > p.n {
+		data = data[:p.n]
+	}
+	n, _ := p.w.Write(data)
+	return n, errors.New("disk is full")
+}
+
+func TestJournal_FailedAppend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.db")
+
+	j, err := OpenJournal(path, SyncNever, 0)
+	require.NoError(t, err)
+	require.NoError(t, j.Append([]byte("first")))
+	// Part of record is written to file before error
+	j.w = bufio.NewWriter(&partialWriter{w: j.f, n: frameHeaderSize + 2})
+	assert.Error(t, j.Append([]byte("failed")))
+	// Next records are appended after last good one
+	require.NoError(t, j.Append([]byte("second")))
+	require.NoError(t, j.Close())
+
+	j, err = OpenJournal(path, SyncNever, 0)
+	require.NoError(t, err)
+	defer j.Close()
+	assert.Equal(t, []string{"first", "second"}, readAll(t, j))
+}
+
+func TestJournal_Broken(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.db")
+
+	j, err := OpenJournal(path, SyncNever, 0)
+	require.NoError(t, err)
+	defer j.Close()
+	require.NoError(t, j.Append([]byte("first")))
+
+	// Written part of record can't be cut from read only file
+	rw := j.f
+	defer rw.Close()
+	j.f, err = os.Open(path)
+	require.NoError(t, err)
+	j.w = bufio.NewWriter(&partialWriter{w: rw, n: frameHeaderSize + 2})
+	assert.Error(t, j.Append([]byte("failed")))
+	assert.ErrorIs(t, j.Append([]byte("second")), ErrJournalBroken)
+}
+
+func TestJournal_Compact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.db")
+
+	j, err := OpenJournal(path, SyncAlways, 0)
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, j.Append([]byte("record")))
+	}
+	err = j.Compact(func(emit func(data []byte) error) error {
+		return emit([]byte("snapshot"))
+	})
+	require.NoError(t, err)
+	require.NoError(t, j.Append([]byte("after")))
+	require.NoError(t, j.Close())
+
+	j, err = OpenJournal(path, SyncAlways, 0)
+	require.NoError(t, err)
+	defer j.Close()
+	assert.Equal(t, []string{"snapshot", "after"}, readAll(t, j))
+}
+
+func TestOpenJournal_Legacy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.db")
+	require.NoError(t, os.WriteFile(path, []byte("legacy gob data"), 0666))
+
+	_, err := OpenJournal(path, SyncAlways, 0)
+	assert.ErrorIs(t, err, ErrLegacyFormat)
+}
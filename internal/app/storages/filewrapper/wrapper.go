@@ -2,7 +2,6 @@
 package filewrapper
 
 import (
-	"bufio"
 	"encoding/gob"
 	"errors"
 	"io"
@@ -12,32 +11,8 @@ import (
 // ErrFileStorageNotClose closing error
 var ErrFileStorageNotClose = errors.New("file storage has not close")
 
-// Write data to path
-func Write(path string, data interface{}) error {
-	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0777)
-	if err != nil {
-		return err
-	}
-	// Handle for file close
-	defer func(f *os.File) {
-		err := f.Close()
-		if err != nil {
-			panic(ErrFileStorageNotClose)
-		}
-	}(f)
-
-	// Convert to gob
-	buffer := bufio.NewWriter(f)
-	ge := gob.NewEncoder(buffer)
-	// encode
-	if err := ge.Encode(data); err != nil {
-		return err
-	}
-	_ = buffer.Flush()
-	return nil
-}
-
-// Read data from path to data variable
+// Read data from path to data variable.
+// It used for import legacy gob snapshot to journal
 func Read(path string, data interface{}) error {
 	f, err := os.OpenFile(path, os.O_RDONLY|os.O_CREATE, 0777)
 	if err != nil {
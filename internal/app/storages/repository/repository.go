@@ -3,28 +3,53 @@ package repository
 
 import (
 	"context"
+	"time"
 
 	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/shortlink"
 	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/user"
 )
 
-// Repository interface for working with global repository
+// Repository interface for working with global repository.
+// Every method take context of request, so cancelled requests stop storage work
 // go:generate mockery --name=Repository --inpackage
 type Repository interface {
-	// LinkByShort get original link from all storage
-	LinkByShort(short shortlink.Short) (string, error)
-	// Save link to repository
-	Save(userID user.UniqUser, url string) (shortlink.Short, error)
-	// BunchSave save mass urls and generate shorts
-	BunchSave(userID user.UniqUser, urls []shortlink.URLs) ([]shortlink.ShortURLs, error)
+	// LinkByShort get original link from all storage. Returns ErrURLExpired after link expiration
+	LinkByShort(ctx context.Context, short shortlink.Short) (string, error)
+	// RecordByShort get link with owner by short, also deleted and expired one. Returns ErrURLNotFound for unknown short
+	RecordByShort(ctx context.Context, short shortlink.Short) (shortlink.Record, error)
+	// Save link to repository. If alias is set in options, it used as short
+	Save(ctx context.Context, userID user.UniqUser, url string, opts shortlink.Options) (shortlink.Short, error)
+	// BunchSave save mass urls and generate shorts or use aliases. Result has one item per url in same order.
+	// Invalid items are skipped, in atomic mode they roll back whole batch with er.ErrBatchAborted
+	BunchSave(ctx context.Context, userID user.UniqUser, urls []shortlink.URLs, opts shortlink.BatchOptions) ([]shortlink.ShortURLs, error)
 	// LinksByUser return all user links
-	LinksByUser(userID user.UniqUser) (shortlink.ShortLinks, error)
+	LinksByUser(ctx context.Context, userID user.UniqUser) (shortlink.ShortLinks, error)
+	// LinksPage return page of user links by filters, sorted by creation time
+	LinksPage(ctx context.Context, userID user.UniqUser, q shortlink.Query) (shortlink.Page, error)
 	// Clear storage
-	Clear() error
-	// BunchUpdateAsDeleted set flag as deleted
-	BunchUpdateAsDeleted(ctx context.Context, ids []string, userID string) error
-	// UrlCount get url count in storage
-	URLCount() int
+	Clear(ctx context.Context) error
+	// BunchUpdateAsDeleted set flag as deleted by correlation ids or shorts, returns updated links
+	// with their shorts and correlation ids
+	BunchUpdateAsDeleted(ctx context.Context, ids []string, userID string) ([]shortlink.Link, error)
+	// UpdateExpiredAsDeleted set flag as deleted for links expired before now, returns count of updated links
+	UpdateExpiredAsDeleted(ctx context.Context, now time.Time) (int, error)
+	// Restore remove deleted flag from user links by correlation ids or shorts, which were deleted
+	// not before since and are not expired. Returns shorts of restored links
+	Restore(ctx context.Context, ids []string, userID string, since time.Time) ([]shortlink.Short, error)
+	// Purge remove links which were deleted before time. Returns shorts of removed links
+	Purge(ctx context.Context, before time.Time) ([]shortlink.Short, error)
+	// URLCount get url count in storage
+	URLCount(ctx context.Context) (int, error)
 	// UserCount get users count in storage
-	UserCount() int
+	UserCount(ctx context.Context) (int, error)
+	// WalkUser call fn for every link of user without loading all of them in memory.
+	// Order of links is defined by storage. Error of fn stop walk and returned
+	WalkUser(ctx context.Context, userID user.UniqUser, fn func(l shortlink.Link) error) error
+	// Walk call fn for every link of storage in order of user and short, starting after position.
+	// Zero position for walk from start. Error of fn stop walk and returned
+	Walk(ctx context.Context, after shortlink.Position, fn func(rec shortlink.Record) error) error
+	// Import save records with their shorts, owners and flags in one batch. Records which storage
+	// already has are skipped, other conflicts fail whole batch with ErrImportConflict.
+	// Returns count of imported records
+	Import(ctx context.Context, recs []shortlink.Record) (int, error)
 }
@@ -0,0 +1,18 @@
+// Package repositorytest helpers of repository for tests of storages
+package repositorytest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// AssertCount check counter of storage
+func AssertCount(t *testing.T, expected int, counter func(ctx context.Context) (int, error)) {
+	t.Helper()
+	actual, err := counter(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, expected, actual)
+}
@@ -0,0 +1,780 @@
+// Package sqlite contain methods for SQLite storage with same schema as PostgreSQL
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/pressly/goose/v3"
+	"go.uber.org/zap"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+
+	er "github.com/triumphpc/go-musthave-shortener-tpl/internal/app/errors"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/helpers/shortcode"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/shortlink"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/user"
+	dbh "github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/db"
+	"github.com/triumphpc/go-musthave-shortener-tpl/migrations"
+)
+
+// Scheme of DSN for SQLite storage, rest of DSN is path of database file
+const Scheme = "sqlite://"
+
+// busyTimeout wait of lock by other process in milliseconds
+const busyTimeout = 5000
+
+// Storage of links in SQLite database
+type Storage struct {
+	db       *sql.DB
+	l        *zap.Logger
+	timeouts dbh.Timeouts
+	alloc    *shortcode.Allocator
+}
+
+// Option configure Storage
+type Option func(s *Storage)
+
+// WithAllocator set generator of short codes
+func WithAllocator(a *shortcode.Allocator) Option {
+	return func(s *Storage) {
+		s.alloc = a
+	}
+}
+
+// WithTimeouts set query timeouts
+func WithTimeouts(t dbh.Timeouts) Option {
+	return func(s *Storage) {
+		s.timeouts = t
+	}
+}
+
+// sqlNewRecord for new record in db
+const sqlNewRecord = `
+insert into short_links (user_id, origin, short, correlation_id, expires_at, created_at) 
+values (?, ?, ?, ?, ?, ?)
+`
+
+// sqlDeleteRecords sql for clear records
+const sqlDeleteRecords = `delete from short_links`
+
+// sqlGetCurrentRecord for get current record
+const sqlGetCurrentRecord = "select short from short_links where user_id=? and origin=?"
+
+// sqlSelectOrigin select origin
+const sqlSelectOrigin = `
+select origin, coalesce(is_deleted, false), expires_at from short_links where short=?
+`
+
+// sqlSelectRecord select link with owner by short
+const sqlSelectRecord = `
+select coalesce(user_id, ''), origin, coalesce(correlation_id, ''), created_at, expires_at, coalesce(is_deleted, false) 
+from short_links 
+where short=?
+`
+
+// sqlSelectOriginAndShort select origin and short
+const sqlSelectOriginAndShort = `
+select origin, short from short_links where user_id=?
+`
+
+// sqlSelectLinks user links by status, other filters are applied in memory. Expired links are deleted
+const sqlSelectLinks = `
+select short, origin, coalesce(correlation_id, ''), created_at, expires_at, coalesce(is_deleted, false) 
+from short_links 
+where user_id=? 
+and (? = 'all' or (coalesce(is_deleted, false) or coalesce(expires_at <= ?, false)) = (? = 'deleted'))
+`
+
+// sqlUpdate for set delete flag, placeholders of ids are added by count
+const sqlUpdate = `
+update short_links 
+set is_deleted=true, deleted_at=? 
+where user_id=? 
+and coalesce(is_deleted, false)=false 
+and (correlation_id in (%[1]s) or short in (%[1]s)) 
+returning short, coalesce(correlation_id, '')
+`
+
+// sqlUpdateExpired for set delete flag on expired links
+const sqlUpdateExpired = `
+update short_links 
+set is_deleted=true, deleted_at=? 
+where expires_at <= ? 
+and coalesce(is_deleted, false)=false
+`
+
+// sqlRestore remove delete flag from links deleted since time, placeholders of ids are added by count
+const sqlRestore = `
+update short_links 
+set is_deleted=false, deleted_at=null 
+where user_id=? 
+and is_deleted=true 
+and deleted_at >= ? 
+and (expires_at is null or expires_at > ?) 
+and (correlation_id in (%[1]s) or short in (%[1]s)) 
+returning short
+`
+
+// sqlPurge remove links deleted before time
+const sqlPurge = `
+delete from short_links where is_deleted=true and deleted_at < ?
+returning short
+`
+
+// sqlWalk chunk of all links after position
+const sqlWalk = `
+select coalesce(user_id, ''), short, origin, coalesce(correlation_id, ''), created_at, expires_at, coalesce(is_deleted, false) 
+from short_links 
+where (coalesce(user_id, ''), short) > (?, ?) 
+order by coalesce(user_id, ''), short 
+limit ?
+`
+
+// sqlUserWalk chunk of user links after creation time and short
+const sqlUserWalk = `
+select short, origin, coalesce(correlation_id, ''), created_at, expires_at, coalesce(is_deleted, false) 
+from short_links 
+where user_id=? 
+and (created_at, short) > (?, ?) 
+order by created_at, short 
+limit ?
+`
+
+// sqlImportRecord for imported link with all fields
+const sqlImportRecord = `
+insert into short_links (user_id, origin, short, correlation_id, is_deleted, created_at, expires_at, deleted_at) 
+values (?, ?, ?, nullif(?, ''), ?, ?, ?, ?)
+on conflict do nothing
+`
+
+// sqlSelectOwner owner and origin of short
+const sqlSelectOwner = `
+select coalesce(user_id, ''), origin from short_links where short=?
+`
+
+// walkChunk count of links selected by one query of walk
+const walkChunk = 1000
+
+// sqlSelectCounter max persisted value of counter
+const sqlSelectCounter = `select value from counters where name = ?`
+
+// sqlMarkCounter persist value of counter if it is bigger than stored one
+const sqlMarkCounter = `
+insert into counters (name, value) values (?, ?)
+on conflict (name) do update set value = max(value, excluded.value)
+`
+
+// counterShortCodes name of sequence short codes counter
+const counterShortCodes = "short_codes"
+
+// sqlURLCount count of links
+const sqlURLCount = `select count(*) from short_links`
+
+// sqlUserCount count of users
+const sqlUserCount = `select count(distinct user_id) from short_links`
+
+// New open SQLite database on path and apply migrations
+func New(path string, l *zap.Logger, opts ...Option) (*Storage, error) {
+	dsn := fmt.Sprintf("file:%s?_pragma=busy_timeout(%d)&_pragma=journal_mode(wal)", path, busyTimeout)
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite has one writer, so connections would wait each other on locks
+	db.SetMaxOpenConns(1)
+
+	goose.SetBaseFS(migrations.EmbedMigrations)
+	if err = goose.SetDialect("sqlite3"); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	if err = goose.Up(db, migrations.SQLiteDir); err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	s := &Storage{db: db, l: l, alloc: shortcode.Default()}
+	for _, opt := range opts {
+		opt(s)
+	}
+	// Continue codes counter after last persisted value
+	if c := s.alloc.Counter(); c != nil {
+		var value int64
+		err = db.QueryRow(sqlSelectCounter, counterShortCodes).Scan(&value)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			_ = db.Close()
+			return nil, err
+		}
+		c.Seed(uint64(value))
+	}
+	return s, nil
+}
+
+// DB connection of storage
+func (s *Storage) DB() *sql.DB {
+	return s.db
+}
+
+// LinkByShort get origin by short
+func (s *Storage) LinkByShort(ctx context.Context, short shortlink.Short) (string, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Read)
+	defer cancel()
+
+	var origin string
+	var gone bool
+	var expiresAt sql.NullInt64
+
+	err := s.db.QueryRowContext(ctx, sqlSelectOrigin, string(short)).Scan(&origin, &gone, &expiresAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", er.ErrURLNotFound
+	}
+	if err != nil {
+		return "", contextError(ctx, err)
+	}
+	if gone {
+		return "", er.ErrURLIsGone
+	}
+	if expiresAt.Valid && time.Now().UnixNano() >= expiresAt.Int64 {
+		return "", er.ErrURLExpired
+	}
+	return origin, nil
+}
+
+// RecordByShort get link with owner by short
+func (s *Storage) RecordByShort(ctx context.Context, short shortlink.Short) (shortlink.Record, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Read)
+	defer cancel()
+
+	rec := shortlink.Record{Link: shortlink.Link{Short: short}}
+	var createdAt int64
+	var expiresAt sql.NullInt64
+
+	err := s.db.QueryRowContext(ctx, sqlSelectRecord, string(short)).
+		Scan(&rec.UserID, &rec.Origin, &rec.CorrelationID, &createdAt, &expiresAt, &rec.Deleted)
+	if errors.Is(err, sql.ErrNoRows) {
+		return shortlink.Record{}, er.ErrURLNotFound
+	}
+	if err != nil {
+		return shortlink.Record{}, contextError(ctx, err)
+	}
+	rec.CreatedAt = time.Unix(0, createdAt).UTC()
+	if expiresAt.Valid {
+		rec.ExpiresAt = time.Unix(0, expiresAt.Int64).UTC()
+	}
+	return rec, nil
+}
+
+// LinksByUser return all user links
+func (s *Storage) LinksByUser(ctx context.Context, userID user.UniqUser) (shortlink.ShortLinks, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Read)
+	defer cancel()
+
+	origins := shortlink.ShortLinks{}
+	rows, err := s.db.QueryContext(ctx, sqlSelectOriginAndShort, string(userID))
+	if err != nil {
+		return origins, contextError(ctx, err)
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	for rows.Next() {
+		var origin, short string
+		if err = rows.Scan(&origin, &short); err != nil {
+			return origins, contextError(ctx, err)
+		}
+		origins[shortlink.Short(short)] = origin
+	}
+	if err = rows.Err(); err != nil {
+		return origins, contextError(ctx, err)
+	}
+	return origins, nil
+}
+
+// LinksPage return page of user links by filters
+func (s *Storage) LinksPage(ctx context.Context, userID user.UniqUser, q shortlink.Query) (shortlink.Page, error) {
+	q, err := q.Normalize()
+	if err != nil {
+		return shortlink.Page{}, err
+	}
+	ctx, cancel := withTimeout(ctx, s.timeouts.Read)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, sqlSelectLinks, string(userID), q.Status, q.Now.UnixNano(), q.Status)
+	if err != nil {
+		return shortlink.Page{}, contextError(ctx, err)
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	var links []shortlink.Link
+	for rows.Next() {
+		l, err := scanLink(rows)
+		if err != nil {
+			return shortlink.Page{}, contextError(ctx, err)
+		}
+		links = append(links, l)
+	}
+	if err = rows.Err(); err != nil {
+		return shortlink.Page{}, contextError(ctx, err)
+	}
+	return shortlink.Paginate(links, q), nil
+}
+
+// Save url in storage of short links
+func (s *Storage) Save(ctx context.Context, userID user.UniqUser, origin string, opts shortlink.Options) (shortlink.Short, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Write)
+	defer cancel()
+
+	insert := func(short shortlink.Short) error {
+		_, err := s.db.ExecContext(ctx, sqlNewRecord, string(userID), origin, string(short), nil, nullTime(opts.ExpiresAt), time.Now().UnixNano())
+		return conflictError(err)
+	}
+	var short shortlink.Short
+	var err error
+	if opts.Alias != "" {
+		short = shortlink.Short(opts.Alias)
+		if err = insert(short); errors.Is(err, shortcode.ErrCollision) {
+			return "", er.ErrAliasTaken
+		}
+	} else {
+		short, err = s.alloc.Allocate(origin, insert)
+		if err == nil {
+			err = s.markCounter(ctx, s.db)
+		}
+	}
+	if errors.Is(err, er.ErrAlreadyHasShort) {
+		// take current link
+		var current string
+		_ = s.db.QueryRowContext(ctx, sqlGetCurrentRecord, string(userID), origin).Scan(&current)
+		return shortlink.Short(current), er.ErrAlreadyHasShort
+	}
+	if err != nil {
+		return short, contextError(ctx, err)
+	}
+	return short, nil
+}
+
+// BunchSave save mass urls in one transaction
+func (s *Storage) BunchSave(ctx context.Context, userID user.UniqUser, urls []shortlink.URLs, opts shortlink.BatchOptions) ([]shortlink.ShortURLs, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Batch)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	results := make([]shortlink.ShortURLs, len(urls))
+	now := time.Now().UnixNano()
+	for k, v := range urls {
+		var expiresAt sql.NullInt64
+		if v.ExpiresAt != nil {
+			expiresAt = nullTime(*v.ExpiresAt)
+		}
+		insert := func(short shortlink.Short) error {
+			_, err := tx.ExecContext(ctx, sqlNewRecord, string(userID), v.Origin, string(short), v.ID, expiresAt, now)
+			return conflictError(err)
+		}
+		var short shortlink.Short
+		if v.Alias != "" {
+			short = shortlink.Short(v.Alias)
+			if err = insert(short); errors.Is(err, shortcode.ErrCollision) {
+				err = fmt.Errorf("%w: %s", er.ErrAliasTaken, v.Alias)
+			}
+		} else {
+			short, err = s.alloc.Allocate(v.Origin, insert)
+		}
+		switch {
+		case err == nil:
+			results[k] = shortlink.Created(v.ID, short)
+		case errors.Is(err, er.ErrAlreadyHasShort):
+			var current string
+			if err = tx.QueryRowContext(ctx, sqlGetCurrentRecord, string(userID), v.Origin).Scan(&current); err != nil {
+				return nil, contextError(ctx, err)
+			}
+			results[k] = shortlink.Existing(v.ID, shortlink.Short(current))
+		case errors.Is(err, er.ErrAliasTaken), errors.Is(err, shortcode.ErrAttemptsExhausted):
+			results[k] = shortlink.Invalid(v.ID, err)
+		default:
+			return nil, contextError(ctx, err)
+		}
+	}
+	if opts.Atomic && shortlink.HasInvalid(results) {
+		shortlink.Abort(results)
+		return results, er.ErrBatchAborted
+	}
+	if err = s.markCounter(ctx, tx); err != nil {
+		return nil, contextError(ctx, err)
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return results, nil
+}
+
+// execer database or transaction
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// markCounter persist value of codes counter, so restart don't repeat persisted codes
+func (s *Storage) markCounter(ctx context.Context, db execer) error {
+	c := s.alloc.Counter()
+	if c == nil {
+		return nil
+	}
+	_, err := db.ExecContext(ctx, sqlMarkCounter, counterShortCodes, int64(c.Value()))
+	return err
+}
+
+// Clear links table
+func (s *Storage) Clear(ctx context.Context) error {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Batch)
+	defer cancel()
+
+	if _, err := s.db.ExecContext(ctx, sqlDeleteRecords); err != nil {
+		return contextError(ctx, err)
+	}
+	return nil
+}
+
+// BunchUpdateAsDeleted update as deleted by correlation ids or shorts
+func (s *Storage) BunchUpdateAsDeleted(ctx context.Context, ids []string, userID string) ([]shortlink.Link, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	ctx, cancel := withTimeout(ctx, s.timeouts.Batch)
+	defer cancel()
+
+	placeholders, args := idsArgs(ids, time.Now().UnixNano(), userID)
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(sqlUpdate, placeholders), args...)
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	defer rows.Close()
+
+	var links []shortlink.Link
+	for rows.Next() {
+		var l shortlink.Link
+		if err = rows.Scan(&l.Short, &l.CorrelationID); err != nil {
+			return nil, err
+		}
+		links = append(links, l)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return links, nil
+}
+
+// UpdateExpiredAsDeleted set delete flag for links expired before now
+func (s *Storage) UpdateExpiredAsDeleted(ctx context.Context, now time.Time) (int, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Batch)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, sqlUpdateExpired, now.UnixNano(), now.UnixNano())
+	if err != nil {
+		return 0, contextError(ctx, err)
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(updated), nil
+}
+
+// Restore remove delete flag from user links by correlation ids or shorts, which were deleted since time
+func (s *Storage) Restore(ctx context.Context, ids []string, userID string, since time.Time) ([]shortlink.Short, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	ctx, cancel := withTimeout(ctx, s.timeouts.Batch)
+	defer cancel()
+
+	placeholders, args := idsArgs(ids, userID, since.UnixNano(), time.Now().UnixNano())
+	return s.queryShorts(ctx, fmt.Sprintf(sqlRestore, placeholders), args...)
+}
+
+// queryShorts run query returning shorts of changed links
+func (s *Storage) queryShorts(ctx context.Context, query string, args ...interface{}) ([]shortlink.Short, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	defer rows.Close()
+
+	var shorts []shortlink.Short
+	for rows.Next() {
+		var short shortlink.Short
+		if err = rows.Scan(&short); err != nil {
+			return nil, err
+		}
+		shorts = append(shorts, short)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return shorts, nil
+}
+
+// Purge remove links deleted before time
+func (s *Storage) Purge(ctx context.Context, before time.Time) ([]shortlink.Short, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Batch)
+	defer cancel()
+
+	return s.queryShorts(ctx, sqlPurge, before.UnixNano())
+}
+
+// URLCount get saved url in storage
+func (s *Storage) URLCount(ctx context.Context) (counter int, err error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Read)
+	defer cancel()
+
+	if err = s.db.QueryRowContext(ctx, sqlURLCount).Scan(&counter); err != nil {
+		return 0, contextError(ctx, err)
+	}
+	return counter, nil
+}
+
+// UserCount get uniq users count in storage
+func (s *Storage) UserCount(ctx context.Context) (counter int, err error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Read)
+	defer cancel()
+
+	if err = s.db.QueryRowContext(ctx, sqlUserCount).Scan(&counter); err != nil {
+		return 0, contextError(ctx, err)
+	}
+	return counter, nil
+}
+
+// WalkUser call fn for every link of user in order of creation.
+// Links are selected by chunks, so connection is free while fn works
+func (s *Storage) WalkUser(ctx context.Context, userID user.UniqUser, fn func(l shortlink.Link) error) error {
+	after, afterShort := int64(math.MinInt64), ""
+	for {
+		links, err := s.userChunk(ctx, userID, after, afterShort)
+		if err != nil {
+			return err
+		}
+		for _, l := range links {
+			if err = fn(l); err != nil {
+				return err
+			}
+		}
+		if len(links) < walkChunk {
+			return nil
+		}
+		last := links[len(links)-1]
+		after, afterShort = last.CreatedAt.UnixNano(), string(last.Short)
+	}
+}
+
+// userChunk select chunk of user links after creation time and short
+func (s *Storage) userChunk(ctx context.Context, userID user.UniqUser, after int64, afterShort string) ([]shortlink.Link, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Batch)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, sqlUserWalk, string(userID), after, afterShort, walkChunk)
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	links := make([]shortlink.Link, 0, walkChunk)
+	for rows.Next() {
+		l, err := scanLink(rows)
+		if err != nil {
+			return nil, contextError(ctx, err)
+		}
+		links = append(links, l)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return links, nil
+}
+
+// Walk call fn for every link in order of user and short after position.
+// Links are selected by chunks, so connection is free while fn works
+func (s *Storage) Walk(ctx context.Context, after shortlink.Position, fn func(rec shortlink.Record) error) error {
+	for {
+		recs, err := s.walkChunk(ctx, after)
+		if err != nil {
+			return err
+		}
+		for _, rec := range recs {
+			if err = fn(rec); err != nil {
+				return err
+			}
+		}
+		if len(recs) < walkChunk {
+			return nil
+		}
+		after = recs[len(recs)-1].Position()
+	}
+}
+
+// walkChunk select chunk of links after position
+func (s *Storage) walkChunk(ctx context.Context, after shortlink.Position) ([]shortlink.Record, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Batch)
+	defer cancel()
+
+	rows, err := s.db.QueryContext(ctx, sqlWalk, string(after.UserID), string(after.Short), walkChunk)
+	if err != nil {
+		return nil, contextError(ctx, err)
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	recs := make([]shortlink.Record, 0, walkChunk)
+	for rows.Next() {
+		var rec shortlink.Record
+		var createdAt int64
+		var expiresAt sql.NullInt64
+		err = rows.Scan(&rec.UserID, &rec.Short, &rec.Origin, &rec.CorrelationID, &createdAt, &expiresAt, &rec.Deleted)
+		if err != nil {
+			return nil, contextError(ctx, err)
+		}
+		rec.CreatedAt = time.Unix(0, createdAt).UTC()
+		if expiresAt.Valid {
+			rec.ExpiresAt = time.Unix(0, expiresAt.Int64).UTC()
+		}
+		recs = append(recs, rec)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, contextError(ctx, err)
+	}
+	return recs, nil
+}
+
+// Import save records with their shorts in one transaction.
+// Existing records are skipped, conflicts rollback whole batch
+func (s *Storage) Import(ctx context.Context, recs []shortlink.Record) (int, error) {
+	ctx, cancel := withTimeout(ctx, s.timeouts.Batch)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, contextError(ctx, err)
+	}
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	imported := 0
+	now := time.Now()
+	for _, rec := range recs {
+		// Retention of imported deleted links starts now
+		var deletedAt sql.NullInt64
+		if rec.Deleted {
+			deletedAt = nullTime(now)
+		}
+		res, err := tx.ExecContext(ctx, sqlImportRecord, string(rec.UserID), rec.Origin, string(rec.Short), rec.CorrelationID,
+			rec.Deleted, rec.CreatedAt.UnixNano(), nullTime(rec.ExpiresAt), deletedAt)
+		if err != nil {
+			return 0, contextError(ctx, err)
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			imported++
+			continue
+		}
+		// Nothing inserted: same link is already imported or conflict
+		var owner, origin string
+		err = tx.QueryRowContext(ctx, sqlSelectOwner, string(rec.Short)).Scan(&owner, &origin)
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, fmt.Errorf("%w: user %s already has %s", er.ErrImportConflict, rec.UserID, rec.Origin)
+		}
+		if err != nil {
+			return 0, contextError(ctx, err)
+		}
+		if owner != string(rec.UserID) || origin != rec.Origin {
+			return 0, fmt.Errorf("%w: short %s", er.ErrImportConflict, rec.Short)
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, contextError(ctx, err)
+	}
+	return imported, nil
+}
+
+// Close database
+func (s *Storage) Close() error {
+	return s.db.Close()
+}
+
+// scanLink scan row of link with times in unix nanoseconds
+func scanLink(rows *sql.Rows) (shortlink.Link, error) {
+	var l shortlink.Link
+	var createdAt int64
+	var expiresAt sql.NullInt64
+	if err := rows.Scan(&l.Short, &l.Origin, &l.CorrelationID, &createdAt, &expiresAt, &l.Deleted); err != nil {
+		return l, err
+	}
+	l.CreatedAt = time.Unix(0, createdAt).UTC()
+	if expiresAt.Valid {
+		l.ExpiresAt = time.Unix(0, expiresAt.Int64).UTC()
+	}
+	return l, nil
+}
+
+// withTimeout limit context by timeout if it set
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// contextError return context error if query was interrupted by deadline or cancel
+func contextError(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+// nullTime convert expiration time to unix nanoseconds, zero time is null
+func nullTime(t time.Time) sql.NullInt64 {
+	return sql.NullInt64{Int64: t.UnixNano(), Valid: !t.IsZero()}
+}
+
+// conflictError convert unique violations to collision of short or existing origin of user
+// like PostgreSQL storage. SQLite error contain columns of violated index
+func conflictError(err error) error {
+	var sqlErr *sqlite.Error
+	if errors.As(err, &sqlErr) && sqlErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE {
+		if strings.Contains(sqlErr.Error(), "short_links.short") {
+			return shortcode.ErrCollision
+		}
+		return er.ErrAlreadyHasShort
+	}
+	return err
+}
+
+// idsArgs placeholders of ids for query and args with ids after leading args. Ids are added twice
+// for match by correlation id or short
+func idsArgs(ids []string, leading ...interface{}) (string, []interface{}) {
+	placeholders := strings.TrimSuffix(strings.Repeat("?,", len(ids)), ",")
+	args := make([]interface{}, 0, 2*len(ids)+len(leading))
+	args = append(args, leading...)
+	for i := 0; i < 2; i++ {
+		for _, id := range ids {
+			args = append(args, id)
+		}
+	}
+	return placeholders, args
+}
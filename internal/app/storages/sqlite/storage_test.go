@@ -0,0 +1,362 @@
+package sqlite
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	er "github.com/triumphpc/go-musthave-shortener-tpl/internal/app/errors"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/helpers/shortcode"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/click"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/shortlink"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/user"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/clicks"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/repository/repositorytest"
+)
+
+func newStorage(t *testing.T) (*Storage, string) {
+	path := filepath.Join(t.TempDir(), "links.sqlite")
+	s, err := New(path, zap.NewNop())
+	require.NoError(t, err)
+	return s, path
+}
+
+func TestStorage_Save(t *testing.T) {
+	ctx := context.Background()
+	s, path := newStorage(t)
+
+	short, err := s.Save(ctx, "user", "http://test.ru", shortlink.Options{})
+	require.NoError(t, err)
+	existing, err := s.Save(ctx, "user", "http://test.ru", shortlink.Options{})
+	assert.ErrorIs(t, err, er.ErrAlreadyHasShort)
+	assert.Equal(t, short, existing)
+
+	_, err = s.Save(ctx, "user", "http://alias.ru", shortlink.Options{Alias: "my-alias"})
+	require.NoError(t, err)
+	_, err = s.Save(ctx, "other", "http://alias.ru", shortlink.Options{Alias: "my-alias"})
+	assert.ErrorIs(t, err, er.ErrAliasTaken)
+	require.NoError(t, s.Close())
+
+	// Data is kept after reopen
+	s, err = New(path, zap.NewNop())
+	require.NoError(t, err)
+	defer s.Close()
+
+	origin, err := s.LinkByShort(ctx, short)
+	require.NoError(t, err)
+	assert.Equal(t, "http://test.ru", origin)
+	_, err = s.LinkByShort(ctx, "unknown")
+	assert.ErrorIs(t, err, er.ErrURLNotFound)
+	repositorytest.AssertCount(t, 2, s.URLCount)
+	repositorytest.AssertCount(t, 1, s.UserCount)
+}
+
+func TestStorage_BunchSave(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newStorage(t)
+	defer s.Close()
+
+	exists, err := s.Save(ctx, "user", "http://exists.ru", shortlink.Options{})
+	require.NoError(t, err)
+
+	shorts, err := s.BunchSave(ctx, "user", []shortlink.URLs{
+		{ID: "1", Origin: "http://one.ru"},
+		{ID: "2", Origin: "http://exists.ru"},
+		{ID: "3", Origin: "http://three.ru", Alias: "three"},
+	}, shortlink.BatchOptions{})
+	require.NoError(t, err)
+	require.Len(t, shorts, 3)
+	assert.Equal(t, shortlink.ItemCreated, shorts[0].Status)
+	assert.Equal(t, shortlink.Existing("2", exists), shorts[1])
+	assert.Equal(t, shortlink.Created("3", "three"), shorts[2])
+
+	// Taken alias is invalid item, other items are saved
+	shorts, err = s.BunchSave(ctx, "user", []shortlink.URLs{
+		{ID: "4", Origin: "http://four.ru"},
+		{ID: "5", Origin: "http://five.ru", Alias: "three"},
+	}, shortlink.BatchOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, shortlink.ItemCreated, shorts[0].Status)
+	assert.Equal(t, shortlink.ItemInvalid, shorts[1].Status)
+	assert.NotEmpty(t, shorts[1].Reason)
+	repositorytest.AssertCount(t, 4, s.URLCount)
+
+	// Taken alias rollback whole atomic batch
+	shorts, err = s.BunchSave(ctx, "user", []shortlink.URLs{
+		{ID: "6", Origin: "http://six.ru"},
+		{ID: "7", Origin: "http://seven.ru", Alias: "three"},
+	}, shortlink.BatchOptions{Atomic: true})
+	assert.ErrorIs(t, err, er.ErrBatchAborted)
+	require.Len(t, shorts, 2)
+	assert.Equal(t, shortlink.ShortURLs{ID: "6", Status: shortlink.ItemAborted}, shorts[0])
+	assert.Equal(t, shortlink.ItemInvalid, shorts[1].Status)
+	repositorytest.AssertCount(t, 4, s.URLCount)
+}
+
+func TestStorage_BunchUpdateAsDeleted(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newStorage(t)
+	defer s.Close()
+
+	shorts, err := s.BunchSave(ctx, "user", []shortlink.URLs{
+		{ID: "1", Origin: "http://one.ru"},
+		{ID: "2", Origin: "http://two.ru"},
+		{ID: "3", Origin: "http://three.ru"},
+	}, shortlink.BatchOptions{})
+	require.NoError(t, err)
+
+	// Other user can't delete
+	updated, err := s.BunchUpdateAsDeleted(ctx, []string{"1"}, "other")
+	require.NoError(t, err)
+	assert.Empty(t, updated)
+	updated, err = s.BunchUpdateAsDeleted(ctx, []string{"1", shorts[1].Short}, "user")
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []shortlink.Link{
+		{Short: shortlink.Short(shorts[0].Short), CorrelationID: "1"},
+		{Short: shortlink.Short(shorts[1].Short), CorrelationID: "2"},
+	}, updated)
+
+	for _, v := range shorts[:2] {
+		_, err = s.LinkByShort(ctx, shortlink.Short(v.Short))
+		assert.ErrorIs(t, err, er.ErrURLIsGone)
+	}
+	page, err := s.LinksPage(ctx, "user", shortlink.Query{})
+	require.NoError(t, err)
+	assert.Equal(t, 1, page.Total)
+	assert.Equal(t, shortlink.Short(shorts[2].Short), page.Links[0].Short)
+}
+
+func TestStorage_UpdateExpiredAsDeleted(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newStorage(t)
+	defer s.Close()
+
+	now := time.Now()
+	expired, err := s.Save(ctx, "user", "http://expired.ru", shortlink.Options{ExpiresAt: now.Add(-time.Second)})
+	require.NoError(t, err)
+	_, err = s.Save(ctx, "user", "http://alive.ru", shortlink.Options{ExpiresAt: now.Add(time.Hour)})
+	require.NoError(t, err)
+
+	_, err = s.LinkByShort(ctx, expired)
+	assert.ErrorIs(t, err, er.ErrURLExpired)
+
+	updated, err := s.UpdateExpiredAsDeleted(ctx, now)
+	require.NoError(t, err)
+	assert.Equal(t, 1, updated)
+	_, err = s.LinkByShort(ctx, expired)
+	assert.ErrorIs(t, err, er.ErrURLIsGone)
+
+	require.NoError(t, s.Clear(ctx))
+	repositorytest.AssertCount(t, 0, s.URLCount)
+}
+
+func TestStorage_LinksPage(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newStorage(t)
+	defer s.Close()
+
+	_, err := s.BunchSave(ctx, "user", []shortlink.URLs{
+		{ID: "1", Origin: "http://one.ru/a"},
+		{ID: "2", Origin: "http://two.ru/b"},
+		{ID: "3", Origin: "http://sub.one.ru/c"},
+	}, shortlink.BatchOptions{})
+	require.NoError(t, err)
+	_, err = s.BunchUpdateAsDeleted(ctx, []string{"2"}, "user")
+	require.NoError(t, err)
+
+	page, err := s.LinksPage(ctx, "user", shortlink.Query{Status: shortlink.StatusDeleted})
+	require.NoError(t, err)
+	require.Equal(t, 1, page.Total)
+	assert.True(t, page.Links[0].Deleted)
+	assert.Equal(t, "2", page.Links[0].CorrelationID)
+
+	page, err = s.LinksPage(ctx, "user", shortlink.Query{Domain: "one.ru", Limit: 1})
+	require.NoError(t, err)
+	assert.Equal(t, 2, page.Total)
+	assert.Len(t, page.Links, 1)
+	assert.NotEmpty(t, page.NextCursor)
+
+	// Expired link isn't active before reaper marks it
+	_, err = s.Save(ctx, "user", "http://expired.ru", shortlink.Options{ExpiresAt: time.Now().Add(-time.Second)})
+	require.NoError(t, err)
+	page, err = s.LinksPage(ctx, "user", shortlink.Query{})
+	require.NoError(t, err)
+	assert.Equal(t, 2, page.Total)
+	page, err = s.LinksPage(ctx, "user", shortlink.Query{Status: shortlink.StatusDeleted})
+	require.NoError(t, err)
+	assert.Equal(t, 2, page.Total)
+}
+
+func TestStorage_Clicks(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newStorage(t)
+	defer s.Close()
+
+	day := time.Date(2026, 10, 18, 23, 0, 0, 0, time.UTC)
+	store := clicks.NewSQLiteStore(s.DB(), time.Second)
+	require.NoError(t, store.Save(ctx, []click.Click{
+		{At: day.AddDate(0, 0, 1), Short: "short"},
+		{At: day, Short: "short"},
+		{At: day, Short: "other"},
+	}))
+
+	days, err := store.Daily(ctx, "short")
+	require.NoError(t, err)
+	assert.Equal(t, []click.DailyCount{{Day: "2026-10-18", Count: 1}, {Day: "2026-10-19", Count: 1}}, days)
+
+	// Clicks of purged links are deleted
+	require.NoError(t, store.Delete(ctx, []shortlink.Short{"short"}))
+	days, err = store.Daily(ctx, "short")
+	require.NoError(t, err)
+	assert.Empty(t, days)
+	days, err = store.Daily(ctx, "other")
+	require.NoError(t, err)
+	assert.Len(t, days, 1)
+}
+
+func TestStorage_RestorePurge(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newStorage(t)
+	defer s.Close()
+	shorts, err := s.BunchSave(ctx, "user", []shortlink.URLs{
+		{ID: "1", Origin: "http://one.ru"},
+		{ID: "2", Origin: "http://two.ru"},
+	}, shortlink.BatchOptions{})
+	require.NoError(t, err)
+	_, err = s.BunchUpdateAsDeleted(ctx, []string{"1", "2"}, "user")
+	require.NoError(t, err)
+
+	now := time.Now()
+	// Links of other user and deleted before grace period are kept deleted
+	restored, err := s.Restore(ctx, []string{"1"}, "other", now.Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Empty(t, restored)
+	restored, err = s.Restore(ctx, []string{"1"}, "user", now.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Empty(t, restored)
+
+	restored, err = s.Restore(ctx, []string{"1"}, "user", now.Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Len(t, restored, 1)
+	origin, err := s.LinkByShort(ctx, shortlink.Short(shorts[0].Short))
+	require.NoError(t, err)
+	assert.Equal(t, "http://one.ru", origin)
+
+	// Only deletions before time are purged
+	purged, err := s.Purge(ctx, now.Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Empty(t, purged)
+	purged, err = s.Purge(ctx, time.Now().Add(time.Second))
+	require.NoError(t, err)
+	assert.Len(t, purged, 1)
+	_, err = s.LinkByShort(ctx, shortlink.Short(shorts[1].Short))
+	assert.ErrorIs(t, err, er.ErrURLNotFound)
+	repositorytest.AssertCount(t, 1, s.URLCount)
+}
+
+func TestStorage_Counter(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "links.sqlite")
+	open := func() *Storage {
+		alloc := shortcode.New(shortcode.NewSequence(shortcode.Base62, 0), shortcode.WithAttempts(1))
+		s, err := New(path, zap.NewNop(), WithAllocator(alloc))
+		require.NoError(t, err)
+		return s
+	}
+
+	s := open()
+	first, err := s.Save(ctx, "user", "http://one.ru", shortlink.Options{})
+	require.NoError(t, err)
+	// Purged code is not in storage, but counter still don't repeat it
+	_, err = s.BunchUpdateAsDeleted(ctx, []string{string(first)}, "user")
+	require.NoError(t, err)
+	purged, err := s.Purge(ctx, time.Now().Add(time.Second))
+	require.NoError(t, err)
+	require.Len(t, purged, 1)
+	require.NoError(t, s.Close())
+
+	s = open()
+	results, err := s.BunchSave(ctx, "user", []shortlink.URLs{{ID: "1", Origin: "http://two.ru"}}, shortlink.BatchOptions{})
+	require.NoError(t, err)
+	require.NoError(t, s.Close())
+	second := shortlink.Short(results[0].Short)
+
+	s = open()
+	defer s.Close()
+	third, err := s.Save(ctx, "user", "http://three.ru", shortlink.Options{})
+	require.NoError(t, err)
+	assert.NotEqual(t, first, second)
+	assert.NotEqual(t, first, third)
+	assert.NotEqual(t, second, third)
+}
+
+func TestStorage_WalkImport(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newStorage(t)
+	defer s.Close()
+
+	created := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	recs := []shortlink.Record{
+		{UserID: "b", Link: shortlink.Link{Short: "b1", Origin: "http://one.ru", CorrelationID: "1", CreatedAt: created}},
+		{UserID: "a", Link: shortlink.Link{Short: "a2", Origin: "http://two.ru", CreatedAt: created, Deleted: true}},
+		{UserID: "a", Link: shortlink.Link{Short: "a1", Origin: "http://one.ru", CreatedAt: created, ExpiresAt: created.Add(time.Hour)}},
+	}
+	imported, err := s.Import(ctx, recs)
+	require.NoError(t, err)
+	assert.Equal(t, 3, imported)
+
+	// Same records are skipped, conflicts rollback batch
+	imported, err = s.Import(ctx, recs[:1])
+	require.NoError(t, err)
+	assert.Equal(t, 0, imported)
+	_, err = s.Import(ctx, []shortlink.Record{
+		{UserID: "c", Link: shortlink.Link{Short: "c1", Origin: "http://one.ru", CreatedAt: created}},
+		{UserID: "c", Link: shortlink.Link{Short: "b1", Origin: "http://two.ru", CreatedAt: created}},
+	})
+	assert.ErrorIs(t, err, er.ErrImportConflict)
+	repositorytest.AssertCount(t, 3, s.URLCount)
+
+	var walked []shortlink.Record
+	require.NoError(t, s.Walk(ctx, shortlink.Position{}, func(rec shortlink.Record) error {
+		walked = append(walked, rec)
+		return nil
+	}))
+	assert.Equal(t, []shortlink.Record{recs[2], recs[1], recs[0]}, walked)
+
+	// Walk after position
+	walked = walked[:0]
+	require.NoError(t, s.Walk(ctx, recs[2].Position(), func(rec shortlink.Record) error {
+		walked = append(walked, rec)
+		return nil
+	}))
+	assert.Equal(t, []shortlink.Record{recs[1], recs[0]}, walked)
+}
+
+func TestStorage_RecordByShort(t *testing.T) {
+	ctx := context.Background()
+	s, _ := newStorage(t)
+	defer s.Close()
+
+	expiresAt := time.Now().Add(-time.Second).UTC().Truncate(time.Millisecond)
+	short, err := s.Save(ctx, "user", "http://expired.ru", shortlink.Options{ExpiresAt: expiresAt})
+	require.NoError(t, err)
+	_, err = s.BunchUpdateAsDeleted(ctx, []string{string(short)}, "user")
+	require.NoError(t, err)
+
+	// Deleted and expired link is found with owner
+	rec, err := s.RecordByShort(ctx, short)
+	require.NoError(t, err)
+	assert.Equal(t, user.UniqUser("user"), rec.UserID)
+	assert.Equal(t, short, rec.Short)
+	assert.Equal(t, "http://expired.ru", rec.Origin)
+	assert.True(t, rec.Deleted)
+	assert.True(t, expiresAt.Equal(rec.ExpiresAt))
+
+	_, err = s.RecordByShort(ctx, "unknown")
+	assert.ErrorIs(t, err, er.ErrURLNotFound)
+}
@@ -0,0 +1,241 @@
+package tasks
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// sqlPushTask for new task
+const sqlPushTask = `
+insert into storage.tasks (id, type, user_id, payload) 
+values ($1, $2, $3, $4)
+`
+
+// sqlClaimTask lease oldest available task of types, locked tasks are skipped by other instances
+const sqlClaimTask = `
+update storage.tasks 
+set leased_until=now() + make_interval(secs => $1), attempts=attempts+1 
+where id = (
+	select id from storage.tasks 
+	where (leased_until is null or leased_until < now()) and type = any($2) 
+	order by created_at 
+	limit 1 
+	for update skip locked
+) 
+returning id, type, user_id, payload, attempts, coalesce(last_error, ''), leased_until
+`
+
+// sqlCountTasks of type
+const sqlCountTasks = `
+select count(*) from storage.tasks where type=$1
+`
+
+// sqlReadyTasks count tasks of type available for claim
+const sqlReadyTasks = `
+select count(*) from storage.tasks where type=$1 and (leased_until is null or leased_until < now())
+`
+
+// sqlAckTask remove done task of lease
+const sqlAckTask = `
+delete from storage.tasks where id=$1 and leased_until is not distinct from $2
+`
+
+// sqlRetryTask make task of lease available from time
+const sqlRetryTask = `
+update storage.tasks 
+set leased_until=$2, last_error=$3 
+where id=$1 and leased_until is not distinct from $4
+`
+
+// sqlFailTask move task of lease to dead letters
+const sqlFailTask = `
+with failed as (
+	delete from storage.tasks where id=$1 and leased_until is not distinct from $3 
+	returning id, type, user_id, payload, attempts
+) 
+insert into storage.dead_letters (id, type, user_id, payload, attempts, error, failed_at) 
+select id, type, user_id, payload, attempts, $2, now() from failed
+`
+
+// sqlPruneDeadLetters drop letters failed before time and oldest letters over max count
+const sqlPruneDeadLetters = `
+delete from storage.dead_letters 
+where failed_at < $1 
+or ($2 > 0 and id in (select id from storage.dead_letters order by failed_at desc offset greatest($2, 0)))
+`
+
+// sqlDeadLetters last dead letters
+const sqlDeadLetters = `
+select id, type, user_id, payload, attempts, error, failed_at 
+from storage.dead_letters 
+order by failed_at desc 
+limit $1
+`
+
+// PostgreSQLQueue queue of tasks in storage.tasks table.
+// Table is created by migrations of links storage
+type PostgreSQLQueue struct {
+	db      *sql.DB
+	timeout time.Duration
+	// Limits of dead letters
+	maxDead       int
+	deadRetention time.Duration
+}
+
+// Option configure queue in database
+type Option func(q *PostgreSQLQueue)
+
+// WithDeadLetterLimits set max count and retention of dead letters in database like WithDeadLetters
+// of file queue. Not positive value disable its limit
+func WithDeadLetterLimits(max int, retention time.Duration) Option {
+	return func(q *PostgreSQLQueue) {
+		q.maxDead, q.deadRetention = max, retention
+	}
+}
+
+// NewPostgreSQLQueue queue on connection with timeout of queries. Zero timeout disable limit
+func NewPostgreSQLQueue(db *sql.DB, timeout time.Duration, opts ...Option) *PostgreSQLQueue {
+	q := &PostgreSQLQueue{db: db, timeout: timeout, maxDead: DefaultMaxDeadLetters, deadRetention: DefaultDeadLetterRetention}
+	for _, opt := range opts {
+		opt(q)
+	}
+	return q
+}
+
+// Push new task of type for user
+func (q *PostgreSQLQueue) Push(ctx context.Context, typ, userID string, payload json.RawMessage) (string, error) {
+	ctx, cancel := q.withTimeout(ctx)
+	defer cancel()
+
+	id := uuid.NewString()
+	if _, err := q.db.ExecContext(ctx, sqlPushTask, id, typ, userID, string(payload)); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// Claim oldest available task of types for lease time
+func (q *PostgreSQLQueue) Claim(ctx context.Context, lease time.Duration, types []string) (Task, bool, error) {
+	ctx, cancel := q.withTimeout(ctx)
+	defer cancel()
+
+	var t Task
+	err := q.db.QueryRowContext(ctx, sqlClaimTask, lease.Seconds(), pq.Array(types)).Scan(&t.ID, &t.Type, &t.UserID, &t.Payload, &t.Attempts, &t.Error, &t.Lease)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Task{}, false, nil
+	}
+	if err != nil {
+		return Task{}, false, err
+	}
+	return t, true, nil
+}
+
+// Len count of tasks of type
+func (q *PostgreSQLQueue) Len(ctx context.Context, typ string) (int, error) {
+	ctx, cancel := q.withTimeout(ctx)
+	defer cancel()
+
+	var n int
+	err := q.db.QueryRowContext(ctx, sqlCountTasks, typ).Scan(&n)
+	return n, err
+}
+
+// Ready count of tasks of type without lease or delay
+func (q *PostgreSQLQueue) Ready(ctx context.Context, typ string) (int, error) {
+	ctx, cancel := q.withTimeout(ctx)
+	defer cancel()
+
+	var n int
+	err := q.db.QueryRowContext(ctx, sqlReadyTasks, typ).Scan(&n)
+	return n, err
+}
+
+// Ack remove done task
+func (q *PostgreSQLQueue) Ack(ctx context.Context, t Task) error {
+	ctx, cancel := q.withTimeout(ctx)
+	defer cancel()
+
+	return leased(q.db.ExecContext(ctx, sqlAckTask, t.ID, nullLease(t.Lease)))
+}
+
+// Retry make failed task available from time
+func (q *PostgreSQLQueue) Retry(ctx context.Context, t Task, at time.Time, reason string) error {
+	ctx, cancel := q.withTimeout(ctx)
+	defer cancel()
+
+	return leased(q.db.ExecContext(ctx, sqlRetryTask, t.ID, at, reason, nullLease(t.Lease)))
+}
+
+// Fail move failed task to dead letters and drop letters over limits
+func (q *PostgreSQLQueue) Fail(ctx context.Context, t Task, reason string) error {
+	ctx, cancel := q.withTimeout(ctx)
+	defer cancel()
+
+	if err := leased(q.db.ExecContext(ctx, sqlFailTask, t.ID, reason, nullLease(t.Lease))); err != nil {
+		return err
+	}
+	var before sql.NullTime
+	if q.deadRetention > 0 {
+		before = sql.NullTime{Time: time.Now().Add(-q.deadRetention), Valid: true}
+	}
+	_, err := q.db.ExecContext(ctx, sqlPruneDeadLetters, before, q.maxDead)
+	return err
+}
+
+// DeadLetters get last dead letters
+func (q *PostgreSQLQueue) DeadLetters(ctx context.Context, limit int) ([]DeadLetter, error) {
+	ctx, cancel := q.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := q.db.QueryContext(ctx, sqlDeadLetters, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	letters := make([]DeadLetter, 0)
+	for rows.Next() {
+		var d DeadLetter
+		if err = rows.Scan(&d.ID, &d.Type, &d.UserID, &d.Payload, &d.Attempts, &d.Error, &d.FailedAt); err != nil {
+			return nil, err
+		}
+		letters = append(letters, d)
+	}
+	return letters, rows.Err()
+}
+
+// leased check that update of task found it by lease
+func leased(res sql.Result, err error) error {
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// nullLease lease of claim, NULL for task which isn't claimed
+func nullLease(lease time.Time) sql.NullTime {
+	return sql.NullTime{Time: lease, Valid: !lease.IsZero()}
+}
+
+// withTimeout limit context by timeout if it set
+func (q *PostgreSQLQueue) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if q.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, q.timeout)
+}
@@ -0,0 +1,414 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	fw "github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/filewrapper"
+)
+
+// DefaultCompactEvery count of journal records before compaction
+const DefaultCompactEvery = 1000
+
+// Defaults of dead letters keeping, oldest letters over max count or older than retention are dropped
+const (
+	DefaultMaxDeadLetters      = 1000
+	DefaultDeadLetterRetention = 7 * 24 * time.Hour
+)
+
+// Operations of journal
+const (
+	opPush  = "push"
+	opClaim = "claim"
+	opAck   = "ack"
+	opRetry = "retry"
+	opFail  = "fail"
+)
+
+// entry of journal
+type entry struct {
+	Op      string          `json:"op"`
+	ID      string          `json:"id"`
+	Type    string          `json:"type,omitempty"`
+	UserID  string          `json:"user,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+	// IDs of deletion in journals written before types of tasks
+	IDs []string `json:"ids,omitempty"`
+	// Attempts and lease of claimed task in unix nanoseconds
+	Attempts    int   `json:"attempts,omitempty"`
+	LeasedUntil int64 `json:"leased_until,omitempty"`
+	// Error of failed attempt and time of move to dead letters in unix nanoseconds
+	Error    string `json:"error,omitempty"`
+	FailedAt int64  `json:"failed_at,omitempty"`
+}
+
+// fileTask task with lease
+type fileTask struct {
+	Task
+	leasedUntil time.Time
+}
+
+// FileQueue keep tasks in memory and changes of them in journal, which is replayed on start
+type FileQueue struct {
+	mu       sync.Mutex
+	tasks    map[string]*fileTask
+	order    []string
+	dead     []DeadLetter
+	journal  *fw.Journal
+	appended int
+	// Limits of dead letters
+	maxDead       int
+	deadRetention time.Duration
+}
+
+// FileOption configure FileQueue
+type FileOption func(q *FileQueue)
+
+// WithDeadLetters set max count and retention of dead letters. Not positive value disable its limit
+func WithDeadLetters(max int, retention time.Duration) FileOption {
+	return func(q *FileQueue) {
+		q.maxDead, q.deadRetention = max, retention
+	}
+}
+
+// NewFileQueue open journal of tasks on path and restore queue from it.
+// Empty path for memory only queue
+func NewFileQueue(path string, policy fw.SyncPolicy, interval time.Duration, opts ...FileOption) (*FileQueue, error) {
+	q := &FileQueue{tasks: make(map[string]*fileTask), maxDead: DefaultMaxDeadLetters, deadRetention: DefaultDeadLetterRetention}
+	for _, opt := range opts {
+		opt(q)
+	}
+	if path == "" {
+		return q, nil
+	}
+	j, err := fw.OpenJournal(path, policy, interval)
+	if err != nil {
+		return nil, err
+	}
+	q.journal = j
+
+	err = j.Replay(func(data []byte) error {
+		var e entry
+		if err := json.Unmarshal(data, &e); err != nil {
+			return err
+		}
+		q.appended++
+		return q.apply(e)
+	})
+	if err != nil {
+		_ = j.Close()
+		return nil, err
+	}
+	return q, nil
+}
+
+// Push new task of type for user
+func (q *FileQueue) Push(ctx context.Context, typ, userID string, payload json.RawMessage) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	e := entry{Op: opPush, ID: uuid.NewString(), Type: typ, UserID: userID, Payload: payload}
+	if err := q.write(e); err != nil {
+		return "", err
+	}
+	return e.ID, nil
+}
+
+// Claim oldest available task of types for lease time
+func (q *FileQueue) Claim(ctx context.Context, lease time.Duration, types []string) (Task, bool, error) {
+	if err := ctx.Err(); err != nil {
+		return Task{}, false, err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	for _, id := range q.order {
+		t := q.tasks[id]
+		if t.leasedUntil.After(now) || !contains(types, t.Type) {
+			continue
+		}
+		e := entry{Op: opClaim, ID: id, Attempts: t.Attempts + 1, LeasedUntil: now.Add(lease).UnixNano()}
+		if err := q.write(e); err != nil {
+			return Task{}, false, err
+		}
+		claimed := t.Task
+		claimed.Lease = t.leasedUntil
+		return claimed, true, nil
+	}
+	return Task{}, false, nil
+}
+
+// Len count of tasks of type
+func (q *FileQueue) Len(ctx context.Context, typ string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	n := 0
+	for _, t := range q.tasks {
+		if t.Type == typ {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Ready count of tasks of type without lease or delay
+func (q *FileQueue) Ready(ctx context.Context, typ string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	n := 0
+	for _, t := range q.tasks {
+		if t.Type == typ && !t.leasedUntil.After(now) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Ack remove done task
+func (q *FileQueue) Ack(ctx context.Context, t Task) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.leased(t) {
+		return ErrLeaseLost
+	}
+	if err := q.write(entry{Op: opAck, ID: t.ID}); err != nil {
+		return err
+	}
+	return q.compactIfNeeded()
+}
+
+// Retry make failed task available from time
+func (q *FileQueue) Retry(ctx context.Context, t Task, at time.Time, reason string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.leased(t) {
+		return ErrLeaseLost
+	}
+	return q.write(entry{Op: opRetry, ID: t.ID, LeasedUntil: at.UnixNano(), Error: reason})
+}
+
+// Fail move failed task to dead letters
+func (q *FileQueue) Fail(ctx context.Context, t Task, reason string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.leased(t) {
+		return ErrLeaseLost
+	}
+	if err := q.write(entry{Op: opFail, ID: t.ID, Error: reason, FailedAt: time.Now().UnixNano()}); err != nil {
+		return err
+	}
+	return q.compactIfNeeded()
+}
+
+// DeadLetters get last dead letters
+func (q *FileQueue) DeadLetters(ctx context.Context, limit int) ([]DeadLetter, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	letters := make([]DeadLetter, 0, limit)
+	for k := len(q.dead) - 1; k >= 0 && len(letters) < limit; k-- {
+		letters = append(letters, q.dead[k])
+	}
+	return letters, nil
+}
+
+// Close journal of queue
+func (q *FileQueue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.journal == nil {
+		return nil
+	}
+	return q.journal.Close()
+}
+
+// write entry to journal and apply it. Must be called under lock
+func (q *FileQueue) write(e entry) error {
+	if q.journal != nil {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		if err = q.journal.Append(data); err != nil {
+			return err
+		}
+		q.appended++
+	}
+	return q.apply(e)
+}
+
+// apply entry to queue. Must be called under lock
+func (q *FileQueue) apply(e entry) error {
+	switch e.Op {
+	case opPush:
+		if e.Type == "" {
+			payload, err := json.Marshal(e.IDs)
+			if err != nil {
+				return err
+			}
+			e.Type, e.Payload = LegacyType, payload
+		}
+		q.tasks[e.ID] = &fileTask{Task: Task{ID: e.ID, Type: e.Type, UserID: e.UserID, Payload: e.Payload, Attempts: e.Attempts, Error: e.Error}}
+		if e.LeasedUntil != 0 {
+			q.tasks[e.ID].leasedUntil = time.Unix(0, e.LeasedUntil)
+		}
+		q.order = append(q.order, e.ID)
+	case opClaim:
+		if t, ok := q.tasks[e.ID]; ok {
+			t.Attempts = e.Attempts
+			t.leasedUntil = time.Unix(0, e.LeasedUntil)
+		}
+	case opRetry:
+		if t, ok := q.tasks[e.ID]; ok {
+			t.Error = e.Error
+			t.leasedUntil = time.Unix(0, e.LeasedUntil)
+		}
+	case opAck:
+		q.remove(e.ID)
+	case opFail:
+		if t, ok := q.tasks[e.ID]; ok {
+			d := DeadLetter{Task: t.Task, FailedAt: time.Unix(0, e.FailedAt)}
+			d.Error = e.Error
+			q.dead = append(q.dead, d)
+			q.remove(e.ID)
+			q.prune(time.Now())
+		}
+	default:
+		return fmt.Errorf("unknown operation of tasks journal: %s", e.Op)
+	}
+	return nil
+}
+
+// leased check that task is in queue and has lease of its claim. Must be called under lock
+func (q *FileQueue) leased(t Task) bool {
+	ft, ok := q.tasks[t.ID]
+	return ok && ft.leasedUntil.Equal(t.Lease)
+}
+
+// remove task from queue. Must be called under lock
+func (q *FileQueue) remove(id string) {
+	if _, ok := q.tasks[id]; !ok {
+		return
+	}
+	delete(q.tasks, id)
+	for k, v := range q.order {
+		if v == id {
+			q.order = append(q.order[:k], q.order[k+1:]...)
+			break
+		}
+	}
+}
+
+// prune drop oldest dead letters over max count and older than retention. Must be called under lock
+func (q *FileQueue) prune(now time.Time) {
+	drop := 0
+	if q.maxDead > 0 && len(q.dead) > q.maxDead {
+		drop = len(q.dead) - q.maxDead
+	}
+	if q.deadRetention > 0 {
+		for drop < len(q.dead) && now.Sub(q.dead[drop].FailedAt) > q.deadRetention {
+			drop++
+		}
+	}
+	if drop == 0 {
+		return
+	}
+	// Shift letters, so dropped ones are released
+	n := copy(q.dead, q.dead[drop:])
+	for k := n; k < len(q.dead); k++ {
+		q.dead[k] = DeadLetter{}
+	}
+	q.dead = q.dead[:n]
+}
+
+// compactIfNeeded rewrite journal when it is much bigger than queue. Dropped dead letters
+// aren't rewritten. Must be called under lock
+func (q *FileQueue) compactIfNeeded() error {
+	if q.journal == nil || q.appended < DefaultCompactEvery {
+		return nil
+	}
+	q.prune(time.Now())
+	// Dead letter is written by two records
+	size := len(q.tasks) + 2*len(q.dead)
+	if q.appended < 2*size {
+		return nil
+	}
+	written := 0
+	err := q.journal.Compact(func(emit func(data []byte) error) error {
+		var entries []entry
+		for _, d := range q.dead {
+			entries = append(entries,
+				entry{Op: opPush, ID: d.ID, Type: d.Type, UserID: d.UserID, Payload: d.Payload, Attempts: d.Attempts},
+				entry{Op: opFail, ID: d.ID, Error: d.Error, FailedAt: d.FailedAt.UnixNano()},
+			)
+		}
+		for _, id := range q.order {
+			t := q.tasks[id]
+			e := entry{Op: opPush, ID: id, Type: t.Type, UserID: t.UserID, Payload: t.Payload, Attempts: t.Attempts, Error: t.Error}
+			if !t.leasedUntil.IsZero() {
+				e.LeasedUntil = t.leasedUntil.UnixNano()
+			}
+			entries = append(entries, e)
+		}
+		for _, e := range entries {
+			data, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			if err = emit(data); err != nil {
+				return err
+			}
+			written++
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	q.appended = written
+	return nil
+}
+
+// contains check if types have type
+func contains(types []string, typ string) bool {
+	for _, v := range types {
+		if v == typ {
+			return true
+		}
+	}
+	return false
+}
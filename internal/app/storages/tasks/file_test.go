@@ -0,0 +1,272 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	fw "github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/filewrapper"
+)
+
+// types of test tasks
+var types = []string{"delete"}
+
+// assertLeases check claims order, leases and redelivery of queue
+func assertLeases(t *testing.T, q Queue) {
+	ctx := context.Background()
+	first, err := q.Push(ctx, "delete", "user", json.RawMessage(`["1","2"]`))
+	require.NoError(t, err)
+	second, err := q.Push(ctx, "delete", "other", json.RawMessage(`["3"]`))
+	require.NoError(t, err)
+
+	task, ok, err := q.Claim(ctx, time.Hour, types)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, first, task.ID)
+	assert.Equal(t, "delete", task.Type)
+	assert.Equal(t, "user", task.UserID)
+	assert.JSONEq(t, `["1","2"]`, string(task.Payload))
+	assert.Equal(t, 1, task.Attempts)
+	require.NoError(t, q.Ack(ctx, task))
+
+	// Lease of second task expires without ack
+	expired, ok, err := q.Claim(ctx, 50*time.Millisecond, types)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, second, expired.ID)
+	_, ok, err = q.Claim(ctx, time.Hour, types)
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	time.Sleep(100 * time.Millisecond)
+	task, ok, err = q.Claim(ctx, time.Hour, types)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, second, task.ID)
+	assert.Equal(t, 2, task.Attempts)
+
+	// Worker of expired lease don't own task claimed again
+	assert.ErrorIs(t, q.Ack(ctx, expired), ErrLeaseLost)
+	assert.ErrorIs(t, q.Retry(ctx, expired, time.Now(), "late"), ErrLeaseLost)
+	assert.ErrorIs(t, q.Fail(ctx, expired, "late"), ErrLeaseLost)
+	require.NoError(t, q.Ack(ctx, task))
+	assert.ErrorIs(t, q.Ack(ctx, task), ErrLeaseLost)
+}
+
+// assertDeadLetters check retry of failed task and its move to dead letters
+func assertDeadLetters(t *testing.T, q Queue) {
+	ctx := context.Background()
+	id, err := q.Push(ctx, "delete", "user", json.RawMessage(`["1"]`))
+	require.NoError(t, err)
+	claimed, _, err := q.Claim(ctx, time.Hour, types)
+	require.NoError(t, err)
+
+	// Retried task is available after delay
+	require.NoError(t, q.Retry(ctx, claimed, time.Now().Add(50*time.Millisecond), "timeout"))
+	_, ok, err := q.Claim(ctx, time.Hour, types)
+	require.NoError(t, err)
+	assert.False(t, ok)
+	time.Sleep(100 * time.Millisecond)
+	task, ok, err := q.Claim(ctx, time.Hour, types)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, 2, task.Attempts)
+	assert.Equal(t, "timeout", task.Error)
+
+	require.NoError(t, q.Fail(ctx, task, "broken"))
+	letters, err := q.DeadLetters(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, letters, 1)
+	assert.Equal(t, id, letters[0].ID)
+	assert.Equal(t, "delete", letters[0].Type)
+	assert.JSONEq(t, `["1"]`, string(letters[0].Payload))
+	assert.Equal(t, 2, letters[0].Attempts)
+	assert.Equal(t, "broken", letters[0].Error)
+	assert.False(t, letters[0].FailedAt.IsZero())
+	_, ok, err = q.Claim(ctx, 0, types)
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestFileQueue_Claim(t *testing.T) {
+	q, err := NewFileQueue("", fw.SyncNever, 0)
+	require.NoError(t, err)
+	assertLeases(t, q)
+}
+
+func TestFileQueue_DeadLetters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "links.tasks")
+	q, err := NewFileQueue(path, fw.SyncAlways, 0)
+	require.NoError(t, err)
+	assertDeadLetters(t, q)
+	require.NoError(t, q.Close())
+
+	// Dead letters are replayed from journal
+	q, err = NewFileQueue(path, fw.SyncAlways, 0)
+	require.NoError(t, err)
+	defer q.Close()
+	letters, err := q.DeadLetters(context.Background(), 10)
+	require.NoError(t, err)
+	require.Len(t, letters, 1)
+	assert.Equal(t, "broken", letters[0].Error)
+}
+
+func TestFileQueue_DeadLettersLimit(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "links.tasks")
+	q, err := NewFileQueue(path, fw.SyncNever, 0, WithDeadLetters(3, 0))
+	require.NoError(t, err)
+	// Enough records for compaction
+	var ids []string
+	for k := 0; k < DefaultCompactEvery; k++ {
+		id, err := q.Push(ctx, "delete", "user", json.RawMessage(`["1"]`))
+		require.NoError(t, err)
+		require.NoError(t, q.Fail(ctx, Task{ID: id}, "broken"))
+		ids = append(ids, id)
+	}
+	// Oldest letters are dropped
+	letters, err := q.DeadLetters(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, letters, 3)
+	assert.Equal(t, ids[len(ids)-1], letters[0].ID)
+	assert.Less(t, q.appended, DefaultCompactEvery)
+	require.NoError(t, q.Close())
+
+	q, err = NewFileQueue(path, fw.SyncNever, 0, WithDeadLetters(3, 0))
+	require.NoError(t, err)
+	defer q.Close()
+	letters, err = q.DeadLetters(ctx, 10)
+	require.NoError(t, err)
+	assert.Len(t, letters, 3)
+}
+
+func TestFileQueue_DeadLettersRetention(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "links.tasks")
+	j, err := fw.OpenJournal(path, fw.SyncAlways, 0)
+	require.NoError(t, err)
+	old := time.Now().Add(-2 * time.Hour).UnixNano()
+	require.NoError(t, j.Append([]byte(`{"op":"push","id":"x","type":"delete","user":"user","payload":["1"]}`)))
+	require.NoError(t, j.Append([]byte(fmt.Sprintf(`{"op":"fail","id":"x","error":"broken","failed_at":%d}`, old))))
+	require.NoError(t, j.Close())
+
+	// Letter older than retention is dropped on replay
+	q, err := NewFileQueue(path, fw.SyncAlways, 0, WithDeadLetters(0, time.Hour))
+	require.NoError(t, err)
+	defer q.Close()
+	letters, err := q.DeadLetters(context.Background(), 10)
+	require.NoError(t, err)
+	assert.Empty(t, letters)
+}
+
+func TestFileQueue_Journal(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "links.tasks")
+	q, err := NewFileQueue(path, fw.SyncAlways, 0)
+	require.NoError(t, err)
+
+	done, err := q.Push(ctx, "delete", "user", json.RawMessage(`["1"]`))
+	require.NoError(t, err)
+	claimed, err := q.Push(ctx, "delete", "user", json.RawMessage(`["2"]`))
+	require.NoError(t, err)
+	pending, err := q.Push(ctx, "delete", "user", json.RawMessage(`["3"]`))
+	require.NoError(t, err)
+	task, _, err := q.Claim(ctx, time.Hour, types)
+	require.NoError(t, err)
+	require.Equal(t, done, task.ID)
+	require.NoError(t, q.Ack(ctx, task))
+	_, _, err = q.Claim(ctx, time.Hour, types)
+	require.NoError(t, err)
+	require.NoError(t, q.Close())
+
+	// Claimed task is kept leased after restart, pending task is available
+	q, err = NewFileQueue(path, fw.SyncAlways, 0)
+	require.NoError(t, err)
+	defer q.Close()
+	assert.Len(t, q.tasks, 2)
+	assert.Equal(t, 1, q.tasks[claimed].Attempts)
+
+	task, ok, err := q.Claim(ctx, time.Hour, types)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, pending, task.ID)
+	_, ok, err = q.Claim(ctx, time.Hour, types)
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+// assertTypes check claim of tasks by types
+func assertTypes(t *testing.T, q Queue) {
+	ctx := context.Background()
+	check, err := q.Push(ctx, "check", "", json.RawMessage(`{"short":"abc"}`))
+	require.NoError(t, err)
+	_, err = q.Push(ctx, "delete", "user", json.RawMessage(`["1"]`))
+	require.NoError(t, err)
+	_, err = q.Push(ctx, "delete", "other", json.RawMessage(`["2"]`))
+	require.NoError(t, err)
+	n, err := q.Len(ctx, "delete")
+	require.NoError(t, err)
+	assert.Equal(t, 2, n)
+
+	task, ok, err := q.Claim(ctx, time.Hour, []string{"check", "flush"})
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, check, task.ID)
+	assert.JSONEq(t, `{"short":"abc"}`, string(task.Payload))
+	_, ok, err = q.Claim(ctx, time.Hour, []string{"check", "flush"})
+	require.NoError(t, err)
+	assert.False(t, ok)
+	claimed, ok, err := q.Claim(ctx, time.Hour, types)
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	// Claimed and delayed tasks aren't ready
+	n, err = q.Ready(ctx, "delete")
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	require.NoError(t, q.Retry(ctx, claimed, time.Now().Add(time.Hour), "busy"))
+	n, err = q.Ready(ctx, "delete")
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	// Claimed tasks are counted until ack
+	n, err = q.Len(ctx, "check")
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	n, err = q.Ready(ctx, "check")
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+	require.NoError(t, q.Ack(ctx, task))
+	n, err = q.Len(ctx, "check")
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestFileQueue_Types(t *testing.T) {
+	q, err := NewFileQueue("", fw.SyncNever, 0)
+	require.NoError(t, err)
+	assertTypes(t, q)
+}
+
+func TestFileQueue_LegacyJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "links.tasks")
+	// Journal written before types of tasks
+	j, err := fw.OpenJournal(path, fw.SyncAlways, 0)
+	require.NoError(t, err)
+	require.NoError(t, j.Append([]byte(`{"op":"push","id":"x","user":"user","ids":["1","2"]}`)))
+	require.NoError(t, j.Close())
+
+	q, err := NewFileQueue(path, fw.SyncAlways, 0)
+	require.NoError(t, err)
+	defer q.Close()
+	task, ok, err := q.Claim(context.Background(), time.Hour, []string{LegacyType})
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, "x", task.ID)
+	assert.JSONEq(t, `["1","2"]`, string(task.Payload))
+}
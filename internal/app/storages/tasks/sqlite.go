@@ -0,0 +1,218 @@
+package tasks
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// sqlSQLitePushTask for new task, time in unix nanoseconds
+const sqlSQLitePushTask = `
+insert into tasks (id, type, user_id, payload, created_at) 
+values (?, ?, ?, ?, ?)
+`
+
+// sqlSQLiteClaimTask lease oldest available task of types in JSON array
+const sqlSQLiteClaimTask = `
+update tasks 
+set leased_until=?, attempts=attempts+1 
+where id = (
+	select id from tasks 
+	where (leased_until is null or leased_until < ?) and type in (select value from json_each(?)) 
+	order by created_at, rowid 
+	limit 1
+) 
+returning id, type, user_id, payload, attempts, coalesce(last_error, ''), leased_until
+`
+
+// sqlSQLiteCountTasks of type
+const sqlSQLiteCountTasks = `
+select count(*) from tasks where type=?
+`
+
+// sqlSQLiteReadyTasks count tasks of type available for claim
+const sqlSQLiteReadyTasks = `
+select count(*) from tasks where type=? and (leased_until is null or leased_until < ?)
+`
+
+// sqlSQLiteAckTask remove done task of lease
+const sqlSQLiteAckTask = `
+delete from tasks where id=? and leased_until is ?
+`
+
+// sqlSQLiteRetryTask make task of lease available from time
+const sqlSQLiteRetryTask = `
+update tasks 
+set leased_until=?, last_error=? 
+where id=? and leased_until is ?
+`
+
+// sqlSQLiteCopyDeadLetter copy task of lease to dead letters
+const sqlSQLiteCopyDeadLetter = `
+insert into dead_letters (id, type, user_id, payload, attempts, error, failed_at) 
+select id, type, user_id, payload, attempts, ?, ? from tasks where id=? and leased_until is ?
+`
+
+// sqlSQLitePruneDeadLetters drop letters failed before time and oldest letters over max count
+const sqlSQLitePruneDeadLetters = `
+delete from dead_letters 
+where failed_at < ? 
+or (? > 0 and id in (select id from dead_letters order by failed_at desc limit -1 offset max(?, 0)))
+`
+
+// sqlSQLiteDeadLetters last dead letters
+const sqlSQLiteDeadLetters = `
+select id, type, user_id, payload, attempts, error, failed_at 
+from dead_letters 
+order by failed_at desc 
+limit ?
+`
+
+// SQLiteQueue queue of tasks in tasks table of SQLite links storage
+type SQLiteQueue struct {
+	PostgreSQLQueue
+}
+
+// NewSQLiteQueue queue on connection of SQLite links storage with timeout of queries
+func NewSQLiteQueue(db *sql.DB, timeout time.Duration, opts ...Option) *SQLiteQueue {
+	return &SQLiteQueue{*NewPostgreSQLQueue(db, timeout, opts...)}
+}
+
+// Push new task of type for user
+func (q *SQLiteQueue) Push(ctx context.Context, typ, userID string, payload json.RawMessage) (string, error) {
+	ctx, cancel := q.withTimeout(ctx)
+	defer cancel()
+
+	id := uuid.NewString()
+	if _, err := q.db.ExecContext(ctx, sqlSQLitePushTask, id, typ, userID, string(payload), time.Now().UnixNano()); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// Claim oldest available task of types for lease time
+func (q *SQLiteQueue) Claim(ctx context.Context, lease time.Duration, types []string) (Task, bool, error) {
+	ctx, cancel := q.withTimeout(ctx)
+	defer cancel()
+
+	filter, err := json.Marshal(types)
+	if err != nil {
+		return Task{}, false, err
+	}
+	now := time.Now()
+	var t Task
+	var payload string
+	var leasedUntil int64
+	err = q.db.QueryRowContext(ctx, sqlSQLiteClaimTask, now.Add(lease).UnixNano(), now.UnixNano(), string(filter)).Scan(&t.ID, &t.Type, &t.UserID, &payload, &t.Attempts, &t.Error, &leasedUntil)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Task{}, false, nil
+	}
+	if err != nil {
+		return Task{}, false, err
+	}
+	t.Payload = json.RawMessage(payload)
+	t.Lease = time.Unix(0, leasedUntil)
+	return t, true, nil
+}
+
+// Len count of tasks of type
+func (q *SQLiteQueue) Len(ctx context.Context, typ string) (int, error) {
+	ctx, cancel := q.withTimeout(ctx)
+	defer cancel()
+
+	var n int
+	err := q.db.QueryRowContext(ctx, sqlSQLiteCountTasks, typ).Scan(&n)
+	return n, err
+}
+
+// Ready count of tasks of type without lease or delay
+func (q *SQLiteQueue) Ready(ctx context.Context, typ string) (int, error) {
+	ctx, cancel := q.withTimeout(ctx)
+	defer cancel()
+
+	var n int
+	err := q.db.QueryRowContext(ctx, sqlSQLiteReadyTasks, typ, time.Now().UnixNano()).Scan(&n)
+	return n, err
+}
+
+// Ack remove done task
+func (q *SQLiteQueue) Ack(ctx context.Context, t Task) error {
+	ctx, cancel := q.withTimeout(ctx)
+	defer cancel()
+
+	return leased(q.db.ExecContext(ctx, sqlSQLiteAckTask, t.ID, unixLease(t.Lease)))
+}
+
+// Retry make failed task available from time
+func (q *SQLiteQueue) Retry(ctx context.Context, t Task, at time.Time, reason string) error {
+	ctx, cancel := q.withTimeout(ctx)
+	defer cancel()
+
+	return leased(q.db.ExecContext(ctx, sqlSQLiteRetryTask, at.UnixNano(), reason, t.ID, unixLease(t.Lease)))
+}
+
+// Fail move failed task to dead letters and drop letters over limits in one transaction
+func (q *SQLiteQueue) Fail(ctx context.Context, t Task, reason string) error {
+	ctx, cancel := q.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := q.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func(tx *sql.Tx) {
+		_ = tx.Rollback()
+	}(tx)
+
+	if err = leased(tx.ExecContext(ctx, sqlSQLiteCopyDeadLetter, reason, time.Now().UnixNano(), t.ID, unixLease(t.Lease))); err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, sqlSQLiteAckTask, t.ID, unixLease(t.Lease)); err != nil {
+		return err
+	}
+	var before int64
+	if q.deadRetention > 0 {
+		before = time.Now().Add(-q.deadRetention).UnixNano()
+	}
+	if _, err = tx.ExecContext(ctx, sqlSQLitePruneDeadLetters, before, q.maxDead, q.maxDead); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeadLetters get last dead letters
+func (q *SQLiteQueue) DeadLetters(ctx context.Context, limit int) ([]DeadLetter, error) {
+	ctx, cancel := q.withTimeout(ctx)
+	defer cancel()
+
+	rows, err := q.db.QueryContext(ctx, sqlSQLiteDeadLetters, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer func(rows *sql.Rows) {
+		_ = rows.Close()
+	}(rows)
+
+	letters := make([]DeadLetter, 0)
+	for rows.Next() {
+		var d DeadLetter
+		var payload string
+		var failedAt int64
+		if err = rows.Scan(&d.ID, &d.Type, &d.UserID, &payload, &d.Attempts, &d.Error, &failedAt); err != nil {
+			return nil, err
+		}
+		d.Payload = json.RawMessage(payload)
+		d.FailedAt = time.Unix(0, failedAt)
+		letters = append(letters, d)
+	}
+	return letters, rows.Err()
+}
+
+// unixLease lease of claim in unix nanoseconds, NULL for task which isn't claimed
+func unixLease(lease time.Time) sql.NullInt64 {
+	return sql.NullInt64{Int64: lease.UnixNano(), Valid: !lease.IsZero()}
+}
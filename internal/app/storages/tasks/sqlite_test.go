@@ -0,0 +1,72 @@
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/sqlite"
+)
+
+func TestSQLiteQueue_Claim(t *testing.T) {
+	s, err := sqlite.New(filepath.Join(t.TempDir(), "links.sqlite"), zap.NewNop())
+	require.NoError(t, err)
+	defer s.Close()
+
+	assertLeases(t, NewSQLiteQueue(s.DB(), 0))
+}
+
+func TestSQLiteQueue_DeadLetters(t *testing.T) {
+	s, err := sqlite.New(filepath.Join(t.TempDir(), "links.sqlite"), zap.NewNop())
+	require.NoError(t, err)
+	defer s.Close()
+
+	assertDeadLetters(t, NewSQLiteQueue(s.DB(), 0))
+}
+
+func TestSQLiteQueue_Types(t *testing.T) {
+	s, err := sqlite.New(filepath.Join(t.TempDir(), "links.sqlite"), zap.NewNop())
+	require.NoError(t, err)
+	defer s.Close()
+
+	assertTypes(t, NewSQLiteQueue(s.DB(), 0))
+}
+
+func TestSQLiteQueue_DeadLettersLimit(t *testing.T) {
+	ctx := context.Background()
+	s, err := sqlite.New(filepath.Join(t.TempDir(), "links.sqlite"), zap.NewNop())
+	require.NoError(t, err)
+	defer s.Close()
+
+	// Oldest letters over max count are dropped
+	q := NewSQLiteQueue(s.DB(), 0, WithDeadLetterLimits(3, 0))
+	var ids []string
+	for k := 0; k < 5; k++ {
+		id, err := q.Push(ctx, "delete", "user", json.RawMessage(`["1"]`))
+		require.NoError(t, err)
+		require.NoError(t, q.Fail(ctx, Task{ID: id}, "broken"))
+		ids = append(ids, id)
+	}
+	letters, err := q.DeadLetters(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, letters, 3)
+	assert.Equal(t, ids[4], letters[0].ID)
+	assert.Equal(t, ids[2], letters[2].ID)
+
+	// Letters older than retention are dropped
+	q = NewSQLiteQueue(s.DB(), 0, WithDeadLetterLimits(0, 50*time.Millisecond))
+	time.Sleep(100 * time.Millisecond)
+	id, err := q.Push(ctx, "delete", "user", json.RawMessage(`["2"]`))
+	require.NoError(t, err)
+	require.NoError(t, q.Fail(ctx, Task{ID: id}, "broken"))
+	letters, err = q.DeadLetters(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, letters, 1)
+	assert.Equal(t, id, letters[0].ID)
+}
@@ -0,0 +1,61 @@
+// Package tasks contain durable queues of background tasks
+package tasks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// DefaultLease time of task ownership by worker after claim
+const DefaultLease = time.Minute
+
+// ErrLeaseLost if task isn't leased by claim of caller anymore. Lease is expired and task is claimed
+// again or task is already done by other worker
+var ErrLeaseLost = errors.New("lease of task is lost")
+
+// LegacyType of tasks pushed before types of tasks, they are deletions of links by ids
+const LegacyType = "delete"
+
+// Task of user with type and payload in json, payload is decoded by handler of type
+type Task struct {
+	ID      string          `json:"id"`
+	Type    string          `json:"type"`
+	UserID  string          `json:"user_id"`
+	Payload json.RawMessage `json:"payload"`
+	// Attempts count of claims, first claim is attempt 1
+	Attempts int `json:"attempts"`
+	// Error of last failed attempt
+	Error string `json:"error,omitempty"`
+	// Lease end of lease given by claim, zero for task which isn't claimed.
+	// Ack, Retry and Fail are accepted only with lease of current claim
+	Lease time.Time `json:"-"`
+}
+
+// DeadLetter task removed from queue after last failed attempt
+type DeadLetter struct {
+	Task
+	FailedAt time.Time `json:"failed_at"`
+}
+
+// Queue persist tasks until they are acknowledged. Claimed task is leased to one worker
+// and is delivered again after lease expiration, so task of crashed worker isn't lost
+type Queue interface {
+	// Push new task of type for user and get its id
+	Push(ctx context.Context, typ, userID string, payload json.RawMessage) (string, error)
+	// Claim oldest available task of types for lease time. False if queue has no available tasks
+	Claim(ctx context.Context, lease time.Duration, types []string) (Task, bool, error)
+	// Len count of tasks of type in queue, claimed and retried tasks included
+	Len(ctx context.Context, typ string) (int, error)
+	// Ready count of tasks of type available for claim now, leased and delayed tasks aren't counted
+	Ready(ctx context.Context, typ string) (int, error)
+	// Ack remove done task from queue. Returns ErrLeaseLost if task isn't leased by its claim
+	Ack(ctx context.Context, t Task) error
+	// Retry make failed task available again from time. Returns ErrLeaseLost if task isn't leased by its claim
+	Retry(ctx context.Context, t Task, at time.Time, reason string) error
+	// Fail move failed task from queue to dead letters. Returns ErrLeaseLost if task isn't leased by its claim
+	Fail(ctx context.Context, t Task, reason string) error
+	// DeadLetters get last dead letters up to limit, newest first
+	DeadLetters(ctx context.Context, limit int) ([]DeadLetter, error)
+}
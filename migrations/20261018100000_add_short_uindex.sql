@@ -0,0 +1,9 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+create unique index if not exists short_links_short_uindex
+    on storage.short_links (short);
+
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+drop index if exists storage.short_links_short_uindex;
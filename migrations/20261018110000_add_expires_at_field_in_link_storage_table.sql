@@ -0,0 +1,16 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+alter table storage.short_links
+    add expires_at timestamptz;
+
+comment on column storage.short_links.expires_at is 'Link expiration time';
+
+create index if not exists short_links_expires_at_index
+    on storage.short_links (expires_at)
+    where expires_at is not null and is_deleted = false;
+
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+drop index if exists storage.short_links_expires_at_index;
+alter table storage.short_links drop column expires_at;
@@ -0,0 +1,26 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+create table if not exists storage.clicks
+(
+    id         bigserial   not null
+        constraint clicks_pk
+            primary key,
+    short      varchar(50) not null,
+    clicked_at timestamptz not null,
+    referrer   text,
+    user_agent text,
+    ip_hash    varchar(64)
+);
+comment on table storage.clicks is 'Redirect events of short links';
+comment on column storage.clicks.short is 'Short link';
+comment on column storage.clicks.clicked_at is 'Time of redirect';
+comment on column storage.clicks.referrer is 'Referer header';
+comment on column storage.clicks.user_agent is 'User-Agent header';
+comment on column storage.clicks.ip_hash is 'Hash of client ip';
+create index if not exists clicks_short_clicked_at_index
+    on storage.clicks (short, clicked_at);
+
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+drop table storage.clicks;
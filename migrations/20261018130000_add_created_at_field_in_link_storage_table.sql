@@ -0,0 +1,15 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+alter table storage.short_links
+    add created_at timestamptz not null default now();
+
+comment on column storage.short_links.created_at is 'Link creation time';
+
+create index if not exists short_links_user_id_created_at_index
+    on storage.short_links (user_id, created_at, short);
+
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+drop index if exists storage.short_links_user_id_created_at_index;
+alter table storage.short_links drop column created_at;
@@ -0,0 +1,19 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+alter table storage.short_links
+    add deleted_at timestamptz;
+
+comment on column storage.short_links.deleted_at is 'Link soft deletion time';
+
+-- Retention of links deleted before migration starts now
+update storage.short_links set deleted_at = now() where is_deleted = true;
+
+create index if not exists short_links_deleted_at_index
+    on storage.short_links (deleted_at)
+    where is_deleted = true;
+
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+drop index if exists storage.short_links_deleted_at_index;
+alter table storage.short_links drop column deleted_at;
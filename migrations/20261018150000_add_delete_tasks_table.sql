@@ -0,0 +1,24 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+create table if not exists storage.delete_tasks
+(
+    id           uuid        not null
+        constraint delete_tasks_pk
+            primary key,
+    user_id      varchar(50) not null,
+    ids          text[]      not null,
+    attempts     integer     not null default 0,
+    created_at   timestamptz not null default now(),
+    leased_until timestamptz
+);
+comment on table storage.delete_tasks is 'Queue of links deletion tasks';
+comment on column storage.delete_tasks.ids is 'Correlation ids or shorts of user links';
+comment on column storage.delete_tasks.attempts is 'Count of claims by workers';
+comment on column storage.delete_tasks.leased_until is 'Time of claim expiration, task is delivered again after it';
+create index if not exists delete_tasks_created_at_index
+    on storage.delete_tasks (created_at);
+
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+drop table storage.delete_tasks;
@@ -0,0 +1,27 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+alter table storage.delete_tasks
+    add column if not exists last_error text;
+comment on column storage.delete_tasks.last_error is 'Error of last failed attempt';
+create table if not exists storage.delete_dead_letters
+(
+    id        uuid        not null
+        constraint delete_dead_letters_pk
+            primary key,
+    user_id   varchar(50) not null,
+    ids       text[]      not null,
+    attempts  integer     not null,
+    error     text        not null,
+    failed_at timestamptz not null
+);
+comment on table storage.delete_dead_letters is 'Deletion tasks failed after last attempt';
+comment on column storage.delete_dead_letters.error is 'Error of last attempt';
+create index if not exists delete_dead_letters_failed_at_index
+    on storage.delete_dead_letters (failed_at);
+
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+drop table storage.delete_dead_letters;
+alter table storage.delete_tasks
+    drop column if exists last_error;
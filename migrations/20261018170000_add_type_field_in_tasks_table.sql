@@ -0,0 +1,75 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+alter table storage.delete_tasks
+    rename to tasks;
+alter table storage.tasks
+    rename constraint delete_tasks_pk to tasks_pk;
+alter index storage.delete_tasks_created_at_index
+    rename to tasks_created_at_index;
+alter table storage.tasks
+    add column if not exists type    varchar(50) not null default 'delete',
+    add column if not exists payload jsonb;
+update storage.tasks
+set payload = to_jsonb(ids);
+alter table storage.tasks
+    alter column payload set not null,
+    drop column ids;
+comment on table storage.tasks is 'Queue of background tasks';
+comment on column storage.tasks.type is 'Type of task, it selects handler of workers';
+comment on column storage.tasks.payload is 'Arguments of task handler';
+create index if not exists tasks_type_created_at_index
+    on storage.tasks (type, created_at);
+
+alter table storage.delete_dead_letters
+    rename to dead_letters;
+alter table storage.dead_letters
+    rename constraint delete_dead_letters_pk to dead_letters_pk;
+alter index storage.delete_dead_letters_failed_at_index
+    rename to dead_letters_failed_at_index;
+alter table storage.dead_letters
+    add column if not exists type    varchar(50) not null default 'delete',
+    add column if not exists payload jsonb;
+update storage.dead_letters
+set payload = to_jsonb(ids);
+alter table storage.dead_letters
+    alter column payload set not null,
+    drop column ids;
+comment on table storage.dead_letters is 'Background tasks failed after last attempt';
+
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+delete from storage.tasks where type <> 'delete';
+drop index if exists storage.tasks_type_created_at_index;
+alter table storage.tasks
+    add column ids text[];
+update storage.tasks
+set ids = array(select jsonb_array_elements_text(payload));
+alter table storage.tasks
+    alter column ids set not null,
+    drop column payload,
+    drop column type;
+alter index storage.tasks_created_at_index
+    rename to delete_tasks_created_at_index;
+alter table storage.tasks
+    rename constraint tasks_pk to delete_tasks_pk;
+alter table storage.tasks
+    rename to delete_tasks;
+comment on table storage.delete_tasks is 'Queue of links deletion tasks';
+
+delete from storage.dead_letters where type <> 'delete';
+alter table storage.dead_letters
+    add column ids text[];
+update storage.dead_letters
+set ids = array(select jsonb_array_elements_text(payload));
+alter table storage.dead_letters
+    alter column ids set not null,
+    drop column payload,
+    drop column type;
+alter index storage.dead_letters_failed_at_index
+    rename to delete_dead_letters_failed_at_index;
+alter table storage.dead_letters
+    rename constraint dead_letters_pk to delete_dead_letters_pk;
+alter table storage.dead_letters
+    rename to delete_dead_letters;
+comment on table storage.delete_dead_letters is 'Deletion tasks failed after last attempt';
@@ -0,0 +1,16 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+create table if not exists storage.counters
+(
+    name  varchar(50) not null
+        constraint counters_pk
+            primary key,
+    value bigint      not null
+);
+comment on table storage.counters is 'Counters shared by instances';
+comment on column storage.counters.value is 'End of last reserved block of values';
+
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+drop table storage.counters;
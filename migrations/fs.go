@@ -3,5 +3,11 @@ package migrations
 
 import "embed"
 
-//go:embed *.sql
+// EmbedMigrations contain PostgreSQL migrations in root and SQLite variants
+// of same versions in SQLiteDir
+//
+//go:embed *.sql sqlite/*.sql
 var EmbedMigrations embed.FS
+
+// SQLiteDir directory of SQLite migrations in EmbedMigrations
+const SQLiteDir = "sqlite"
@@ -0,0 +1,19 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+create table if not exists short_links
+(
+    id             integer      not null
+        constraint short_links_pk
+            primary key autoincrement,
+    user_id        varchar(50),
+    origin         varchar(255) not null,
+    short          varchar(50)  not null,
+    correlation_id varchar(100)
+);
+create unique index if not exists short_links_user_id_origin_uindex
+    on short_links (user_id, origin);
+
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+drop table short_links;
@@ -0,0 +1,9 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+alter table short_links
+    add is_deleted boolean default false;
+
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+alter table short_links drop column is_deleted;
@@ -0,0 +1,15 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+-- Time is unix time in nanoseconds, so it is compared as number
+alter table short_links
+    add expires_at integer;
+
+create index if not exists short_links_expires_at_index
+    on short_links (expires_at)
+    where expires_at is not null and is_deleted = false;
+
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+drop index if exists short_links_expires_at_index;
+alter table short_links drop column expires_at;
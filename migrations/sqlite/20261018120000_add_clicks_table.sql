@@ -0,0 +1,20 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+create table if not exists clicks
+(
+    id         integer     not null
+        constraint clicks_pk
+            primary key autoincrement,
+    short      varchar(50) not null,
+    clicked_at integer     not null,
+    referrer   text,
+    user_agent text,
+    ip_hash    varchar(64)
+);
+create index if not exists clicks_short_clicked_at_index
+    on clicks (short, clicked_at);
+
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+drop table clicks;
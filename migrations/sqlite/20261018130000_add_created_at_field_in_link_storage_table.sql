@@ -0,0 +1,14 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+-- Existing links get zero time, because column can't have non constant default
+alter table short_links
+    add created_at integer not null default 0;
+
+create index if not exists short_links_user_id_created_at_index
+    on short_links (user_id, created_at, short);
+
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+drop index if exists short_links_user_id_created_at_index;
+alter table short_links drop column created_at;
@@ -0,0 +1,18 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+-- Time in unix nanoseconds like other time columns
+alter table short_links
+    add deleted_at integer;
+
+-- Retention of links deleted before migration starts now
+update short_links set deleted_at = cast(strftime('%s', 'now') as integer) * 1000000000 where is_deleted = true;
+
+create index if not exists short_links_deleted_at_index
+    on short_links (deleted_at)
+    where is_deleted = true;
+
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+drop index if exists short_links_deleted_at_index;
+alter table short_links drop column deleted_at;
@@ -0,0 +1,20 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+create table if not exists delete_tasks
+(
+    id           varchar(36) not null
+        constraint delete_tasks_pk
+            primary key,
+    user_id      varchar(50) not null,
+    ids          text        not null,
+    attempts     integer     not null default 0,
+    created_at   integer     not null,
+    leased_until integer
+);
+create index if not exists delete_tasks_created_at_index
+    on delete_tasks (created_at);
+
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+drop table delete_tasks;
@@ -0,0 +1,24 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+alter table delete_tasks
+    add column last_error text;
+create table if not exists delete_dead_letters
+(
+    id        varchar(36) not null
+        constraint delete_dead_letters_pk
+            primary key,
+    user_id   varchar(50) not null,
+    ids       text        not null,
+    attempts  integer     not null,
+    error     text        not null,
+    failed_at integer     not null
+);
+create index if not exists delete_dead_letters_failed_at_index
+    on delete_dead_letters (failed_at);
+
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+drop table delete_dead_letters;
+alter table delete_tasks
+    drop column last_error;
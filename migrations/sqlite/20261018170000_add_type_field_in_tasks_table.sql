@@ -0,0 +1,37 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+alter table delete_tasks
+    rename to tasks;
+alter table tasks
+    add column type varchar(50) not null default 'delete';
+alter table tasks
+    rename column ids to payload;
+create index if not exists tasks_type_created_at_index
+    on tasks (type, created_at);
+
+alter table delete_dead_letters
+    rename to dead_letters;
+alter table dead_letters
+    add column type varchar(50) not null default 'delete';
+alter table dead_letters
+    rename column ids to payload;
+
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+delete from dead_letters where type <> 'delete';
+alter table dead_letters
+    rename column payload to ids;
+alter table dead_letters
+    drop column type;
+alter table dead_letters
+    rename to delete_dead_letters;
+
+delete from tasks where type <> 'delete';
+drop index if exists tasks_type_created_at_index;
+alter table tasks
+    rename column payload to ids;
+alter table tasks
+    drop column type;
+alter table tasks
+    rename to delete_tasks;
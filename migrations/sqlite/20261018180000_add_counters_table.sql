@@ -0,0 +1,14 @@
+-- +goose Up
+-- SQL in this section is executed when the migration is applied.
+create table if not exists counters
+(
+    name  varchar(50) not null
+        constraint counters_pk
+            primary key,
+    value integer     not null
+);
+
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+drop table counters;
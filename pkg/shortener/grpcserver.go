@@ -13,17 +13,27 @@ import (
 	"database/sql"
 	"encoding/json"
 	"github.com/gorilla/mux"
+	er "github.com/triumphpc/go-musthave-shortener-tpl/internal/app/errors"
 	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/handlers"
 	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/handlers/delete"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/handlers/linkstats"
 	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/handlers/ping"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/handlers/restore"
 	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/handlers/stats"
 	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/helpers/worker"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/click"
 	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/shortlink"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/clicks"
 	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/repository"
 	proto "github.com/triumphpc/go-musthave-shortener-tpl/pkg/api"
 	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // ShortenerServer implement main methods for gRPC
@@ -35,7 +45,20 @@ type ShortenerServer struct {
 	s  repository.Repository
 	l  *zap.Logger
 	db *sql.DB
-	p  *worker.Pool
+	p  worker.IPool
+	c  clicks.Store
+	// Grace period of deleted links restore
+	grace time.Duration
+}
+
+// Option configure ShortenerServer
+type Option func(s *ShortenerServer)
+
+// WithRestoreGrace set grace period of deleted links restore
+func WithRestoreGrace(d time.Duration) Option {
+	return func(s *ShortenerServer) {
+		s.grace = d
+	}
 }
 
 // ResponseWriterMap it's bridge for response from main handler
@@ -67,8 +90,12 @@ func (rw *ResponseWriterMap) Write(data []byte) (int, error) {
 }
 
 // New instance for gRPC server
-func New(l *zap.Logger, s repository.Repository, db *sql.DB, p *worker.Pool) *ShortenerServer {
-	return &ShortenerServer{proto.UnimplementedShortenerServer{}, s, l, db, p}
+func New(l *zap.Logger, s repository.Repository, db *sql.DB, p worker.IPool, c clicks.Store, opts ...Option) *ShortenerServer {
+	srv := &ShortenerServer{UnimplementedShortenerServer: proto.UnimplementedShortenerServer{}, s: s, l: l, db: db, p: p, c: c}
+	for _, opt := range opts {
+		opt(srv)
+	}
+	return srv
 }
 
 // AddLink implement add new link
@@ -79,7 +106,17 @@ func (s *ShortenerServer) AddLink(ctx context.Context, r *proto.AddLinkRequest)
 	h := handlers.New(s.l, s.s)
 	link := r.GetLink().Link
 
-	req, err := http.NewRequest(http.MethodPost, "/", strings.NewReader(link))
+	// Custom short code and expiration are supported by JSON handler only
+	if r.GetAlias() != "" || r.GetExpiresAt() != 0 || r.GetTtl() != "" {
+		return s.addJSONLink(ctx, h, shortlink.URL{
+			URL:       link,
+			Alias:     r.GetAlias(),
+			ExpiresAt: unixTime(r.GetExpiresAt()),
+			TTL:       r.GetTtl(),
+		})
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "/", strings.NewReader(link))
 	if err != nil {
 		return &response, err
 	}
@@ -89,6 +126,9 @@ func (s *ShortenerServer) AddLink(ctx context.Context, r *proto.AddLinkRequest)
 	h.Save(resp, req)
 
 	response.Code = int32(resp.code)
+	if err := statusError(resp.code); err != nil {
+		return &response, err
+	}
 
 	response.Link = &proto.ShortLink{
 		Link: resp.buf.String(),
@@ -97,12 +137,50 @@ func (s *ShortenerServer) AddLink(ctx context.Context, r *proto.AddLinkRequest)
 	return &response, nil
 }
 
+// addJSONLink save link with options by JSON handler
+func (s *ShortenerServer) addJSONLink(ctx context.Context, h *handlers.Handler, link shortlink.URL) (*proto.AddLinkResponse, error) {
+	response := new(proto.AddLinkResponse)
+
+	body, err := json.Marshal(link)
+	if err != nil {
+		return response, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "/api/shorten", strings.NewReader(string(body)))
+	if err != nil {
+		return response, err
+	}
+
+	resp := NewResponseWriterMap()
+	h.SaveJSON(resp, req)
+
+	response.Code = int32(resp.code)
+	if err := statusError(resp.code); err != nil {
+		return response, err
+	}
+	// Errors are in plain text
+	if !strings.HasPrefix(resp.Header().Get("Content-Type"), "application/json") {
+		return response, nil
+	}
+
+	data := struct {
+		Result string `json:"result"`
+	}{}
+	if err = json.Unmarshal(resp.buf.Bytes(), &data); err != nil {
+		return response, err
+	}
+	response.Link = &proto.ShortLink{
+		Link: data.Result,
+	}
+
+	return response, nil
+}
+
 // Ping implement method for gRPC for check DB connection
-func (s *ShortenerServer) Ping(context.Context, *proto.PingRequest) (*proto.PingResponse, error) {
+func (s *ShortenerServer) Ping(ctx context.Context, _ *proto.PingRequest) (*proto.PingResponse, error) {
 	var response proto.PingResponse
 
 	resp := NewResponseWriterMap()
-	req, err := http.NewRequest(http.MethodPost, "/ping", strings.NewReader(""))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "/ping", strings.NewReader(""))
 	if err != nil {
 		return &response, err
 	}
@@ -115,7 +193,8 @@ func (s *ShortenerServer) Ping(context.Context, *proto.PingRequest) (*proto.Ping
 	return &response, nil
 }
 
-// AddBatch implement add batch links implementation
+// AddBatch implement add batch links implementation. Every link has status of save,
+// in atomic mode code is 422 and nothing is saved if any link is invalid
 func (s *ShortenerServer) AddBatch(ctx context.Context, r *proto.AddBatchRequest) (*proto.AddBatchResponse, error) {
 	response := new(proto.AddBatchResponse)
 
@@ -125,14 +204,24 @@ func (s *ShortenerServer) AddBatch(ctx context.Context, r *proto.AddBatchRequest
 
 	var urls []shortlink.URLs
 	for _, requestLink := range requestLinks {
-		urls = append(urls, shortlink.URLs{ID: requestLink.Id.Id, Origin: requestLink.Link})
+		urls = append(urls, shortlink.URLs{
+			ID:        requestLink.Id.Id,
+			Origin:    requestLink.Link,
+			Alias:     requestLink.Alias,
+			ExpiresAt: unixTime(requestLink.ExpiresAt),
+			TTL:       requestLink.Ttl,
+		})
 	}
 	body, err := json.Marshal(urls)
 	if err != nil {
 		return response, err
 	}
 
-	req, err := http.NewRequest(http.MethodPost, "/api/shorten/batch", strings.NewReader(string(body)))
+	target := "/api/shorten/batch"
+	if r.GetAtomic() {
+		target += "?atomic=true"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, strings.NewReader(string(body)))
 	if err != nil {
 		return response, err
 	}
@@ -141,6 +230,9 @@ func (s *ShortenerServer) AddBatch(ctx context.Context, r *proto.AddBatchRequest
 
 	h.BunchSaveJSON(resp, req)
 	response.Code = int32(resp.code)
+	if err := statusError(resp.code); err != nil {
+		return response, err
+	}
 
 	data := make([]shortlink.ShortURLs, len(urls))
 	err = json.Unmarshal(resp.buf.Bytes(), &data)
@@ -152,8 +244,10 @@ func (s *ShortenerServer) AddBatch(ctx context.Context, r *proto.AddBatchRequest
 	shortLinks := make([]*proto.JSONBatchShortLink, len(urls))
 	for k, v := range data {
 		shortLinks[k] = &proto.JSONBatchShortLink{
-			Link: v.Short,
-			Id:   &proto.LinkID{Id: v.ID},
+			Link:   v.Short,
+			Id:     &proto.LinkID{Id: v.ID},
+			Status: v.Status,
+			Reason: v.Reason,
 		}
 	}
 	response.Links = shortLinks
@@ -174,7 +268,7 @@ func (s *ShortenerServer) AddJSONLink(ctx context.Context, r *proto.AddJSONLinkR
 		return response, err
 	}
 
-	req, err := http.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(string(body)))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "/api/shorten", strings.NewReader(string(body)))
 	if err != nil {
 		return response, err
 	}
@@ -185,6 +279,9 @@ func (s *ShortenerServer) AddJSONLink(ctx context.Context, r *proto.AddJSONLinkR
 
 	h.SaveJSON(resp, req)
 	response.Code = int32(resp.code)
+	if err := statusError(resp.code); err != nil {
+		return response, err
+	}
 
 	data := struct {
 		Result string `json:"result"`
@@ -202,10 +299,24 @@ func (s *ShortenerServer) AddJSONLink(ctx context.Context, r *proto.AddJSONLinkR
 	return response, nil
 }
 
-func (s *ShortenerServer) UserLinks(context.Context, *proto.JSONUserLinksRequest) (*proto.JSONUserLinksResponse, error) {
+func (s *ShortenerServer) UserLinks(ctx context.Context, r *proto.JSONUserLinksRequest) (*proto.JSONUserLinksResponse, error) {
 	response := new(proto.JSONUserLinksResponse)
 
-	req, err := http.NewRequest(http.MethodGet, "/api/user/urls", nil)
+	params := url.Values{}
+	if r.GetLimit() != 0 {
+		params.Set("limit", strconv.Itoa(int(r.GetLimit())))
+	}
+	for k, v := range map[string]string{
+		"cursor": r.GetCursor(),
+		"status": r.GetStatus(),
+		"domain": r.GetDomain(),
+		"order":  r.GetOrder(),
+	} {
+		if v != "" {
+			params.Set(k, v)
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "/api/user/urls?"+params.Encode(), nil)
 	if err != nil {
 		return response, err
 	}
@@ -217,22 +328,51 @@ func (s *ShortenerServer) UserLinks(context.Context, *proto.JSONUserLinksRequest
 	h.GetUrls(resp, req)
 
 	response.Code = int32(resp.code)
+	if err := statusError(resp.code); err != nil {
+		return response, err
+	}
+	total, _ := strconv.Atoi(resp.Header().Get("X-Total-Count"))
+	response.Total = int32(total)
+	response.NextCursor = resp.Header().Get("X-Next-Cursor")
+	if resp.code != http.StatusOK {
+		return response, nil
+	}
 
-	return response, nil
+	var links []struct {
+		Short     string    `json:"short_url"`
+		Origin    string    `json:"original_url"`
+		CreatedAt time.Time `json:"created_at"`
+		Deleted   bool      `json:"is_deleted"`
+	}
+	if err = json.Unmarshal(resp.buf.Bytes(), &links); err != nil {
+		return response, err
+	}
+	for _, l := range links {
+		response.Links = append(response.Links, &proto.UserLinks{
+			Origin:    &proto.Link{Link: l.Origin},
+			Short:     &proto.ShortLink{Link: l.Short},
+			CreatedAt: l.CreatedAt.Unix(),
+			Deleted:   l.Deleted,
+		})
+	}
 
+	return response, nil
 }
 
-func (s *ShortenerServer) Stats(context.Context, *proto.StatsRequest) (*proto.StatsResponse, error) {
+func (s *ShortenerServer) Stats(ctx context.Context, _ *proto.StatsRequest) (*proto.StatsResponse, error) {
 	response := new(proto.StatsResponse)
 
 	handler := stats.NewStats(s.s, s.l)
-	req, err := http.NewRequest(http.MethodGet, "/api/internal/stats", nil)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "/api/internal/stats", nil)
 	if err != nil {
 		return response, err
 	}
 
 	resp := NewResponseWriterMap()
 	handler.ServeHTTP(resp, req)
+	if err := statusError(resp.code); err != nil {
+		return response, err
+	}
 
 	result := struct {
 		Urls  int `json:"urls"`
@@ -264,7 +404,7 @@ func (s *ShortenerServer) Delete(ctx context.Context, r *proto.DeleteRequest) (*
 		return response, err
 	}
 
-	req, err := http.NewRequest(http.MethodDelete, "/api/user/urls", strings.NewReader(string(body)))
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, "/api/user/urls", strings.NewReader(string(body)))
 	if err != nil {
 		return response, err
 	}
@@ -275,16 +415,103 @@ func (s *ShortenerServer) Delete(ctx context.Context, r *proto.DeleteRequest) (*
 	handler.ServeHTTP(resp, req)
 
 	response.Code = int32(resp.code)
+	if err := statusError(resp.code); err != nil {
+		return response, err
+	}
+	if resp.code != http.StatusAccepted {
+		return response, nil
+	}
+
+	var result struct {
+		ID string `json:"id"`
+	}
+	if err = json.Unmarshal(resp.buf.Bytes(), &result); err != nil {
+		return response, err
+	}
+	response.JobId = result.ID
 
 	return response, nil
 
 }
 
+// DeleteJob get state of deletion job
+func (s *ShortenerServer) DeleteJob(ctx context.Context, r *proto.DeleteJobRequest) (*proto.DeleteJobResponse, error) {
+	response := new(proto.DeleteJobResponse)
+	id := r.GetId()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, delete.JobsRoute+"/"+id, nil)
+	if err != nil {
+		return response, err
+	}
+	req = mux.SetURLVars(req, map[string]string{"id": id})
+
+	resp := NewResponseWriterMap()
+	delete.New(s.l, s.p).Status(resp, req)
+
+	response.Code = int32(resp.code)
+	if err := statusError(resp.code); err != nil {
+		return response, err
+	}
+	if resp.code != http.StatusOK {
+		return response, nil
+	}
+
+	var job worker.Job
+	if err = json.Unmarshal(resp.buf.Bytes(), &job); err != nil {
+		return response, err
+	}
+	response.Status = job.Status
+	response.Affected = int64(job.Affected)
+	response.Error = job.Error
+
+	return response, nil
+}
+
+// Restore deleted links by ids within grace period
+func (s *ShortenerServer) Restore(ctx context.Context, r *proto.RestoreRequest) (*proto.RestoreResponse, error) {
+	response := new(proto.RestoreResponse)
+
+	var linkIDs []string
+	for _, id := range r.GetId() {
+		linkIDs = append(linkIDs, id.GetId())
+	}
+	body, err := json.Marshal(linkIDs)
+	if err != nil {
+		return response, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, restore.Route, strings.NewReader(string(body)))
+	if err != nil {
+		return response, err
+	}
+
+	resp := NewResponseWriterMap()
+	restore.New(s.l, s.s, s.grace).ServeHTTP(resp, req)
+
+	response.Code = int32(resp.code)
+	if err := statusError(resp.code); err != nil {
+		return response, err
+	}
+	if resp.code != http.StatusOK {
+		return response, nil
+	}
+
+	var result struct {
+		Restored int64 `json:"restored"`
+	}
+	if err = json.Unmarshal(resp.buf.Bytes(), &result); err != nil {
+		return response, err
+	}
+	response.Restored = result.Restored
+
+	return response, nil
+}
+
 func (s *ShortenerServer) Origin(ctx context.Context, r *proto.OriginRequest) (*proto.OriginResponse, error) {
 	response := new(proto.OriginResponse)
 	id := r.GetLink().Link
 
-	req, err := http.NewRequest(http.MethodGet, "/"+id, strings.NewReader(""))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "/"+id, strings.NewReader(""))
 	if err != nil {
 		return response, err
 	}
@@ -301,6 +528,9 @@ func (s *ShortenerServer) Origin(ctx context.Context, r *proto.OriginRequest) (*
 	h.Get(resp, req)
 
 	response.Code = int32(resp.code)
+	if err := statusError(resp.code); err != nil {
+		return response, err
+	}
 	response.Link = &proto.Link{
 		Link: resp.Header().Get("Location"),
 	}
@@ -308,3 +538,61 @@ func (s *ShortenerServer) Origin(ctx context.Context, r *proto.OriginRequest) (*
 	return response, nil
 
 }
+
+// LinkStats get clicks statistic of link
+func (s *ShortenerServer) LinkStats(ctx context.Context, r *proto.LinkStatsRequest) (*proto.LinkStatsResponse, error) {
+	response := new(proto.LinkStatsResponse)
+	short := r.GetLink().GetLink()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "/api/user/urls/"+short+"/stats", nil)
+	if err != nil {
+		return response, err
+	}
+	req = mux.SetURLVars(req, map[string]string{"short": short})
+
+	resp := NewResponseWriterMap()
+	linkstats.New(s.l, s.s, s.c).ServeHTTP(resp, req)
+
+	response.Code = int32(resp.code)
+	if err := statusError(resp.code); err != nil {
+		return response, err
+	}
+	if resp.code != http.StatusOK {
+		return response, nil
+	}
+
+	var result click.Stats
+	if err = json.Unmarshal(resp.buf.Bytes(), &result); err != nil {
+		return response, err
+	}
+	response.Total = int32(result.Total)
+	for _, d := range result.Days {
+		response.Days = append(response.Days, &proto.DailyClicks{Day: d.Day, Count: int32(d.Count)})
+	}
+
+	return response, nil
+}
+
+// statusError convert storage timeout and unavailable responses of handlers to gRPC status
+func statusError(code int) error {
+	switch code {
+	case http.StatusGatewayTimeout:
+		return status.Error(codes.DeadlineExceeded, er.ErrStorageTimeout.Error())
+	case http.StatusServiceUnavailable:
+		return status.Error(codes.Unavailable, er.ErrStorageUnavailable.Error())
+	case http.StatusRequestEntityTooLarge:
+		return status.Error(codes.InvalidArgument, er.ErrBatchTooLarge.Error())
+	case http.StatusTooManyRequests:
+		return status.Error(codes.ResourceExhausted, er.ErrQueueFull.Error())
+	}
+	return nil
+}
+
+// unixTime convert optional unix time of request, zero is nil
+func unixTime(sec int64) *time.Time {
+	if sec == 0 {
+		return nil
+	}
+	t := time.Unix(sec, 0)
+	return &t
+}
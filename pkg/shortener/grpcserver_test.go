@@ -7,11 +7,15 @@ import (
 	"fmt"
 	"github.com/stretchr/testify/assert"
 	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/helpers/worker"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/helpers/worker/workertest"
+	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/models/shortlink"
 	"github.com/triumphpc/go-musthave-shortener-tpl/internal/app/storages/file"
 	proto "github.com/triumphpc/go-musthave-shortener-tpl/pkg/api"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 	"log"
 	"math/rand"
 	"net/http"
@@ -27,7 +31,7 @@ func TestNew(t *testing.T) {
 		log.Fatal(err)
 	}
 
-	server := New(zap.NewNop(), rep, &sql.DB{}, &worker.Pool{})
+	server := New(zap.NewNop(), rep, &sql.DB{}, &worker.Pool{}, nil)
 
 	assert.IsType(t, &ShortenerServer{}, server)
 
@@ -265,3 +269,71 @@ func TestShortenerServer_Origin(t *testing.T) {
 	assert.Equal(t, respOrigin.Link.Link, link.Link)
 
 }
+
+func TestShortenerServer_Restore(t *testing.T) {
+	ctx := context.Background()
+	rep, err := file.New("")
+	if err != nil {
+		log.Fatal(err)
+	}
+	short, err := rep.Save(ctx, "all", "http://restore.ru", shortlink.Options{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, err = rep.BunchUpdateAsDeleted(ctx, []string{string(short)}, "all"); err != nil {
+		log.Fatal(err)
+	}
+
+	server := New(zap.NewNop(), rep, &sql.DB{}, &worker.Pool{}, nil, WithRestoreGrace(time.Hour))
+	resp, err := server.Restore(ctx, &proto.RestoreRequest{Id: []*proto.LinkID{{Id: string(short)}}})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, int(resp.GetCode()))
+	assert.Equal(t, int64(1), resp.GetRestored())
+
+	origin, err := rep.LinkByShort(ctx, short)
+	assert.NoError(t, err)
+	assert.Equal(t, "http://restore.ru", origin)
+}
+
+func TestShortenerServer_DeleteJob(t *testing.T) {
+	ctx := context.Background()
+	rep, err := file.New("")
+	if err != nil {
+		log.Fatal(err)
+	}
+	short, err := rep.Save(ctx, "all", "http://job.ru", shortlink.Options{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	p, poolClose := worker.New(ctx, zap.NewNop(), worker.WithType(worker.TypeDelete, worker.Delete(rep, 0, 0)))
+	defer poolClose()
+
+	server := New(zap.NewNop(), rep, &sql.DB{}, p, nil)
+	resp, err := server.Delete(ctx, &proto.DeleteRequest{Id: []*proto.LinkID{{Id: string(short)}}})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusAccepted, int(resp.GetCode()))
+	assert.NotEmpty(t, resp.GetJobId())
+
+	assert.Eventually(t, func() bool {
+		job, err := server.DeleteJob(ctx, &proto.DeleteJobRequest{Id: resp.GetJobId()})
+		return err == nil && job.GetStatus() == worker.JobDone && job.GetAffected() == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	job, err := server.DeleteJob(ctx, &proto.DeleteJobRequest{Id: "unknown"})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusNotFound, int(job.GetCode()))
+}
+
+func TestShortenerServer_DeleteQueueFull(t *testing.T) {
+	ctx := context.Background()
+	rep, err := file.New("")
+	if err != nil {
+		log.Fatal(err)
+	}
+	p := workertest.FullPool(t, rep)
+
+	server := New(zap.NewNop(), rep, &sql.DB{}, p, nil)
+	resp, err := server.Delete(ctx, &proto.DeleteRequest{Id: []*proto.LinkID{{Id: "2"}}})
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+	assert.Equal(t, http.StatusTooManyRequests, int(resp.GetCode()))
+}